@@ -20,10 +20,16 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+	tmpl "text/template"
+	"time"
 
 	"notabug.org/gearsix/dati"
 )
@@ -32,17 +38,31 @@ import (
 type Data map[string]interface{}
 
 type options struct {
-	RootPath        string
+	RootPaths       []string
 	PartialPaths    []string
 	GlobalDataPaths []string
 	DataPaths       []string
 	DataKey         string
+	MarkdownHTML    string
+	ExpandKeys      bool
 	SortData        string
 	ConfigFile      string
+	Timeout         time.Duration
+	Merge           string
+	NoInject        bool
+	OutFile         string
+	OutDir          string
+	OutExts         map[string]string
+	TemplateAliases map[string]string
+	OutPath         string
+	Force           bool
+	Watch           bool
+	Addr            string
 }
 
 var opts options
 var cwd string
+var serving bool
 
 func warn(err error, msg string, args ...interface{}) {
 	warning := "WARNING "
@@ -58,13 +78,6 @@ func warn(err error, msg string, args ...interface{}) {
 	fmt.Println(warning)
 }
 
-func assert(err error, msg string, args ...interface{}) {
-	if err != nil {
-		fmt.Printf("ERROR %s\n%s\n", strings.TrimSuffix(fmt.Sprintf(msg, args...), "\n"), err)
-		os.Exit(1)
-	}
-}
-
 func basedir(path string) string {
 	if !filepath.IsAbs(path) {
 		path = filepath.Join(cwd, path)
@@ -72,67 +85,368 @@ func basedir(path string) string {
 	return path
 }
 
+// the path of a data file, "-" (stdin) isn't changed
+func dataPath(path string) string {
+	if path == "-" {
+		return path
+	}
+	return basedir(path)
+}
+
 func init() {
 	if len(os.Args) <= 1 {
 		fmt.Println("nothing to do")
 		os.Exit(0)
 	}
 
-	opts = parseArgs(os.Args[1:], options{})
-	if len(opts.ConfigFile) != 0 {
-		cwd = filepath.Dir(opts.ConfigFile)
-		opts = parseConfig(opts.ConfigFile, opts)
+	if os.Args[1] == "serve" {
+		serving = true
+	}
+	opts = loadOptions(cliArgs())
+}
+
+// returns the command-line arguments, without the program name or command
+func cliArgs() []string {
+	if serving {
+		return os.Args[2:]
 	}
-	opts = setDefaultOptions(opts)
+	return os.Args[1:]
+}
+
+// the template aliases set by the last call to `loadOptions`
+var templateAliases []string
+
+// parse `args` and any config file they set
+func loadOptions(args []string) (o options) {
+	cwd = "" // command-line paths are relative to the working directory
+	o = parseArgs(append([]string(nil), args...), options{})
+	if len(o.ConfigFile) != 0 {
+		cwd = filepath.Dir(o.ConfigFile)
+		o = parseConfig(o.ConfigFile, o)
+	}
+	for _, ext := range templateAliases { // remove any aliases from a previous config
+		dati.RegisterTemplateAlias(ext, "")
+	}
+	templateAliases = nil
+	for ext, lang := range o.TemplateAliases {
+		dati.RegisterTemplateAlias(ext, dati.TemplateLanguage(lang))
+		templateAliases = append(templateAliases, ext)
+	}
+	md := dati.MarkdownOptions{HTMLKey: o.MarkdownHTML}
+	dati.RegisterDataFormat(dati.MD, []string{"md", "markdown"},
+		dati.NewMarkdownDecoder(md), dati.NewMarkdownEncoder(md))
+	properties := dati.PropertiesOptions{ExpandKeys: o.ExpandKeys}
+	dati.RegisterDataFormat(dati.PROPERTIES, []string{"properties"},
+		dati.NewPropertiesDecoder(properties), dati.NewPropertiesEncoder(properties))
+	return setDefaultOptions(o)
 }
 
 func main() {
-	var err error
-	var global Data
-	var data []Data
-	var template dati.Template
-	var out bytes.Buffer
+	if serving {
+		serve()
+	} else if opts.Watch {
+		watch()
+	} else if err := build(); err != nil {
+		fmt.Printf("ERROR %s\n", err)
+		os.Exit(1)
+	}
+}
+
+// returns `err` with `msg` (formatted with `args`) on the line above it
+func fail(err error, msg string, args ...interface{}) error {
+	return fmt.Errorf("%s\n%s", strings.TrimSuffix(fmt.Sprintf(msg, args...), "\n"), err)
+}
 
-	opts.GlobalDataPaths = loadFilePaths(opts.GlobalDataPaths...)
-	for _, path := range opts.GlobalDataPaths {
+// all the data & template paths loaded from `opts`
+type site struct {
+	global    Data
+	data      []interface{}
+	dataPaths []string
+	roots     []string
+	partials  []string
+}
+
+// a single result of executing a root template
+type page struct {
+	name string // path of the result, relative to the output directory
+	data interface{}
+}
+
+// load all data & template paths set in `opts`
+func loadSite() (s site, err error) {
+	var globals []map[string]interface{}
+	var globalDataPaths, paths []string
+
+	if stdin := countStdin(opts.GlobalDataPaths) + countStdin(opts.DataPaths); stdin > 1 {
+		err = fmt.Errorf("'-' (stdin) can only be used once in -global-data and -data")
+		return
+	}
+
+	if paths, err = loadFilePaths(opts.GlobalDataPaths...); err != nil {
+		return
+	}
+	for _, path := range filterDataPaths(paths) {
 		var d Data
-		err = dati.LoadDataFile(path, &d)
-		assert(err, "failed to load global data '%s'", path)
-		data = append(data, d)
+		if err = loadGlobalDataFile(path, &d); err != nil && isDetectedDataFile(path) {
+			warn(err, "skipping '%s', failed to load detected data format", path)
+			err = nil
+			continue
+		} else if err != nil {
+			err = fail(err, "failed to load global data '%s'", path)
+			return
+		}
+		globals = append(globals, d)
+		globalDataPaths = append(globalDataPaths, path)
+	}
+	if s.global, err = mergeGlobalData(globals, globalDataPaths); err != nil {
+		return
 	}
-	global = mergeData(data)
 
-	opts.DataPaths = loadFilePaths(opts.DataPaths...)
-	opts.DataPaths, err = dati.SortFileList(opts.DataPaths, opts.SortData)
-	if err != nil {
+	if paths, err = loadFilePaths(opts.DataPaths...); err != nil {
+		return
+	}
+	if paths, err = dati.SortFileList(filterDataPaths(paths), opts.SortData); err != nil {
 		warn(err, "failed to sort data files")
+		err = nil
 	}
-	data = make([]Data, 0)
-	for _, path := range opts.DataPaths {
-		var d Data
-		err = dati.LoadDataFile(path, &d)
-		assert(err, "failed to load data '%s'", path)
-		data = append(data, d)
+	s.data = make([]interface{}, 0, len(paths))
+	for _, path := range paths {
+		var d interface{}
+		if d, err = loadDataFile(path); err != nil && isDetectedDataFile(path) {
+			warn(err, "skipping '%s', failed to load detected data format", path)
+			err = nil
+			continue
+		} else if err != nil {
+			err = fail(err, "failed to load data '%s'", path)
+			return
+		}
+		s.data = append(s.data, d)
+		s.dataPaths = append(s.dataPaths, path)
+	}
+
+	if s.roots, err = loadFilePaths(opts.RootPaths...); err != nil {
+		return
+	} else if s.partials, err = loadFilePaths(opts.PartialPaths...); err != nil {
+		return
+	}
+	s.roots = filterTemplatePaths(s.roots)
+	s.partials = filterTemplatePaths(s.partials)
+	if len(s.roots) == 0 {
+		warn(nil, "no root templates to execute")
+	}
+	return
+}
+
+// returns the pages to generate from the root template at `root`. If
+// -out-path is set there's a page for each data item, otherwise there's a
+// single page of the super-structure.
+// `sources` is the root template & data file of every page name returned so
+// far, a warning is printed if more than one page has the same name.
+func (s site) pages(root string, sources map[string]string) ([]page, error) {
+	if len(opts.OutPath) == 0 {
+		p := page{
+			name: outputName(root),
+			data: dati.GenerateSuperData(opts.DataKey, s.global, s.data, !opts.NoInject),
+		}
+		addPageSource(sources, p.name, root)
+		return []page{p}, nil
 	}
-	global[opts.DataKey] = data
 
-	template, err = dati.LoadTemplateFile(opts.RootPath, opts.PartialPaths...)
-	assert(err, "unable to load templates")
+	pages := make([]page, 0, len(s.data))
+	for i, item := range s.data {
+		p := page{data: dati.InjectGlobalData(opts.DataKey, s.global, item)}
 
-	out, err = template.Execute(global)
-	assert(err, "failed to execute template '%s'", opts.RootPath)
-	fmt.Print(out.String())
+		var err error
+		if p.name, err = pagePath(root, s.dataPaths[i], p.data); err != nil {
+			return nil, fail(err, "failed to generate -out-path for '%s'", s.dataPaths[i])
+		} else if !isWithinOutDir(p.name) {
+			return nil, fail(fmt.Errorf("'%s' is outside of the output directory", p.name),
+				"invalid -out-path for '%s'", s.dataPaths[i])
+		}
+		addPageSource(sources, p.name, fmt.Sprintf("%s (%s)", s.dataPaths[i], root))
+
+		pages = append(pages, p)
+	}
+	return pages, nil
+}
+
+func addPageSource(sources map[string]string, name, source string) {
+	if src, ok := sources[name]; ok {
+		warn(nil, "'%s' and '%s' both write to '%s'", src, source, name)
+	}
+	sources[name] = source
+}
+
+// returns true if `name` (a path relative to -out-dir) is within -out-dir
+func isWithinOutDir(name string) bool {
+	name = filepath.Clean(name)
+	return name != ".." && !strings.HasPrefix(name, ".."+string(filepath.Separator))
+}
+
+// the paths written by the last call to `build`, these can be overwritten
+// by the next build without -force (see -watch)
+var written = make(map[string]bool)
+
+// load all data & templates set in `opts` and execute them
+func build() error {
+	s, err := loadSite()
+	if err != nil {
+		return err
+	}
+
+	last := written
+	written = make(map[string]bool)
+
+	if len(s.roots) > 1 && len(opts.OutPath) == 0 {
+		if len(opts.OutFile) > 0 {
+			return fail(fmt.Errorf("%d root templates found", len(s.roots)),
+				"-out can only be used with a single root template, see -out-dir")
+		} else if len(opts.OutDir) == 0 {
+			return fail(fmt.Errorf("%d root templates found", len(s.roots)),
+				"each root template is written to it's own file, use -out-dir")
+		}
+	}
+
+	if len(opts.OutFile) > 0 && len(opts.OutPath) > 0 {
+		warn(nil, "ignoring -out, -out-path is set")
+	}
+
+	sources := make(map[string]string)
+	for _, root := range s.roots {
+		var template dati.Template
+		if template, err = dati.LoadTemplateFile(root, s.partials...); err != nil {
+			return fail(err, "unable to load templates for '%s'", root)
+		}
+
+		var pages []page
+		if pages, err = s.pages(root, sources); err != nil {
+			return err
+		}
+		for _, p := range pages {
+			path := outputPath(p)
+			if len(path) > 0 {
+				if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+					return fail(err, "failed to create output directory for '%s'", path)
+				}
+			}
+			if err = execute(template, root, p.data, path, opts.Force || last[path]); err != nil {
+				return err
+			} else if len(path) > 0 {
+				written[path] = true
+			}
+		}
+	}
+
+	return nil
+}
+
+// returns a context for executing a template, which has the -timeout set
+func executeContext(parent context.Context) (context.Context, context.CancelFunc) {
+	if opts.Timeout > 0 {
+		return context.WithTimeout(parent, opts.Timeout)
+	}
+	return context.WithCancel(parent)
+}
 
+// execute `template` (loaded from `root`) against `data`, writing the
+// result to `path` (or stdout if `path` is ""). If `force` is set, any
+// existing file at `path` is overwritten.
+func execute(template dati.Template, root string, data interface{}, path string, force bool) (err error) {
+	ctx, cancel := executeContext(context.Background())
+	defer cancel()
+
+	if len(path) > 0 {
+		var f *os.File
+		if f, err = template.ExecuteToFileContext(ctx, data, path, force); err == nil {
+			f.Close()
+		} else if err == os.ErrExist {
+			err = fmt.Errorf("'%s' already exists, use -force to overwrite it", path)
+		}
+	} else {
+		out := bufio.NewWriter(os.Stdout)
+		if err = template.ExecuteContext(ctx, out, data); err == nil {
+			if err = out.Flush(); err != nil {
+				return fail(err, "failed to write output")
+			}
+		}
+	}
+
+	if err != nil {
+		err = fail(err, "failed to execute template '%s'", root)
+	}
 	return
 }
 
+// returns the path of the result of executing `root` against `page`,
+// generated by executing the -out-path template against `page`.
+func pagePath(root string, dataPath string, page interface{}) (string, error) {
+	funcs := tmpl.FuncMap{
+		"root": func() string {
+			return strings.TrimSuffix(filepath.Base(root), filepath.Ext(root))
+		},
+		"file": func() string {
+			return strings.TrimSuffix(filepath.Base(dataPath), filepath.Ext(dataPath))
+		},
+	}
+
+	t, err := tmpl.New("out-path").Funcs(funcs).Option("missingkey=error").Parse(opts.OutPath)
+	if err != nil {
+		return "", err
+	}
+
+	var path strings.Builder
+	if err = t.Execute(&path, page); err != nil {
+		return "", err
+	}
+	return filepath.Clean(path.String()), nil
+}
+
+// returns the name of the file to write the result of executing `root` to,
+// the name of `root` with the -out-ext for it's template language
+func outputName(root string) string {
+	name := strings.TrimSuffix(filepath.Base(root), filepath.Ext(root))
+	ext, ok := opts.OutExts[dati.ReadTemplateLangauge(root).String()]
+	if !ok {
+		ext = "txt"
+	}
+	if len(ext) > 0 {
+		name += "." + ext
+	}
+	return name
+}
+
+// returns the path of the file to write `p` to, or "" if it should be
+// written to stdout
+func outputPath(p page) string {
+	if len(opts.OutPath) > 0 {
+		return basedir(filepath.Join(opts.OutDir, p.name))
+	} else if len(opts.OutFile) > 0 {
+		return opts.OutFile
+	} else if len(opts.OutDir) > 0 {
+		return filepath.Join(opts.OutDir, p.name)
+	}
+	return ""
+}
+
 func help() {
-	fmt.Print("Usage: dati [OPTIONS]\n\n")
+	fmt.Print("Usage: dati [OPTIONS]\n")
+	fmt.Print("       dati serve [OPTIONS]\n\n")
+
+	fmt.Print(`Commands
+  serve  
+    instead of writing any files, serve the result of executing each root
+    template over HTTP (at -addr). All files are loaded again for every
+    request, so any changes are shown when the page is reloaded.
+
+`)
 
 	fmt.Print("Options")
 	fmt.Print(`
-   -r path, -root path  
-    path of template file to execute against.
+  -r path..., -root path...  
+    path of (multiple) root template files to execute against. If a
+    directory is passed then all files within that directory will
+    (recursively) be loaded. Each root template is executed in turn, -out-dir
+    must be set if there's more than one.
 
   -p path..., -partial path...  
     path of (multiple) template files that are called upon by at least one
@@ -142,15 +456,25 @@ func help() {
   -gd path..., -global-data path...  
     path of (multiple) data files to load as "global data". If a directory is
     passed then all files within that directory will (recursively) be loaded.
+    If path is "-", data is read from stdin.
 
   -d path..., -data path...  
    path of (multiple) data files to load as "data". If a directory is passed
    then all files within that directory will (recursively) be loaded.
+   If path is "-", data is read from stdin.
 
   -dk name, -data-key name  
     set the name of the key used for the generated array of data (default:
     "data")
 
+  -mh name, -md-html name  
+    render the body of markdown data files as HTML and set it to name (e.g.
+    "html"). By default the body isn't rendered.
+
+  -ek, -expand-keys  
+    decode dotted keys in .properties data files to nested maps (e.g. "a.b=c"
+    is decoded to {"a": {"b": "c"}}).
+
   -sd attribute, -sort-data attribute  
     The file attribute to order data files by. If no value is provided, the data
     will be provided in the order it's loaded.
@@ -158,11 +482,77 @@ func help() {
     A suffix can be appended to each value to set the sort order: "-asc" (for
     ascending), "-desc" (for descending). If not specified, this defaults to
     "-asc".
+
+  -o file, -out file  
+    write the result of executing the root template to file, instead of
+    stdout. Can only be used with a single root template.
+
+  -od dir, -out-dir dir  
+    write the result of executing each root template to a file in dir,
+    instead of stdout. The file name is the root template name, with the
+    file extension set by -out-ext (e.g. html.hmpl writes to dir/html.html).
+
+  -oe lang:ext..., -out-ext lang:ext...  
+    set the file extension used by -out-dir for root templates of template
+    language lang (default: "tmpl:txt", "hmpl:html", "mst:txt",
+    "hbs:html", "jinja:html", "liquid:html").
+
+  -ta lang:ext..., -template-alias lang:ext...  
+    read root & partial files with the file extension ext as template
+    language lang (e.g. "liquid:html"). This is checked before the default
+    file extensions of each template language.
+
+  -op template, -out-path template  
+    execute each root template once for each data file, instead of once
+    against all data. The data file is provided as "." with the global data
+    written into it. template is a golang text/template used to generate the
+    path of the file to write each result to (relative to -out-dir), it's
+    executed against the same data. It can also call "file" (the data file
+    name, without extension) and "root" (the root template name, without
+    extension). E.g. -out-path "posts/{{.slug}}.html". Paths outside of
+    -out-dir are an error, -out is ignored.
+
+  -f, -force  
+    overwrite any existing files written to by -out or -out-dir.
+
+  -ni, -no-inject  
+    don't write global data into the root of each data array object.
+
+  -m strategy, -merge strategy  
+    how to merge keys that are set in more than one global data file, a
+    comma-separated list of any of: "first" (keep the first value found),
+    "last" (keep the last value found), "deep" (merge nested maps), "append"
+    (append lists), "error" (exit on any conflict). (default: "first")
+
+  -w, -watch  
+    keep running and execute the root templates again whenever any of the
+    root, partial, global data, data or config files change. Any errors are
+    reported without exiting.
+
+  -a address, -addr address  
+    the address to listen on for "dati serve" (default: "localhost:8080").
+
+  -t duration, -timeout duration  
+    stop executing the root template if it takes longer than duration (e.g.
+    "30s", "1m"). By default there is no timeout.
+
   -cfg file, -config file  
     A data file to provide default values for the above options (see CONFIG).
 
 `)
 
+	fmt.Print("Data formats\n  ")
+	for _, format := range dati.DataFormats() {
+		fmt.Printf(" %s", format)
+	}
+	fmt.Print("\n\n")
+
+	fmt.Print("Template languages\n  ")
+	for _, lang := range dati.TemplateLanguages() {
+		fmt.Printf(" %s", lang)
+	}
+	fmt.Print("\n\n")
+
 	fmt.Println("See doc/dati.txt for further details")
 }
 
@@ -172,7 +562,7 @@ func parseArgs(args []string, existing options) (o options) {
 	var flag string
 	for a := 0; a < len(args); a++ {
 		arg := args[a]
-		if arg[0] == '-' && flag != "--" {
+		if arg[0] == '-' && arg != "-" && flag != "--" {
 			flag = arg
 			ndelims := 0
 			for len(flag) > 0 && flag[0] == '-' {
@@ -196,19 +586,72 @@ func parseArgs(args []string, existing options) (o options) {
 			if flag == "h" || flag == "help" {
 				help()
 				os.Exit(0)
+			} else if flag == "ni" || flag == "no-inject" {
+				o.NoInject = true
+				flag = ""
+			} else if flag == "f" || flag == "force" {
+				o.Force = true
+				flag = ""
+			} else if flag == "w" || flag == "watch" {
+				o.Watch = true
+				flag = ""
+			} else if flag == "ek" || flag == "expand-keys" {
+				o.ExpandKeys = true
+				flag = ""
 			}
-		} else if (flag == "r" || flag == "root") && len(o.RootPath) == 0 {
-			o.RootPath = basedir(arg)
+		} else if flag == "r" || flag == "root" {
+			o.RootPaths = append(o.RootPaths, basedir(arg))
 		} else if flag == "p" || flag == "partial" {
 			o.PartialPaths = append(o.PartialPaths, basedir(arg))
 		} else if flag == "gd" || flag == "globaldata" {
-			o.GlobalDataPaths = append(o.GlobalDataPaths, basedir(arg))
+			o.GlobalDataPaths = append(o.GlobalDataPaths, dataPath(arg))
 		} else if flag == "d" || flag == "data" {
-			o.DataPaths = append(o.DataPaths, basedir(arg))
+			o.DataPaths = append(o.DataPaths, dataPath(arg))
 		} else if flag == "dk" || flag == "datakey" && len(o.DataKey) == 0 {
 			o.DataKey = arg
+		} else if (flag == "mh" || flag == "md-html") && len(o.MarkdownHTML) == 0 {
+			o.MarkdownHTML = arg
 		} else if flag == "sd" || flag == "sortdata" && len(o.SortData) == 0 {
 			o.SortData = arg
+		} else if (flag == "o" || flag == "out") && len(o.OutFile) == 0 {
+			o.OutFile = basedir(arg)
+		} else if (flag == "od" || flag == "out-dir") && len(o.OutDir) == 0 {
+			o.OutDir = basedir(arg)
+		} else if (flag == "op" || flag == "out-path") && len(o.OutPath) == 0 {
+			o.OutPath = arg
+		} else if flag == "oe" || flag == "out-ext" {
+			if split := strings.SplitN(arg, ":", 2); len(split) == 2 {
+				if o.OutExts == nil {
+					o.OutExts = make(map[string]string)
+				}
+				lang := strings.ToLower(split[0])
+				if _, ok := o.OutExts[lang]; !ok {
+					o.OutExts[lang] = strings.TrimPrefix(split[1], ".")
+				}
+			} else {
+				warn(nil, "invalid out-ext '%s', should be 'language:extension'", arg)
+			}
+		} else if flag == "ta" || flag == "template-alias" {
+			if split := strings.SplitN(arg, ":", 2); len(split) == 2 {
+				if o.TemplateAliases == nil {
+					o.TemplateAliases = make(map[string]string)
+				}
+				ext := strings.ToLower(strings.TrimPrefix(split[1], "."))
+				if _, ok := o.TemplateAliases[ext]; !ok {
+					o.TemplateAliases[ext] = strings.ToLower(split[0])
+				}
+			} else {
+				warn(nil, "invalid template-alias '%s', should be 'language:extension'", arg)
+			}
+		} else if (flag == "m" || flag == "merge") && len(o.Merge) == 0 {
+			o.Merge = arg
+		} else if (flag == "a" || flag == "addr") && len(o.Addr) == 0 {
+			o.Addr = arg
+		} else if (flag == "t" || flag == "timeout") && o.Timeout == 0 {
+			var err error
+			if o.Timeout, err = time.ParseDuration(arg); err != nil {
+				warn(err, "invalid timeout '%s'", arg)
+			}
 		} else if flag == "cfg" || flag == "config" && len(o.ConfigFile) == 0 {
 			o.ConfigFile = basedir(arg)
 		} else if len(flag) == 0 {
@@ -251,20 +694,34 @@ func setDefaultOptions(o options) options {
 	if len(o.DataKey) == 0 {
 		o.DataKey = "data"
 	}
+	if len(o.Merge) == 0 {
+		o.Merge = "first"
+	}
+	if len(o.Addr) == 0 {
+		o.Addr = "localhost:8080"
+	}
+	if o.OutExts == nil {
+		o.OutExts = make(map[string]string)
+	}
+	for lang, ext := range map[string]string{"tmpl": "txt", "hmpl": "html", "mst": "txt", "hbs": "html", "jinja": "html", "liquid": "html"} {
+		if _, ok := o.OutExts[lang]; !ok {
+			o.OutExts[lang] = ext
+		}
+	}
 	return o
 }
 
 // load glob & dir filepaths as individual filepaths
-func loadFilePaths(paths ...string) (filepaths []string) {
+func loadFilePaths(paths ...string) (filepaths []string, err error) {
 	for _, path := range paths {
-		var err error
-		if strings.Contains(path, "*") {
+		if path == "-" {
+			filepaths = append(filepaths, path)
+		} else if strings.Contains(path, "*") {
 			var glob []string
-			glob, err = filepath.Glob(path)
-			assert(err, "failed to glob '%s'", path)
-			for _, p := range glob {
-				filepaths = append(filepaths, p)
+			if glob, err = filepath.Glob(path); err != nil {
+				return nil, fail(err, "failed to glob '%s'", path)
 			}
+			filepaths = append(filepaths, glob...)
 		} else {
 			err = filepath.Walk(path,
 				func(p string, info os.FileInfo, e error) error {
@@ -273,24 +730,163 @@ func loadFilePaths(paths ...string) (filepaths []string) {
 					}
 					return e
 				})
+			if err != nil {
+				return nil, fail(err, "failed to load filepaths for '%s'", path)
+			}
 		}
-		if err != nil {
-			assert(err, "failed to load filepaths for '%s'", path)
+	}
+	return
+}
+
+// filter out any paths that aren't a registered template language
+func filterTemplatePaths(paths []string) (filtered []string) {
+	for _, path := range paths {
+		if dati.IsTemplateLanguage(path) {
+			filtered = append(filtered, path)
+		} else {
+			warn(nil, "skipping '%s', unknown template language", path)
 		}
 	}
 	return
 }
 
-func mergeData(data []Data) (merged Data) {
-	merged = make(Data)
-	for _, d := range data {
-		for key, val := range d {
-			if merged[key] == nil {
-				merged[key] = val
-			} else {
-				warn(nil, "merge conflict for global data key: '%s'", key)
-			}
+// filter out any paths that aren't a registered data format
+func filterDataPaths(paths []string) (filtered []string) {
+	for _, path := range paths {
+		if path == "-" || dati.IsDataFormat(path) || len(detectDataFile(path)) > 0 {
+			filtered = append(filtered, path)
+		} else {
+			warn(nil, "skipping '%s', unknown data format", path)
+		}
+	}
+	return
+}
+
+// returns true if the format of the data file at `path` is detected from it's
+// contents, instead of it's file extension
+func isDetectedDataFile(path string) bool {
+	return path != "-" && !dati.IsDataFormat(path)
+}
+
+// returns the number of `paths` that are "-" (stdin)
+func countStdin(paths []string) (n int) {
+	for _, path := range paths {
+		if path == "-" {
+			n++
 		}
 	}
 	return
 }
+
+// returns the format of the data in the file at `path`, detected from it's
+// contents. If it can't be detected, "" is returned.
+func detectDataFile(path string) dati.DataFormat {
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	format, _, _ := dati.DetectDataFormat(f)
+	return format
+}
+
+// stdin is only read once, when serving or watching it's kept here so that
+// every request & rebuild gets the same data.
+var stdin struct {
+	once sync.Once
+	data []byte
+	err  error
+}
+
+// returns a reader for stdin and the format of the data in it
+func openStdin() (dati.DataFormat, io.Reader, error) {
+	var r io.Reader = os.Stdin
+	if serving || opts.Watch {
+		stdin.once.Do(func() { stdin.data, stdin.err = ioutil.ReadAll(os.Stdin) })
+		if stdin.err != nil {
+			return "", nil, stdin.err
+		}
+		r = bytes.NewReader(stdin.data)
+	}
+	format, in, err := dati.DetectDataFormat(r)
+	if err == nil && len(format) == 0 {
+		err = fmt.Errorf("failed to detect data format of stdin")
+	}
+	return format, in, err
+}
+
+// load the global data file at `path` into `d`, "-" reads from stdin
+func loadGlobalDataFile(path string, d *Data) error {
+	if path != "-" {
+		return dati.LoadDataFile(path, d)
+	}
+	format, in, err := openStdin()
+	if err != nil {
+		return err
+	}
+	return dati.LoadData(format, in, d)
+}
+
+// load the data file at `path`, "-" reads from stdin. If it's format can be
+// streamed, each record is decoded as it's read (instead of reading the
+// whole file first) and the result is a list of all the records.
+func loadDataFile(path string) (d interface{}, err error) {
+	var format dati.DataFormat
+	var in io.Reader
+	if path == "-" {
+		if format, in, err = openStdin(); err != nil {
+			return
+		}
+	} else {
+		if format = dati.ReadDataFormat(path); !dati.IsDataStreamable(format) {
+			err = dati.LoadDataFile(path, &d)
+			return
+		}
+
+		var f *os.File
+		if f, err = os.Open(path); err != nil {
+			return
+		}
+		defer f.Close()
+		in = f
+	}
+
+	if !dati.IsDataStreamable(format) {
+		err = dati.LoadData(format, in, &d)
+		return
+	}
+
+	var stream dati.DataStream
+	if stream, err = dati.LoadDataStream(format, in); err != nil {
+		return
+	}
+	records := make([]interface{}, 0)
+	for {
+		var record interface{}
+		if err = stream.Next(&record); err == io.EOF {
+			return records, nil
+		} else if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+}
+
+// merge `globals` (loaded from `paths`) using the -merge strategy
+func mergeGlobalData(globals []map[string]interface{}, paths []string) (Data, error) {
+	strategy, err := dati.ParseMergeStrategy(opts.Merge)
+	if err != nil {
+		return nil, fail(err, "invalid merge option")
+	}
+
+	merged, conflicts, err := dati.MergeData(strategy, globals...)
+	for _, c := range conflicts {
+		msg := "merge conflict for global data key '%s' ('%s' & '%s')"
+		args := []interface{}{c.Key(), paths[c.Docs[0]], paths[c.Docs[1]]}
+		if err != nil {
+			return nil, fail(err, msg, args...)
+		}
+		warn(nil, msg, args...)
+	}
+	return merged, nil
+}
@@ -4,7 +4,7 @@
 diff="diff -bs"
 fail=0
 
-go build -o dati dati.go
+go build -o dati .
 
 if [ -e dati ]; then
 	./dati -cfg ../examples/dati.cfg -r ../examples/template/html.hmpl > out.html
@@ -15,6 +15,84 @@ if [ -e dati ]; then
 	$diff out.txt ../examples/out.txt
 	if [ $? -ne 0 ]; then fail=1; else rm out.txt; fi
 
+	./dati -cfg ../examples/dati.cfg -r ../examples/template/ -od out
+	$diff out/html.html ../examples/out.html
+	if [ $? -ne 0 ]; then fail=1; fi
+	$diff out/txt.txt ../examples/out.txt
+	if [ $? -ne 0 ]; then fail=1; fi
+	if [ $fail -eq 0 ]; then rm -r out; fi
+
+	# data with an unknown file extension & stdin are detected by content
+	echo '{{.x}} {{range .data}}{{.y}}{{end}}' > detect.tmpl
+	echo 'y = "file"' > detect
+	echo '{"x": "stdin"}' | ./dati -r detect.tmpl -gd - -d detect > detect.out
+	if [ "$(cat detect.out)" != "stdin file" ]; then
+		echo "detect: '$(cat detect.out)' should be 'stdin file'"
+		fail=1
+	fi
+
+	# files that can't be decoded as their detected format are skipped
+	mkdir detectdir
+	echo 'y = "file"' > detectdir/detect
+	printf 'Note: this is a readme.\nIt: has: prose.\n' > detectdir/README
+	echo '{"x": "stdin"}' | ./dati -r detect.tmpl -gd - -d detectdir > detect.out
+	if ! grep -q "stdin file" detect.out || ! grep -q "skipping '.*README'" detect.out; then
+		echo "detect: '$(cat detect.out)' should skip README"
+		fail=1
+	fi
+
+	# stdin can't be used more than once
+	if echo '{}' | ./dati -r detect.tmpl -gd - -d - > /dev/null 2>&1; then
+		echo "detect: '-gd - -d -' should be rejected"
+		fail=1
+	fi
+	rm -r detect.tmpl detect detect.out detectdir
+
+	# markdown is only rendered as HTML with -md-html
+	echo '{{.title}}{{.html}}' > md.hmpl
+	printf -- '---\ntitle: x\n---\n*y*\n' > md.md
+	./dati -r md.hmpl -gd md.md -mh html > md.out
+	if [ "$(cat md.out)" != "x<p><em>y</em></p>" ]; then
+		echo "md-html: '$(cat md.out)' should be 'x<p><em>y</em></p>'"
+		fail=1
+	fi
+	rm md.hmpl md.md md.out
+
+	# dotted .properties keys are expanded with -expand-keys
+	echo '{{.a.b}}' > ek.tmpl
+	echo 'a.b = c' > ek.properties
+	./dati -r ek.tmpl -gd ek.properties -ek > ek.out
+	if [ "$(cat ek.out)" != "c" ]; then
+		echo "expand-keys: '$(cat ek.out)' should be 'c'"
+		fail=1
+	fi
+	rm ek.tmpl ek.properties ek.out
+
+	# -out-path can't write outside of -out-dir
+	echo '{{.x}}' > outpath.tmpl
+	echo '{"x": "../../outpath"}' > outpath.json
+	if ./dati -r outpath.tmpl -d outpath.json -od outpath -op '{{.x}}' > /dev/null 2>&1; then
+		echo "outpath: '../../outpath' should be rejected"
+		fail=1
+	fi
+	rm -rf outpath.tmpl outpath.json outpath
+
+	# -watch must overwrite the files it wrote itself, without -force
+	cp -r ../examples watch
+	./dati -cfg watch/dati.cfg -r watch/template/ -od watch/out -w > watch.log &
+	pid=$!
+	sleep 2
+	touch watch/template/html.hmpl
+	sleep 2
+	kill $pid
+	if ! grep -q "change detected" watch.log || grep -q "ERROR" watch.log; then
+		cat watch.log
+		fail=1
+	fi
+	$diff watch/out/html.html ../examples/out.html
+	if [ $? -ne 0 ]; then fail=1; fi
+	if [ $fail -eq 0 ]; then rm -r watch watch.log; fi
+
 	rm dati
 
 	if [ $fail -eq 1 ]; then echo "TEST FAIL"; else echo "TEST PASS"; fi
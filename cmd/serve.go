@@ -0,0 +1,151 @@
+package main
+
+/*
+	Copyright (C) 2023 gearsix <gearsix@tuta.io>
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	hmpl "html/template"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"notabug.org/gearsix/dati"
+)
+
+var serveIndex = hmpl.Must(hmpl.New("index").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>dati</title></head>
+<body>
+<ul>
+{{range .}}<li><a href="/{{.}}">{{.}}</a></li>
+{{else}}<li>no root templates to execute</li>
+{{end}}</ul>
+</body>
+</html>
+`))
+
+var serveError = hmpl.Must(hmpl.New("error").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>dati: {{.Status}}</title></head>
+<body>
+<h1>{{.Status}}</h1>
+<pre>{{.Err}}</pre>
+</body>
+</html>
+`))
+
+// an error that occurred while handling a request, and the HTTP status
+// code to respond with
+type serveErr struct {
+	status int
+	err    error
+}
+
+// listen on -addr, serving the result of executing each page of each root
+// template. Everything is loaded again for each request.
+func serve() {
+	fmt.Printf("serving on http://%s\n", opts.Addr)
+	if err := http.ListenAndServe(opts.Addr, http.HandlerFunc(handleRequest)); err != nil {
+		fmt.Printf("ERROR failed to serve on '%s'\n%s\n", opts.Addr, err)
+		os.Exit(1)
+	}
+}
+
+func handleRequest(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+
+	var buf bytes.Buffer
+	contentType, serr := render(r.Context(), &buf, name)
+	if serr != nil {
+		fmt.Printf("ERROR %s: %s\n", r.URL.Path, serr.err)
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(serr.status)
+		err := serveError.Execute(w, struct {
+			Status string
+			Err    string
+		}{http.StatusText(serr.status), serr.err.Error()})
+		if err != nil {
+			fmt.Printf("ERROR %s: failed to write response\n%s\n", r.URL.Path, err)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		fmt.Printf("ERROR %s: failed to write response\n%s\n", r.URL.Path, err)
+	}
+}
+
+// execute the page called `name` and write the result to `w`. If `name` is
+// "", then the "index.html" page or an index of all pages is written.
+func render(ctx context.Context, w io.Writer, name string) (contentType string, serr *serveErr) {
+	s, err := loadSite()
+	if err != nil {
+		return "", &serveErr{http.StatusInternalServerError, err}
+	}
+
+	var names []string
+	sources := make(map[string]string)
+	for _, root := range s.roots {
+		var pages []page
+		if pages, err = s.pages(root, sources); err != nil {
+			return "", &serveErr{http.StatusInternalServerError, err}
+		}
+
+		for _, p := range pages {
+			pname := filepath.ToSlash(p.name)
+			if pname != name && !(name == "" && pname == "index.html") {
+				names = append(names, pname)
+				continue
+			}
+
+			var template dati.Template
+			if template, err = dati.LoadTemplateFile(root, s.partials...); err != nil {
+				return "", &serveErr{http.StatusInternalServerError,
+					fail(err, "unable to load templates for '%s'", root)}
+			}
+
+			ctx, cancel := executeContext(ctx)
+			err = template.ExecuteContext(ctx, w, p.data)
+			cancel()
+			if err != nil {
+				return "", &serveErr{http.StatusInternalServerError,
+					fail(err, "failed to execute template '%s'", root)}
+			}
+
+			if contentType = mime.TypeByExtension(path.Ext(pname)); len(contentType) == 0 {
+				contentType = "text/plain; charset=utf-8"
+			}
+			return contentType, nil
+		}
+	}
+
+	if name == "" {
+		if err = serveIndex.Execute(w, names); err != nil {
+			return "", &serveErr{http.StatusInternalServerError, err}
+		}
+		return "text/html; charset=utf-8", nil
+	}
+	return "", &serveErr{http.StatusNotFound, fmt.Errorf("no page found for '%s'", name)}
+}
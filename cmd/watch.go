@@ -0,0 +1,145 @@
+package main
+
+/*
+	Copyright (C) 2023 gearsix <gearsix@tuta.io>
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// how often watched files are polled for changes
+	watchInterval = 500 * time.Millisecond
+	// how long watched files must stay unchanged before rebuilding
+	watchDebounce = 250 * time.Millisecond
+)
+
+type fileState struct {
+	modTime time.Time
+	size    int64
+}
+
+// snapshot of every file (and directory) found in the watched paths
+type fileSnapshot map[string]fileState
+
+func (s fileSnapshot) equal(other fileSnapshot) bool {
+	if len(s) != len(other) {
+		return false
+	}
+	for path, state := range s {
+		if o, ok := other[path]; !ok || !o.modTime.Equal(state.modTime) || o.size != state.size {
+			return false
+		}
+	}
+	return true
+}
+
+// build, then poll all the paths set in `opts` and build again whenever
+// anything changes. Build errors are reported, but don't exit.
+func watch() {
+	rebuild := func() {
+		if err := build(); err != nil {
+			fmt.Printf("ERROR %s\n", err)
+		}
+	}
+
+	last := snapshotWatchPaths()
+	rebuild()
+	for {
+		time.Sleep(watchInterval)
+
+		next := snapshotWatchPaths()
+		if next.equal(last) {
+			continue
+		}
+
+		// wait for the changes to settle, so a burst of writes only
+		// triggers one build
+		for {
+			time.Sleep(watchDebounce)
+			settled := snapshotWatchPaths()
+			if settled.equal(next) {
+				break
+			}
+			next = settled
+		}
+
+		fmt.Printf("change detected, rebuilding (%s)\n", time.Now().Format(time.Kitchen))
+		opts = loadOptions(cliArgs()) // the config file may have changed
+		rebuild()
+		last = snapshotWatchPaths() // ignore anything written by the build
+	}
+}
+
+func snapshotWatchPaths() fileSnapshot {
+	snapshot := make(fileSnapshot)
+
+	var paths []string
+	paths = append(paths, opts.RootPaths...)
+	paths = append(paths, opts.PartialPaths...)
+	paths = append(paths, opts.GlobalDataPaths...)
+	paths = append(paths, opts.DataPaths...)
+	if len(opts.ConfigFile) > 0 {
+		paths = append(paths, opts.ConfigFile)
+	}
+
+	for _, path := range paths {
+		if strings.Contains(path, "*") {
+			glob, _ := filepath.Glob(path)
+			for _, p := range glob {
+				snapshotPath(snapshot, p)
+			}
+		} else {
+			snapshotPath(snapshot, path)
+		}
+	}
+
+	return snapshot
+}
+
+// add `path` and (if it's a directory) every file within it to `snapshot`.
+// Any output paths within `path` are skipped. Errors are ignored, missing
+// files are just missing from `snapshot`.
+func snapshotPath(snapshot fileSnapshot, path string) {
+	skipOutput := !isOutputPath(path)
+	filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		} else if skipOutput && isOutputPath(p) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		snapshot[p] = fileState{modTime: info.ModTime(), size: info.Size()}
+		return nil
+	})
+}
+
+func isOutputPath(path string) bool {
+	if len(opts.OutFile) > 0 && path == opts.OutFile {
+		return true
+	} else if len(opts.OutDir) > 0 {
+		rel, err := filepath.Rel(opts.OutDir, path)
+		return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+	}
+	return false
+}
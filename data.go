@@ -24,7 +24,9 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
+	"sync"
 
 	"github.com/pelletier/go-toml"
 	"gopkg.in/yaml.v3"
@@ -50,6 +52,113 @@ var ErrUnsupportedData = func(format string) error {
 	return fmt.Errorf("data format '%s' is not supported", format)
 }
 
+// DataDecoder is the function signature used to decode `in` into the
+// value pointed at by `out` for a registered *DataFormat*.
+type DataDecoder func(in []byte, out interface{}) error
+
+// DataEncoder is the function signature used to encode `data` to `w`
+// for a registered *DataFormat*.
+type DataEncoder func(w io.Writer, data interface{}) error
+
+type dataCodec struct {
+	extensions []string
+	decode     DataDecoder
+	encode     DataEncoder
+}
+
+var (
+	dataCodecsMu sync.RWMutex
+	dataCodecs   = make(map[DataFormat]dataCodec)
+	dataFormats  []DataFormat // registration order, used to resolve extensions
+)
+
+func init() {
+	RegisterDataFormat(JSON, []string{"json"}, json.Unmarshal,
+		func(w io.Writer, data interface{}) error {
+			return json.NewEncoder(w).Encode(data)
+		})
+	RegisterDataFormat(YAML, []string{"yaml"}, yaml.Unmarshal,
+		func(w io.Writer, data interface{}) error {
+			return yaml.NewEncoder(w).Encode(data)
+		})
+	RegisterDataFormat(TOML, []string{"toml"}, toml.Unmarshal,
+		func(w io.Writer, data interface{}) error {
+			return toml.NewEncoder(w).Encode(data)
+		})
+}
+
+// RegisterDataFormat adds `format` to the list of known *DataFormat*s, any
+// files with a file extension in `extensions` will be read as `format`.
+// `decoder` is called by `LoadData` and `encoder` is called by `WriteData`,
+// either may be nil if `format` can't be decoded/encoded.
+// If `format` is already registered, it will be replaced.
+func RegisterDataFormat(format DataFormat, extensions []string, decoder DataDecoder, encoder DataEncoder) {
+	codec := dataCodec{decode: decoder, encode: encoder}
+	for _, ext := range extensions {
+		codec.extensions = append(codec.extensions, cleanExt(ext))
+	}
+
+	dataCodecsMu.Lock()
+	defer dataCodecsMu.Unlock()
+	if _, ok := dataCodecs[format]; !ok {
+		dataFormats = append(dataFormats, format)
+	}
+	dataCodecs[format] = codec
+}
+
+// DataFormats returns a list of all registered *DataFormat*s, in the order
+// they were registered.
+func DataFormats() []DataFormat {
+	dataCodecsMu.RLock()
+	defer dataCodecsMu.RUnlock()
+	return append([]DataFormat(nil), dataFormats...)
+}
+
+func getDataCodec(format DataFormat) (codec dataCodec, ok bool) {
+	dataCodecsMu.RLock()
+	defer dataCodecsMu.RUnlock()
+	codec, ok = dataCodecs[format]
+	return
+}
+
+// dataError makes sure that `err` indicates the *DataFormat* being parsed.
+func dataError(format DataFormat, err error) error {
+	if err == nil || strings.HasPrefix(err.Error(), format.String()+":") {
+		return err
+	}
+	return fmt.Errorf("%s: %s", format, err.Error())
+}
+
+// setData writes `v` to the value pointed at by `out`. If `v` can't be
+// assigned to that value, it's converted by encoding it to json and
+// decoding the result into `out`. This is useful for *DataDecoder*s that
+// generate generic map/list data, so they still support any type of `out`.
+func setData(v interface{}, out interface{}) error {
+	rv := reflect.ValueOf(out)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("invalid output value (%T), must be a non-nil pointer", out)
+	}
+
+	elem := rv.Elem()
+	if v == nil {
+		elem.Set(reflect.Zero(elem.Type()))
+		return nil
+	} else if vv := reflect.ValueOf(v); vv.Type().AssignableTo(elem.Type()) {
+		elem.Set(vv)
+		return nil
+	}
+
+	buf, err := json.Marshal(v)
+	if err == nil {
+		err = json.Unmarshal(buf, out)
+	}
+	return err
+}
+
+func cleanExt(ext string) string {
+	return strings.TrimPrefix(strings.ToLower(ext), ".")
+}
+
 // IsDataFile checks if `path` is one of the known *DatFormat*s.
 func IsDataFormat(path string) bool {
 	return ReadDataFormat(path) != ""
@@ -67,15 +176,18 @@ func ReadDataFormat(path string) DataFormat {
 	if len(ext) == 0 {
 		ext = path // assume `path` the name of the format
 	}
+	ext = cleanExt(ext)
 
-	ext = strings.ToLower(ext)
-	if len(ext) > 0 && ext[0] == '.' {
-		ext = ext[1:]
-	}
-
-	for _, fmt := range []DataFormat{JSON, YAML, TOML} {
-		if fmt.String() == ext {
-			return fmt
+	dataCodecsMu.RLock()
+	defer dataCodecsMu.RUnlock()
+	for _, format := range dataFormats {
+		if format.String() == ext {
+			return format
+		}
+		for _, e := range dataCodecs[format].extensions {
+			if e == ext {
+				return format
+			}
 		}
 	}
 	return ""
@@ -91,27 +203,18 @@ func LoadData(format DataFormat, in io.Reader, out interface{}) error {
 		return nil
 	}
 
-	switch format {
-	case JSON:
-		if err = json.Unmarshal(inbuf, out); err != nil {
-			err = fmt.Errorf("%s: %s", JSON, err.Error())
-		}
-	case YAML:
-		err = yaml.Unmarshal(inbuf, out)
-	case TOML:
-		if err = toml.Unmarshal(inbuf, out); err != nil {
-			err = fmt.Errorf("%s %s", TOML, err.Error())
-		}
-	default:
-		err = ErrUnsupportedData(format.String())
+	codec, ok := getDataCodec(format)
+	if !ok || codec.decode == nil {
+		return ErrUnsupportedData(format.String())
 	}
-
-	return err
+	return dataError(format, codec.decode(inbuf, out))
 }
 
 // LoadDataFile loads all the data from the file found at `path` into
 // the the format of that files extension (e.g. "x.json" will be loaded
-// as a json). The result is written to the value pointed at by `outp`.
+// as a json). If the extension isn't a known *DataFormat*, the format is
+// detected from the contents of the file (see `DetectDataFormat`).
+// The result is written to the value pointed at by `outp`.
 func LoadDataFile(path string, outp interface{}) error {
 	file, err := os.Open(path)
 	if err != nil {
@@ -119,29 +222,25 @@ func LoadDataFile(path string, outp interface{}) error {
 	}
 	defer file.Close()
 
-	return LoadData(ReadDataFormat(path), file, outp)
+	var in io.Reader = file
+	format := ReadDataFormat(path)
+	if len(format) == 0 {
+		if format, in, err = DetectDataFormat(file); err != nil {
+			return err
+		} else if len(format) == 0 {
+			return fmt.Errorf("failed to detect data format of '%s'", path)
+		}
+	}
+	return LoadData(format, in, outp)
 }
 
 // WriteData attempts to write `data` as `format` to `outp`.
 func WriteData(format DataFormat, data interface{}, w io.Writer) error {
-	var err error
-
-	switch format {
-	case JSON:
-		if err = json.NewEncoder(w).Encode(data); err != nil {
-			err = fmt.Errorf("%s: %s", JSON, err.Error())
-		}
-	case YAML:
-		err = yaml.NewEncoder(w).Encode(data)
-	case TOML:
-		if err = toml.NewEncoder(w).Encode(data); err != nil {
-			err = fmt.Errorf("%s %s", TOML, err.Error())
-		}
-	default:
-		err = ErrUnsupportedData(format.String())
+	codec, ok := getDataCodec(format)
+	if !ok || codec.encode == nil {
+		return ErrUnsupportedData(format.String())
 	}
-
-	return err
+	return dataError(format, codec.encode(w, data))
 }
 
 // WriteDataFile attempts to write `data` as `format` to the file at `path`.
@@ -156,7 +255,7 @@ func WriteDataFile(format DataFormat, data interface{}, path string, force bool)
 		err = os.ErrExist
 	}
 
-	if err != nil  {
+	if err != nil {
 		return
 	}
 
@@ -0,0 +1,203 @@
+package dati
+
+/*
+Copyright (C) 2023 gearsix <gearsix@tuta.io>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// This file has the parts shared by the binary data formats (CBOR &
+// MessagePack). Both decode to the same types:
+//   - maps are decoded to map[string]interface{}, keys that aren't strings
+//     are converted to strings (e.g. 1 is "1").
+//   - arrays are decoded to []interface{}.
+//   - integers are decoded to int64 (or uint64, if they're too large).
+//   - floats are decoded to float64.
+//   - byte strings are decoded to []byte.
+
+// the maximum number of nested arrays/maps that will be decoded or encoded
+const binaryMaxDepth = 10000
+
+var (
+	errBinaryEOF   = errors.New("unexpected end of data")
+	errBinaryDepth = fmt.Errorf("data is nested more than %d levels deep", binaryMaxDepth)
+)
+
+// binaryReader reads bytes from `in`, it's used by the binary decoders.
+type binaryReader struct {
+	in  []byte
+	pos int
+}
+
+func (r *binaryReader) remaining() int {
+	return len(r.in) - r.pos
+}
+
+// read returns the next `n` bytes, or errBinaryEOF if there aren't enough
+func (r *binaryReader) read(n uint64) ([]byte, error) {
+	if n > uint64(r.remaining()) {
+		return nil, errBinaryEOF
+	}
+	b := r.in[r.pos : r.pos+int(n)]
+	r.pos += int(n)
+	return b, nil
+}
+
+func (r *binaryReader) readByte() (byte, error) {
+	b, err := r.read(1)
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+// readUint reads a big-endian unsigned integer of `n` bytes
+func (r *binaryReader) readUint(n int) (uint64, error) {
+	b, err := r.read(uint64(n))
+	if err != nil {
+		return 0, err
+	}
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v, nil
+}
+
+// checkLen returns an error if there aren't at least `n` bytes left, it's
+// used to avoid allocating space for items that don't exist.
+func (r *binaryReader) checkLen(n uint64) error {
+	if n > uint64(r.remaining()) {
+		return errBinaryEOF
+	}
+	return nil
+}
+
+// decoded unsigned integers are int64, unless they're too large
+func binaryUint(v uint64) interface{} {
+	if v > math.MaxInt64 {
+		return v
+	}
+	return int64(v)
+}
+
+// binaryKey normalises a decoded map key to a string
+func binaryKey(key interface{}) string {
+	if b, ok := key.([]byte); ok {
+		return string(b)
+	} else if s, ok := key.(string); ok {
+		return s
+	}
+	return fmt.Sprint(key)
+}
+
+// binaryWriter is implemented by the encoders of the binary data formats,
+// see `encodeBinary`.
+type binaryWriter interface {
+	writeNil()
+	writeBool(v bool)
+	writeInt(v int64)
+	writeUint(v uint64)
+	writeFloat(v float64)
+	writeString(v string)
+	writeBytes(v []byte)
+	writeTime(v time.Time)
+	writeArrayHeader(n int)
+	writeMapHeader(n int)
+}
+
+// encodeBinary writes `v` to `w`. Map keys are converted to strings and
+// sorted, any types that can't be written directly (e.g. structs) are
+// converted using `toGenericData`.
+func encodeBinary(w binaryWriter, v interface{}, depth int) error {
+	if depth > binaryMaxDepth {
+		return errBinaryDepth
+	}
+
+	switch val := v.(type) {
+	case nil:
+		w.writeNil()
+		return nil
+	case time.Time:
+		w.writeTime(val)
+		return nil
+	case []byte:
+		w.writeBytes(val)
+		return nil
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Bool:
+		w.writeBool(rv.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		w.writeInt(rv.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		w.writeUint(rv.Uint())
+	case reflect.Float32, reflect.Float64:
+		w.writeFloat(rv.Float())
+	case reflect.String:
+		w.writeString(rv.String())
+	case reflect.Ptr, reflect.Interface:
+		if rv.IsNil() {
+			w.writeNil()
+			return nil
+		}
+		return encodeBinary(w, rv.Elem().Interface(), depth+1)
+	case reflect.Map:
+		m, _ := toStringMap(v)
+		keys := make([]string, 0, len(m))
+		for key := range m {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		w.writeMapHeader(len(keys))
+		for _, key := range keys {
+			w.writeString(key)
+			if err := encodeBinary(w, m[key], depth+1); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		if rv.Type().Elem().Kind() == reflect.Uint8 {
+			b := make([]byte, rv.Len())
+			reflect.Copy(reflect.ValueOf(b), rv)
+			w.writeBytes(b)
+			return nil
+		}
+		l, _ := toList(v)
+		w.writeArrayHeader(len(l))
+		for _, item := range l {
+			if err := encodeBinary(w, item, depth+1); err != nil {
+				return err
+			}
+		}
+	default:
+		generic, err := toGenericData(v)
+		if err != nil {
+			return err
+		}
+		return encodeBinary(w, generic, depth+1)
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+//go:build go1.18
+// +build go1.18
+
+package dati
+
+/*
+Copyright (C) 2023 gearsix <gearsix@tuta.io>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"bytes"
+	"testing"
+)
+
+// fuzzBinary checks that `decode` doesn't panic on any input and that
+// anything it decodes can be encoded and decoded again. The results are
+// compared as encoded data, since NaN values aren't equal to themselves.
+func fuzzBinary(f *testing.F, good map[string]interface{}, bad []string, decode DataDecoder, encode DataEncoder) {
+	for in := range good {
+		f.Add(mustHex(f, in))
+	}
+	for _, in := range bad {
+		f.Add(mustHex(f, in))
+	}
+
+	f.Fuzz(func(t *testing.T, in []byte) {
+		var d interface{}
+		if err := decode(in, &d); err != nil {
+			return
+		}
+
+		var first, second bytes.Buffer
+		if err := encode(&first, d); err != nil {
+			t.Fatalf("failed to encode decoded data %#v: %s", d, err)
+		}
+		var again interface{}
+		if err := decode(first.Bytes(), &again); err != nil {
+			t.Fatalf("failed to decode encoded data %x: %s", first.Bytes(), err)
+		}
+		if err := encode(&second, again); err != nil {
+			t.Fatal(err)
+		} else if !bytes.Equal(first.Bytes(), second.Bytes()) {
+			t.Fatalf("round-trip result %x does not match %x", second.Bytes(), first.Bytes())
+		}
+	})
+}
+
+func FuzzCBOR(f *testing.F) {
+	fuzzBinary(f, cborGood, cborBad, decodeCBOR, encodeCBOR)
+}
+
+func FuzzMsgPack(f *testing.F) {
+	fuzzBinary(f, msgpackGood, msgpackBad, decodeMsgPack, encodeMsgPack)
+}
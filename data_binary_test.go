@@ -0,0 +1,239 @@
+package dati
+
+/*
+Copyright (C) 2023 gearsix <gearsix@tuta.io>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func mustHex(t testing.TB, s string) []byte {
+	b, err := hex.DecodeString(strings.Replace(s, " ", "", -1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestReadBinaryDataFormat(t *testing.T) {
+	for path, format := range map[string]DataFormat{
+		"x.cbor": CBOR, "CBOR": CBOR, "x.msgpack": MSGPACK, "x.mpk": MSGPACK,
+	} {
+		if f := ReadDataFormat(path); f != format {
+			t.Fatalf("'%s' returned '%s', not '%s'", path, f, format)
+		}
+	}
+}
+
+// some of these are from the examples in RFC 8949, appendix A
+var cborGood = map[string]interface{}{
+	"00":                         int64(0),
+	"1903e8":                     int64(1000),
+	"3903e7":                     int64(-1000),
+	"1bffffffffffffffff":         uint64(math.MaxUint64),
+	"f93c00":                     1.0,
+	"f9c400":                     -4.0,
+	"f97c00":                     math.Inf(1),
+	"fa47c35000":                 100000.0,
+	"fb3ff199999999999a":         1.1,
+	"f4":                         false,
+	"f5":                         true,
+	"f6":                         nil,
+	"f7":                         nil,
+	"4401020304":                 []byte{1, 2, 3, 4},
+	"6449455446":                 "IETF",
+	"7f657374726561646d696e67ff": "streaming",
+	"c074323031332d30332d32315432303a30343a30305a": "2013-03-21T20:04:00Z",
+	"83010203":               []interface{}{int64(1), int64(2), int64(3)},
+	"9f018202039f0405ffff":   []interface{}{int64(1), []interface{}{int64(2), int64(3)}, []interface{}{int64(4), int64(5)}},
+	"a201020304":             map[string]interface{}{"1": int64(2), "3": int64(4)},
+	"bf61610161629f0203ffff": map[string]interface{}{"a": int64(1), "b": []interface{}{int64(2), int64(3)}},
+}
+
+var cborBad = []string{
+	"",                    // empty
+	"19 03",               // missing argument bytes
+	"1c",                  // reserved additional information
+	"5f 61 61 ff",         // text chunk in byte string
+	"9b ffffffffffffffff", // array longer than the data
+	"ff",                  // unexpected break
+	"f8 20",               // unsupported simple value
+	"3b ffffffffffffffff", // negative integer overflow
+	"00 00",               // trailing data
+}
+
+func TestLoadCBORData(t *testing.T) {
+	for in, expect := range cborGood {
+		var d interface{}
+		if err := decodeCBOR(mustHex(t, in), &d); err != nil {
+			t.Fatalf("%s: %s", in, err)
+		} else if !reflect.DeepEqual(d, expect) {
+			t.Fatalf("%s: invalid result %#v should be %#v", in, d, expect)
+		}
+	}
+
+	var d interface{}
+	if err := decodeCBOR(mustHex(t, "f97e00"), &d); err != nil || !math.IsNaN(d.(float64)) {
+		t.Fatalf("NaN decoded as %v (%v)", d, err)
+	}
+
+	for _, in := range cborBad {
+		if err := LoadData(CBOR, bytes.NewReader(mustHex(t, in)), &d); err == nil && len(in) > 0 {
+			t.Fatalf("bad data passed: %s", in)
+		} else if err != nil && !strings.HasPrefix(err.Error(), "cbor: ") {
+			t.Fatalf("error does not indicate format: %s", err)
+		}
+	}
+
+	nested := bytes.Repeat([]byte{0x81}, binaryMaxDepth+2)
+	if err := decodeCBOR(append(nested, 0x00), &d); err != errBinaryDepth {
+		t.Fatalf("deeply nested data returned: %v", err)
+	}
+}
+
+var msgpackGood = map[string]interface{}{
+	"00":                             int64(0),
+	"7f":                             int64(127),
+	"ff":                             int64(-1),
+	"d080":                           int64(-128),
+	"cd0100":                         int64(256),
+	"d1ff00":                         int64(-256),
+	"cfffffffffffffffff":             uint64(math.MaxUint64),
+	"ca3fc00000":                     1.5,
+	"cb3ff199999999999a":             1.1,
+	"c0":                             nil,
+	"c2":                             false,
+	"c3":                             true,
+	"a3616263":                       "abc",
+	"d903616263":                     "abc",
+	"c4020102":                       []byte{1, 2},
+	"d40102":                         []byte{2},
+	"d6ff00000001":                   time.Unix(1, 0).UTC(),
+	"d7ff0000000400000001":           time.Unix(1, 1).UTC(),
+	"c70cff00000001ffffffffffffffff": time.Unix(-1, 1).UTC(),
+	"9301a161c3":                     []interface{}{int64(1), "a", true},
+	"dc0001c0":                       []interface{}{nil},
+	"820102a162c0":                   map[string]interface{}{"1": int64(2), "b": nil},
+	"81c3c2":                         map[string]interface{}{"true": false},
+}
+
+var msgpackBad = []string{
+	"",                      // empty
+	"c1",                    // never used
+	"cd01",                  // missing bytes
+	"a3 6162",               // string longer than the data
+	"dd ffffffff",           // array longer than the data
+	"d6ff",                  // missing timestamp
+	"d5ff0000",              // invalid timestamp length
+	"d7ff ffffffff00000000", // invalid timestamp nanoseconds
+	"00 00",                 // trailing data
+}
+
+func TestLoadMsgPackData(t *testing.T) {
+	for in, expect := range msgpackGood {
+		var d interface{}
+		if err := decodeMsgPack(mustHex(t, in), &d); err != nil {
+			t.Fatalf("%s: %s", in, err)
+		} else if !reflect.DeepEqual(d, expect) {
+			t.Fatalf("%s: invalid result %#v should be %#v", in, d, expect)
+		}
+	}
+
+	var d interface{}
+	for _, in := range msgpackBad {
+		if err := LoadData(MSGPACK, bytes.NewReader(mustHex(t, in)), &d); err == nil && len(in) > 0 {
+			t.Fatalf("bad data passed: %s", in)
+		} else if err != nil && !strings.HasPrefix(err.Error(), "msgpack: ") {
+			t.Fatalf("error does not indicate format: %s", err)
+		}
+	}
+
+	nested := bytes.Repeat([]byte{0x91}, binaryMaxDepth+2)
+	if err := decodeMsgPack(append(nested, 0x00), &d); err != errBinaryDepth {
+		t.Fatalf("deeply nested data returned: %v", err)
+	}
+}
+
+func TestWriteBinaryData(t *testing.T) {
+	type record struct {
+		Name string `json:"name"`
+		N    int    `json:"n"`
+	}
+	data := map[string]interface{}{
+		"a": 1,
+		"b": []interface{}{true, nil, -33},
+		"c": 1.5,
+		"d": []byte("x"),
+		"e": map[int]string{1: "x"},
+		"f": &record{"x", 1},
+		"g": time.Unix(1, 0).UTC(),
+	}
+
+	for format, expect := range map[DataFormat]string{
+		CBOR: "a7 6161 01 6162 83 f5 f6 3820 6163 fb3ff8000000000000 6164 4178" +
+			"6165 a1 6131 6178 6166 a2 616e 01 646e616d65 6178" +
+			"6167 c0 74 313937302d30312d30315430303a30303a30315a",
+		MSGPACK: "87 a161 01 a162 93 c3 c0 d0df a163 cb3ff8000000000000 a164 c40178" +
+			"a165 81 a131 a178 a166 82 a16e 01 a46e616d65 a178" +
+			"a167 d6ff00000001",
+	} {
+		var buf bytes.Buffer
+		if err := WriteData(format, data, &buf); err != nil {
+			t.Fatalf("%s: %s", format, err)
+		} else if !bytes.Equal(buf.Bytes(), mustHex(t, expect)) {
+			t.Fatalf("%s: invalid result %x should be %x", format, buf.Bytes(), mustHex(t, expect))
+		}
+
+		var d map[string]interface{}
+		if err := LoadData(format, &buf, &d); err != nil {
+			t.Fatalf("%s: %s", format, err)
+		} else if d["e"].(map[string]interface{})["1"] != "x" {
+			t.Fatalf("%s: map keys not normalised: %v", format, d["e"])
+		}
+
+		if err := WriteData(format, map[string]interface{}{"x": func() {}}, &buf); err == nil {
+			t.Fatalf("%s: bad data passed", format)
+		}
+	}
+}
+
+func TestExecuteBinaryData(t *testing.T) {
+	var d interface{}
+	if err := LoadData(MSGPACK, bytes.NewReader(mustHex(t, "81 01 a3616263")), &d); err != nil {
+		t.Skip("setup failure:", err)
+	}
+
+	for lang, root := range map[TemplateLanguage]string{
+		TMPL: `{{index . "1"}}`, HMPL: `{{index . "1"}}`, MST: `{{1}}`,
+	} {
+		template, err := LoadTemplateString(lang, "binary", root, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result, err := template.Execute(d); err != nil {
+			t.Fatal(err)
+		} else if result.String() != "abc" {
+			t.Fatalf("%s: invalid result '%s'", lang, result.String())
+		}
+	}
+}
@@ -0,0 +1,348 @@
+package dati
+
+/*
+Copyright (C) 2023 gearsix <gearsix@tuta.io>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+const CBOR DataFormat = "cbor"
+
+func init() {
+	RegisterDataFormat(CBOR, []string{"cbor"}, decodeCBOR, encodeCBOR)
+	RegisterDataSniffer(CBOR, func(head []byte) bool {
+		return bytes.HasPrefix(head, []byte{0xd9, 0xd9, 0xf7}) // self-described CBOR tag
+	})
+}
+
+// cbor major types
+const (
+	cborUint = iota
+	cborNegInt
+	cborBytes
+	cborText
+	cborArray
+	cborMap
+	cborTag
+	cborSimple
+)
+
+const cborIndefinite = 31
+const cborBreak = 0xff
+
+// decodeCBOR decodes CBOR data (see https://www.rfc-editor.org/rfc/rfc8949)
+// to the types listed in data_binary.go. Tags are ignored (only the tagged
+// value is decoded) and "undefined" is decoded as nil.
+func decodeCBOR(in []byte, out interface{}) error {
+	d := cborDecoder{binaryReader{in: in}}
+	v, err := d.value(0)
+	if err != nil {
+		return err
+	} else if d.remaining() > 0 {
+		return fmt.Errorf("unexpected data after offset %d", d.pos)
+	}
+	return setData(v, out)
+}
+
+type cborDecoder struct {
+	binaryReader
+}
+
+// head reads the initial byte & argument of the next item. `arg` isn't set
+// if `info` is cborIndefinite.
+func (d *cborDecoder) head() (major, info byte, arg uint64, err error) {
+	var b byte
+	if b, err = d.readByte(); err != nil {
+		return
+	}
+	major, info = b>>5, b&0x1f
+
+	switch {
+	case info < 24:
+		arg = uint64(info)
+	case info <= 27:
+		arg, err = d.readUint(1 << (info - 24))
+	case info == cborIndefinite && major >= cborBytes && major != cborTag:
+	default:
+		err = fmt.Errorf("invalid additional information %d at offset %d", info, d.pos-1)
+	}
+	return
+}
+
+// returns true (and skips it) if the next byte is a "break" code
+func (d *cborDecoder) isBreak() (bool, error) {
+	if d.remaining() == 0 {
+		return false, errBinaryEOF
+	} else if d.in[d.pos] == cborBreak {
+		d.pos++
+		return true, nil
+	}
+	return false, nil
+}
+
+func (d *cborDecoder) value(depth int) (interface{}, error) {
+	if depth > binaryMaxDepth {
+		return nil, errBinaryDepth
+	}
+
+	offset := d.pos
+	major, info, arg, err := d.head()
+	if err != nil {
+		return nil, err
+	}
+
+	switch major {
+	case cborUint:
+		return binaryUint(arg), nil
+	case cborNegInt:
+		if arg > math.MaxInt64 {
+			return nil, fmt.Errorf("negative integer at offset %d is too large", offset)
+		}
+		return -1 - int64(arg), nil
+	case cborBytes, cborText:
+		b, err := d.str(major, info, arg)
+		if err != nil {
+			return nil, err
+		} else if major == cborText {
+			return string(b), nil
+		}
+		return b, nil
+	case cborArray:
+		if info != cborIndefinite {
+			if err = d.checkLen(arg); err != nil {
+				return nil, err
+			}
+		}
+		list := make([]interface{}, 0, int(arg))
+		for i := uint64(0); info == cborIndefinite || i < arg; i++ {
+			if info == cborIndefinite {
+				if end, err := d.isBreak(); err != nil {
+					return nil, err
+				} else if end {
+					break
+				}
+			}
+			item, err := d.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, item)
+		}
+		return list, nil
+	case cborMap:
+		if info != cborIndefinite {
+			if err = d.checkLen(arg); err != nil {
+				return nil, err
+			}
+		}
+		m := make(map[string]interface{}, int(arg))
+		for i := uint64(0); info == cborIndefinite || i < arg; i++ {
+			if info == cborIndefinite {
+				if end, err := d.isBreak(); err != nil {
+					return nil, err
+				} else if end {
+					break
+				}
+			}
+			key, err := d.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			val, err := d.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			m[binaryKey(key)] = val
+		}
+		return m, nil
+	case cborTag:
+		return d.value(depth + 1)
+	default: // cborSimple
+		switch info {
+		case 20:
+			return false, nil
+		case 21:
+			return true, nil
+		case 22, 23:
+			return nil, nil
+		case 25:
+			return halfToFloat(uint16(arg)), nil
+		case 26:
+			return float64(math.Float32frombits(uint32(arg))), nil
+		case 27:
+			return math.Float64frombits(arg), nil
+		case cborIndefinite:
+			return nil, fmt.Errorf("unexpected break at offset %d", offset)
+		default:
+			return nil, fmt.Errorf("unsupported simple value at offset %d", offset)
+		}
+	}
+}
+
+// str reads the contents of a byte or text string
+func (d *cborDecoder) str(major, info byte, arg uint64) ([]byte, error) {
+	if info != cborIndefinite {
+		b, err := d.read(arg)
+		return append([]byte(nil), b...), err
+	}
+
+	var b []byte
+	for {
+		if end, err := d.isBreak(); err != nil {
+			return nil, err
+		} else if end {
+			return b, nil
+		}
+
+		offset := d.pos
+		chunkMajor, chunkInfo, chunkArg, err := d.head()
+		if err != nil {
+			return nil, err
+		} else if chunkMajor != major || chunkInfo == cborIndefinite {
+			return nil, fmt.Errorf("invalid string chunk at offset %d", offset)
+		}
+		chunk, err := d.read(chunkArg)
+		if err != nil {
+			return nil, err
+		}
+		b = append(b, chunk...)
+	}
+}
+
+// converts an IEEE 754 half-precision float to a float64
+func halfToFloat(h uint16) float64 {
+	exp := int(h>>10) & 0x1f
+	frac := float64(h & 0x3ff)
+
+	var f float64
+	switch exp {
+	case 0:
+		f = math.Ldexp(frac, -24)
+	case 0x1f:
+		if frac == 0 {
+			f = math.Inf(1)
+		} else {
+			f = math.NaN()
+		}
+	default:
+		f = math.Ldexp(frac+1024, exp-25)
+	}
+
+	if h&0x8000 != 0 {
+		return -f
+	}
+	return f
+}
+
+// encodeCBOR encodes `data` as CBOR, see `encodeBinary`. Integers are
+// written using the smallest possible encoding, floats are always 64-bit and
+// times are written as RFC 3339 strings (tag 0).
+func encodeCBOR(w io.Writer, data interface{}) error {
+	var e cborEncoder
+	if err := encodeBinary(&e, data, 0); err != nil {
+		return err
+	}
+	_, err := w.Write(e.buf)
+	return err
+}
+
+type cborEncoder struct {
+	buf []byte
+}
+
+func (e *cborEncoder) head(major byte, arg uint64) {
+	major <<= 5
+	switch {
+	case arg < 24:
+		e.buf = append(e.buf, major|byte(arg))
+	case arg <= math.MaxUint8:
+		e.buf = append(e.buf, major|24, byte(arg))
+	case arg <= math.MaxUint16:
+		e.buf = append(e.buf, major|25, byte(arg>>8), byte(arg))
+	case arg <= math.MaxUint32:
+		e.buf = append(e.buf, major|26)
+		e.buf = appendUint(e.buf, arg, 4)
+	default:
+		e.buf = append(e.buf, major|27)
+		e.buf = appendUint(e.buf, arg, 8)
+	}
+}
+
+// appends `v` to `buf` as a big-endian unsigned integer of `n` bytes
+func appendUint(buf []byte, v uint64, n int) []byte {
+	for i := n - 1; i >= 0; i-- {
+		buf = append(buf, byte(v>>(8*uint(i))))
+	}
+	return buf
+}
+
+func (e *cborEncoder) writeNil() {
+	e.buf = append(e.buf, 0xf6)
+}
+
+func (e *cborEncoder) writeBool(v bool) {
+	if v {
+		e.buf = append(e.buf, 0xf5)
+	} else {
+		e.buf = append(e.buf, 0xf4)
+	}
+}
+
+func (e *cborEncoder) writeInt(v int64) {
+	if v < 0 {
+		e.head(cborNegInt, uint64(-1-v))
+	} else {
+		e.head(cborUint, uint64(v))
+	}
+}
+
+func (e *cborEncoder) writeUint(v uint64) {
+	e.head(cborUint, v)
+}
+
+func (e *cborEncoder) writeFloat(v float64) {
+	e.buf = append(e.buf, 0xfb)
+	e.buf = appendUint(e.buf, math.Float64bits(v), 8)
+}
+
+func (e *cborEncoder) writeString(v string) {
+	e.head(cborText, uint64(len(v)))
+	e.buf = append(e.buf, v...)
+}
+
+func (e *cborEncoder) writeBytes(v []byte) {
+	e.head(cborBytes, uint64(len(v)))
+	e.buf = append(e.buf, v...)
+}
+
+func (e *cborEncoder) writeTime(v time.Time) {
+	e.head(cborTag, 0)
+	e.writeString(v.Format(time.RFC3339Nano))
+}
+
+func (e *cborEncoder) writeArrayHeader(n int) {
+	e.head(cborArray, uint64(n))
+}
+
+func (e *cborEncoder) writeMapHeader(n int) {
+	e.head(cborMap, uint64(n))
+}
@@ -0,0 +1,228 @@
+package dati
+
+/*
+Copyright (C) 2023 gearsix <gearsix@tuta.io>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	CSV DataFormat = "csv"
+	TSV DataFormat = "tsv"
+)
+
+func init() {
+	RegisterDataFormat(CSV, []string{"csv"},
+		NewCSVDecoder(CSVOptions{Comma: ','}), NewCSVEncoder(CSVOptions{Comma: ','}))
+	RegisterDataFormat(TSV, []string{"tsv"},
+		NewCSVDecoder(CSVOptions{Comma: '\t'}), NewCSVEncoder(CSVOptions{Comma: '\t'}))
+}
+
+// CSVOptions sets how CSV (and TSV) data is decoded & encoded, see
+// `NewCSVDecoder` and `NewCSVEncoder`.
+type CSVOptions struct {
+	// Comma is the field delimiter, if not set ',' is used.
+	Comma rune
+	// NoHeader should be true if the first record is not a header row.
+	NoHeader bool
+	// InferTypes will decode any fields that are numbers or booleans
+	// ("true", "false") as such, instead of as strings. "NaN" & "Inf" are
+	// left as strings.
+	InferTypes bool
+}
+
+func (opts CSVOptions) comma() rune {
+	if opts.Comma == 0 {
+		return ','
+	}
+	return opts.Comma
+}
+
+// NewCSVDecoder returns a *DataDecoder* that decodes CSV data using `opts`.
+// By default, each record is decoded to a map, keyed by the fields of the
+// header row. If `opts.NoHeader` is set, each record is decoded to a list of
+// fields. The result is a list of all records.
+// To change how CSV files are decoded, register the result with
+// `RegisterDataFormat`.
+func NewCSVDecoder(opts CSVOptions) DataDecoder {
+	return func(in []byte, out interface{}) error {
+		r := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(in, []byte("\xef\xbb\xbf"))))
+		r.Comma = opts.comma()
+
+		records, err := r.ReadAll()
+		if err != nil {
+			return err
+		}
+
+		var header []string
+		if !opts.NoHeader && len(records) > 0 {
+			header = records[0]
+			records = records[1:]
+		}
+
+		data := make([]interface{}, 0, len(records))
+		for _, record := range records {
+			if header != nil {
+				row := make(map[string]interface{}, len(header))
+				for i, field := range record {
+					row[header[i]] = opts.decodeField(field)
+				}
+				data = append(data, row)
+			} else {
+				row := make([]interface{}, len(record))
+				for i, field := range record {
+					row[i] = opts.decodeField(field)
+				}
+				data = append(data, row)
+			}
+		}
+
+		return setData(data, out)
+	}
+}
+
+func (opts CSVOptions) decodeField(field string) interface{} {
+	if !opts.InferTypes {
+		return field
+	}
+
+	if i, err := strconv.ParseInt(field, 10, 64); err == nil {
+		return i
+	} else if f, err := strconv.ParseFloat(field, 64); err == nil && !math.IsNaN(f) && !math.IsInf(f, 0) {
+		return f
+	} else if strings.EqualFold(field, "true") {
+		return true
+	} else if strings.EqualFold(field, "false") {
+		return false
+	}
+	return field
+}
+
+// NewCSVEncoder returns a *DataEncoder* that encodes data as CSV using `opts`.
+// The data should be a list of maps, structs or lists (a single map or
+// struct is written as a single record). A header row is written of all the
+// map keys (sorted) or struct field names (the `csv` field tag can be used
+// to set the name, or "-" to skip the field), unless `opts.NoHeader` is set.
+// To change how CSV files are encoded, register the result with
+// `RegisterDataFormat`.
+func NewCSVEncoder(opts CSVOptions) DataEncoder {
+	return func(w io.Writer, data interface{}) error {
+		rows, ok := toList(data)
+		if !ok {
+			rows = []interface{}{data}
+		}
+
+		var header []string
+		var records [][]string
+		for _, row := range rows {
+			rv := reflect.Indirect(reflect.ValueOf(row))
+			if !rv.IsValid() {
+				continue
+			}
+
+			if m, ok := toStringMap(row); ok {
+				if header == nil {
+					header = csvMapHeader(rows)
+				}
+				record := make([]string, len(header))
+				for i, key := range header {
+					record[i] = csvField(m[key])
+				}
+				records = append(records, record)
+			} else if rv.Kind() == reflect.Struct {
+				fields, names := csvStructFields(rv)
+				if header == nil {
+					header = names
+				}
+				record := make([]string, len(fields))
+				for i, field := range fields {
+					record[i] = csvField(field)
+				}
+				records = append(records, record)
+			} else if l, ok := toList(row); ok {
+				record := make([]string, len(l))
+				for i, field := range l {
+					record[i] = csvField(field)
+				}
+				records = append(records, record)
+			} else {
+				return fmt.Errorf("can't encode %T as a record", row)
+			}
+		}
+
+		cw := csv.NewWriter(w)
+		cw.Comma = opts.comma()
+		if header != nil && !opts.NoHeader {
+			records = append([][]string{header}, records...)
+		}
+		return cw.WriteAll(records)
+	}
+}
+
+// returns the sorted keys of every map in `rows`
+func csvMapHeader(rows []interface{}) (header []string) {
+	keys := make(map[string]bool)
+	for _, row := range rows {
+		if m, ok := toStringMap(row); ok {
+			for key := range m {
+				if !keys[key] {
+					keys[key] = true
+					header = append(header, key)
+				}
+			}
+		}
+	}
+	sort.Strings(header)
+	return
+}
+
+// returns the values & names of all exported fields in `rv`
+func csvStructFields(rv reflect.Value) (fields []interface{}, names []string) {
+	for i := 0; i < rv.NumField(); i++ {
+		field := rv.Type().Field(i)
+		if field.PkgPath != "" { // unexported
+			continue
+		}
+
+		name := field.Name
+		if tag := strings.Split(field.Tag.Get("csv"), ",")[0]; tag == "-" {
+			continue
+		} else if len(tag) > 0 {
+			name = tag
+		}
+
+		fields = append(fields, rv.Field(i).Interface())
+		names = append(names, name)
+	}
+	return
+}
+
+func csvField(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	return fmt.Sprint(v)
+}
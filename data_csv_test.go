@@ -0,0 +1,139 @@
+package dati
+
+/*
+Copyright (C) 2023 gearsix <gearsix@tuta.io>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const csvGood = "\xef\xbb\xbfname,age,member\nx,1,true\n\"y, z\",2.5,false\n"
+const tsvGood = "name\tage\tmember\nx\t1\ttrue\n"
+const csvBad = "name,age\nx,1,2\n"
+
+func TestReadCSVDataFormat(t *testing.T) {
+	for path, format := range map[string]DataFormat{
+		"x.csv": CSV, "CSV": CSV, "x.tsv": TSV, ".TSV": TSV,
+	} {
+		if f := ReadDataFormat(path); f != format {
+			t.Fatalf("'%s' returned '%s', not '%s'", path, f, format)
+		}
+	}
+}
+
+func TestLoadCSVData(t *testing.T) {
+	var d []map[string]interface{}
+	if err := LoadData(CSV, strings.NewReader(csvGood), &d); err != nil {
+		t.Fatal(err)
+	}
+	expect := []map[string]interface{}{
+		{"name": "x", "age": "1", "member": "true"},
+		{"name": "y, z", "age": "2.5", "member": "false"},
+	}
+	if !reflect.DeepEqual(d, expect) {
+		t.Fatalf("invalid result: %v should be %v", d, expect)
+	}
+
+	var i interface{}
+	if err := LoadData(TSV, strings.NewReader(tsvGood), &i); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(i, []interface{}{expect[0]}) {
+		t.Fatalf("invalid result: %v", i)
+	}
+
+	typed := NewCSVDecoder(CSVOptions{InferTypes: true})
+	if err := typed([]byte(csvGood), &i); err != nil {
+		t.Fatal(err)
+	} else if expect := []interface{}{
+		map[string]interface{}{"name": "x", "age": int64(1), "member": true},
+		map[string]interface{}{"name": "y, z", "age": 2.5, "member": false},
+	}; !reflect.DeepEqual(i, expect) {
+		t.Fatalf("invalid result: %v should be %v", i, expect)
+	}
+
+	if err := typed([]byte("a,b,c,d\nnan,Inf,-infinity,1e400\n"), &i); err != nil {
+		t.Fatal(err)
+	} else if expect := []interface{}{
+		map[string]interface{}{"a": "nan", "b": "Inf", "c": "-infinity", "d": "1e400"},
+	}; !reflect.DeepEqual(i, expect) {
+		t.Fatalf("invalid result: %v should be %v", i, expect)
+	}
+
+	noHeader := NewCSVDecoder(CSVOptions{Comma: ';', NoHeader: true})
+	if err := noHeader([]byte("a;b\n1;2\n"), &i); err != nil {
+		t.Fatal(err)
+	} else if expect := []interface{}{
+		[]interface{}{"a", "b"}, []interface{}{"1", "2"},
+	}; !reflect.DeepEqual(i, expect) {
+		t.Fatalf("invalid result: %v should be %v", i, expect)
+	}
+
+	if err := LoadData(CSV, strings.NewReader(csvBad), &i); err == nil {
+		t.Fatal("bad data passed")
+	} else if !strings.HasPrefix(err.Error(), "csv: ") {
+		t.Fatalf("error does not indicate format: %s", err)
+	}
+}
+
+func TestWriteCSVData(t *testing.T) {
+	var buf bytes.Buffer
+
+	var d interface{}
+	if err := LoadData(CSV, strings.NewReader(csvGood), &d); err != nil {
+		t.Skip("setup failure:", err)
+	}
+	if err := WriteData(CSV, d, &buf); err != nil {
+		t.Fatal(err)
+	} else if expect := "age,member,name\n1,true,x\n2.5,false,\"y, z\"\n"; buf.String() != expect {
+		t.Fatalf("invalid result: %s should be %s", buf.String(), expect)
+	}
+
+	var again interface{}
+	if err := LoadData(CSV, &buf, &again); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(d, again) {
+		t.Fatalf("round-trip result %v does not match %v", again, d)
+	}
+
+	type record struct {
+		Name    string `csv:"name"`
+		Age     float64
+		Skipped string `csv:"-"`
+		private string
+	}
+	buf.Reset()
+	if err := WriteData(TSV, []*record{{"x", 1, "", ""}, {"y", 2.5, "", ""}}, &buf); err != nil {
+		t.Fatal(err)
+	} else if expect := "name\tAge\nx\t1\ny\t2.5\n"; buf.String() != expect {
+		t.Fatalf("invalid result: %s should be %s", buf.String(), expect)
+	}
+
+	buf.Reset()
+	noHeader := NewCSVEncoder(CSVOptions{NoHeader: true})
+	if err := noHeader(&buf, map[string]interface{}{"a": 1, "b": nil}); err != nil {
+		t.Fatal(err)
+	} else if buf.String() != "1,\n" {
+		t.Fatalf("invalid result: %s", buf.String())
+	}
+
+	if err := WriteData(CSV, []interface{}{1}, &buf); err == nil {
+		t.Fatal("bad data passed")
+	}
+}
@@ -0,0 +1,147 @@
+package dati
+
+/*
+Copyright (C) 2023 gearsix <gearsix@tuta.io>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"regexp"
+)
+
+// the maximum number of bytes read by `DetectDataFormat`
+const dataSniffLen = 4096
+
+// DataSniffer is the function signature used to check if `head` (the first
+// bytes of some data) looks like a registered *DataFormat*. `head` may
+// be cut off at any point if the data is long.
+type DataSniffer func(head []byte) bool
+
+var dataSniffers = make(map[DataFormat]DataSniffer) // guarded by dataCodecsMu
+
+func init() {
+	RegisterDataSniffer(JSON, func(head []byte) bool {
+		n, ok := sniffJSON(head)
+		return ok && n == 1
+	})
+	RegisterDataSniffer(YAML, sniffYAML)
+	RegisterDataSniffer(TOML, sniffTOML)
+}
+
+// RegisterDataSniffer sets `sniffer` as the *DataSniffer* used by
+// `DetectDataFormat` for `format`. `format` should also be registered with
+// `RegisterDataFormat`, which doesn't change the sniffer of a format.
+// If `sniffer` is nil, `format` won't be detected.
+func RegisterDataSniffer(format DataFormat, sniffer DataSniffer) {
+	dataCodecsMu.Lock()
+	defer dataCodecsMu.Unlock()
+	if sniffer == nil {
+		delete(dataSniffers, format)
+	} else {
+		dataSniffers[format] = sniffer
+	}
+}
+
+// DetectDataFormat reads the first few bytes of `r` and returns the first
+// registered *DataFormat* (in the order they were registered) that has a
+// *DataSniffer* that matches them. If no format matches, "" is returned.
+// The returned io.Reader reads all the data from `r`, including the bytes
+// that were read to detect the format.
+func DetectDataFormat(r io.Reader) (DataFormat, io.Reader, error) {
+	br := bufio.NewReaderSize(r, dataSniffLen)
+	head, err := br.Peek(dataSniffLen)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return "", br, err
+	}
+
+	dataCodecsMu.RLock()
+	defer dataCodecsMu.RUnlock()
+	for _, format := range dataFormats {
+		if sniffer, ok := dataSniffers[format]; ok && sniffer(head) {
+			return format, br, nil
+		}
+	}
+	return "", br, nil
+}
+
+// sniffJSON returns the number of json values at the start of `head`, ok is
+// false if `head` isn't an object or list or has a syntax error.
+func sniffJSON(head []byte) (n int, ok bool) {
+	if c := firstByte(head); c != '{' && c != '[' {
+		return 0, false
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(head))
+	for {
+		var v json.RawMessage
+		switch err := dec.Decode(&v); err {
+		case nil:
+			n++
+		case io.EOF:
+			return n, n > 0
+		case io.ErrUnexpectedEOF: // `head` was cut off
+			return n + 1, true
+		default:
+			return n, false
+		}
+	}
+}
+
+var (
+	yamlStart     = regexp.MustCompile(`^(---|%YAML|- |[^\s#:={}\[\]<>"'-][^:=]*:(\s|$))`)
+	tomlTable     = regexp.MustCompile(`^\[\[?[^\[\]=]+\]\]?\s*(#.*)?$`)
+	tomlKeyValue  = regexp.MustCompile(`^[\w\-."' ]+=\s*(["'\[{+\-\d]|true|false|inf|nan)`)
+	sniffComments = regexp.MustCompile(`^\s*(#.*)?$`)
+)
+
+func sniffYAML(head []byte) bool {
+	line := firstLine(head)
+	if bytes.HasPrefix(line, []byte("//")) || bytes.HasPrefix(line, []byte("/*")) {
+		return false // a JSON5 (or similar) comment, e.g. "// note: x"
+	}
+	return yamlStart.Match(line)
+}
+
+func sniffTOML(head []byte) bool {
+	line := firstLine(head)
+	return tomlTable.Match(line) || tomlKeyValue.Match(line)
+}
+
+// firstLine returns the first line in `head` that isn't empty or a comment
+// (starting with "#"), with any trailing whitespace removed.
+func firstLine(head []byte) []byte {
+	head = bytes.TrimPrefix(head, []byte("\xef\xbb\xbf"))
+	for len(head) > 0 {
+		var line []byte
+		line, head = splitLine(head)
+		if !sniffComments.Match(line) {
+			return bytes.TrimRight(line, " \t\r")
+		}
+	}
+	return nil
+}
+
+// firstByte returns the first byte in `head` that isn't whitespace
+func firstByte(head []byte) byte {
+	head = bytes.TrimLeft(bytes.TrimPrefix(head, []byte("\xef\xbb\xbf")), " \t\r\n")
+	if len(head) == 0 {
+		return 0
+	}
+	return head[0]
+}
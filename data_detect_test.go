@@ -0,0 +1,123 @@
+package dati
+
+/*
+Copyright (C) 2023 gearsix <gearsix@tuta.io>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var detectGood = map[string]DataFormat{
+	`{"eg": 0}`:                      JSON,
+	"\n  [1, 2, 3]\n":                JSON,
+	"{\"a\": 1}\n{\"a\": 2}\n":       JSONL,
+	"// comment\n{eg: 0}":            JSON5,
+	"{eg: 0,}":                       JSON5,
+	"// note: x\n{eg: 0}":            JSON5,
+	"/* note: x */\n{eg: 0}":         JSON5,
+	"eg: 0\n":                        YAML,
+	"# comment\n\n---\neg: 0\n":      YAML,
+	"- a\n- b\n":                     YAML,
+	"eg = 0\n":                       TOML,
+	"# comment\n[table]\neg = 'x'\n": TOML,
+	"<?xml version=\"1.0\"?><eg/>":   XML,
+	"\n<eg>0</eg>":                   XML,
+	"\xd9\xd9\xf7\xa1\x62eg\x00":     CBOR,
+}
+
+var detectBad = []string{
+	"", "eg", "just some text", "eg = unquoted", "1", "\x00\x01",
+}
+
+func TestDetectDataFormat(t *testing.T) {
+	good := map[string]DataFormat{
+		`{"eg": [` + strings.Repeat(`"x",`, dataSniffLen) + `"x"]}`: JSON, // cut off
+	}
+	for in, expect := range detectGood {
+		good[in] = expect
+	}
+
+	for in, expect := range good {
+		format, r, err := DetectDataFormat(strings.NewReader(in))
+		if err != nil {
+			t.Fatal(err)
+		} else if format != expect {
+			t.Fatalf("'%.20s' returned '%s', not '%s'", in, format, expect)
+		}
+
+		if buf, err := ioutil.ReadAll(r); err != nil {
+			t.Fatal(err)
+		} else if string(buf) != in {
+			t.Fatalf("returned reader does not replay data: '%.20s'", buf)
+		}
+	}
+
+	for _, in := range detectBad {
+		if format, _, err := DetectDataFormat(strings.NewReader(in)); err != nil {
+			t.Fatal(err)
+		} else if format != "" {
+			t.Fatalf("'%s' returned '%s'", in, format)
+		}
+	}
+
+	const custom DataFormat = "custom"
+	RegisterDataFormat(custom, nil, nil, nil)
+	RegisterDataSniffer(custom, func(head []byte) bool {
+		return bytes.HasPrefix(head, []byte("CUSTOM"))
+	})
+	if format, _, _ := DetectDataFormat(strings.NewReader("CUSTOM")); format != custom {
+		t.Fatalf("registered sniffer not used: '%s'", format)
+	}
+	RegisterDataSniffer(custom, nil)
+	if format, _, _ := DetectDataFormat(strings.NewReader("CUSTOM")); format != "" {
+		t.Fatalf("removed sniffer used: '%s'", format)
+	}
+}
+
+func TestLoadDataFileDetect(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dati")
+	if err != nil {
+		t.Skip("setup failure:", err)
+	}
+	defer os.RemoveAll(dir)
+
+	var d map[string]interface{}
+	for _, in := range []string{`{"eg": 0}`, "eg: 0\n", "eg = 0\n"} {
+		path := filepath.Join(dir, "data")
+		if err := ioutil.WriteFile(path, []byte(in), 0644); err != nil {
+			t.Skip("setup failure:", err)
+		}
+		if err := LoadDataFile(path, &d); err != nil {
+			t.Fatal(err)
+		} else if len(d) != 1 || d["eg"] == nil {
+			t.Fatalf("invalid result for '%s': %v", in, d)
+		}
+	}
+
+	path := filepath.Join(dir, "bad")
+	if err := ioutil.WriteFile(path, []byte("eg"), 0644); err != nil {
+		t.Skip("setup failure:", err)
+	}
+	if err := LoadDataFile(path, &d); err == nil {
+		t.Fatal("undetectable data passed")
+	}
+}
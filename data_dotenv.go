@@ -0,0 +1,300 @@
+package dati
+
+/*
+Copyright (C) 2023 gearsix <gearsix@tuta.io>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+const DOTENV DataFormat = "dotenv"
+
+func init() {
+	RegisterDataFormat(DOTENV, []string{"env"}, NewDotenvDecoder(DotenvOptions{}), encodeDotenv)
+}
+
+// DotenvOptions sets how .env data is decoded, see `NewDotenvDecoder`.
+type DotenvOptions struct {
+	// ExpandEnv will expand variables that aren't set by an earlier key in
+	// the file using the process environment (see `os.Getenv`).
+	ExpandEnv bool
+}
+
+// NewDotenvDecoder returns a *DataDecoder* that decodes .env data using
+// `opts`. The result is a map of all the keys, all values are strings.
+// Each line is a "KEY=VALUE" pair (optionally prefixed with "export"), lines
+// starting with "#" are comments. Values can be:
+//   - unquoted, any whitespace and comments (" #...") after it are ignored.
+//   - single-quoted, the value is used as-is.
+//   - double-quoted, escape sequences ("\n", "\t", "\"", "\\", "\$") are
+//     unescaped.
+//
+// Quoted values can span multiple lines. Variables ("${KEY}" or "$KEY") in
+// unquoted & double-quoted values are expanded to the value of an earlier
+// key, or "" if it isn't set.
+func NewDotenvDecoder(opts DotenvOptions) DataDecoder {
+	return func(in []byte, out interface{}) error {
+		p := dotenvParser{src: strings.TrimPrefix(string(in), "\ufeff"), line: 1, opts: opts}
+		data := make(map[string]interface{})
+		p.data = data
+
+		for {
+			p.skip(" \t\r\n")
+			if p.done() {
+				break
+			} else if p.peek() == '#' {
+				p.skipLine()
+				continue
+			}
+
+			line := p.line
+			key := p.key()
+			if key == "export" && strings.ContainsRune(" \t", p.peek()) {
+				p.skip(" \t")
+				key = p.key()
+			}
+			if !isDotenvKey(key) {
+				return fmt.Errorf("line %d: invalid key '%s'", line, key)
+			}
+
+			p.skip(" \t")
+			if p.peek() != '=' {
+				return fmt.Errorf("line %d: missing '=' after key '%s'", line, key)
+			}
+			p.pos++
+			p.skip(" \t")
+
+			value, err := p.value()
+			if err != nil {
+				return fmt.Errorf("line %d: %s", line, err)
+			}
+			data[key] = value
+		}
+
+		return setData(data, out)
+	}
+}
+
+type dotenvParser struct {
+	src  string
+	pos  int
+	line int
+	opts DotenvOptions
+	data map[string]interface{}
+}
+
+func (p *dotenvParser) done() bool {
+	return p.pos >= len(p.src)
+}
+
+func (p *dotenvParser) peek() rune {
+	if p.done() {
+		return 0
+	}
+	return rune(p.src[p.pos])
+}
+
+func (p *dotenvParser) next() byte {
+	c := p.src[p.pos]
+	if p.pos++; c == '\n' {
+		p.line++
+	}
+	return c
+}
+
+func (p *dotenvParser) skip(chars string) {
+	for !p.done() && strings.IndexByte(chars, p.src[p.pos]) >= 0 {
+		p.next()
+	}
+}
+
+func (p *dotenvParser) skipLine() {
+	for !p.done() && p.next() != '\n' {
+	}
+}
+
+// returns everything up to the next whitespace or '='
+func (p *dotenvParser) key() string {
+	start := p.pos
+	for !p.done() && strings.IndexByte(" \t\r\n=", p.src[p.pos]) < 0 {
+		p.pos++
+	}
+	return p.src[start:p.pos]
+}
+
+func (p *dotenvParser) value() (string, error) {
+	var b strings.Builder
+	switch quote := p.peek(); quote {
+	case '\'', '"':
+		p.next()
+		for {
+			if p.done() {
+				return "", fmt.Errorf("missing closing %c", quote)
+			}
+			c := p.next()
+			if c == byte(quote) {
+				break
+			} else if quote == '\'' {
+				b.WriteByte(c)
+			} else if c == '$' {
+				b.WriteString(p.expand())
+			} else if c == '\\' && !p.done() {
+				switch e := p.next(); e {
+				case 'n':
+					b.WriteByte('\n')
+				case 'r':
+					b.WriteByte('\r')
+				case 't':
+					b.WriteByte('\t')
+				case '"', '\\', '$':
+					b.WriteByte(e)
+				default:
+					b.WriteByte('\\')
+					b.WriteByte(e)
+				}
+			} else {
+				b.WriteByte(c)
+			}
+		}
+
+		p.skip(" \t\r")
+		if !p.done() && p.peek() != '\n' && p.peek() != '#' {
+			return "", fmt.Errorf("unexpected characters after closing %c", quote)
+		}
+		p.skipLine()
+		return b.String(), nil
+	}
+
+	for !p.done() && p.peek() != '\n' {
+		if c := p.next(); c == '#' && strings.ContainsRune(" \t", rune(p.src[p.pos-2])) {
+			p.skipLine()
+			break
+		} else if c == '$' {
+			b.WriteString(p.expand())
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return strings.TrimSpace(b.String()), nil
+}
+
+// expand returns the value of the variable after a '$', or "$" if there's no
+// variable name.
+func (p *dotenvParser) expand() string {
+	braces := p.peek() == '{'
+	start := p.pos
+	if braces {
+		start++
+		if end := strings.IndexAny(p.src[start:], "}\n"); end >= 0 && p.src[start+end] == '}' {
+			p.pos = start + end + 1
+			return p.lookup(p.src[start : start+end])
+		}
+		return "$"
+	}
+
+	for !p.done() && isDotenvKeyChar(p.src[p.pos], p.pos == start) {
+		p.pos++
+	}
+	if p.pos == start {
+		return "$"
+	}
+	return p.lookup(p.src[start:p.pos])
+}
+
+func (p *dotenvParser) lookup(key string) string {
+	if v, ok := p.data[key]; ok {
+		return fmt.Sprint(v)
+	} else if p.opts.ExpandEnv {
+		return os.Getenv(key)
+	}
+	return ""
+}
+
+func isDotenvKey(key string) bool {
+	if len(key) == 0 {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if !isDotenvKeyChar(key[i], i == 0) && !(i > 0 && (key[i] == '.' || key[i] == '-')) {
+			return false
+		}
+	}
+	return true
+}
+
+func isDotenvKeyChar(c byte, first bool) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') ||
+		(!first && c >= '0' && c <= '9')
+}
+
+// encodeDotenv encodes `data` as .env data, it reverses the decoder returned
+// by `NewDotenvDecoder`. `data` must be a map (or struct) and values can't be
+// maps or lists. Values are only quoted when needed, single quotes are used
+// unless the value contains a "'" or a newline.
+func encodeDotenv(w io.Writer, data interface{}) error {
+	generic, err := toGenericData(data)
+	if err != nil {
+		return err
+	}
+	m, ok := generic.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("can't encode %T as dotenv", data)
+	}
+
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		if !isDotenvKey(key) {
+			return fmt.Errorf("invalid key '%s'", key)
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	bw := bufio.NewWriter(w)
+	for _, key := range keys {
+		var value string
+		switch v := m[key].(type) {
+		case nil:
+		case map[string]interface{}, []interface{}:
+			return fmt.Errorf("can't encode %T value of '%s' as dotenv", v, key)
+		default:
+			value = fmt.Sprint(v)
+		}
+		fmt.Fprintf(bw, "%s=%s\n", key, quoteDotenv(value))
+	}
+	return bw.Flush()
+}
+
+func quoteDotenv(value string) string {
+	safe := true
+	for i := 0; i < len(value) && safe; i++ {
+		safe = isDotenvKeyChar(value[i], false) || strings.IndexByte("./:@%+,-", value[i]) >= 0
+	}
+	if safe {
+		return value
+	} else if !strings.ContainsAny(value, "'\n\r") {
+		return "'" + value + "'"
+	}
+	return `"` + strings.NewReplacer(
+		`\`, `\\`, `"`, `\"`, `$`, `\$`, "\n", `\n`, "\r", `\r`, "\t", `\t`,
+	).Replace(value) + `"`
+}
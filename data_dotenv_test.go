@@ -0,0 +1,137 @@
+package dati
+
+/*
+Copyright (C) 2023 gearsix <gearsix@tuta.io>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const dotenvGood = `# comment
+HOST=localhost
+export PORT = 8080 # inline comment
+URL=http://${HOST}:$PORT/
+HASH=#notacomment
+LITERAL='${HOST} $PORT'
+QUOTED="a \"b\"\t$HOST\$"
+MULTI="line 1
+line 2"
+EMPTY=
+UNSET=${DATI_TEST_UNSET}
+`
+
+func TestReadDotenvDataFormat(t *testing.T) {
+	for path, format := range map[string]DataFormat{
+		".env": DOTENV, "x.env": DOTENV, "DOTENV": DOTENV,
+	} {
+		if f := ReadDataFormat(path); f != format {
+			t.Fatalf("'%s' returned '%s', not '%s'", path, f, format)
+		}
+	}
+}
+
+func TestLoadDotenvData(t *testing.T) {
+	os.Setenv("DATI_TEST_UNSET", "env")
+	defer os.Unsetenv("DATI_TEST_UNSET")
+
+	var d map[string]interface{}
+	if err := LoadData(DOTENV, strings.NewReader(dotenvGood), &d); err != nil {
+		t.Fatal(err)
+	}
+	expect := map[string]interface{}{
+		"HOST":    "localhost",
+		"PORT":    "8080",
+		"URL":     "http://localhost:8080/",
+		"HASH":    "#notacomment",
+		"LITERAL": "${HOST} $PORT",
+		"QUOTED":  "a \"b\"\tlocalhost$",
+		"MULTI":   "line 1\nline 2",
+		"EMPTY":   "",
+		"UNSET":   "",
+	}
+	if !reflect.DeepEqual(d, expect) {
+		t.Fatalf("invalid result: %v should be %v", d, expect)
+	}
+
+	expandEnv := NewDotenvDecoder(DotenvOptions{ExpandEnv: true})
+	if err := expandEnv([]byte(dotenvGood), &d); err != nil {
+		t.Fatal(err)
+	} else if d["UNSET"] != "env" {
+		t.Fatalf("process environment not expanded: %v", d["UNSET"])
+	}
+
+	for _, bad := range []string{
+		"1KEY=x\n", "KEY x\n", "KEY='x\n", "KEY=\"x\" y\n",
+	} {
+		if err := LoadData(DOTENV, strings.NewReader(bad), &d); err == nil {
+			t.Fatalf("bad data passed: %s", bad)
+		} else if !strings.HasPrefix(err.Error(), "dotenv: line 1: ") {
+			t.Fatalf("error does not indicate format & line: %s", err)
+		}
+	}
+}
+
+func TestWriteDotenvData(t *testing.T) {
+	var buf bytes.Buffer
+
+	var d interface{}
+	if err := LoadData(DOTENV, strings.NewReader(dotenvGood), &d); err != nil {
+		t.Skip("setup failure:", err)
+	}
+	if err := WriteData(DOTENV, d, &buf); err != nil {
+		t.Fatal(err)
+	} else if expect := `EMPTY=
+HASH='#notacomment'
+HOST=localhost
+LITERAL='${HOST} $PORT'
+MULTI="line 1\nline 2"
+PORT=8080
+QUOTED='a "b"	localhost$'
+UNSET=
+URL=http://localhost:8080/
+`; buf.String() != expect {
+		t.Fatalf("invalid result: %s should be %s", buf.String(), expect)
+	}
+
+	var again interface{}
+	if err := LoadData(DOTENV, &buf, &again); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(d, again) {
+		t.Fatalf("round-trip result %v does not match %v", again, d)
+	}
+
+	buf.Reset()
+	if err := WriteData(DOTENV, map[string]interface{}{"A": "it's $x\\"}, &buf); err != nil {
+		t.Fatal(err)
+	} else if expect := "A=\"it's \\$x\\\\\"\n"; buf.String() != expect {
+		t.Fatalf("invalid result: %s should be %s", buf.String(), expect)
+	}
+
+	for _, bad := range []interface{}{
+		[]string{"x"},
+		map[string]interface{}{"A B": "x"},
+		map[string]interface{}{"A": []string{"x"}},
+	} {
+		if err := WriteData(DOTENV, bad, &buf); err == nil {
+			t.Fatalf("bad data passed: %v", bad)
+		}
+	}
+}
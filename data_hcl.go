@@ -0,0 +1,757 @@
+package dati
+
+/*
+Copyright (C) 2023 gearsix <gearsix@tuta.io>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+const HCL DataFormat = "hcl"
+
+func init() {
+	RegisterDataFormat(HCL, []string{"hcl", "tfvars"}, decodeHCL, encodeHCL)
+}
+
+// the maximum number of nested blocks, lists & objects that will be decoded
+// or encoded
+const hclMaxDepth = 10000
+
+// decodeHCL decodes HCL attribute & block files (see
+// https://github.com/hashicorp/hcl/blob/main/hclsyntax/spec.md) to the same
+// types that YAML is decoded to.
+//   - attributes (`key = value`) are set to their key.
+//   - blocks (`type "label" { ... }`) are decoded to a map of their body,
+//     set to their type & labels (e.g. `{"type": {"label": {...}}}`). If
+//     more than one block has the same type & labels, the result is a list.
+//   - numbers are decoded to int (if they're integers) or float64, lists &
+//     objects are decoded to []interface{} & map[string]interface{}.
+//   - templates ("${...}" & "%{...}") in strings & heredocs aren't
+//     evaluated, they're decoded as-is.
+//   - any other expressions (e.g. `var.x`, `max(1, 2)`) are decoded to a
+//     string of the expression.
+func decodeHCL(in []byte, out interface{}) error {
+	p := hclParser{src: strings.TrimPrefix(string(in), "\xef\xbb\xbf"), line: 1}
+	data, err := p.body(0)
+	if err == nil && !p.done() {
+		err = p.errorf("unexpected '%c'", p.peek())
+	}
+	if err != nil {
+		return err
+	}
+	return setData(data, out)
+}
+
+type hclParser struct {
+	src  string
+	pos  int
+	line int
+}
+
+func (p *hclParser) errorf(format string, a ...interface{}) error {
+	return fmt.Errorf("line %d: %s", p.line, fmt.Sprintf(format, a...))
+}
+
+func (p *hclParser) done() bool {
+	return p.pos >= len(p.src)
+}
+
+func (p *hclParser) peek() rune {
+	r, _ := utf8.DecodeRuneInString(p.src[p.pos:])
+	return r
+}
+
+func (p *hclParser) next() rune {
+	r, size := utf8.DecodeRuneInString(p.src[p.pos:])
+	if p.pos += size; r == '\n' {
+		p.line++
+	}
+	return r
+}
+
+func (p *hclParser) hasPrefix(s string) bool {
+	return strings.HasPrefix(p.src[p.pos:], s)
+}
+
+// skipSpace skips any whitespace & comments, newlines are only skipped if
+// `newlines` is true.
+func (p *hclParser) skipSpace(newlines bool) error {
+	for !p.done() {
+		switch r := p.peek(); {
+		case r == ' ' || r == '\t' || r == '\r' || (newlines && r == '\n'):
+			p.next()
+		case r == '#' || p.hasPrefix("//"):
+			for !p.done() && p.peek() != '\n' {
+				p.next()
+			}
+		case p.hasPrefix("/*"):
+			line := p.line
+			for p.pos += 2; !p.hasPrefix("*/"); p.next() {
+				if p.done() {
+					p.line = line
+					return p.errorf("missing closing */")
+				}
+			}
+			p.pos += 2
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+// endOfItem checks that the next character ends an attribute or block
+func (p *hclParser) endOfItem() error {
+	if err := p.skipSpace(false); err != nil {
+		return err
+	} else if !p.done() && p.peek() != '\n' && p.peek() != '}' {
+		return p.errorf("unexpected '%c', expected a new line", p.peek())
+	}
+	return nil
+}
+
+// body reads attributes & blocks until the end of the data or a '}'
+func (p *hclParser) body(depth int) (map[string]interface{}, error) {
+	if depth > hclMaxDepth {
+		return nil, p.errorf("data is nested more than %d levels deep", hclMaxDepth)
+	}
+
+	data := make(map[string]interface{})
+	attrs := make(map[string]bool)
+	for {
+		if err := p.skipSpace(true); err != nil {
+			return nil, err
+		} else if p.done() || p.peek() == '}' {
+			return data, nil
+		}
+
+		name := p.ident()
+		if len(name) == 0 {
+			return nil, p.errorf("unexpected '%c', expected an attribute or block", p.peek())
+		}
+		if err := p.skipSpace(false); err != nil {
+			return nil, err
+		}
+
+		if p.peek() == '=' {
+			p.next()
+			if _, ok := data[name]; ok {
+				return nil, p.errorf("'%s' is set more than once", name)
+			}
+			v, err := p.expr(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			data[name] = v
+			attrs[name] = true
+		} else {
+			if attrs[name] {
+				return nil, p.errorf("'%s' is set more than once", name)
+			}
+			if err := p.block(data, name, depth); err != nil {
+				return nil, err
+			}
+		}
+
+		if err := p.endOfItem(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// block reads the labels & body of a block and adds it to `data`
+func (p *hclParser) block(data map[string]interface{}, name string, depth int) error {
+	keys := []string{name}
+	for p.peek() != '{' {
+		var label string
+		var err error
+		if p.peek() == '"' {
+			label, err = p.str()
+		} else if label = p.ident(); len(label) == 0 {
+			err = p.errorf("unexpected '%c', expected a block label or '{'", p.peek())
+		}
+		if err != nil {
+			return err
+		}
+		keys = append(keys, label)
+		if err = p.skipSpace(false); err != nil {
+			return err
+		}
+	}
+	p.next() // '{'
+
+	line := p.line
+	body, err := p.body(depth + 1)
+	if err != nil {
+		return err
+	} else if p.done() {
+		p.line = line
+		return p.errorf("missing closing } for block '%s'", name)
+	}
+	p.next() // '}'
+
+	m := data
+	for _, key := range keys[:len(keys)-1] {
+		if m[key] == nil {
+			m[key] = make(map[string]interface{})
+		}
+		var ok bool
+		if m, ok = m[key].(map[string]interface{}); !ok {
+			return p.errorf("block '%s' conflicts with an existing value", strings.Join(keys, " "))
+		}
+	}
+
+	key := keys[len(keys)-1]
+	switch existing := m[key].(type) {
+	case nil:
+		m[key] = body
+	case []interface{}:
+		m[key] = append(existing, body)
+	default:
+		m[key] = []interface{}{existing, body}
+	}
+	return nil
+}
+
+func (p *hclParser) ident() string {
+	start := p.pos
+	for !p.done() && isHCLIdentChar(p.peek(), p.pos == start) {
+		p.next()
+	}
+	return p.src[start:p.pos]
+}
+
+func isHCLIdentChar(r rune, first bool) bool {
+	return unicode.IsLetter(r) || r == '_' || (!first && (unicode.IsDigit(r) || r == '-'))
+}
+
+func isHCLIdent(s string) bool {
+	for i, r := range s {
+		if !isHCLIdentChar(r, i == 0) {
+			return false
+		}
+	}
+	return len(s) > 0
+}
+
+// expr reads a value. If it isn't a literal value, list or object, the
+// source of the expression is returned.
+func (p *hclParser) expr(depth int) (interface{}, error) {
+	if depth > hclMaxDepth {
+		return nil, p.errorf("data is nested more than %d levels deep", hclMaxDepth)
+	} else if err := p.skipSpace(false); err != nil {
+		return nil, err
+	}
+
+	start, line := p.pos, p.line
+	v, ok, err := p.literal(depth)
+	if err != nil {
+		return nil, err
+	} else if ok {
+		if err = p.skipSpace(false); err != nil {
+			return nil, err
+		} else if p.done() || strings.ContainsRune("\n,)]}", p.peek()) {
+			return v, nil
+		}
+	}
+
+	p.pos, p.line = start, line
+	return p.rawExpr()
+}
+
+// literal reads a literal value, list or object. If the next value isn't
+// one of these, `ok` is false.
+func (p *hclParser) literal(depth int) (v interface{}, ok bool, err error) {
+	switch r := p.peek(); {
+	case r == '"':
+		v, err = p.str()
+	case p.hasPrefix("<<"):
+		v, err = p.heredoc()
+	case r == '[':
+		v, err = p.list(depth)
+	case r == '{':
+		v, err = p.object(depth)
+	case (r >= '0' && r <= '9') || (r == '-' && p.pos+1 < len(p.src) && p.src[p.pos+1] >= '0' && p.src[p.pos+1] <= '9'):
+		return p.number()
+	case isHCLIdentChar(r, true):
+		switch ident := p.ident(); ident {
+		case "true", "false":
+			return ident == "true", true, nil
+		case "null":
+			return nil, true, nil
+		}
+		return nil, false, nil
+	default:
+		return nil, false, nil
+	}
+	return v, err == nil, err
+}
+
+func (p *hclParser) number() (interface{}, bool, error) {
+	start := p.pos
+	if p.peek() == '-' {
+		p.next()
+	}
+	for !p.done() && strings.ContainsRune("0123456789.eE", p.peek()) {
+		if c := p.next(); (c == 'e' || c == 'E') && (p.peek() == '+' || p.peek() == '-') {
+			p.next()
+		}
+	}
+
+	s := p.src[start:p.pos]
+	if i, err := strconv.ParseInt(s, 10, 0); err == nil {
+		return int(i), true, nil
+	} else if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return f, true, nil
+	}
+	return nil, false, nil
+}
+
+// rawExpr returns the source of the expression at the current position, up
+// to the end of the line (or a ',' or unmatched bracket).
+func (p *hclParser) rawExpr() (interface{}, error) {
+	start, depth := p.pos, 0
+	for !p.done() {
+		r := p.peek()
+		if depth == 0 && (r == '\n' || r == ',' || r == ')' || r == ']' || r == '}' ||
+			r == '#' || p.hasPrefix("//") || p.hasPrefix("/*")) {
+			break
+		}
+
+		switch r {
+		case '"':
+			if _, err := p.str(); err != nil {
+				return nil, err
+			}
+			continue
+		case '(', '[', '{':
+			depth++
+		case ')', ']', '}':
+			depth--
+		}
+		p.next()
+	}
+
+	expr := strings.TrimSpace(p.src[start:p.pos])
+	if len(expr) == 0 {
+		return nil, p.errorf("expected a value")
+	} else if depth > 0 {
+		return nil, p.errorf("missing closing bracket in '%s'", expr)
+	}
+	return expr, nil
+}
+
+func (p *hclParser) list(depth int) (interface{}, error) {
+	p.next() // '['
+	list := make([]interface{}, 0)
+	for {
+		if err := p.skipSpace(true); err != nil {
+			return nil, err
+		} else if p.done() {
+			return nil, p.errorf("missing closing ]")
+		} else if p.peek() == ']' {
+			p.next()
+			return list, nil
+		}
+
+		item, err := p.expr(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, item)
+
+		if err = p.skipSpace(true); err != nil {
+			return nil, err
+		} else if !p.done() && p.peek() == ',' {
+			p.next()
+		} else if !p.done() && p.peek() != ']' {
+			return nil, p.errorf("missing ',' or ']' after list item")
+		}
+	}
+}
+
+func (p *hclParser) object(depth int) (interface{}, error) {
+	p.next() // '{'
+	m := make(map[string]interface{})
+	for {
+		if err := p.skipSpace(true); err != nil {
+			return nil, err
+		} else if p.done() {
+			return nil, p.errorf("missing closing }")
+		} else if p.peek() == '}' {
+			p.next()
+			return m, nil
+		}
+
+		var key string
+		var err error
+		if p.peek() == '"' {
+			key, err = p.str()
+		} else if key = p.ident(); len(key) == 0 {
+			err = p.errorf("unexpected '%c', expected an object key", p.peek())
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if err = p.skipSpace(false); err != nil {
+			return nil, err
+		} else if p.peek() != '=' && p.peek() != ':' {
+			return nil, p.errorf("missing '=' after key '%s'", key)
+		}
+		p.next()
+		if m[key], err = p.expr(depth + 1); err != nil {
+			return nil, err
+		}
+
+		if err = p.skipSpace(false); err != nil {
+			return nil, err
+		} else if !p.done() && p.peek() == ',' {
+			p.next()
+		} else if !p.done() && p.peek() != '\n' && p.peek() != '}' {
+			return nil, p.errorf("missing ',' or '}' after value of '%s'", key)
+		}
+	}
+}
+
+// str reads a quoted string, templates are returned as-is
+func (p *hclParser) str() (string, error) {
+	line := p.line
+	p.next() // '"'
+
+	var b strings.Builder
+	for {
+		if p.done() || p.peek() == '\n' {
+			p.line = line
+			return "", p.errorf("missing closing \"")
+		}
+
+		switch r := p.next(); r {
+		case '"':
+			return b.String(), nil
+		case '\\':
+			if err := p.escape(&b); err != nil {
+				return "", err
+			}
+		case '$', '%':
+			b.WriteRune(r)
+			if p.hasPrefix(string(r) + "{") { // "$${" & "%%{" are escaped
+				p.next()
+			} else if p.peek() == '{' {
+				if err := p.template(&b); err != nil {
+					return "", err
+				}
+			}
+		default:
+			b.WriteRune(r)
+		}
+	}
+}
+
+// template writes the source of a "${...}" or "%{...}" sequence to `b`,
+// which can contain strings & nested braces.
+func (p *hclParser) template(b *strings.Builder) error {
+	start, depth := p.pos, 0
+	for !p.done() {
+		switch p.peek() {
+		case '"':
+			if _, err := p.str(); err != nil {
+				return err
+			}
+			continue
+		case '{':
+			depth++
+		case '}':
+			if depth--; depth == 0 {
+				p.next()
+				b.WriteString(p.src[start:p.pos])
+				return nil
+			}
+		}
+		p.next()
+	}
+	return p.errorf("missing closing } in template")
+}
+
+func (p *hclParser) escape(b *strings.Builder) error {
+	if p.done() {
+		return p.errorf("unexpected end of data")
+	}
+
+	switch r := p.next(); r {
+	case 'n':
+		b.WriteByte('\n')
+	case 'r':
+		b.WriteByte('\r')
+	case 't':
+		b.WriteByte('\t')
+	case '"', '\\':
+		b.WriteRune(r)
+	case 'u', 'U':
+		n := 4
+		if r == 'U' {
+			n = 8
+		}
+		if p.pos+n > len(p.src) {
+			return p.errorf("invalid escape, expected %d hexadecimal digits", n)
+		}
+		c, err := strconv.ParseUint(p.src[p.pos:p.pos+n], 16, 32)
+		if err != nil || !utf8.ValidRune(rune(c)) {
+			return p.errorf("invalid escape, expected %d hexadecimal digits", n)
+		}
+		p.pos += n
+		b.WriteRune(rune(c))
+	default:
+		return p.errorf("invalid escape '\\%c'", r)
+	}
+	return nil
+}
+
+// heredoc reads a "<<EOF" or "<<-EOF" string. The result includes the
+// final newline, "<<-" removes any indentation shared by all the lines.
+func (p *hclParser) heredoc() (string, error) {
+	line := p.line
+	p.pos += 2
+	indented := p.peek() == '-'
+	if indented {
+		p.next()
+	}
+	marker := p.ident()
+	if len(marker) == 0 {
+		return "", p.errorf("missing heredoc marker")
+	} else if p.hasPrefix("\r") {
+		p.next()
+	}
+	if p.done() || p.next() != '\n' {
+		return "", p.errorf("missing new line after heredoc marker")
+	}
+
+	var lines []string
+	for {
+		if p.done() {
+			p.line = line
+			return "", p.errorf("heredoc '%s' is not closed", marker)
+		}
+		end := strings.IndexByte(p.src[p.pos:], '\n')
+		if end < 0 {
+			end = len(p.src) - p.pos
+		}
+		l := strings.TrimSuffix(p.src[p.pos:p.pos+end], "\r")
+		if strings.TrimSpace(l) == marker {
+			p.pos += len(strings.TrimRight(p.src[p.pos:p.pos+end], " \t\r"))
+			break
+		}
+		lines = append(lines, l)
+		p.pos += end
+		if !p.done() {
+			p.next()
+		}
+	}
+
+	if indented {
+		indent := -1
+		for _, l := range lines {
+			if trimmed := strings.TrimLeft(l, " \t"); len(trimmed) > 0 {
+				if n := len(l) - len(trimmed); indent < 0 || n < indent {
+					indent = n
+				}
+			}
+		}
+		for i, l := range lines {
+			if len(l) >= indent && indent > 0 {
+				lines[i] = l[indent:]
+			} else if indent > 0 {
+				lines[i] = ""
+			}
+		}
+	}
+
+	if len(lines) == 0 {
+		return "", nil
+	}
+	return strings.Join(lines, "\n") + "\n", nil
+}
+
+// encodeHCL encodes `data` as HCL attributes. `data` must be a map (or
+// struct) with keys that are valid identifiers. Maps are written as objects
+// (not blocks) with sorted keys, times are written as RFC 3339 strings.
+func encodeHCL(w io.Writer, data interface{}) error {
+	m, ok := toStringMap(data)
+	if !ok {
+		generic, err := toGenericData(data)
+		if err != nil {
+			return err
+		} else if m, ok = generic.(map[string]interface{}); !ok {
+			return fmt.Errorf("can't encode %T as hcl", data)
+		}
+	}
+
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		if !isHCLIdent(key) {
+			return fmt.Errorf("invalid attribute name '%s'", key)
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	bw := bufio.NewWriter(w)
+	for _, key := range keys {
+		bw.WriteString(key + " = ")
+		if err := writeHCLValue(bw, m[key], "", 0); err != nil {
+			return fmt.Errorf("%s: %s", key, err)
+		}
+		bw.WriteByte('\n')
+	}
+	return bw.Flush()
+}
+
+func writeHCLValue(w *bufio.Writer, v interface{}, indent string, depth int) error {
+	if depth > hclMaxDepth {
+		return fmt.Errorf("data is nested more than %d levels deep", hclMaxDepth)
+	}
+
+	switch val := v.(type) {
+	case nil:
+		w.WriteString("null")
+		return nil
+	case time.Time:
+		w.WriteString(quoteHCL(val.Format(time.RFC3339Nano)))
+		return nil
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Bool:
+		w.WriteString(strconv.FormatBool(rv.Bool()))
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		w.WriteString(strconv.FormatInt(rv.Int(), 10))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		w.WriteString(strconv.FormatUint(rv.Uint(), 10))
+	case reflect.Float32, reflect.Float64:
+		f := rv.Float()
+		if math.IsInf(f, 0) || math.IsNaN(f) {
+			return fmt.Errorf("can't encode %v as hcl", f)
+		}
+		s := strconv.FormatFloat(f, 'g', -1, 64)
+		if !strings.ContainsAny(s, ".e") {
+			s += ".0" // so it's decoded as a float
+		}
+		w.WriteString(s)
+	case reflect.String:
+		w.WriteString(quoteHCL(rv.String()))
+	case reflect.Ptr, reflect.Interface:
+		if rv.IsNil() {
+			return writeHCLValue(w, nil, indent, depth)
+		}
+		return writeHCLValue(w, rv.Elem().Interface(), indent, depth+1)
+	case reflect.Map:
+		m, _ := toStringMap(v)
+		if len(m) == 0 {
+			w.WriteString("{}")
+			return nil
+		}
+		keys := make([]string, 0, len(m))
+		for key := range m {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		w.WriteString("{\n")
+		for _, key := range keys {
+			if !isHCLIdent(key) {
+				key = quoteHCL(key)
+			}
+			w.WriteString(indent + "  " + key + " = ")
+			if err := writeHCLValue(w, m[key], indent+"  ", depth+1); err != nil {
+				return err
+			}
+			w.WriteByte('\n')
+		}
+		w.WriteString(indent + "}")
+	case reflect.Slice, reflect.Array:
+		l, ok := toList(v)
+		if !ok { // []byte
+			generic, err := toGenericData(v)
+			if err != nil {
+				return err
+			}
+			return writeHCLValue(w, generic, indent, depth+1)
+		} else if len(l) == 0 {
+			w.WriteString("[]")
+			return nil
+		}
+		w.WriteString("[\n")
+		for _, item := range l {
+			w.WriteString(indent + "  ")
+			if err := writeHCLValue(w, item, indent+"  ", depth+1); err != nil {
+				return err
+			}
+			w.WriteString(",\n")
+		}
+		w.WriteString(indent + "]")
+	default:
+		generic, err := toGenericData(v)
+		if err != nil {
+			return err
+		}
+		return writeHCLValue(w, generic, indent, depth+1)
+	}
+	return nil
+}
+
+// quoteHCL returns `s` as a quoted string, "${" & "%{" are escaped as "$${"
+// & "%%{".
+func quoteHCL(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i, r := range s {
+		switch r {
+		case '"', '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case '$', '%':
+			b.WriteRune(r)
+			if strings.HasPrefix(s[i+1:], "{") {
+				b.WriteRune(r)
+			}
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		default:
+			if r < 0x20 || r == 0x7f {
+				fmt.Fprintf(&b, `\u%04x`, r)
+			} else {
+				b.WriteRune(r)
+			}
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
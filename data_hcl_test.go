@@ -0,0 +1,165 @@
+package dati
+
+/*
+Copyright (C) 2023 gearsix <gearsix@tuta.io>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const hclGood = `# comment
+region = "eu-west-1" // comment
+count  = 3
+ratio  = 0.5
+enabled = true
+owner = null
+tags = {
+  Name = "web ${var.env}"
+  "cost-centre": 42,
+}
+zones = ["a", "b",
+  "c", /* comment */
+]
+ami = data.aws_ami.id
+size = max(1, var.min) * 2
+motd = <<-EOF
+    hello
+      world
+    EOF
+escaped = "a\"b\\\n$${x}"
+
+variable "image" {
+  type    = string
+  default = "nginx"
+}
+
+variable "port" { default = 80 }
+
+ingress {
+  port = 80
+}
+ingress {
+  port = 443
+}
+`
+
+func TestReadHCLDataFormat(t *testing.T) {
+	for path, format := range map[string]DataFormat{
+		"x.hcl": HCL, "x.tfvars": HCL, "HCL": HCL,
+	} {
+		if f := ReadDataFormat(path); f != format {
+			t.Fatalf("'%s' returned '%s', not '%s'", path, f, format)
+		}
+	}
+}
+
+func TestLoadHCLData(t *testing.T) {
+	var d map[string]interface{}
+	if err := LoadData(HCL, strings.NewReader(hclGood), &d); err != nil {
+		t.Fatal(err)
+	}
+	expect := map[string]interface{}{
+		"region":  "eu-west-1",
+		"count":   3,
+		"ratio":   0.5,
+		"enabled": true,
+		"owner":   nil,
+		"tags":    map[string]interface{}{"Name": "web ${var.env}", "cost-centre": 42},
+		"zones":   []interface{}{"a", "b", "c"},
+		"ami":     "data.aws_ami.id",
+		"size":    "max(1, var.min) * 2",
+		"motd":    "hello\n  world\n",
+		"escaped": "a\"b\\\n${x}",
+		"variable": map[string]interface{}{
+			"image": map[string]interface{}{"type": "string", "default": "nginx"},
+			"port":  map[string]interface{}{"default": 80},
+		},
+		"ingress": []interface{}{
+			map[string]interface{}{"port": 80},
+			map[string]interface{}{"port": 443},
+		},
+	}
+	if !reflect.DeepEqual(d, expect) {
+		t.Fatalf("invalid result: %v should be %v", d, expect)
+	}
+
+	var v interface{}
+	for _, bad := range []string{
+		"a = 1\na = 2", "a", "a = ", "a = \"x", "a = [1, 2",
+		"a = {b 1}", "a = \"\\q\"", "a = <<EOF\nx", "b {", "}", "a = (1",
+		"a = 1\na {\n}", "/* x",
+	} {
+		if err := LoadData(HCL, strings.NewReader(bad), &v); err == nil {
+			t.Fatalf("bad data passed: %s", bad)
+		} else if !strings.HasPrefix(err.Error(), "hcl: line ") {
+			t.Fatalf("error does not indicate format & line: %s", err)
+		}
+	}
+}
+
+func TestWriteHCLData(t *testing.T) {
+	var d interface{}
+	if err := LoadData(HCL, strings.NewReader(hclGood), &d); err != nil {
+		t.Skip("setup failure:", err)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteData(HCL, d, &buf); err != nil {
+		t.Fatal(err)
+	}
+
+	var again interface{}
+	if err := LoadData(HCL, bytes.NewReader(buf.Bytes()), &again); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(d, again) {
+		t.Fatalf("round-trip result %v does not match %v", again, d)
+	}
+
+	buf.Reset()
+	data := map[string]interface{}{
+		"a": []interface{}{1, 1.0, "${x}"},
+		"b": map[string]interface{}{"c d": nil},
+		"e": []interface{}{},
+	}
+	if err := WriteData(HCL, data, &buf); err != nil {
+		t.Fatal(err)
+	} else if expect := `a = [
+  1,
+  1.0,
+  "$${x}",
+]
+b = {
+  "c d" = null
+}
+e = []
+`; buf.String() != expect {
+		t.Fatalf("invalid result: %s should be %s", buf.String(), expect)
+	}
+
+	for _, bad := range []interface{}{
+		[]string{"x"},
+		map[string]interface{}{"a b": 1},
+		map[string]interface{}{"a": func() {}},
+	} {
+		if err := WriteData(HCL, bad, &buf); err == nil {
+			t.Fatalf("bad data passed: %v", bad)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package dati
+
+/*
+Copyright (C) 2023 gearsix <gearsix@tuta.io>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+const INI DataFormat = "ini"
+
+func init() {
+	RegisterDataFormat(INI, []string{"ini"}, decodeINI, encodeINI)
+}
+
+// decodeINI decodes INI data to a map. Keys set before the first section are
+// set in the top-level map, every section is set to a nested map of it's keys.
+// Lines starting with ";" or "#" are comments, keys & values can be separated
+// by "=" or ":" and all values are strings. If a value is surrounded by
+// matching quotes, they're removed.
+func decodeINI(in []byte, out interface{}) error {
+	data := make(map[string]interface{})
+	section := data
+
+	scanner := bufio.NewScanner(bytes.NewReader(bytes.TrimPrefix(in, []byte("\xef\xbb\xbf"))))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || line[0] == ';' || line[0] == '#' {
+			continue
+		}
+
+		if line[0] == '[' {
+			if line[len(line)-1] != ']' {
+				return fmt.Errorf("line %d: section '%s' is missing a closing ']'", n, line)
+			}
+			name := strings.TrimSpace(line[1 : len(line)-1])
+			if existing, ok := data[name].(map[string]interface{}); ok {
+				section = existing
+			} else {
+				section = make(map[string]interface{})
+				data[name] = section
+			}
+			continue
+		}
+
+		i := strings.IndexAny(line, "=:")
+		if i <= 0 {
+			return fmt.Errorf("line %d: '%s' is not a key/value pair", n, line)
+		}
+		section[strings.TrimSpace(line[:i])] = unquoteINI(strings.TrimSpace(line[i+1:]))
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	return setData(data, out)
+}
+
+func unquoteINI(value string) string {
+	if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+		return value[1 : len(value)-1]
+	}
+	return value
+}
+
+// encodeINI encodes `data` as INI, it reverses `decodeINI`. `data` must be a
+// map (or struct), any values that are maps are written as sections. Values
+// in sections can't be maps and no values can be lists.
+func encodeINI(w io.Writer, data interface{}) error {
+	generic, err := toGenericData(data)
+	if err != nil {
+		return err
+	}
+	m, ok := generic.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("can't encode %T as INI", data)
+	}
+
+	var keys, sections []string
+	for key, val := range m {
+		if _, ok := val.(map[string]interface{}); ok {
+			sections = append(sections, key)
+		} else {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	sort.Strings(sections)
+
+	bw := bufio.NewWriter(w)
+	if err = writeINIKeys(bw, "", keys, m); err != nil {
+		return err
+	}
+	for i, name := range sections {
+		if name != strings.TrimSpace(name) || strings.ContainsAny(name, "[]\n") {
+			return fmt.Errorf("invalid section name '%s'", name)
+		}
+		if i > 0 || len(keys) > 0 {
+			bw.WriteString("\n")
+		}
+		fmt.Fprintf(bw, "[%s]\n", name)
+
+		section := m[name].(map[string]interface{})
+		keys = keys[:0]
+		for key := range section {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		if err = writeINIKeys(bw, name, keys, section); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+func writeINIKeys(w *bufio.Writer, section string, keys []string, values map[string]interface{}) error {
+	for _, key := range keys {
+		path := key
+		if len(section) > 0 {
+			path = section + "." + key
+		}
+
+		if len(key) == 0 || key != strings.TrimSpace(key) ||
+			strings.ContainsAny(key, "=:\n") || strings.ContainsAny(key[:1], ";#[") {
+			return fmt.Errorf("invalid key '%s'", path)
+		}
+
+		var value string
+		switch v := values[key].(type) {
+		case nil:
+		case map[string]interface{}, []interface{}:
+			return fmt.Errorf("can't encode %T value of '%s' as INI", v, path)
+		default:
+			value = fmt.Sprint(v)
+		}
+
+		if strings.ContainsAny(value, "\n") {
+			return fmt.Errorf("can't encode multi-line value of '%s' as INI", path)
+		} else if value != strings.TrimSpace(value) || unquoteINI(value) != value {
+			value = "\"" + value + "\""
+		}
+		fmt.Fprintf(w, "%s = %s\n", key, value)
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package dati
+
+/*
+Copyright (C) 2023 gearsix <gearsix@tuta.io>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const iniGood = `; comment
+name = dati
+# another comment
+empty =
+
+[server]
+host: localhost
+port = 8080
+motd = " hello "
+
+[database]
+url = 'postgres://x'
+`
+const iniBad = "[server\nport = 8080\n"
+
+func TestReadINIDataFormat(t *testing.T) {
+	for path, format := range map[string]DataFormat{"x.ini": INI, "INI": INI} {
+		if f := ReadDataFormat(path); f != format {
+			t.Fatalf("'%s' returned '%s', not '%s'", path, f, format)
+		}
+	}
+}
+
+func TestLoadINIData(t *testing.T) {
+	var d map[string]interface{}
+	if err := LoadData(INI, strings.NewReader(iniGood), &d); err != nil {
+		t.Fatal(err)
+	}
+	expect := map[string]interface{}{
+		"name":  "dati",
+		"empty": "",
+		"server": map[string]interface{}{
+			"host": "localhost", "port": "8080", "motd": " hello ",
+		},
+		"database": map[string]interface{}{"url": "postgres://x"},
+	}
+	if !reflect.DeepEqual(d, expect) {
+		t.Fatalf("invalid result: %v should be %v", d, expect)
+	}
+
+	for _, bad := range []string{iniBad, "novalue\n"} {
+		if err := LoadData(INI, strings.NewReader(bad), &d); err == nil {
+			t.Fatalf("bad data passed: %s", bad)
+		} else if !strings.HasPrefix(err.Error(), "ini: ") {
+			t.Fatalf("error does not indicate format: %s", err)
+		}
+	}
+}
+
+func TestWriteINIData(t *testing.T) {
+	var buf bytes.Buffer
+
+	var d interface{}
+	if err := LoadData(INI, strings.NewReader(iniGood), &d); err != nil {
+		t.Skip("setup failure:", err)
+	}
+	if err := WriteData(INI, d, &buf); err != nil {
+		t.Fatal(err)
+	} else if expect := "empty = \nname = dati\n\n" +
+		"[database]\nurl = postgres://x\n\n" +
+		"[server]\nhost = localhost\nmotd = \" hello \"\nport = 8080\n"; buf.String() != expect {
+		t.Fatalf("invalid result: %s should be %s", buf.String(), expect)
+	}
+
+	var again interface{}
+	if err := LoadData(INI, &buf, &again); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(d, again) {
+		t.Fatalf("round-trip result %v does not match %v", again, d)
+	}
+
+	buf.Reset()
+	if err := WriteData(INI, map[string]interface{}{"n": 1000000, "q": "'x'"}, &buf); err != nil {
+		t.Fatal(err)
+	} else if expect := "n = 1000000\nq = \"'x'\"\n"; buf.String() != expect {
+		t.Fatalf("invalid result: %s should be %s", buf.String(), expect)
+	}
+
+	for _, bad := range []interface{}{
+		[]string{"x"},
+		map[string]interface{}{"a": map[string]interface{}{"b": map[string]interface{}{}}},
+		map[string]interface{}{"a": []int{1}},
+		map[string]interface{}{"a=b": 1},
+		map[string]interface{}{"a": "b\nc"},
+	} {
+		if err := WriteData(INI, bad, &buf); err == nil {
+			t.Fatalf("bad data passed: %v", bad)
+		}
+	}
+}
@@ -0,0 +1,456 @@
+package dati
+
+/*
+Copyright (C) 2023 gearsix <gearsix@tuta.io>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+const JSON5 DataFormat = "json5"
+
+func init() {
+	RegisterDataFormat(JSON5, []string{"json5"}, decodeJSON5, encodeJSON5)
+	RegisterDataSniffer(JSON5, sniffJSON5)
+}
+
+// the maximum number of nested arrays/objects that will be decoded
+const json5MaxDepth = 10000
+
+// decodeJSON5 decodes JSON5 data (see https://spec.json5.org/) to the same
+// types as `json.Unmarshal` does for an interface{} value (numbers are
+// float64). On top of JSON, JSON5 allows:
+//   - "//" and "/* */" comments.
+//   - object keys that are identifiers (e.g. `{key: 1}`).
+//   - single-quoted strings, escaped newlines in strings & extra escapes
+//     ("\v", "\0", "\xFF").
+//   - trailing commas in objects & arrays.
+//   - hexadecimal numbers, leading/trailing decimal points, a leading "+",
+//     Infinity & NaN.
+func decodeJSON5(in []byte, out interface{}) error {
+	p := json5Parser{src: strings.TrimPrefix(string(in), "\ufeff"), line: 1}
+	v, err := p.value(0)
+	if err == nil {
+		if err = p.skipSpace(); err == nil && !p.done() {
+			err = p.errorf("unexpected '%c' after value", p.peek())
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return setData(v, out)
+}
+
+type json5Parser struct {
+	src  string
+	pos  int
+	line int
+}
+
+func (p *json5Parser) errorf(format string, a ...interface{}) error {
+	return fmt.Errorf("line %d: %s", p.line, fmt.Sprintf(format, a...))
+}
+
+func (p *json5Parser) done() bool {
+	return p.pos >= len(p.src)
+}
+
+func (p *json5Parser) peek() rune {
+	r, _ := utf8.DecodeRuneInString(p.src[p.pos:])
+	return r
+}
+
+func (p *json5Parser) next() rune {
+	r, size := utf8.DecodeRuneInString(p.src[p.pos:])
+	if p.pos += size; r == '\n' {
+		p.line++
+	}
+	return r
+}
+
+// skipSpace skips any whitespace & comments
+func (p *json5Parser) skipSpace() error {
+	for !p.done() {
+		switch r := p.peek(); {
+		case r == '\ufeff' || unicode.IsSpace(r):
+			p.next()
+		case strings.HasPrefix(p.src[p.pos:], "//"):
+			for !p.done() && p.peek() != '\n' && p.peek() != '\r' {
+				p.next()
+			}
+		case strings.HasPrefix(p.src[p.pos:], "/*"):
+			line := p.line
+			p.pos += 2
+			for !strings.HasPrefix(p.src[p.pos:], "*/") {
+				if p.done() {
+					p.line = line
+					return p.errorf("missing closing */")
+				}
+				p.next()
+			}
+			p.pos += 2
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+func (p *json5Parser) value(depth int) (interface{}, error) {
+	if depth > json5MaxDepth {
+		return nil, p.errorf("data is nested more than %d levels deep", json5MaxDepth)
+	} else if err := p.skipSpace(); err != nil {
+		return nil, err
+	} else if p.done() {
+		return nil, p.errorf("unexpected end of data")
+	}
+
+	switch r := p.peek(); {
+	case r == '{':
+		return p.object(depth)
+	case r == '[':
+		return p.array(depth)
+	case r == '"' || r == '\'':
+		return p.str()
+	case r == '-' || r == '+' || r == '.' || (r >= '0' && r <= '9'):
+		return p.number()
+	case isJSON5IdentChar(r, true):
+		switch ident := p.ident(); ident {
+		case "null":
+			return nil, nil
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		case "Infinity", "NaN":
+			return json5Number(ident, 1)
+		default:
+			return nil, p.errorf("unexpected '%s'", ident)
+		}
+	default:
+		return nil, p.errorf("unexpected '%c'", r)
+	}
+}
+
+func (p *json5Parser) object(depth int) (interface{}, error) {
+	p.next() // '{'
+	m := make(map[string]interface{})
+	for {
+		if err := p.skipSpace(); err != nil {
+			return nil, err
+		} else if p.done() {
+			return nil, p.errorf("missing closing }")
+		} else if p.peek() == '}' {
+			p.next()
+			return m, nil
+		}
+
+		var key string
+		var err error
+		if r := p.peek(); r == '"' || r == '\'' {
+			key, err = p.str()
+		} else if isJSON5IdentChar(r, true) || r == '\\' {
+			key, err = p.key()
+		} else {
+			err = p.errorf("invalid key, unexpected '%c'", r)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if err = p.skipSpace(); err != nil {
+			return nil, err
+		} else if p.done() || p.peek() != ':' {
+			return nil, p.errorf("missing ':' after key '%s'", key)
+		}
+		p.next()
+
+		if m[key], err = p.value(depth + 1); err != nil {
+			return nil, err
+		}
+		if err = p.skipSpace(); err != nil {
+			return nil, err
+		} else if !p.done() && p.peek() == ',' {
+			p.next()
+		} else if !p.done() && p.peek() != '}' {
+			return nil, p.errorf("missing ',' or '}' after value of '%s'", key)
+		}
+	}
+}
+
+func (p *json5Parser) array(depth int) (interface{}, error) {
+	p.next() // '['
+	list := make([]interface{}, 0)
+	for {
+		if err := p.skipSpace(); err != nil {
+			return nil, err
+		} else if p.done() {
+			return nil, p.errorf("missing closing ]")
+		} else if p.peek() == ']' {
+			p.next()
+			return list, nil
+		}
+
+		item, err := p.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, item)
+
+		if err = p.skipSpace(); err != nil {
+			return nil, err
+		} else if !p.done() && p.peek() == ',' {
+			p.next()
+		} else if !p.done() && p.peek() != ']' {
+			return nil, p.errorf("missing ',' or ']' after list item")
+		}
+	}
+}
+
+// ident returns the identifier characters at the current position
+func (p *json5Parser) ident() string {
+	start := p.pos
+	for !p.done() && isJSON5IdentChar(p.peek(), p.pos == start) {
+		p.next()
+	}
+	return p.src[start:p.pos]
+}
+
+// key reads an unquoted object key, which can contain "\uXXXX" escapes
+func (p *json5Parser) key() (string, error) {
+	var b strings.Builder
+	for !p.done() {
+		r := p.peek()
+		if r == '\\' {
+			p.next()
+			if p.done() || p.next() != 'u' {
+				return "", p.errorf("invalid escape in key")
+			}
+			var err error
+			if r, err = p.hex(4); err != nil {
+				return "", err
+			} else if !isJSON5IdentChar(r, b.Len() == 0) {
+				return "", p.errorf("invalid character in key '%c'", r)
+			}
+		} else if isJSON5IdentChar(r, b.Len() == 0) {
+			p.next()
+		} else {
+			break
+		}
+		b.WriteRune(r)
+	}
+	return b.String(), nil
+}
+
+func (p *json5Parser) str() (string, error) {
+	line := p.line
+	quote := p.next()
+
+	var b strings.Builder
+	for {
+		if p.done() {
+			p.line = line
+			return "", p.errorf("missing closing %c", quote)
+		}
+		if r := p.peek(); r == '\n' || r == '\r' {
+			return "", p.errorf("unescaped newline in string")
+		}
+		switch r := p.next(); r {
+		case quote:
+			return b.String(), nil
+		case '\\':
+			if err := p.escape(&b); err != nil {
+				return "", err
+			}
+		default:
+			b.WriteRune(r)
+		}
+	}
+}
+
+// escape writes the result of the escape sequence after a '\' to `b`
+func (p *json5Parser) escape(b *strings.Builder) error {
+	if p.done() {
+		return p.errorf("unexpected end of data")
+	}
+
+	switch r := p.next(); r {
+	case 'b':
+		b.WriteByte('\b')
+	case 'f':
+		b.WriteByte('\f')
+	case 'n':
+		b.WriteByte('\n')
+	case 'r':
+		b.WriteByte('\r')
+	case 't':
+		b.WriteByte('\t')
+	case 'v':
+		b.WriteByte('\v')
+	case '0':
+		if !p.done() && p.peek() >= '0' && p.peek() <= '9' {
+			return p.errorf("invalid escape '\\0%c'", p.peek())
+		}
+		b.WriteByte(0)
+	case 'x':
+		c, err := p.hex(2)
+		if err != nil {
+			return err
+		}
+		b.WriteRune(c)
+	case 'u':
+		c, err := p.hex(4)
+		if err != nil {
+			return err
+		}
+		if utf16.IsSurrogate(c) && strings.HasPrefix(p.src[p.pos:], "\\u") {
+			start, line := p.pos, p.line
+			p.pos += 2
+			if c2, err := p.hex(4); err == nil && utf16.DecodeRune(c, c2) != unicode.ReplacementChar {
+				c = utf16.DecodeRune(c, c2)
+			} else {
+				p.pos, p.line = start, line
+			}
+		}
+		b.WriteRune(c)
+	case '\r', '\n', '\u2028', '\u2029': // line continuation
+		if r == '\r' && !p.done() && p.peek() == '\n' {
+			p.next()
+		}
+	default:
+		if r >= '1' && r <= '9' {
+			return p.errorf("invalid escape '\\%c'", r)
+		}
+		b.WriteRune(r)
+	}
+	return nil
+}
+
+// hex reads `n` hexadecimal digits as a rune
+func (p *json5Parser) hex(n int) (rune, error) {
+	if p.pos+n > len(p.src) {
+		return 0, p.errorf("invalid escape, expected %d hexadecimal digits", n)
+	}
+	v, err := strconv.ParseUint(p.src[p.pos:p.pos+n], 16, 32)
+	if err != nil {
+		return 0, p.errorf("invalid escape, expected %d hexadecimal digits", n)
+	}
+	p.pos += n
+	return rune(v), nil
+}
+
+func (p *json5Parser) number() (interface{}, error) {
+	start := p.pos
+	sign := 1.0
+	if r := p.peek(); r == '-' || r == '+' {
+		if p.next() == '-' {
+			sign = -1
+		}
+	}
+	if !p.done() && isJSON5IdentChar(p.peek(), true) {
+		ident := p.ident()
+		if ident == "Infinity" || ident == "NaN" {
+			return json5Number(ident, sign)
+		}
+		return nil, p.errorf("invalid number '%s'", p.src[start:p.pos])
+	}
+
+	for !p.done() && strings.ContainsRune("0123456789abcdefABCDEFxX.+-", p.peek()) {
+		// '+' & '-' are only part of the number after an exponent
+		if r := p.peek(); (r == '+' || r == '-') && !strings.ContainsRune("eE", rune(p.src[p.pos-1])) {
+			break
+		}
+		p.next()
+	}
+	v, err := json5Number(p.src[start:p.pos], 1)
+	if err != nil {
+		return nil, p.errorf("%s", err)
+	}
+	return v, nil
+}
+
+// json5Number parses `s` (which may start with a sign) as a JSON5 number,
+// multiplied by `sign`.
+func json5Number(s string, sign float64) (interface{}, error) {
+	invalid := fmt.Errorf("invalid number '%s'", s)
+
+	digits := s
+	if strings.HasPrefix(digits, "-") {
+		sign, digits = -sign, digits[1:]
+	} else {
+		digits = strings.TrimPrefix(digits, "+")
+	}
+
+	switch {
+	case digits == "Infinity":
+		return math.Inf(int(sign)), nil
+	case digits == "NaN":
+		return math.NaN(), nil
+	case strings.HasPrefix(digits, "0x") || strings.HasPrefix(digits, "0X"):
+		v, err := strconv.ParseUint(digits[2:], 16, 64)
+		if err != nil && !errors.Is(err, strconv.ErrRange) {
+			return nil, invalid
+		} else if err != nil {
+			return sign * math.Inf(1), nil
+		}
+		return sign * float64(v), nil
+	case len(digits) == 0 || strings.ContainsAny(digits, "xXabcdfABCDF") ||
+		strings.HasPrefix(digits, ".e") || strings.HasPrefix(digits, ".E") || digits == ".":
+		return nil, invalid
+	case len(digits) > 1 && digits[0] == '0' && digits[1] >= '0' && digits[1] <= '9':
+		return nil, fmt.Errorf("invalid number '%s', leading zeros are not allowed", s)
+	}
+
+	v, err := strconv.ParseFloat(digits, 64)
+	if err != nil && !errors.Is(err, strconv.ErrRange) {
+		return nil, invalid
+	}
+	return sign * v, nil
+}
+
+func isJSON5IdentChar(r rune, first bool) bool {
+	return r == '_' || r == '$' || unicode.IsLetter(r) || unicode.Is(unicode.Nl, r) ||
+		(!first && (unicode.IsDigit(r) || unicode.In(r, unicode.Mn, unicode.Mc, unicode.Pc) ||
+			r == '\u200c' || r == '\u200d'))
+}
+
+// sniffJSON5 matches objects, lists & comments that aren't valid JSON
+func sniffJSON5(head []byte) bool {
+	trimmed := bytes.TrimLeft(head, " \t\r\n")
+	if c := firstByte(head); c != '{' && c != '[' &&
+		!bytes.HasPrefix(trimmed, []byte("//")) && !bytes.HasPrefix(trimmed, []byte("/*")) {
+		return false
+	}
+	_, ok := sniffJSON(head)
+	return !ok
+}
+
+// encodeJSON5 encodes `data` as JSON, which is valid JSON5. Infinity & NaN
+// can't be encoded.
+func encodeJSON5(w io.Writer, data interface{}) error {
+	return json.NewEncoder(w).Encode(data)
+}
@@ -0,0 +1,125 @@
+package dati
+
+/*
+Copyright (C) 2023 gearsix <gearsix@tuta.io>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const json5Good = `// comment
+{
+	unquoted: 'single "quoted"',
+	"quoted": "a\
+b\x41é😀\v\0",
+	$_key1: [1, +2, -.5, 5., 0xFF, -0x10, 1e3, /* inline */ true, null,],
+	Infinity: -Infinity,
+	nested: {a: {b: false,},},
+}
+`
+
+func TestReadJSON5DataFormat(t *testing.T) {
+	for path, format := range map[string]DataFormat{
+		"x.json5": JSON5, "JSON5": JSON5, "x.json": JSON,
+	} {
+		if f := ReadDataFormat(path); f != format {
+			t.Fatalf("'%s' returned '%s', not '%s'", path, f, format)
+		}
+	}
+}
+
+func TestLoadJSON5Data(t *testing.T) {
+	var d map[string]interface{}
+	if err := LoadData(JSON5, strings.NewReader(json5Good), &d); err != nil {
+		t.Fatal(err)
+	}
+	expect := map[string]interface{}{
+		"unquoted": `single "quoted"`,
+		"quoted":   "abAé😀\v\x00",
+		"$_key1":   []interface{}{1.0, 2.0, -0.5, 5.0, 255.0, -16.0, 1000.0, true, nil},
+		"Infinity": math.Inf(-1),
+		"nested": map[string]interface{}{
+			"a": map[string]interface{}{"b": false},
+		},
+	}
+	if !reflect.DeepEqual(d, expect) {
+		t.Fatalf("invalid result: %v should be %v", d, expect)
+	}
+
+	var v interface{}
+	if err := LoadData(JSON5, strings.NewReader("NaN"), &v); err != nil || !math.IsNaN(v.(float64)) {
+		t.Fatalf("NaN decoded as %v (%v)", v, err)
+	}
+
+	// any json should be valid json5
+	const json = `{"a": [1.5, -2e-3, "\u00e9\ud83d\ude00\n"], "b": {"c": null}, "d": ""}`
+	var j, j5 interface{}
+	if err := LoadData(JSON, strings.NewReader(json), &j); err != nil {
+		t.Skip("setup failure:", err)
+	}
+	if err := LoadData(JSON5, strings.NewReader(json), &j5); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(j, j5) {
+		t.Fatalf("json decoded as %v, not %v", j5, j)
+	}
+
+	for _, bad := range []string{
+		"{a: 1", "{a 1}", "[1 2]", "{1: 2}", "'abc", "'a\nb'", "01", "0x", "1e",
+		"+-1", "tru", "{a: 1} x", "/* comment", `'\1'`, `'\x4'`, "[1,,]",
+	} {
+		if err := LoadData(JSON5, strings.NewReader(bad), &v); err == nil {
+			t.Fatalf("bad data passed: %s", bad)
+		} else if !strings.HasPrefix(err.Error(), "json5: line 1: ") {
+			t.Fatalf("error does not indicate format & line: %s", err)
+		}
+	}
+
+	if err := LoadData(JSON5, strings.NewReader("{\n\ta: 1,\n\tb: ]\n}"), &v); err == nil ||
+		!strings.HasPrefix(err.Error(), "json5: line 3: ") {
+		t.Fatalf("error does not indicate line: %v", err)
+	}
+
+	nested := strings.Repeat("[", json5MaxDepth+2)
+	if err := LoadData(JSON5, strings.NewReader(nested), &v); err == nil {
+		t.Fatal("deeply nested data passed")
+	}
+}
+
+func TestWriteJSON5Data(t *testing.T) {
+	var d interface{}
+	if err := LoadData(JSON5, strings.NewReader(`{a: [1, 'x'], b: null}`), &d); err != nil {
+		t.Skip("setup failure:", err)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteData(JSON5, d, &buf); err != nil {
+		t.Fatal(err)
+	} else if expect := `{"a":[1,"x"],"b":null}` + "\n"; buf.String() != expect {
+		t.Fatalf("invalid result: %s should be %s", buf.String(), expect)
+	}
+
+	var again interface{}
+	if err := LoadData(JSON5, &buf, &again); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(d, again) {
+		t.Fatalf("round-trip result %v does not match %v", again, d)
+	}
+}
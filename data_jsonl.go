@@ -0,0 +1,103 @@
+package dati
+
+/*
+Copyright (C) 2023 gearsix <gearsix@tuta.io>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+const JSONL DataFormat = "jsonl"
+
+func init() {
+	RegisterDataFormat(JSONL, []string{"jsonl", "ndjson"}, decodeJSONL, encodeJSONL)
+	RegisterDataStreamer(JSONL, newJSONLStream)
+	RegisterDataSniffer(JSONL, func(head []byte) bool {
+		n, ok := sniffJSON(head)
+		return ok && n > 1
+	})
+}
+
+// decodeJSONL decodes JSON Lines data (see https://jsonlines.org/) to a list
+// of each line. Empty lines are skipped.
+func decodeJSONL(in []byte, out interface{}) error {
+	stream := newJSONLStream(bytes.NewReader(in))
+	data := make([]interface{}, 0)
+	for {
+		var record interface{}
+		if err := stream.Next(&record); err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		data = append(data, record)
+	}
+	return setData(data, out)
+}
+
+// encodeJSONL encodes each item of `data` as a line of JSON. If `data` isn't
+// a list, it's written as a single line.
+func encodeJSONL(w io.Writer, data interface{}) error {
+	records, ok := toList(data)
+	if !ok {
+		records = []interface{}{data}
+	}
+
+	bw := bufio.NewWriter(w)
+	e := json.NewEncoder(bw)
+	for _, record := range records {
+		if err := e.Encode(record); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+type jsonlStream struct {
+	r    *bufio.Reader
+	line int
+}
+
+func newJSONLStream(r io.Reader) DataStream {
+	return &jsonlStream{r: bufio.NewReader(r)}
+}
+
+func (s *jsonlStream) Next(out interface{}) error {
+	for {
+		buf, err := s.r.ReadBytes('\n')
+		if len(buf) > 0 {
+			s.line++
+		}
+		if len(bytes.TrimSpace(buf)) == 0 {
+			if err != nil {
+				return err
+			}
+			continue
+		} else if err != nil && err != io.EOF {
+			return err
+		}
+
+		if err = json.Unmarshal(buf, out); err != nil {
+			return fmt.Errorf("line %d: %s", s.line, err)
+		}
+		return nil
+	}
+}
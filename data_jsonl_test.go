@@ -0,0 +1,162 @@
+package dati
+
+/*
+Copyright (C) 2023 gearsix <gearsix@tuta.io>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const jsonlGood = "{\"id\": 1, \"msg\": \"a\"}\n\n{\"id\": 2, \"msg\": \"b\"}\r\n[3]"
+const jsonlBad = "{\"id\": 1}\n{\"id\": \n"
+
+func TestReadJSONLDataFormat(t *testing.T) {
+	for path, format := range map[string]DataFormat{
+		"x.jsonl": JSONL, "x.ndjson": JSONL, "JSONL": JSONL,
+	} {
+		if f := ReadDataFormat(path); f != format {
+			t.Fatalf("'%s' returned '%s', not '%s'", path, f, format)
+		}
+	}
+}
+
+func TestLoadJSONLData(t *testing.T) {
+	var d []interface{}
+	if err := LoadData(JSONL, strings.NewReader(jsonlGood), &d); err != nil {
+		t.Fatal(err)
+	}
+	expect := []interface{}{
+		map[string]interface{}{"id": 1.0, "msg": "a"},
+		map[string]interface{}{"id": 2.0, "msg": "b"},
+		[]interface{}{3.0},
+	}
+	if !reflect.DeepEqual(d, expect) {
+		t.Fatalf("invalid result: %v should be %v", d, expect)
+	}
+
+	if err := LoadData(JSONL, strings.NewReader(jsonlBad), &d); err == nil {
+		t.Fatal("bad data passed")
+	} else if !strings.HasPrefix(err.Error(), "jsonl: line 2: ") {
+		t.Fatalf("error does not indicate format & line: %s", err)
+	}
+}
+
+func TestWriteJSONLData(t *testing.T) {
+	var buf bytes.Buffer
+
+	var d interface{}
+	if err := LoadData(JSONL, strings.NewReader(jsonlGood), &d); err != nil {
+		t.Skip("setup failure:", err)
+	}
+	if err := WriteData(JSONL, d, &buf); err != nil {
+		t.Fatal(err)
+	} else if expect := "{\"id\":1,\"msg\":\"a\"}\n{\"id\":2,\"msg\":\"b\"}\n[3]\n"; buf.String() != expect {
+		t.Fatalf("invalid result: %s should be %s", buf.String(), expect)
+	}
+
+	buf.Reset()
+	if err := WriteData(JSONL, map[string]int{"a": 1}, &buf); err != nil {
+		t.Fatal(err)
+	} else if buf.String() != "{\"a\":1}\n" {
+		t.Fatalf("invalid result: %s", buf.String())
+	}
+}
+
+type failReader struct{}
+
+func (failReader) Read([]byte) (int, error) {
+	return 0, errors.New("read failed")
+}
+
+func TestLoadDataStream(t *testing.T) {
+	if !IsDataStreamable(JSONL) || IsDataStreamable(JSON) {
+		t.Fatal("IsDataStreamable returned the wrong result")
+	}
+
+	// records should be decoded before the rest of the input is read
+	in := io.MultiReader(strings.NewReader("{\"id\": 1}\n"), failReader{})
+	stream, err := LoadDataStream(JSONL, in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var record struct{ ID int }
+	if err = stream.Next(&record); err != nil {
+		t.Fatal(err)
+	} else if record.ID != 1 {
+		t.Fatalf("invalid record: %v", record)
+	}
+	if err = stream.Next(&record); err == nil || err == io.EOF {
+		t.Fatal("read error not returned")
+	} else if !strings.HasPrefix(err.Error(), "jsonl: ") {
+		t.Fatalf("error does not indicate format: %s", err)
+	}
+
+	for format, in := range map[DataFormat]string{
+		JSONL: jsonlGood,
+		JSON:  `[{"id": 1, "msg": "a"}, {"id": 2, "msg": "b"}, [3]]`,
+		YAML:  "- {id: 1, msg: a}\n- {id: 2, msg: b}\n- [3]\n",
+	} {
+		if stream, err = LoadDataStream(format, strings.NewReader(in)); err != nil {
+			t.Fatal(err)
+		}
+		var records []interface{}
+		for {
+			var r interface{}
+			if err = stream.Next(&r); err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatalf("%s: %s", format, err)
+			}
+			records = append(records, r)
+		}
+		if len(records) != 3 {
+			t.Fatalf("%s: invalid number of records: %v", format, records)
+		}
+	}
+
+	if stream, err = LoadDataStream(JSON, strings.NewReader(`{"a": 1}`)); err != nil {
+		t.Fatal(err)
+	}
+	var single map[string]interface{}
+	if err = stream.Next(&single); err != nil || single["a"] != 1.0 {
+		t.Fatalf("invalid single record: %v (%v)", single, err)
+	} else if err = stream.Next(&single); err != io.EOF {
+		t.Fatalf("stream did not end: %v", err)
+	}
+
+	if _, err = LoadDataStream(JSON, strings.NewReader(`{`)); err == nil {
+		t.Fatal("bad data passed")
+	}
+}
+
+func TestRegisterDataStreamer(t *testing.T) {
+	const format DataFormat = "test-stream"
+	RegisterDataFormat(format, []string{"test-stream"}, decodeJSONL, encodeJSONL)
+	RegisterDataStreamer(format, newJSONLStream)
+	if !IsDataStreamable(format) {
+		t.Fatal("registered streamer not found")
+	}
+	RegisterDataStreamer(format, nil)
+	if IsDataStreamable(format) {
+		t.Fatal("nil streamer was registered")
+	}
+}
@@ -0,0 +1,188 @@
+package dati
+
+/*
+Copyright (C) 2023 gearsix <gearsix@tuta.io>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	hmpl "html/template"
+	"io"
+	"io/ioutil"
+
+	"github.com/yuin/goldmark"
+)
+
+const MD DataFormat = "md"
+
+func init() {
+	RegisterDataFormat(MD, []string{"md", "markdown"},
+		NewMarkdownDecoder(MarkdownOptions{}), NewMarkdownEncoder(MarkdownOptions{}))
+}
+
+// MarkdownOptions sets how markdown files are decoded & encoded, see
+// `NewMarkdownDecoder` and `NewMarkdownEncoder`.
+type MarkdownOptions struct {
+	// BodyKey is the key used for the body of the file (everything after
+	// the front matter), if not set "body" is used.
+	BodyKey string
+	// HTMLKey is the key used for the body rendered as HTML, if not set
+	// the body isn't rendered.
+	HTMLKey string
+	// Markdown is used to render the body, if nil `goldmark.New()` is used
+	// (which renders CommonMark).
+	Markdown goldmark.Markdown
+}
+
+func (opts MarkdownOptions) bodyKey() string {
+	if len(opts.BodyKey) == 0 {
+		return "body"
+	}
+	return opts.BodyKey
+}
+
+// NewMarkdownDecoder returns a *DataDecoder* that decodes markdown files
+// with (optional) front matter using `opts`.
+// The front matter is decoded using the registered decoder for it's format:
+// YAML if it's surrounded by "---" lines, TOML if it's surrounded by "+++"
+// lines or JSON if the file starts with "{". The result is a map of the front
+// matter, with the body set to `opts.BodyKey`.
+// If `opts.HTMLKey` is set, the body is rendered to HTML and set to that key
+// as an html/template *HTML*, so it isn't escaped by html/template.
+// By default, ".md" and ".markdown" files are decoded with the body set to
+// "body" and the body isn't rendered. To render it, register `MD` again with
+// a decoder that has `HTMLKey` set.
+func NewMarkdownDecoder(opts MarkdownOptions) DataDecoder {
+	return func(in []byte, out interface{}) error {
+		format, frontMatter, body, err := splitFrontMatter(in)
+		if err != nil {
+			return err
+		}
+
+		data := make(map[string]interface{})
+		if len(format) > 0 {
+			if err = LoadData(format, bytes.NewReader(frontMatter), &data); err != nil {
+				return err
+			} else if data == nil {
+				data = make(map[string]interface{})
+			}
+		}
+
+		data[opts.bodyKey()] = string(body)
+		if len(opts.HTMLKey) > 0 {
+			md := opts.Markdown
+			if md == nil {
+				md = goldmark.New()
+			}
+			var html bytes.Buffer
+			if err = md.Convert(body, &html); err != nil {
+				return err
+			}
+			data[opts.HTMLKey] = hmpl.HTML(html.String())
+		}
+
+		return setData(data, out)
+	}
+}
+
+// splitFrontMatter returns the *DataFormat*, front matter & body of the
+// markdown in `in`. If there's no front matter, format is "".
+func splitFrontMatter(in []byte) (format DataFormat, frontMatter, body []byte, err error) {
+	in = bytes.TrimPrefix(in, []byte("\xef\xbb\xbf"))
+
+	if bytes.HasPrefix(in, []byte("{")) {
+		r := bytes.NewReader(in)
+		d := json.NewDecoder(r)
+		var raw json.RawMessage
+		if err = d.Decode(&raw); err != nil {
+			return
+		}
+		buffered, _ := io.Copy(ioutil.Discard, d.Buffered())
+		end := len(in) - r.Len() - int(buffered)
+		return JSON, in[:end], trimNewline(in[end:]), nil
+	}
+
+	delims := map[string]DataFormat{"---": YAML, "+++": TOML}
+	line, rest := splitLine(in)
+	if format = delims[string(bytes.TrimRight(line, " \t\r"))]; len(format) == 0 {
+		return "", nil, in, nil
+	}
+
+	start := len(in) - len(rest)
+	for len(rest) > 0 {
+		offset := len(in) - len(rest)
+		line, rest = splitLine(rest)
+		if delims[string(bytes.TrimRight(line, " \t\r"))] == format {
+			return format, in[start:offset], rest, nil
+		}
+	}
+	return "", nil, nil, fmt.Errorf("%s front matter is not closed", format)
+}
+
+// returns the first line of `in` (without the "\n") and the rest of `in`
+func splitLine(in []byte) (line, rest []byte) {
+	if i := bytes.IndexByte(in, '\n'); i >= 0 {
+		return in[:i], in[i+1:]
+	}
+	return in, nil
+}
+
+func trimNewline(in []byte) []byte {
+	if bytes.HasPrefix(in, []byte("\r\n")) {
+		return in[2:]
+	}
+	return bytes.TrimPrefix(in, []byte("\n"))
+}
+
+// NewMarkdownEncoder returns a *DataEncoder* that encodes data as a markdown
+// file using `opts`, it reverses `NewMarkdownDecoder`.
+// `data` must be a map (or struct), `opts.BodyKey` is written as the body and
+// any other keys (except `opts.HTMLKey`) are written as YAML front matter.
+func NewMarkdownEncoder(opts MarkdownOptions) DataEncoder {
+	return func(w io.Writer, data interface{}) error {
+		generic, err := toGenericData(data)
+		if err != nil {
+			return err
+		}
+		m, ok := generic.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("can't encode %T as a markdown file", data)
+		}
+
+		body := fmt.Sprint(m[opts.bodyKey()])
+		if m[opts.bodyKey()] == nil {
+			body = ""
+		}
+		delete(m, opts.bodyKey())
+		if len(opts.HTMLKey) > 0 {
+			delete(m, opts.HTMLKey)
+		}
+
+		if len(m) > 0 {
+			if _, err = io.WriteString(w, "---\n"); err != nil {
+				return err
+			} else if err = WriteData(YAML, m, w); err != nil {
+				return err
+			} else if _, err = io.WriteString(w, "---\n"); err != nil {
+				return err
+			}
+		}
+		_, err = io.WriteString(w, body)
+		return err
+	}
+}
@@ -0,0 +1,136 @@
+package dati
+
+/*
+Copyright (C) 2023 gearsix <gearsix@tuta.io>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"bytes"
+	hmpl "html/template"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const mdBody = "# Hello\n\nSome *text*.\n"
+const mdHTML = "<h1>Hello</h1>\n<p>Some <em>text</em>.</p>\n"
+
+func TestReadMarkdownDataFormat(t *testing.T) {
+	for path, format := range map[string]DataFormat{
+		"x.md": MD, "MD": MD, "x.markdown": MD,
+	} {
+		if f := ReadDataFormat(path); f != format {
+			t.Fatalf("'%s' returned '%s', not '%s'", path, f, format)
+		}
+	}
+}
+
+func TestLoadMarkdownData(t *testing.T) {
+	for name, in := range map[string]string{
+		"yaml": "---\ntitle: x\ntags: [a, b]\n---\n" + mdBody,
+		"toml": "+++\r\ntitle = \"x\"\r\ntags = [\"a\", \"b\"]\r\n+++\r\n" + mdBody,
+		"json": "\xef\xbb\xbf{\"title\": \"x\", \"tags\": [\"a\", \"b\"]}\n" + mdBody,
+	} {
+		var d map[string]interface{}
+		if err := LoadData(MD, strings.NewReader(in), &d); err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if d["title"] != "x" || len(d["tags"].([]interface{})) != 2 {
+			t.Fatalf("%s: invalid front matter: %v", name, d)
+		} else if d["body"] != mdBody {
+			t.Fatalf("%s: invalid body: '%v'", name, d["body"])
+		} else if _, ok := d["html"]; ok {
+			t.Fatalf("%s: html should not be rendered by default", name)
+		}
+	}
+
+	var i interface{}
+	decode := NewMarkdownDecoder(MarkdownOptions{BodyKey: "content"})
+	if err := decode([]byte(mdBody), &i); err != nil {
+		t.Fatal(err)
+	} else if expect := map[string]interface{}{"content": mdBody}; !reflect.DeepEqual(i, expect) {
+		t.Fatalf("invalid result: %v should be %v", i, expect)
+	}
+
+	html := NewMarkdownDecoder(MarkdownOptions{HTMLKey: "html"})
+	if err := html([]byte(mdBody), &i); err != nil {
+		t.Fatal(err)
+	} else if expect := map[string]interface{}{"body": mdBody, "html": hmpl.HTML(mdHTML)}; !reflect.DeepEqual(i, expect) {
+		t.Fatalf("invalid result: %v should be %v", i, expect)
+	}
+
+	for _, bad := range []string{
+		"---\ntitle: x\n" + mdBody,
+		"+++\ntitle = \n+++\n" + mdBody,
+		"{\"title\": \n" + mdBody,
+	} {
+		if err := LoadData(MD, strings.NewReader(bad), &i); err == nil {
+			t.Fatalf("bad data passed: %s", bad)
+		} else if !strings.HasPrefix(err.Error(), "md: ") {
+			t.Fatalf("error does not indicate format: %s", err)
+		}
+	}
+}
+
+func TestWriteMarkdownData(t *testing.T) {
+	var buf bytes.Buffer
+
+	var d map[string]interface{}
+	if err := LoadData(MD, strings.NewReader("---\ntitle: x\n---\n"+mdBody), &d); err != nil {
+		t.Skip("setup failure:", err)
+	}
+	if err := WriteData(MD, d, &buf); err != nil {
+		t.Fatal(err)
+	} else if expect := "---\ntitle: x\n---\n" + mdBody; buf.String() != expect {
+		t.Fatalf("invalid result: %s should be %s", buf.String(), expect)
+	}
+
+	var again map[string]interface{}
+	if err := LoadData(MD, &buf, &again); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(d, again) {
+		t.Fatalf("round-trip result %v does not match %v", again, d)
+	}
+
+	buf.Reset()
+	if err := WriteData(MD, map[string]string{"body": mdBody}, &buf); err != nil {
+		t.Fatal(err)
+	} else if buf.String() != mdBody {
+		t.Fatalf("invalid result: %s should be %s", buf.String(), mdBody)
+	}
+
+	if err := WriteData(MD, []string{mdBody}, &buf); err == nil {
+		t.Fatal("bad data passed")
+	}
+}
+
+func TestExecuteMarkdownData(t *testing.T) {
+	var d interface{}
+	decode := NewMarkdownDecoder(MarkdownOptions{HTMLKey: "html"})
+	if err := decode([]byte("---\ntitle: x\n---\n"+mdBody), &d); err != nil {
+		t.Skip("setup failure:", err)
+	}
+
+	template, err := LoadTemplateString(HMPL, "md", `<title>{{.title}}</title>{{.html}}`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result, err := template.Execute(d); err != nil {
+		t.Fatal(err)
+	} else if expect := "<title>x</title>" + mdHTML; result.String() != expect {
+		t.Fatalf("invalid result '%s' should be '%s'", result.String(), expect)
+	}
+}
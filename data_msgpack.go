@@ -0,0 +1,337 @@
+package dati
+
+/*
+Copyright (C) 2023 gearsix <gearsix@tuta.io>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+const MSGPACK DataFormat = "msgpack"
+
+func init() {
+	RegisterDataFormat(MSGPACK, []string{"msgpack", "mpk"}, decodeMsgPack, encodeMsgPack)
+}
+
+// the extension type used for timestamps
+const msgpackTimestamp = -1
+
+// decodeMsgPack decodes MessagePack data (see
+// https://github.com/msgpack/msgpack/blob/master/spec.md) to the types
+// listed in data_binary.go. Timestamps are decoded to time.Time (in UTC),
+// any other extension types are decoded to a []byte of their data.
+func decodeMsgPack(in []byte, out interface{}) error {
+	d := msgpackDecoder{binaryReader{in: in}}
+	v, err := d.value(0)
+	if err != nil {
+		return err
+	} else if d.remaining() > 0 {
+		return fmt.Errorf("unexpected data after offset %d", d.pos)
+	}
+	return setData(v, out)
+}
+
+type msgpackDecoder struct {
+	binaryReader
+}
+
+func (d *msgpackDecoder) value(depth int) (interface{}, error) {
+	if depth > binaryMaxDepth {
+		return nil, errBinaryDepth
+	}
+
+	offset := d.pos
+	b, err := d.readByte()
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case b <= 0x7f: // positive fixint
+		return int64(b), nil
+	case b <= 0x8f: // fixmap
+		return d.mapValue(uint64(b&0x0f), depth)
+	case b <= 0x9f: // fixarray
+		return d.arrayValue(uint64(b&0x0f), depth)
+	case b <= 0xbf: // fixstr
+		s, err := d.read(uint64(b & 0x1f))
+		return string(s), err
+	case b >= 0xe0: // negative fixint
+		return int64(int8(b)), nil
+	}
+
+	switch b {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xc5, 0xc6: // bin 8, 16, 32
+		n, err := d.readUint(1 << (b - 0xc4))
+		if err != nil {
+			return nil, err
+		}
+		bin, err := d.read(n)
+		return append([]byte(nil), bin...), err
+	case 0xc7, 0xc8, 0xc9: // ext 8, 16, 32
+		n, err := d.readUint(1 << (b - 0xc7))
+		if err != nil {
+			return nil, err
+		}
+		return d.ext(n, offset)
+	case 0xca:
+		v, err := d.readUint(4)
+		return float64(math.Float32frombits(uint32(v))), err
+	case 0xcb:
+		v, err := d.readUint(8)
+		return math.Float64frombits(v), err
+	case 0xcc, 0xcd, 0xce, 0xcf: // uint 8, 16, 32, 64
+		v, err := d.readUint(1 << (b - 0xcc))
+		return binaryUint(v), err
+	case 0xd0, 0xd1, 0xd2, 0xd3: // int 8, 16, 32, 64
+		size := 1 << (b - 0xd0)
+		v, err := d.readUint(size)
+		shift := uint(64 - 8*size)
+		return int64(v<<shift) >> shift, err // sign-extend
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8: // fixext 1, 2, 4, 8, 16
+		return d.ext(1<<(b-0xd4), offset)
+	case 0xd9, 0xda, 0xdb: // str 8, 16, 32
+		n, err := d.readUint(1 << (b - 0xd9))
+		if err != nil {
+			return nil, err
+		}
+		s, err := d.read(n)
+		return string(s), err
+	case 0xdc, 0xdd: // array 16, 32
+		n, err := d.readUint(2 << (b - 0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return d.arrayValue(n, depth)
+	case 0xde, 0xdf: // map 16, 32
+		n, err := d.readUint(2 << (b - 0xde))
+		if err != nil {
+			return nil, err
+		}
+		return d.mapValue(n, depth)
+	default: // 0xc1
+		return nil, fmt.Errorf("invalid type 0x%x at offset %d", b, offset)
+	}
+}
+
+func (d *msgpackDecoder) arrayValue(n uint64, depth int) (interface{}, error) {
+	if err := d.checkLen(n); err != nil {
+		return nil, err
+	}
+	list := make([]interface{}, n)
+	for i := range list {
+		item, err := d.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		list[i] = item
+	}
+	return list, nil
+}
+
+func (d *msgpackDecoder) mapValue(n uint64, depth int) (interface{}, error) {
+	if err := d.checkLen(n); err != nil {
+		return nil, err
+	}
+	m := make(map[string]interface{}, n)
+	for i := uint64(0); i < n; i++ {
+		key, err := d.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		val, err := d.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		m[binaryKey(key)] = val
+	}
+	return m, nil
+}
+
+// ext reads the type & `n` bytes of data of an extension
+func (d *msgpackDecoder) ext(n uint64, offset int) (interface{}, error) {
+	typ, err := d.readByte()
+	if err != nil {
+		return nil, err
+	}
+	data, err := d.read(n)
+	if err != nil {
+		return nil, err
+	} else if int8(typ) != msgpackTimestamp {
+		return append([]byte(nil), data...), nil
+	}
+
+	r := binaryReader{in: data}
+	var sec, nsec uint64
+	switch n {
+	case 4:
+		sec, _ = r.readUint(4)
+	case 8:
+		v, _ := r.readUint(8)
+		nsec, sec = v>>34, v&(1<<34-1)
+	case 12:
+		nsec, _ = r.readUint(4)
+		sec, _ = r.readUint(8)
+	default:
+		return nil, fmt.Errorf("invalid timestamp length %d at offset %d", n, offset)
+	}
+	if nsec > 999999999 {
+		return nil, fmt.Errorf("invalid timestamp nanoseconds at offset %d", offset)
+	}
+	return time.Unix(int64(sec), int64(nsec)).UTC(), nil
+}
+
+// encodeMsgPack encodes `data` as MessagePack, see `encodeBinary`. Integers
+// are written using the smallest possible encoding, floats are always 64-bit
+// and times are written as timestamps.
+func encodeMsgPack(w io.Writer, data interface{}) error {
+	var e msgpackEncoder
+	if err := encodeBinary(&e, data, 0); err != nil {
+		return err
+	}
+	_, err := w.Write(e.buf)
+	return err
+}
+
+type msgpackEncoder struct {
+	buf []byte
+}
+
+// writes the type byte for the smallest of `types` (8, 16 or 32-bit) that
+// can store `n`, followed by `n`
+func (e *msgpackEncoder) length(n int, types [3]byte) {
+	switch {
+	case n <= math.MaxUint8 && types[0] != 0:
+		e.buf = append(e.buf, types[0], byte(n))
+	case n <= math.MaxUint16:
+		e.buf = append(e.buf, types[1])
+		e.buf = appendUint(e.buf, uint64(n), 2)
+	default:
+		e.buf = append(e.buf, types[2])
+		e.buf = appendUint(e.buf, uint64(n), 4)
+	}
+}
+
+func (e *msgpackEncoder) writeNil() {
+	e.buf = append(e.buf, 0xc0)
+}
+
+func (e *msgpackEncoder) writeBool(v bool) {
+	if v {
+		e.buf = append(e.buf, 0xc3)
+	} else {
+		e.buf = append(e.buf, 0xc2)
+	}
+}
+
+func (e *msgpackEncoder) writeInt(v int64) {
+	switch {
+	case v >= 0:
+		e.writeUint(uint64(v))
+	case v >= -32:
+		e.buf = append(e.buf, byte(v))
+	case v >= math.MinInt8:
+		e.buf = append(e.buf, 0xd0, byte(v))
+	case v >= math.MinInt16:
+		e.buf = append(e.buf, 0xd1)
+		e.buf = appendUint(e.buf, uint64(v), 2)
+	case v >= math.MinInt32:
+		e.buf = append(e.buf, 0xd2)
+		e.buf = appendUint(e.buf, uint64(v), 4)
+	default:
+		e.buf = append(e.buf, 0xd3)
+		e.buf = appendUint(e.buf, uint64(v), 8)
+	}
+}
+
+func (e *msgpackEncoder) writeUint(v uint64) {
+	switch {
+	case v <= 0x7f:
+		e.buf = append(e.buf, byte(v))
+	case v <= math.MaxUint8:
+		e.buf = append(e.buf, 0xcc, byte(v))
+	case v <= math.MaxUint16:
+		e.buf = append(e.buf, 0xcd)
+		e.buf = appendUint(e.buf, v, 2)
+	case v <= math.MaxUint32:
+		e.buf = append(e.buf, 0xce)
+		e.buf = appendUint(e.buf, v, 4)
+	default:
+		e.buf = append(e.buf, 0xcf)
+		e.buf = appendUint(e.buf, v, 8)
+	}
+}
+
+func (e *msgpackEncoder) writeFloat(v float64) {
+	e.buf = append(e.buf, 0xcb)
+	e.buf = appendUint(e.buf, math.Float64bits(v), 8)
+}
+
+func (e *msgpackEncoder) writeString(v string) {
+	if len(v) < 32 {
+		e.buf = append(e.buf, 0xa0|byte(len(v)))
+	} else {
+		e.length(len(v), [3]byte{0xd9, 0xda, 0xdb})
+	}
+	e.buf = append(e.buf, v...)
+}
+
+func (e *msgpackEncoder) writeBytes(v []byte) {
+	e.length(len(v), [3]byte{0xc4, 0xc5, 0xc6})
+	e.buf = append(e.buf, v...)
+}
+
+func (e *msgpackEncoder) writeTime(v time.Time) {
+	sec, nsec := v.Unix(), uint64(v.Nanosecond())
+	switch {
+	case sec >= 0 && sec <= math.MaxUint32 && nsec == 0:
+		e.buf = append(e.buf, 0xd6, 0xff)
+		e.buf = appendUint(e.buf, uint64(sec), 4)
+	case sec >= 0 && sec < 1<<34:
+		e.buf = append(e.buf, 0xd7, 0xff)
+		e.buf = appendUint(e.buf, nsec<<34|uint64(sec), 8)
+	default:
+		e.buf = append(e.buf, 0xc7, 12, 0xff)
+		e.buf = appendUint(e.buf, nsec, 4)
+		e.buf = appendUint(e.buf, uint64(sec), 8)
+	}
+}
+
+func (e *msgpackEncoder) writeArrayHeader(n int) {
+	if n < 16 {
+		e.buf = append(e.buf, 0x90|byte(n))
+	} else {
+		e.length(n, [3]byte{0, 0xdc, 0xdd})
+	}
+}
+
+func (e *msgpackEncoder) writeMapHeader(n int) {
+	if n < 16 {
+		e.buf = append(e.buf, 0x80|byte(n))
+	} else {
+		e.length(n, [3]byte{0, 0xde, 0xdf})
+	}
+}
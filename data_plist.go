@@ -0,0 +1,298 @@
+package dati
+
+/*
+Copyright (C) 2023 gearsix <gearsix@tuta.io>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const PLIST DataFormat = "plist"
+
+func init() {
+	RegisterDataFormat(PLIST, []string{"plist"}, decodePlist, encodePlist)
+}
+
+// the maximum number of nested arrays/dicts that will be decoded or encoded
+const plistMaxDepth = 10000
+
+const plistHeader = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+`
+
+// decodePlist decodes XML property list data (see
+// https://developer.apple.com/documentation/bundleresources/information_property_list)
+// to the same types that YAML is decoded to: <dict> is decoded to a
+// map[string]interface{}, <array> to a []interface{}, <integer> to an int
+// and <real> to a float64. <date> is decoded to a time.Time and <data> to a
+// []byte. Binary property lists aren't supported.
+func decodePlist(in []byte, out interface{}) error {
+	d := plistDecoder{xml.NewDecoder(bytes.NewReader(in))}
+
+	start, err := d.start()
+	if err != nil {
+		return err
+	} else if start == nil || start.Name.Local != "plist" {
+		return fmt.Errorf("missing <plist> element")
+	}
+
+	var v interface{}
+	if start, err = d.start(); err != nil {
+		return err
+	} else if start != nil {
+		if v, err = d.value(*start, 0); err != nil {
+			return err
+		}
+		if start, err = d.start(); err != nil {
+			return err
+		} else if start != nil {
+			return fmt.Errorf("<plist> has more than one value")
+		}
+	}
+	return setData(v, out)
+}
+
+type plistDecoder struct {
+	*xml.Decoder
+}
+
+// start returns the next start element, or nil if an end element (or the
+// end of the data) is found first.
+func (d plistDecoder) start() (*xml.StartElement, error) {
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			return nil, nil
+		} else if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			return &t, nil
+		case xml.EndElement:
+			return nil, nil
+		case xml.CharData:
+			if len(bytes.TrimSpace(t)) > 0 {
+				return nil, fmt.Errorf("unexpected text '%s'", bytes.TrimSpace(t))
+			}
+		}
+	}
+}
+
+// text returns the text of the element started by `start`
+func (d plistDecoder) text(start xml.StartElement) (string, error) {
+	var s string
+	err := d.DecodeElement(&s, &start)
+	return s, err
+}
+
+func (d plistDecoder) value(start xml.StartElement, depth int) (interface{}, error) {
+	if depth > plistMaxDepth {
+		return nil, fmt.Errorf("data is nested more than %d levels deep", plistMaxDepth)
+	}
+
+	switch start.Name.Local {
+	case "dict":
+		m := make(map[string]interface{})
+		for {
+			key, err := d.start()
+			if err != nil {
+				return nil, err
+			} else if key == nil {
+				return m, nil
+			} else if key.Name.Local != "key" {
+				return nil, fmt.Errorf("expected <key> in <dict>, found <%s>", key.Name.Local)
+			}
+			k, err := d.text(*key)
+			if err != nil {
+				return nil, err
+			}
+
+			val, err := d.start()
+			if err != nil {
+				return nil, err
+			} else if val == nil {
+				return nil, fmt.Errorf("missing value for key '%s'", k)
+			}
+			if m[k], err = d.value(*val, depth+1); err != nil {
+				return nil, err
+			}
+		}
+	case "array":
+		list := make([]interface{}, 0)
+		for {
+			item, err := d.start()
+			if err != nil {
+				return nil, err
+			} else if item == nil {
+				return list, nil
+			}
+			v, err := d.value(*item, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+		}
+	case "true", "false":
+		return start.Name.Local == "true", d.Skip()
+	}
+
+	text, err := d.text(start)
+	if err != nil {
+		return nil, err
+	}
+	switch start.Name.Local {
+	case "string":
+		return text, nil
+	case "integer":
+		text = strings.TrimSpace(text)
+		if i, err := strconv.ParseInt(text, 0, 0); err == nil {
+			return int(i), nil
+		} else if u, err := strconv.ParseUint(text, 0, 64); err == nil {
+			return u, nil
+		}
+		return nil, fmt.Errorf("invalid <integer> '%s'", text)
+	case "real":
+		f, err := strconv.ParseFloat(strings.TrimSpace(text), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid <real> '%s'", text)
+		}
+		return f, nil
+	case "date":
+		t, err := time.Parse(time.RFC3339, strings.TrimSpace(text))
+		if err != nil {
+			return nil, fmt.Errorf("invalid <date> '%s'", text)
+		}
+		return t, nil
+	case "data":
+		b, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(text), ""))
+		if err != nil {
+			return nil, fmt.Errorf("invalid <data>: %s", err)
+		}
+		return b, nil
+	default:
+		return nil, fmt.Errorf("unsupported element <%s>", start.Name.Local)
+	}
+}
+
+// encodePlist encodes `data` as an XML property list, it reverses
+// `decodePlist`. Map keys are sorted, nil values can't be encoded.
+func encodePlist(w io.Writer, data interface{}) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString(plistHeader)
+	if err := writePlistValue(bw, data, "", 0); err != nil {
+		return err
+	}
+	bw.WriteString("</plist>\n")
+	return bw.Flush()
+}
+
+func writePlistValue(w *bufio.Writer, v interface{}, indent string, depth int) error {
+	if depth > plistMaxDepth {
+		return fmt.Errorf("data is nested more than %d levels deep", plistMaxDepth)
+	}
+
+	switch val := v.(type) {
+	case nil:
+		return fmt.Errorf("can't encode nil as plist")
+	case time.Time:
+		return writePlistElement(w, indent, "date", val.UTC().Format(time.RFC3339))
+	case []byte:
+		return writePlistElement(w, indent, "data", base64.StdEncoding.EncodeToString(val))
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Bool:
+		fmt.Fprintf(w, "%s<%t/>\n", indent, rv.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return writePlistElement(w, indent, "integer", strconv.FormatInt(rv.Int(), 10))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return writePlistElement(w, indent, "integer", strconv.FormatUint(rv.Uint(), 10))
+	case reflect.Float32, reflect.Float64:
+		f := rv.Float()
+		if math.IsInf(f, 0) || math.IsNaN(f) {
+			return fmt.Errorf("can't encode %v as plist", f)
+		}
+		return writePlistElement(w, indent, "real", strconv.FormatFloat(f, 'g', -1, 64))
+	case reflect.String:
+		return writePlistElement(w, indent, "string", rv.String())
+	case reflect.Ptr, reflect.Interface:
+		if rv.IsNil() {
+			return writePlistValue(w, nil, indent, depth)
+		}
+		return writePlistValue(w, rv.Elem().Interface(), indent, depth+1)
+	case reflect.Map:
+		m, _ := toStringMap(v)
+		keys := make([]string, 0, len(m))
+		for key := range m {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		fmt.Fprintf(w, "%s<dict>\n", indent)
+		for _, key := range keys {
+			if err := writePlistElement(w, indent+"\t", "key", key); err != nil {
+				return err
+			} else if err = writePlistValue(w, m[key], indent+"\t", depth+1); err != nil {
+				return fmt.Errorf("%s: %s", key, err)
+			}
+		}
+		fmt.Fprintf(w, "%s</dict>\n", indent)
+	case reflect.Slice, reflect.Array:
+		if rv.Type().Elem().Kind() == reflect.Uint8 {
+			b := make([]byte, rv.Len())
+			reflect.Copy(reflect.ValueOf(b), rv)
+			return writePlistValue(w, b, indent, depth)
+		}
+		l, _ := toList(v)
+		fmt.Fprintf(w, "%s<array>\n", indent)
+		for _, item := range l {
+			if err := writePlistValue(w, item, indent+"\t", depth+1); err != nil {
+				return err
+			}
+		}
+		fmt.Fprintf(w, "%s</array>\n", indent)
+	default:
+		generic, err := toGenericData(v)
+		if err != nil {
+			return err
+		}
+		return writePlistValue(w, generic, indent, depth+1)
+	}
+	return nil
+}
+
+func writePlistElement(w *bufio.Writer, indent, name, text string) error {
+	fmt.Fprintf(w, "%s<%s>", indent, name)
+	if err := xml.EscapeText(w, []byte(text)); err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "</%s>\n", name)
+	return nil
+}
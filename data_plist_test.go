@@ -0,0 +1,137 @@
+package dati
+
+/*
+Copyright (C) 2023 gearsix <gearsix@tuta.io>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const plistGood = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>CFBundleName</key>
+	<string>dati &amp; co</string>
+	<key>LSMinimumSystemVersion</key>
+	<integer>12</integer>
+	<key>Scale</key>
+	<real>1.5</real>
+	<key>Enabled</key>
+	<true/>
+	<key>Hidden</key>
+	<false/>
+	<key>Created</key>
+	<date>2023-01-02T03:04:05Z</date>
+	<key>Icon</key>
+	<data>
+	aGVsbG8=
+	</data>
+	<key>Languages</key>
+	<array>
+		<string>en</string>
+		<dict/>
+		<array/>
+	</array>
+</dict>
+</plist>
+`
+
+func TestReadPlistDataFormat(t *testing.T) {
+	for path, format := range map[string]DataFormat{
+		"Info.plist": PLIST, "PLIST": PLIST,
+	} {
+		if f := ReadDataFormat(path); f != format {
+			t.Fatalf("'%s' returned '%s', not '%s'", path, f, format)
+		}
+	}
+}
+
+func TestLoadPlistData(t *testing.T) {
+	var d map[string]interface{}
+	if err := LoadData(PLIST, strings.NewReader(plistGood), &d); err != nil {
+		t.Fatal(err)
+	}
+	expect := map[string]interface{}{
+		"CFBundleName":           "dati & co",
+		"LSMinimumSystemVersion": 12,
+		"Scale":                  1.5,
+		"Enabled":                true,
+		"Hidden":                 false,
+		"Created":                time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC),
+		"Icon":                   []byte("hello"),
+		"Languages": []interface{}{
+			"en", map[string]interface{}{}, []interface{}{},
+		},
+	}
+	if !reflect.DeepEqual(d, expect) {
+		t.Fatalf("invalid result: %v should be %v", d, expect)
+	}
+
+	var v interface{}
+	for _, bad := range []string{
+		"<dict/>",
+		"<plist><dict><string>x</string></dict></plist>",
+		"<plist><dict><key>x</key></dict></plist>",
+		"<plist><integer>x</integer></plist>",
+		"<plist><date>today</date></plist>",
+		"<plist><string>a</string><string>b</string></plist>",
+		"<plist><null/></plist>",
+		"<plist><array>",
+	} {
+		if err := LoadData(PLIST, strings.NewReader(bad), &v); err == nil {
+			t.Fatalf("bad data passed: %s", bad)
+		} else if !strings.HasPrefix(err.Error(), "plist: ") {
+			t.Fatalf("error does not indicate format: %s", err)
+		}
+	}
+}
+
+func TestWritePlistData(t *testing.T) {
+	var d interface{}
+	if err := LoadData(PLIST, strings.NewReader(plistGood), &d); err != nil {
+		t.Skip("setup failure:", err)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteData(PLIST, d, &buf); err != nil {
+		t.Fatal(err)
+	} else if !strings.HasPrefix(buf.String(), plistHeader+"<dict>\n\t<key>CFBundleName</key>\n\t<string>dati &amp; co</string>\n") {
+		t.Fatalf("invalid result: %s", buf.String())
+	}
+
+	var again interface{}
+	if err := LoadData(PLIST, &buf, &again); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(d, again) {
+		t.Fatalf("round-trip result %v does not match %v", again, d)
+	}
+
+	for _, bad := range []interface{}{
+		nil,
+		map[string]interface{}{"a": nil},
+		[]interface{}{func() {}},
+	} {
+		if err := WriteData(PLIST, bad, &buf); err == nil {
+			t.Fatalf("bad data passed: %v", bad)
+		}
+	}
+}
@@ -0,0 +1,270 @@
+package dati
+
+/*
+Copyright (C) 2023 gearsix <gearsix@tuta.io>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+)
+
+const PROPERTIES DataFormat = "properties"
+
+func init() {
+	RegisterDataFormat(PROPERTIES, []string{"properties"},
+		NewPropertiesDecoder(PropertiesOptions{}), NewPropertiesEncoder(PropertiesOptions{}))
+}
+
+// PropertiesOptions sets how Java .properties data is decoded & encoded, see
+// `NewPropertiesDecoder` and `NewPropertiesEncoder`.
+type PropertiesOptions struct {
+	// ExpandKeys will decode dotted keys to nested maps, e.g. "a.b=c" is
+	// decoded to {"a": {"b": "c"}} instead of {"a.b": "c"}.
+	ExpandKeys bool
+}
+
+// NewPropertiesDecoder returns a *DataDecoder* that decodes Java .properties
+// data using `opts`, see
+// https://docs.oracle.com/javase/8/docs/api/java/util/Properties.html#load-java.io.Reader-
+// The result is a map of all the keys, all values are strings.
+func NewPropertiesDecoder(opts PropertiesOptions) DataDecoder {
+	return func(in []byte, out interface{}) error {
+		data := make(map[string]interface{})
+
+		lines := strings.Split(strings.TrimPrefix(string(in), "\ufeff"), "\n")
+		for n := 0; n < len(lines); n++ {
+			line := strings.TrimLeft(strings.TrimSuffix(lines[n], "\r"), " \t\f")
+			if len(line) == 0 || line[0] == '#' || line[0] == '!' {
+				continue
+			}
+
+			// join continuation lines (ending in an odd number of '\')
+			for strings.HasSuffix(line, "\\") && (len(line)-len(strings.TrimRight(line, "\\")))%2 == 1 {
+				line = line[:len(line)-1]
+				if n++; n < len(lines) {
+					line += strings.TrimLeft(strings.TrimSuffix(lines[n], "\r"), " \t\f")
+				}
+			}
+
+			key, value, err := splitProperty(line)
+			if err != nil {
+				return fmt.Errorf("line %d: %s", n+1, err)
+			}
+			if !opts.ExpandKeys {
+				data[key] = value
+			} else if err = setPropertyPath(data, key, value); err != nil {
+				return fmt.Errorf("line %d: %s", n+1, err)
+			}
+		}
+
+		return setData(data, out)
+	}
+}
+
+// splitProperty returns the unescaped key & value of the logical `line`.
+// The key ends at the first unescaped '=', ':' or whitespace.
+func splitProperty(line string) (key, value string, err error) {
+	i := 0
+	for ; i < len(line); i++ {
+		if line[i] == '\\' {
+			i++
+		} else if strings.IndexByte("=: \t\f", line[i]) >= 0 {
+			break
+		}
+	}
+
+	rest := ""
+	if i < len(line) {
+		rest = strings.TrimLeft(line[i:], " \t\f")
+		if len(rest) > 0 && (rest[0] == '=' || rest[0] == ':') {
+			rest = strings.TrimLeft(rest[1:], " \t\f")
+		}
+		line = line[:i]
+	}
+
+	if key, err = unescapeProperty(line); err == nil {
+		value, err = unescapeProperty(rest)
+	}
+	return
+}
+
+func unescapeProperty(s string) (string, error) {
+	if strings.IndexByte(s, '\\') < 0 {
+		return s, nil
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			b.WriteByte(s[i])
+			continue
+		} else if i++; i == len(s) {
+			break
+		}
+
+		switch s[i] {
+		case 't':
+			b.WriteByte('\t')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 'f':
+			b.WriteByte('\f')
+		case 'u':
+			if i+5 > len(s) {
+				return "", fmt.Errorf("invalid unicode escape '\\%s'", s[i:])
+			}
+			r, err := strconv.ParseUint(s[i+1:i+5], 16, 16)
+			if err != nil {
+				return "", fmt.Errorf("invalid unicode escape '\\%s'", s[i:i+5])
+			}
+			i += 4
+			if utf16.IsSurrogate(rune(r)) && strings.HasPrefix(s[i+1:], "\\u") && i+7 <= len(s) {
+				if r2, err := strconv.ParseUint(s[i+3:i+7], 16, 16); err == nil {
+					if pair := utf16.DecodeRune(rune(r), rune(r2)); pair != unicode.ReplacementChar {
+						r = uint64(pair)
+						i += 6
+					}
+				}
+			}
+			b.WriteRune(rune(r))
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String(), nil
+}
+
+// sets `value` in `data` at the path of each dot-separated element in `key`
+func setPropertyPath(data map[string]interface{}, key, value string) error {
+	path := strings.Split(key, ".")
+	for i, name := range path[:len(path)-1] {
+		switch next := data[name].(type) {
+		case nil:
+			m := make(map[string]interface{})
+			data[name] = m
+			data = m
+		case map[string]interface{}:
+			data = next
+		default:
+			return fmt.Errorf("key '%s' conflicts with '%s'", key, strings.Join(path[:i+1], "."))
+		}
+	}
+
+	name := path[len(path)-1]
+	if _, ok := data[name].(map[string]interface{}); ok {
+		return fmt.Errorf("key '%s' conflicts with keys that start with '%s.'", key, key)
+	}
+	data[name] = value
+	return nil
+}
+
+// NewPropertiesEncoder returns a *DataEncoder* that encodes data as Java
+// .properties using `opts`, it reverses `NewPropertiesDecoder`.
+// `data` must be a map (or struct), any nested maps are written using dotted
+// keys (regardless of `opts.ExpandKeys`). Keys are sorted and values can't be
+// lists.
+func NewPropertiesEncoder(opts PropertiesOptions) DataEncoder {
+	return func(w io.Writer, data interface{}) error {
+		generic, err := toGenericData(data)
+		if err != nil {
+			return err
+		}
+		m, ok := generic.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("can't encode %T as properties", data)
+		}
+
+		properties := make(map[string]string)
+		if err = flattenProperties(properties, "", m); err != nil {
+			return err
+		}
+		keys := make([]string, 0, len(properties))
+		for key := range properties {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		bw := bufio.NewWriter(w)
+		for _, key := range keys {
+			bw.WriteString(escapeProperty(key, true))
+			bw.WriteString(" = ")
+			bw.WriteString(escapeProperty(properties[key], false))
+			bw.WriteString("\n")
+		}
+		return bw.Flush()
+	}
+}
+
+func flattenProperties(properties map[string]string, prefix string, m map[string]interface{}) error {
+	for key, val := range m {
+		key = prefix + key
+		switch v := val.(type) {
+		case map[string]interface{}:
+			if err := flattenProperties(properties, key+".", v); err != nil {
+				return err
+			}
+		case []interface{}:
+			return fmt.Errorf("can't encode list value of '%s' as properties", key)
+		case nil:
+			properties[key] = ""
+		default:
+			properties[key] = fmt.Sprint(v)
+		}
+	}
+	return nil
+}
+
+func escapeProperty(s string, isKey bool) string {
+	var b strings.Builder
+	for i, r := range s {
+		switch {
+		case r == '\\':
+			b.WriteString(`\\`)
+		case r == '\t':
+			b.WriteString(`\t`)
+		case r == '\n':
+			b.WriteString(`\n`)
+		case r == '\r':
+			b.WriteString(`\r`)
+		case r == '\f':
+			b.WriteString(`\f`)
+		case r == ' ' && (isKey || i == 0):
+			b.WriteString(`\ `)
+		case (r == '=' || r == ':') && isKey:
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case (r == '#' || r == '!') && i == 0:
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case !unicode.IsPrint(r):
+			for _, u := range utf16.Encode([]rune{r}) {
+				fmt.Fprintf(&b, `\u%04x`, u)
+			}
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
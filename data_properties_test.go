@@ -0,0 +1,131 @@
+package dati
+
+/*
+Copyright (C) 2023 gearsix <gearsix@tuta.io>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const propertiesGood = `# comment
+! another comment
+app.name = dati
+app.version:1.0
+app.motd   hello \
+    world
+path=C:\\dati\tx
+key\ with\ spaces = \u00e9\ud83d\ude00
+empty
+`
+const propertiesBad = `x = \u00zz`
+
+func TestReadPropertiesDataFormat(t *testing.T) {
+	for path, format := range map[string]DataFormat{
+		"x.properties": PROPERTIES, "PROPERTIES": PROPERTIES,
+	} {
+		if f := ReadDataFormat(path); f != format {
+			t.Fatalf("'%s' returned '%s', not '%s'", path, f, format)
+		}
+	}
+}
+
+func TestLoadPropertiesData(t *testing.T) {
+	var d map[string]interface{}
+	if err := LoadData(PROPERTIES, strings.NewReader(propertiesGood), &d); err != nil {
+		t.Fatal(err)
+	}
+	expect := map[string]interface{}{
+		"app.name":        "dati",
+		"app.version":     "1.0",
+		"app.motd":        "hello world",
+		"path":            "C:\\dati\tx",
+		"key with spaces": "é😀",
+		"empty":           "",
+	}
+	if !reflect.DeepEqual(d, expect) {
+		t.Fatalf("invalid result: %v should be %v", d, expect)
+	}
+
+	var i interface{}
+	expand := NewPropertiesDecoder(PropertiesOptions{ExpandKeys: true})
+	if err := expand([]byte("a.b = 1\na.c.d = 2\ne = 3\n"), &i); err != nil {
+		t.Fatal(err)
+	} else if expect := map[string]interface{}{
+		"a": map[string]interface{}{"b": "1", "c": map[string]interface{}{"d": "2"}},
+		"e": "3",
+	}; !reflect.DeepEqual(i, expect) {
+		t.Fatalf("invalid result: %v should be %v", i, expect)
+	}
+
+	for _, conflict := range []string{"a = 1\na.b = 2\n", "a.b = 1\na = 2\n"} {
+		if err := expand([]byte(conflict), &i); err == nil {
+			t.Fatalf("conflicting keys passed: %s", conflict)
+		}
+	}
+
+	if err := LoadData(PROPERTIES, strings.NewReader(propertiesBad), &i); err == nil {
+		t.Fatal("bad data passed")
+	} else if !strings.HasPrefix(err.Error(), "properties: ") {
+		t.Fatalf("error does not indicate format: %s", err)
+	}
+}
+
+func TestWritePropertiesData(t *testing.T) {
+	var buf bytes.Buffer
+
+	var d interface{}
+	if err := LoadData(PROPERTIES, strings.NewReader(propertiesGood), &d); err != nil {
+		t.Skip("setup failure:", err)
+	}
+	if err := WriteData(PROPERTIES, d, &buf); err != nil {
+		t.Fatal(err)
+	}
+
+	var again interface{}
+	if err := LoadData(PROPERTIES, bytes.NewReader(buf.Bytes()), &again); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(d, again) {
+		t.Fatalf("round-trip result %v does not match %v\n%s", again, d, buf.String())
+	}
+
+	buf.Reset()
+	data := map[string]interface{}{
+		"a":    map[string]interface{}{"b": 1, "c": map[string]interface{}{"d": true}},
+		"k=v":  " #x\n",
+		"#key": nil,
+	}
+	if err := WriteData(PROPERTIES, data, &buf); err != nil {
+		t.Fatal(err)
+	} else if expect := "\\#key = \na.b = 1\na.c.d = true\nk\\=v = \\ #x\\n\n"; buf.String() != expect {
+		t.Fatalf("invalid result: %s should be %s", buf.String(), expect)
+	}
+
+	expand := NewPropertiesDecoder(PropertiesOptions{ExpandKeys: true})
+	var expanded map[string]interface{}
+	if err := expand(buf.Bytes(), &expanded); err != nil {
+		t.Fatal(err)
+	} else if expanded["a"].(map[string]interface{})["c"].(map[string]interface{})["d"] != "true" {
+		t.Fatalf("invalid round-trip result: %v", expanded)
+	}
+
+	if err := WriteData(PROPERTIES, map[string]interface{}{"a": []int{1}}, &buf); err == nil {
+		t.Fatal("bad data passed")
+	}
+}
@@ -0,0 +1,113 @@
+package dati
+
+/*
+Copyright (C) 2023 gearsix <gearsix@tuta.io>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"io"
+)
+
+// DataStream decodes data one record at a time, see `LoadDataStream`.
+type DataStream interface {
+	// Next decodes the next record into the value pointed at by `out`.
+	// io.EOF is returned when there are no records left.
+	Next(out interface{}) error
+}
+
+// DataStreamer is the function signature used to create a *DataStream*
+// that decodes the records in `r` for a registered *DataFormat*.
+type DataStreamer func(r io.Reader) DataStream
+
+var dataStreamers = make(map[DataFormat]DataStreamer) // guarded by dataCodecsMu
+
+// RegisterDataStreamer sets `streamer` as the *DataStreamer* used by
+// `LoadDataStream` for `format`, so records can be decoded without reading
+// all of the data first. `format` should also be registered with
+// `RegisterDataFormat`, which doesn't change the streamer of a format.
+// If `streamer` is nil, `format` won't be streamed.
+func RegisterDataStreamer(format DataFormat, streamer DataStreamer) {
+	dataCodecsMu.Lock()
+	defer dataCodecsMu.Unlock()
+	if streamer == nil {
+		delete(dataStreamers, format)
+	} else {
+		dataStreamers[format] = streamer
+	}
+}
+
+// IsDataStreamable returns true if a *DataStreamer* is registered for
+// `format`.
+func IsDataStreamable(format DataFormat) bool {
+	dataCodecsMu.RLock()
+	defer dataCodecsMu.RUnlock()
+	_, ok := dataStreamers[format]
+	return ok
+}
+
+// LoadDataStream returns a *DataStream* of the records in `in`, which are
+// decoded as `format`.
+// If `format` has a registered *DataStreamer*, each record is only read from
+// `in` when it's decoded. Otherwise all of `in` is decoded by `LoadData` and
+// each item is returned as a record if the result is a list (if it isn't,
+// the result is returned as a single record).
+func LoadDataStream(format DataFormat, in io.Reader) (DataStream, error) {
+	dataCodecsMu.RLock()
+	streamer, ok := dataStreamers[format]
+	dataCodecsMu.RUnlock()
+	if ok {
+		return &formatStream{format: format, stream: streamer(in)}, nil
+	}
+
+	var data interface{}
+	if err := LoadData(format, in, &data); err != nil {
+		return nil, err
+	}
+	records, ok := toList(data)
+	if !ok {
+		records = []interface{}{data}
+	}
+	return &listStream{records: records}, nil
+}
+
+// formatStream makes sure that all errors returned by `stream` (except
+// io.EOF) indicate `format`.
+type formatStream struct {
+	format DataFormat
+	stream DataStream
+}
+
+func (s *formatStream) Next(out interface{}) error {
+	err := s.stream.Next(out)
+	if err == io.EOF {
+		return err
+	}
+	return dataError(s.format, err)
+}
+
+// listStream returns each of `records` as a record.
+type listStream struct {
+	records []interface{}
+}
+
+func (s *listStream) Next(out interface{}) error {
+	if len(s.records) == 0 {
+		return io.EOF
+	}
+	record := s.records[0]
+	s.records = s.records[1:]
+	return setData(record, out)
+}
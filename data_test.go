@@ -137,6 +137,9 @@ func TestLoadData(t *testing.T) {
 	if e = LoadData(TOML, strings.NewReader(""), &d); e != nil {
 		t.Fatalf("empty data failed %s, %s", d, e)
 	}
+	if e = LoadData("", strings.NewReader(""), &d); e != nil {
+		t.Fatalf("empty data of an unknown format failed %s, %s", d, e)
+	}
 	if e = LoadData("", strings.NewReader("shouldn't pass"), &d); e == nil {
 		t.Fatalf("invalid data language passed")
 	}
@@ -227,13 +230,13 @@ func TestWriteDataFile(t *testing.T) {
 
 	testGoodData := func(format DataFormat) {
 		path = filepath.Join(dir, "good."+string(format))
-		file, err = WriteDataFile(format, good[format], path)
+		file, err = WriteDataFile(format, good[format], path, true)
 		validateWriteData(t, err, good[format], file)
 	}
 
 	testBadFormat := func() {
 		path = filepath.Join(dir, "bad")
-		if file, err = WriteDataFile("", nil, path); err == nil {
+		if file, err = WriteDataFile("", nil, path, true); err == nil {
 			t.Errorf("bad format passed")
 		} else if file != nil {
 			t.Error("file is not nil")
@@ -245,3 +248,63 @@ func TestWriteDataFile(t *testing.T) {
 	}
 	testBadFormat()
 }
+
+func TestRegisterDataFormat(t *testing.T) {
+	const format DataFormat = "test"
+
+	RegisterDataFormat(format, []string{".tst", "TEST2"},
+		func(in []byte, out interface{}) error {
+			if p, ok := out.(*interface{}); ok {
+				*p = strings.ToUpper(string(in))
+				return nil
+			}
+			return fmt.Errorf("invalid out type")
+		},
+		func(w io.Writer, data interface{}) error {
+			_, err := fmt.Fprint(w, strings.ToLower(fmt.Sprint(data)))
+			return err
+		})
+
+	for _, path := range []string{"test", "x.tst", "x.TST", "x.test2"} {
+		if f := ReadDataFormat(path); f != format {
+			t.Fatalf("'%s' returned '%s', not '%s'", path, f, format)
+		}
+	}
+
+	found := false
+	for _, f := range DataFormats() {
+		if f == format {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("'%s' missing from DataFormats()", format)
+	}
+
+	var d interface{}
+	if err := LoadData(format, strings.NewReader("abc"), &d); err != nil {
+		t.Fatal(err)
+	} else if d != "ABC" {
+		t.Fatalf("invalid result: %s", d)
+	}
+	if err := LoadData(format, strings.NewReader("abc"), nil); err == nil {
+		t.Fatal("invalid out type passed")
+	} else if !strings.HasPrefix(err.Error(), format.String()+": ") {
+		t.Fatalf("error does not indicate format: %s", err)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteData(format, "ABC", &buf); err != nil {
+		t.Fatal(err)
+	} else if buf.String() != "abc" {
+		t.Fatalf("invalid result: %s", buf.String())
+	}
+
+	RegisterDataFormat(format, nil, nil, nil)
+	if IsDataFormat("x.tst") {
+		t.Fatal("replaced format extensions still registered")
+	}
+	if err := WriteData(format, "ABC", &buf); err == nil {
+		t.Fatal("nil encoder passed")
+	}
+}
@@ -0,0 +1,308 @@
+package dati
+
+/*
+Copyright (C) 2023 gearsix <gearsix@tuta.io>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const XML DataFormat = "xml"
+
+func init() {
+	RegisterDataFormat(XML, []string{"xml"}, NewXMLDecoder(XMLOptions{}), NewXMLEncoder(XMLOptions{}))
+	RegisterDataSniffer(XML, sniffXML)
+}
+
+// sniffXML matches data that starts with a declaration, comment or element
+func sniffXML(head []byte) bool {
+	head = bytes.TrimLeft(bytes.TrimPrefix(head, []byte("\xef\xbb\xbf")), " \t\r\n")
+	if len(head) < 2 || head[0] != '<' {
+		return false
+	}
+	c, _ := utf8.DecodeRune(head[1:])
+	return c == '?' || c == '!' || c == '_' || unicode.IsLetter(c)
+}
+
+// XMLOptions sets how XML data is decoded & encoded, see `NewXMLDecoder`
+// and `NewXMLEncoder`.
+type XMLOptions struct {
+	// AttrPrefix is prepended to the key of each attribute, if not set
+	// "@" is used.
+	AttrPrefix string
+	// TextKey is the key used for the text of elements that also have
+	// attributes or child elements, if not set "_text" is used.
+	TextKey string
+	// RootName is the name of the root element used when encoding data
+	// that doesn't have a single root key, if not set "data" is used.
+	RootName string
+}
+
+func (opts XMLOptions) attrPrefix() string {
+	if len(opts.AttrPrefix) == 0 {
+		return "@"
+	}
+	return opts.AttrPrefix
+}
+
+func (opts XMLOptions) textKey() string {
+	if len(opts.TextKey) == 0 {
+		return "_text"
+	}
+	return opts.TextKey
+}
+
+func (opts XMLOptions) rootName() string {
+	if len(opts.RootName) == 0 {
+		return "data"
+	}
+	return opts.RootName
+}
+
+// NewXMLDecoder returns a *DataDecoder* that decodes XML data using `opts`.
+// The result is a map with a single key (the name of the root element).
+// Elements are decoded using the following rules:
+//   - An element with no attributes or child elements is decoded as a
+//     string of it's text.
+//   - Any other element is decoded as a map, where:
+//   - each attribute is set to it's name prefixed with `opts.AttrPrefix`
+//     ("@" by default, e.g. `id="x"` is set to "@id").
+//   - each child element is set to it's name. If an element has more
+//     than one child with the same name, they're set as a list.
+//   - any text is set to `opts.TextKey` ("_text" by default).
+//
+// Namespace prefixes are dropped from element & attribute names (except
+// "xmlns" attributes). Whitespace around text is trimmed and all values are
+// strings.
+// Note that golang templates can't use "." to access keys with an "@"
+// prefix, `index` has to be used instead (e.g. `{{index . "@id"}}`).
+func NewXMLDecoder(opts XMLOptions) DataDecoder {
+	return func(in []byte, out interface{}) error {
+		d := xml.NewDecoder(bytes.NewReader(in))
+		for {
+			token, err := d.Token()
+			if err == io.EOF {
+				return fmt.Errorf("no root element found")
+			} else if err != nil {
+				return err
+			}
+
+			if start, ok := token.(xml.StartElement); ok {
+				root, err := opts.decodeElement(d, start)
+				if err != nil {
+					return err
+				}
+				return setData(map[string]interface{}{start.Name.Local: root}, out)
+			}
+		}
+	}
+}
+
+// decodes all tokens from `d` until the end of `start`
+func (opts XMLOptions) decodeElement(d *xml.Decoder, start xml.StartElement) (interface{}, error) {
+	element := make(map[string]interface{})
+	for _, attr := range start.Attr {
+		name := attr.Name.Local
+		if attr.Name.Space == "xmlns" {
+			name = "xmlns:" + name
+		}
+		element[opts.attrPrefix()+name] = attr.Value
+	}
+
+	var text strings.Builder
+	for {
+		token, err := d.Token()
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			child, err := opts.decodeElement(d, t)
+			if err != nil {
+				return nil, err
+			}
+			name := t.Name.Local
+			if existing, ok := element[name]; !ok {
+				element[name] = child
+			} else if list, ok := existing.([]interface{}); ok {
+				element[name] = append(list, child)
+			} else {
+				element[name] = []interface{}{existing, child}
+			}
+		case xml.CharData:
+			text.Write(t)
+		case xml.EndElement:
+			trimmed := strings.TrimSpace(text.String())
+			if len(element) == 0 {
+				return trimmed, nil
+			} else if len(trimmed) > 0 {
+				element[opts.textKey()] = trimmed
+			}
+			return element, nil
+		}
+	}
+}
+
+// NewXMLEncoder returns a *DataEncoder* that encodes data as XML using
+// `opts`, it reverses the rules used by `NewXMLDecoder`.
+// If the data is a map with a single key (that isn't a list), that key is
+// used as the root element. Otherwise the data is written to a root element
+// named `opts.RootName` ("data" by default).
+// Map keys are sorted, lists are written as repeated elements.
+func NewXMLEncoder(opts XMLOptions) DataEncoder {
+	return func(w io.Writer, data interface{}) error {
+		data, err := toGenericData(data)
+		if err != nil {
+			return err
+		}
+
+		name := opts.rootName()
+		if m, ok := data.(map[string]interface{}); ok && len(m) == 1 {
+			for key, val := range m {
+				if _, isList := val.([]interface{}); !isList {
+					name = key
+					data = val
+				}
+			}
+		}
+
+		e := xml.NewEncoder(w)
+		e.Indent("", "\t")
+		if err = opts.encodeElement(e, name, data); err == nil {
+			if err = e.Flush(); err == nil {
+				_, err = io.WriteString(w, "\n")
+			}
+		}
+		return err
+	}
+}
+
+func (opts XMLOptions) encodeElement(e *xml.Encoder, name string, data interface{}) error {
+	if list, ok := data.([]interface{}); ok {
+		for _, item := range list {
+			if err := opts.encodeElement(e, name, item); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if !isXMLName(name) {
+		return fmt.Errorf("invalid element name '%s'", name)
+	}
+	start := xml.StartElement{Name: xml.Name{Local: name}}
+	var text string
+	var children []string
+	m, isMap := data.(map[string]interface{})
+	if isMap {
+		for key := range m {
+			if key == opts.textKey() {
+				text = fmt.Sprint(m[key])
+			} else if strings.HasPrefix(key, opts.attrPrefix()) {
+				attr := strings.TrimPrefix(key, opts.attrPrefix())
+				if !isXMLName(attr) {
+					return fmt.Errorf("invalid attribute name '%s'", attr)
+				}
+				start.Attr = append(start.Attr, xml.Attr{
+					Name:  xml.Name{Local: attr},
+					Value: fmt.Sprint(m[key]),
+				})
+			} else {
+				children = append(children, key)
+			}
+		}
+		sort.Slice(start.Attr, func(i, j int) bool {
+			return start.Attr[i].Name.Local < start.Attr[j].Name.Local
+		})
+		sort.Strings(children)
+	} else if data != nil {
+		text = fmt.Sprint(data)
+	}
+
+	if err := e.EncodeToken(start); err != nil {
+		return err
+	}
+	if len(text) > 0 {
+		if err := e.EncodeToken(xml.CharData(text)); err != nil {
+			return err
+		}
+	}
+	for _, child := range children {
+		if err := opts.encodeElement(e, child, m[child]); err != nil {
+			return err
+		}
+	}
+	return e.EncodeToken(start.End())
+}
+
+// isXMLName returns true if `s` can be used as an element or attribute name
+// (e.g. "item", "xmlns:atom"), names can't start with a digit, '-' or '.'.
+func isXMLName(s string) bool {
+	for i, r := range s {
+		if !(unicode.IsLetter(r) || r == '_' ||
+			(i > 0 && (unicode.IsDigit(r) || r == '-' || r == '.' || r == ':'))) {
+			return false
+		}
+	}
+	return len(s) > 0
+}
+
+// toGenericData converts `data` to the generic map/list/value types that
+// encoding/json decodes to (map[string]interface{}, []interface{}, ...).
+// Numbers are decoded as int64 if they're integers, so they aren't written
+// in exponent form (e.g. "1e+06").
+func toGenericData(data interface{}) (generic interface{}, err error) {
+	var buf []byte
+	if buf, err = json.Marshal(copyData(data)); err != nil {
+		return
+	}
+	d := json.NewDecoder(bytes.NewReader(buf))
+	d.UseNumber()
+	if err = d.Decode(&generic); err == nil {
+		generic = fromJSONNumbers(generic)
+	}
+	return
+}
+
+func fromJSONNumbers(v interface{}) interface{} {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		for key, item := range val {
+			val[key] = fromJSONNumbers(item)
+		}
+	case []interface{}:
+		for i, item := range val {
+			val[i] = fromJSONNumbers(item)
+		}
+	case json.Number:
+		if i, err := val.Int64(); err == nil {
+			return i
+		}
+		f, _ := val.Float64()
+		return f
+	}
+	return v
+}
@@ -0,0 +1,181 @@
+package dati
+
+/*
+Copyright (C) 2023 gearsix <gearsix@tuta.io>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const xmlGood = `<?xml version="1.0" encoding="UTF-8"?>
+<!-- feed -->
+<rss version="2.0" xmlns:atom="http://www.w3.org/2005/Atom">
+	<channel>
+		<title>x &amp; y</title>
+		<atom:link href="http://x.y/rss" rel="self"/>
+		<item id="1">first</item>
+		<item id="2"><![CDATA[<second>]]></item>
+		<empty/>
+	</channel>
+</rss>
+`
+const xmlBad = `<rss><channel></rss>`
+
+func TestReadXMLDataFormat(t *testing.T) {
+	for path, format := range map[string]DataFormat{"x.xml": XML, "XML": XML} {
+		if f := ReadDataFormat(path); f != format {
+			t.Fatalf("'%s' returned '%s', not '%s'", path, f, format)
+		}
+	}
+}
+
+func TestLoadXMLData(t *testing.T) {
+	var d map[string]interface{}
+	if err := LoadData(XML, strings.NewReader(xmlGood), &d); err != nil {
+		t.Fatal(err)
+	}
+	expect := map[string]interface{}{
+		"rss": map[string]interface{}{
+			"@version":    "2.0",
+			"@xmlns:atom": "http://www.w3.org/2005/Atom",
+			"channel": map[string]interface{}{
+				"title": "x & y",
+				"link":  map[string]interface{}{"@href": "http://x.y/rss", "@rel": "self"},
+				"item": []interface{}{
+					map[string]interface{}{"@id": "1", "_text": "first"},
+					map[string]interface{}{"@id": "2", "_text": "<second>"},
+				},
+				"empty": "",
+			},
+		},
+	}
+	if !reflect.DeepEqual(d, expect) {
+		t.Fatalf("invalid result: %v should be %v", d, expect)
+	}
+
+	var i interface{}
+	opts := XMLOptions{AttrPrefix: "-", TextKey: "text"}
+	if err := NewXMLDecoder(opts)([]byte(`<a b="c">d</a>`), &i); err != nil {
+		t.Fatal(err)
+	} else if expect := map[string]interface{}{
+		"a": map[string]interface{}{"-b": "c", "text": "d"},
+	}; !reflect.DeepEqual(i, expect) {
+		t.Fatalf("invalid result: %v should be %v", i, expect)
+	}
+
+	for _, bad := range []string{xmlBad, "<!-- no root -->"} {
+		if err := LoadData(XML, strings.NewReader(bad), &i); err == nil {
+			t.Fatalf("bad data passed: %s", bad)
+		} else if !strings.HasPrefix(err.Error(), "xml: ") {
+			t.Fatalf("error does not indicate format: %s", err)
+		}
+	}
+}
+
+func TestWriteXMLData(t *testing.T) {
+	var buf bytes.Buffer
+
+	var d interface{}
+	if err := LoadData(XML, strings.NewReader(xmlGood), &d); err != nil {
+		t.Skip("setup failure:", err)
+	}
+	if err := WriteData(XML, d, &buf); err != nil {
+		t.Fatal(err)
+	}
+
+	var again interface{}
+	if err := LoadData(XML, bytes.NewReader(buf.Bytes()), &again); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(d, again) {
+		t.Fatalf("round-trip result %v does not match %v", again, d)
+	}
+
+	buf.Reset()
+	data := map[string]interface{}{
+		"title": "a < b",
+		"n":     1,
+		"tags":  []string{"x", "y"},
+		"link":  map[string]string{"@href": "/", "_text": "home"},
+	}
+	if err := WriteData(XML, data, &buf); err != nil {
+		t.Fatal(err)
+	} else if expect := "<data>\n" +
+		"\t<link href=\"/\">home</link>\n" +
+		"\t<n>1</n>\n" +
+		"\t<tags>x</tags>\n" +
+		"\t<tags>y</tags>\n" +
+		"\t<title>a &lt; b</title>\n" +
+		"</data>\n"; buf.String() != expect {
+		t.Fatalf("invalid result: %s should be %s", buf.String(), expect)
+	}
+
+	buf.Reset()
+	type record struct {
+		Name string `json:"@name"`
+		Text string `json:"_text"`
+	}
+	if err := WriteData(XML, map[string]record{"r": {"x", "y"}}, &buf); err != nil {
+		t.Fatal(err)
+	} else if expect := "<r name=\"x\">y</r>\n"; buf.String() != expect {
+		t.Fatalf("invalid result: %s should be %s", buf.String(), expect)
+	}
+
+	for _, bad := range []map[string]interface{}{
+		{"1st": "x"},
+		{"key with space": "x"},
+		{"a": map[string]interface{}{"@bad attr": "x"}},
+		{"": "x"},
+	} {
+		buf.Reset()
+		if err := WriteData(XML, bad, &buf); err == nil {
+			t.Fatalf("%v did not return an error: %s", bad, buf.String())
+		} else if !strings.HasPrefix(err.Error(), "xml: ") {
+			t.Fatalf("%v returned an invalid error: %s", bad, err)
+		}
+	}
+}
+
+func TestExecuteXMLData(t *testing.T) {
+	var d interface{}
+	if err := LoadData(XML, strings.NewReader(xmlGood), &d); err != nil {
+		t.Skip("setup failure:", err)
+	}
+
+	for lang, root := range map[TemplateLanguage]string{
+		TMPL: `{{with .rss.channel}}{{.title}}{{range .item}} {{index . "@id"}}:{{._text}}{{end}}{{end}}`,
+		HMPL: `{{with .rss.channel}}{{.title}}{{range .item}} {{index . "@id"}}:{{._text}}{{end}}{{end}}`,
+		MST:  `{{#rss.channel}}{{{title}}}{{#item}} {{@id}}:{{{_text}}}{{/item}}{{/rss.channel}}`,
+	} {
+		template, err := LoadTemplateString(lang, "xml", root, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expect := "x & y 1:first 2:<second>"
+		if lang == HMPL {
+			expect = "x &amp; y 1:first 2:&lt;second&gt;"
+		}
+		if result, err := template.Execute(d); err != nil {
+			t.Fatal(err)
+		} else if result.String() != expect {
+			t.Fatalf("%s: invalid result '%s' should be '%s'", lang, result.String(), expect)
+		}
+	}
+}
@@ -5,5 +5,6 @@ go 1.13
 require (
 	github.com/cbroglie/mustache v1.2.0
 	github.com/pelletier/go-toml v1.8.1
+	github.com/yuin/goldmark v1.4.13
 	gopkg.in/yaml.v3 v3.0.0-20210107192922-496545a6307b
 )
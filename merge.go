@@ -0,0 +1,309 @@
+package dati
+
+/*
+Copyright (C) 2023 gearsix <gearsix@tuta.io>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// MergeStrategy sets how `MergeData` resolves keys that are set in more
+// than one document. Strategies can be combined (e.g.
+// `MergeDeep | MergeAppend | MergeLastWins`).
+type MergeStrategy uint8
+
+const (
+	// MergeFirstWins keeps the first value found for a key (default)
+	MergeFirstWins MergeStrategy = 0
+	// MergeLastWins keeps the last value found for a key
+	MergeLastWins MergeStrategy = 1
+	// MergeDeep merges the keys of maps found for the same key, instead of
+	// treating them as conflicting values
+	MergeDeep MergeStrategy = 2
+	// MergeAppend appends lists found for the same key, instead of
+	// treating them as conflicting values
+	MergeAppend MergeStrategy = 4
+	// MergeErrorOnConflict returns an error if any conflicts are found
+	MergeErrorOnConflict MergeStrategy = 8
+)
+
+var mergeStrategyNames = []struct {
+	name     string
+	strategy MergeStrategy
+}{
+	{"first", MergeFirstWins},
+	{"last", MergeLastWins},
+	{"deep", MergeDeep},
+	{"append", MergeAppend},
+	{"error", MergeErrorOnConflict},
+}
+
+// ParseMergeStrategy returns the *MergeStrategy* for `s`, a comma-separated
+// list of any of the following values: "first", "last", "deep", "append",
+// "error" (e.g. "deep,append").
+func ParseMergeStrategy(s string) (strategy MergeStrategy, err error) {
+	for _, name := range strings.Split(s, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		found := false
+		for _, n := range mergeStrategyNames {
+			if n.name == name {
+				strategy |= n.strategy
+				found = true
+				break
+			}
+		}
+		if !found {
+			return strategy, fmt.Errorf("invalid merge strategy '%s'", name)
+		}
+	}
+	return
+}
+
+// String returns `strategy` in the format read by `ParseMergeStrategy`.
+func (strategy MergeStrategy) String() string {
+	var names []string
+	for _, n := range mergeStrategyNames {
+		if strategy&n.strategy != 0 {
+			names = append(names, n.name)
+		}
+	}
+	if len(names) == 0 {
+		return mergeStrategyNames[0].name
+	}
+	return strings.Join(names, ",")
+}
+
+// MergeConflict describes a key that was set to different values in more
+// than one document passed to `MergeData`.
+// `Path` is the list of keys leading to the conflicting key, from the root
+// of the document. `Docs` is the index of the document that set the
+// existing value and the index of the document that conflicted with it.
+type MergeConflict struct {
+	Path []string
+	Docs [2]int
+}
+
+// Key returns `Path` joined by ".".
+func (c MergeConflict) Key() string {
+	return strings.Join(c.Path, ".")
+}
+
+func (c MergeConflict) Error() string {
+	return fmt.Sprintf("merge conflict for key '%s' (document %d & %d)", c.Key(), c.Docs[0], c.Docs[1])
+}
+
+type merger struct {
+	strategy  MergeStrategy
+	origins   map[string]int // path -> index of document that set it
+	conflicts []MergeConflict
+}
+
+// MergeData merges all keys in `docs` into a single map, in order. Any keys
+// that are set in more than one document are resolved using `strategy`. The
+// documents in `docs` are not modified.
+// All the conflicts that were found are returned in `conflicts`, if
+// `strategy` has `MergeErrorOnConflict` set and a conflict was found, then
+// `merged` will be nil and `err` will be the first conflict.
+func MergeData(strategy MergeStrategy, docs ...map[string]interface{}) (merged map[string]interface{}, conflicts []MergeConflict, err error) {
+	m := merger{strategy: strategy, origins: make(map[string]int)}
+	merged = make(map[string]interface{})
+	for i, doc := range docs {
+		m.mergeMap(merged, doc, nil, i)
+	}
+
+	conflicts = m.conflicts
+	if strategy&MergeErrorOnConflict != 0 && len(conflicts) > 0 {
+		merged = nil
+		err = conflicts[0]
+	}
+	return
+}
+
+func (m *merger) mergeMap(dst map[string]interface{}, src map[string]interface{}, path []string, doc int) {
+	keys := make([]string, 0, len(src))
+	for key := range src {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys) // keep the order of conflicts consistent
+
+	for _, key := range keys {
+		m.mergeKey(dst, key, src[key], append(path[:len(path):len(path)], key), doc)
+	}
+}
+
+func (m *merger) mergeKey(dst map[string]interface{}, key string, val interface{}, path []string, doc int) {
+	pathKey := strings.Join(path, "\x00")
+
+	existing, ok := dst[key]
+	if !ok {
+		dst[key] = copyData(val)
+		m.setOrigin(pathKey, val, doc)
+		return
+	}
+
+	if m.strategy&MergeDeep != 0 {
+		dstMap, dstOk := toStringMap(existing)
+		srcMap, srcOk := toStringMap(val)
+		if dstOk && srcOk {
+			dst[key] = dstMap
+			m.mergeMap(dstMap, srcMap, path, doc)
+			return
+		}
+	}
+
+	if m.strategy&MergeAppend != 0 {
+		dstList, dstOk := toList(existing)
+		srcList, srcOk := toList(val)
+		if dstOk && srcOk {
+			dst[key] = append(dstList, copyData(srcList).([]interface{})...)
+			return
+		}
+	}
+
+	if reflect.DeepEqual(existing, copyData(val)) {
+		return
+	}
+
+	m.conflicts = append(m.conflicts, MergeConflict{
+		Path: path,
+		Docs: [2]int{m.origins[pathKey], doc},
+	})
+	if m.strategy&MergeLastWins != 0 {
+		dst[key] = copyData(val)
+		for p := range m.origins {
+			if strings.HasPrefix(p, pathKey+"\x00") {
+				delete(m.origins, p)
+			}
+		}
+		m.setOrigin(pathKey, val, doc)
+	}
+}
+
+// setOrigin records `doc` as the origin of `pathKey` and all the keys nested
+// in `val`, so conflicts in those keys are reported against `doc`.
+func (m *merger) setOrigin(pathKey string, val interface{}, doc int) {
+	m.origins[pathKey] = doc
+	if valMap, ok := toStringMap(val); ok {
+		for key, item := range valMap {
+			m.setOrigin(pathKey+"\x00"+key, item, doc)
+		}
+	}
+}
+
+// toStringMap returns `v` as a map[string]interface{} if it's a map.
+// Non-string keys are converted to strings.
+func toStringMap(v interface{}) (map[string]interface{}, bool) {
+	if m, ok := v.(map[string]interface{}); ok {
+		return m, true
+	}
+
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Map {
+		return nil, false
+	}
+	m := make(map[string]interface{}, rv.Len())
+	iter := rv.MapRange()
+	for iter.Next() {
+		m[fmt.Sprint(iter.Key().Interface())] = iter.Value().Interface()
+	}
+	return m, true
+}
+
+// toList returns `v` as a []interface{} if it's a slice or an array.
+// []byte is not treated as a list.
+func toList(v interface{}) ([]interface{}, bool) {
+	if l, ok := v.([]interface{}); ok {
+		return l, true
+	}
+
+	rv := reflect.ValueOf(v)
+	if (rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array) ||
+		rv.Type().Elem().Kind() == reflect.Uint8 {
+		return nil, false
+	}
+	l := make([]interface{}, rv.Len())
+	for i := range l {
+		l[i] = rv.Index(i).Interface()
+	}
+	return l, true
+}
+
+// copyData returns a deep copy of any maps and lists in `v`, maps are
+// returned as map[string]interface{} and lists as []interface{}.
+func copyData(v interface{}) interface{} {
+	if m, ok := toStringMap(v); ok {
+		c := make(map[string]interface{}, len(m))
+		for key, val := range m {
+			c[key] = copyData(val)
+		}
+		return c
+	} else if l, ok := toList(v); ok {
+		c := make([]interface{}, len(l))
+		for i, val := range l {
+			c[i] = copyData(val)
+		}
+		return c
+	}
+	return v
+}
+
+// GenerateSuperData returns the "super-structure" of `global` and `data`:
+// a copy of `global` with `data` set to the `dataKey` key (overwriting any
+// existing value for that key).
+// If `inject` is true, then each item in `data` is replaced with the result
+// of `InjectGlobalData(dataKey, global, item)`.
+func GenerateSuperData(dataKey string, global map[string]interface{}, data []interface{}, inject bool) map[string]interface{} {
+	super := make(map[string]interface{}, len(global)+1)
+	for key, val := range global {
+		super[key] = val
+	}
+
+	items := make([]interface{}, len(data))
+	for i, item := range data {
+		if inject {
+			item = InjectGlobalData(dataKey, global, item)
+		}
+		items[i] = item
+	}
+	super[dataKey] = items
+
+	return super
+}
+
+// InjectGlobalData returns a copy of `item` with any keys from `global` that
+// it doesn't already have (other than `dataKey`) written to the root of it.
+// If `item` isn't a map, then it's returned as-is.
+func InjectGlobalData(dataKey string, global map[string]interface{}, item interface{}) interface{} {
+	m, ok := toStringMap(item)
+	if !ok {
+		return item
+	}
+
+	injected := make(map[string]interface{}, len(m)+len(global))
+	for key, val := range global {
+		if key != dataKey {
+			injected[key] = val
+		}
+	}
+	for key, val := range m {
+		injected[key] = val
+	}
+	return injected
+}
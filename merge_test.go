@@ -0,0 +1,250 @@
+package dati
+
+/*
+Copyright (C) 2023 gearsix <gearsix@tuta.io>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var mergeDocs = []string{
+	`{"title":"a", "meta":{"author":"x", "tags":["one"]}, "list":[1]}`,
+	`{"title":"b", "meta":{"date":"y", "tags":["two"]}, "list":[2]}`,
+	`{"title":"a", "meta":{"author":"z"}}`,
+}
+
+func loadMergeDocs(t *testing.T) (docs []map[string]interface{}) {
+	for _, d := range mergeDocs {
+		var doc map[string]interface{}
+		if err := LoadData(JSON, strings.NewReader(d), &doc); err != nil {
+			t.Skip("setup failure:", err)
+		}
+		docs = append(docs, doc)
+	}
+	return
+}
+
+func validateMergeData(t *testing.T, strategy MergeStrategy, expect string, conflicts ...string) {
+	docs := loadMergeDocs(t)
+	before, _ := json.Marshal(docs)
+
+	merged, c, err := MergeData(strategy, docs...)
+	if err != nil {
+		t.Fatalf("'%s' returned an error: %s", strategy, err)
+	}
+
+	if result, err := json.Marshal(merged); err != nil {
+		t.Fatal(err)
+	} else if string(result) != expect {
+		t.Fatalf("'%s' result %s does not match %s", strategy, result, expect)
+	}
+
+	if len(c) != len(conflicts) {
+		t.Fatalf("'%s' returned conflicts %v, should be %v", strategy, c, conflicts)
+	}
+	for i, conflict := range c {
+		if conflict.Error() != conflicts[i] {
+			t.Fatalf("'%s' conflict '%s' should be '%s'", strategy, conflict, conflicts[i])
+		}
+	}
+
+	if after, _ := json.Marshal(docs); string(after) != string(before) {
+		t.Fatalf("'%s' modified the merged documents: %s", strategy, after)
+	}
+}
+
+func TestMergeData(t *testing.T) {
+	validateMergeData(t, MergeFirstWins,
+		`{"list":[1],"meta":{"author":"x","tags":["one"]},"title":"a"}`,
+		"merge conflict for key 'list' (document 0 & 1)",
+		"merge conflict for key 'meta' (document 0 & 1)",
+		"merge conflict for key 'title' (document 0 & 1)",
+		"merge conflict for key 'meta' (document 0 & 2)")
+
+	validateMergeData(t, MergeLastWins,
+		`{"list":[2],"meta":{"author":"z"},"title":"a"}`,
+		"merge conflict for key 'list' (document 0 & 1)",
+		"merge conflict for key 'meta' (document 0 & 1)",
+		"merge conflict for key 'title' (document 0 & 1)",
+		"merge conflict for key 'meta' (document 1 & 2)",
+		"merge conflict for key 'title' (document 1 & 2)")
+
+	validateMergeData(t, MergeDeep,
+		`{"list":[1],"meta":{"author":"x","date":"y","tags":["one"]},"title":"a"}`,
+		"merge conflict for key 'list' (document 0 & 1)",
+		"merge conflict for key 'meta.tags' (document 0 & 1)",
+		"merge conflict for key 'title' (document 0 & 1)",
+		"merge conflict for key 'meta.author' (document 0 & 2)")
+
+	validateMergeData(t, MergeDeep|MergeAppend|MergeLastWins,
+		`{"list":[1,2],"meta":{"author":"z","date":"y","tags":["one","two"]},"title":"a"}`,
+		"merge conflict for key 'title' (document 0 & 1)",
+		"merge conflict for key 'meta.author' (document 0 & 2)",
+		"merge conflict for key 'title' (document 1 & 2)")
+
+	docs := loadMergeDocs(t)
+	if merged, _, err := MergeData(MergeDeep|MergeErrorOnConflict, docs...); err == nil {
+		t.Fatal("conflict did not return an error")
+	} else if merged != nil {
+		t.Fatal("merged is not nil")
+	} else if c, ok := err.(MergeConflict); !ok || c.Key() != "list" {
+		t.Fatalf("invalid error returned: %s", err)
+	}
+	if _, _, err := MergeData(MergeErrorOnConflict, docs[0], docs[0]); err != nil {
+		t.Fatalf("equal values returned an error: %s", err)
+	}
+
+	nested := func(v interface{}) map[string]interface{} {
+		return map[string]interface{}{"a": v}
+	}
+	for strategy, expect := range map[MergeStrategy][]string{
+		MergeDeep: {
+			"merge conflict for key 'a' (document 1 & 2)",
+			"merge conflict for key 'a.b' (document 1 & 3)",
+			"merge conflict for key 'a.b' (document 1 & 4)",
+		},
+		MergeDeep | MergeLastWins: {
+			"merge conflict for key 'a' (document 1 & 2)",
+			"merge conflict for key 'a' (document 2 & 3)",
+			"merge conflict for key 'a.b' (document 3 & 4)",
+		},
+	} {
+		_, c, err := MergeData(strategy, map[string]interface{}{},
+			nested(map[string]interface{}{"b": 1}), nested(1),
+			nested(map[string]interface{}{"b": 2}), nested(map[string]interface{}{"b": 3}))
+		if err != nil {
+			t.Fatal(err)
+		} else if len(c) != len(expect) {
+			t.Fatalf("'%s' returned conflicts %v, should be %v", strategy, c, expect)
+		}
+		for i, conflict := range c {
+			if conflict.Error() != expect[i] {
+				t.Fatalf("'%s' conflict '%s' should be '%s'", strategy, conflict, expect[i])
+			}
+		}
+	}
+}
+
+func TestParseMergeStrategy(t *testing.T) {
+	for s, expect := range map[string]MergeStrategy{
+		"first":             MergeFirstWins,
+		"last":              MergeLastWins,
+		"deep, APPEND":      MergeDeep | MergeAppend,
+		"deep,append,error": MergeDeep | MergeAppend | MergeErrorOnConflict,
+		"last,deep,append":  MergeLastWins | MergeDeep | MergeAppend,
+	} {
+		if strategy, err := ParseMergeStrategy(s); err != nil {
+			t.Fatal(err)
+		} else if strategy != expect {
+			t.Fatalf("'%s' returned '%s', should be '%s'", s, strategy, expect)
+		} else if again, _ := ParseMergeStrategy(strategy.String()); again != strategy {
+			t.Fatalf("'%s' did not parse back to '%s'", strategy.String(), strategy)
+		}
+	}
+
+	if _, err := ParseMergeStrategy("deep,invalid"); err == nil {
+		t.Fatal("invalid strategy passed")
+	}
+}
+
+func TestGenerateSuperData(t *testing.T) {
+	var global map[string]interface{}
+	if err := LoadDataFile("examples/project.toml", &global); err != nil {
+		t.Skip("setup failure:", err)
+	}
+	global["logfiles"] = "overwritten"
+
+	var data []interface{}
+	err := filepath.Walk("examples/logs", func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		var d interface{}
+		if err = LoadDataFile(path, &d); err == nil {
+			data = append(data, d)
+		}
+		return err
+	})
+	if err != nil || len(data) == 0 {
+		t.Skip("setup failure:", err)
+	}
+	data = append(data, map[string]interface{}{"Title": "Not Injected"})
+
+	validate := func(super map[string]interface{}, inject bool) {
+		for key, val := range global {
+			if key != "logfiles" && super[key] != val {
+				t.Fatalf("super-structure key '%s' is '%v', should be '%v'", key, super[key], val)
+			}
+		}
+
+		items, ok := super["logfiles"].([]interface{})
+		if !ok || len(items) != len(data) {
+			t.Fatalf("invalid super-structure data: %v", super["logfiles"])
+		}
+		for i, item := range items {
+			m := item.(map[string]interface{})
+			if _, ok := m["logfiles"]; ok {
+				t.Fatalf("data key was injected into item %d", i)
+			} else if inject && i < len(items)-1 && len(m) != len(data[i].(map[string]interface{}))+len(global)-1 {
+				t.Fatalf("item %d has invalid length %d", i, len(m))
+			}
+
+			if m["Stardate"] == nil && i < len(items)-1 {
+				t.Fatalf("item %d is missing data: %v", i, m)
+			} else if inject && m["Author"] != global["Author"] {
+				t.Fatalf("item %d global data was not injected: %v", i, m)
+			} else if !inject && m["Author"] != nil {
+				t.Fatalf("item %d global data was injected: %v", i, m)
+			}
+		}
+
+		if items[len(items)-1].(map[string]interface{})["Title"] != "Not Injected" {
+			t.Fatal("global data overwrote an existing item key")
+		}
+	}
+
+	validate(GenerateSuperData("logfiles", global, data, true), true)
+	validate(GenerateSuperData("logfiles", global, data, false), false)
+
+	for i, item := range data {
+		if _, ok := item.(map[string]interface{})["Author"]; ok {
+			t.Fatalf("item %d was modified", i)
+		}
+	}
+}
+
+func TestInjectGlobalData(t *testing.T) {
+	global := map[string]interface{}{"a": 1, "b": 2, "data": 3}
+
+	item := map[string]interface{}{"b": 0}
+	injected, ok := InjectGlobalData("data", global, item).(map[string]interface{})
+	if !ok {
+		t.Fatal("map item did not return a map")
+	} else if len(injected) != 2 || injected["a"] != 1 || injected["b"] != 0 {
+		t.Fatalf("invalid result: %v", injected)
+	} else if len(item) != 1 {
+		t.Fatalf("item was modified: %v", item)
+	}
+
+	if list := InjectGlobalData("data", global, []interface{}{1}); len(list.([]interface{})) != 1 {
+		t.Fatalf("non-map item was modified: %v", list)
+	}
+}
@@ -19,6 +19,7 @@ along with this program.  If not, see <https://www.gnu.org/licenses/>.
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	hmpl "html/template"
@@ -26,8 +27,9 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
-	"reflect"
+	"sort"
 	"strings"
+	"sync"
 	tmpl "text/template"
 
 	mst "github.com/cbroglie/mustache"
@@ -60,6 +62,153 @@ var (
 	ErrNilTemplate = errors.New("template is nil")
 )
 
+// ContextError is returned by `(*Template).ExecuteContext` when execution is
+// stopped because it's context was cancelled or it's deadline passed.
+// `Err` is the error returned by the `Err()` function of that context.
+type ContextError struct {
+	Name string
+	Err  error
+}
+
+func (err *ContextError) Error() string {
+	return fmt.Sprintf("execution of template '%s' stopped: %s", err.Name, err.Err)
+}
+
+// Unwrap returns `err.Err`, so `errors.Is(err, context.DeadlineExceeded)`
+// can be used to check why execution was stopped.
+func (err *ContextError) Unwrap() error {
+	return err.Err
+}
+
+// Executable is a parsed template that can be executed against data.
+type Executable interface {
+	// Execute writes the result of applying the template to `data` to `w`.
+	Execute(w io.Writer, data interface{}) error
+}
+
+// Engine parses templates for a *TemplateLanguage*, see
+// `RegisterTemplateLanguage`.
+type Engine interface {
+	// Parse parses `root` as a template named `rootName`. Each of the
+	// templates in `partials` are parsed and made available to `root`
+	// by the name they're keyed to.
+	Parse(rootName string, root io.Reader, partials map[string]io.Reader) (Executable, error)
+}
+
+// EngineFunc is an adapter to allow the use of an ordinary function as an
+// *Engine*.
+type EngineFunc func(rootName string, root io.Reader, partials map[string]io.Reader) (Executable, error)
+
+// Parse calls `f(rootName, root, partials)`.
+func (f EngineFunc) Parse(rootName string, root io.Reader, partials map[string]io.Reader) (Executable, error) {
+	return f(rootName, root, partials)
+}
+
+type templateEngine struct {
+	extensions []string
+	engine     Engine
+	// if set, partials loaded by `LoadTemplateFile` are named without
+	// their file extension (e.g. "head.mst" is "head")
+	trimPartialExt bool
+}
+
+var (
+	templateEnginesMu sync.RWMutex
+	templateEngines   = make(map[TemplateLanguage]templateEngine)
+	templateLanguages []TemplateLanguage // registration order, used to resolve extensions
+	templateAliases   = make(map[string]TemplateLanguage)
+)
+
+func init() {
+	RegisterTemplateLanguage(TMPL, []string{"tmpl", "gotmpl"}, EngineFunc(loadTemplateTmpl))
+	RegisterTemplateLanguage(HMPL, []string{"hmpl", "gohmpl"}, EngineFunc(loadTemplateHmpl))
+	registerTemplateLanguage(MST, []string{"mst", "mu", "mustache"}, EngineFunc(loadTemplateMst), true)
+}
+
+// RegisterTemplateLanguage adds `lang` to the list of known
+// *TemplateLanguage*s, any files with a file extension in `extensions` will
+// be read as `lang` and parsed by `engine`.
+// If `lang` is already registered, it will be replaced.
+func RegisterTemplateLanguage(lang TemplateLanguage, extensions []string, engine Engine) {
+	registerTemplateLanguage(lang, extensions, engine, false)
+}
+
+func registerTemplateLanguage(lang TemplateLanguage, extensions []string, engine Engine, trimPartialExt bool) {
+	e := templateEngine{engine: engine, trimPartialExt: trimPartialExt}
+	for _, ext := range extensions {
+		e.extensions = append(e.extensions, cleanExt(ext))
+	}
+
+	templateEnginesMu.Lock()
+	defer templateEnginesMu.Unlock()
+	if _, ok := templateEngines[lang]; !ok {
+		templateLanguages = append(templateLanguages, lang)
+	}
+	templateEngines[lang] = e
+}
+
+// TemplateLanguages returns a list of all registered *TemplateLanguage*s, in
+// the order they were registered.
+func TemplateLanguages() []TemplateLanguage {
+	templateEnginesMu.RLock()
+	defer templateEnginesMu.RUnlock()
+	return append([]TemplateLanguage(nil), templateLanguages...)
+}
+
+// RegisterTemplateAlias sets files with the file extension `ext` to be read
+// as `lang`, in addition to the extensions `lang` was registered with.
+// Aliases take priority over registered extensions, so they can also be used
+// to change the language of an extension (e.g. "html" to `LIQUID`).
+// If `lang` is "", the alias for `ext` is removed.
+func RegisterTemplateAlias(ext string, lang TemplateLanguage) {
+	ext = cleanExt(ext)
+
+	templateEnginesMu.Lock()
+	defer templateEnginesMu.Unlock()
+	if lang == "" {
+		delete(templateAliases, ext)
+	} else {
+		templateAliases[ext] = lang
+	}
+}
+
+// TemplateExtensions returns the file extensions (without a leading ".") that
+// are read as `lang`, the extensions it was registered with followed by any
+// aliases set by `RegisterTemplateAlias`.
+func TemplateExtensions(lang TemplateLanguage) (exts []string) {
+	templateEnginesMu.RLock()
+	defer templateEnginesMu.RUnlock()
+	for _, ext := range templateEngines[lang].extensions {
+		if l, ok := templateAliases[ext]; !ok || l == lang {
+			exts = append(exts, ext)
+		}
+	}
+	var aliases []string
+	for ext, l := range templateAliases {
+		if l == lang && !containsString(exts, ext) {
+			aliases = append(aliases, ext)
+		}
+	}
+	sort.Strings(aliases)
+	return append(exts, aliases...)
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func getTemplateEngine(lang TemplateLanguage) (e templateEngine, ok bool) {
+	templateEnginesMu.RLock()
+	defer templateEnginesMu.RUnlock()
+	e, ok = templateEngines[lang]
+	return
+}
+
 // IsTemplateLanguage will return a bool if the file found at `path`
 // is a known *TemplateLanguage*, based upon it's file extension.
 func IsTemplateLanguage(path string) bool {
@@ -67,8 +216,9 @@ func IsTemplateLanguage(path string) bool {
 }
 
 // ReadTemplateLanguage returns the *TemplateLanguage* that the file
-// extension of `path` matches. If the file extension of `path` does
-// not match any *TemplateLanguage*, then an "" is returned.
+// extension of `path` matches (see `TemplateExtensions`). If the file
+// extension of `path` does not match any *TemplateLanguage*, then an ""
+// is returned.
 func ReadTemplateLangauge(path string) TemplateLanguage {
 	if len(path) == 0 {
 		return ""
@@ -78,15 +228,21 @@ func ReadTemplateLangauge(path string) TemplateLanguage {
 	if len(ext) == 0 {
 		ext = path // assume `path` the name of the format
 	}
+	ext = cleanExt(ext)
 
-	ext = strings.ToLower(ext)
-	if len(ext) > 0 && ext[0] == '.' {
-		ext = ext[1:]
+	templateEnginesMu.RLock()
+	defer templateEnginesMu.RUnlock()
+	if lang, ok := templateAliases[ext]; ok {
+		return lang
 	}
-
-	for _, fmt := range []TemplateLanguage{TMPL, HMPL, MST} {
-		if fmt.String() == ext {
-			return fmt
+	for _, lang := range templateLanguages {
+		if lang.String() == ext {
+			return lang
+		}
+		for _, e := range templateEngines[lang].extensions {
+			if e == ext {
+				return lang
+			}
 		}
 	}
 	return ""
@@ -97,74 +253,176 @@ func getTemplateType(path string) string {
 }
 
 // Template is a wrapper to interface with any template parsed by dati.
-// Ideally it would have just been an interface{} that defines Execute but
-// the libaries being used aren't that uniform.
 type Template struct {
 	Name string
-	T    interface{}
+	T    Executable
 }
 
-// Execute executes `t` against `d`. Reflection is used to determine
-// the template type and call it's execution fuction.
+// Execute executes `t` against `d` and returns the result.
 func (t *Template) Execute(data interface{}) (result bytes.Buffer, err error) {
-	var funcName string
-	var params []reflect.Value
-	tType := reflect.TypeOf(t.T)
-	if tType == nil {
-		err = ErrNilTemplate
-		return
+	err = t.ExecuteTo(&result, data)
+	return
+}
+
+// ExecuteTo executes `t` against `d` and writes the result to `w` as it's
+// generated, it's preferable to `Execute` for large results.
+func (t *Template) ExecuteTo(w io.Writer, data interface{}) error {
+	if t.T == nil {
+		return ErrNilTemplate
+	}
+	return t.T.Execute(w, data)
+}
+
+// ExecuteContext executes `t` against `d` and writes the result to `w`, the
+// same as `ExecuteTo`, unless `ctx` is cancelled or it's deadline passes
+// first. In that case a *ContextError* is returned immediately and nothing
+// else is written to `w`.
+// Execution continues in the background until the template next writes to
+// `w`, the `HBS`, `JINJA` & `LIQUID` engines also stop at the start of each
+// loop iteration.
+// The `TMPL`, `HMPL` & `MST` engines can't be interrupted, so if one of
+// those templates never writes to `w` again (e.g. it's stuck in a loop or a
+// function it calls never returns), it's goroutine runs until the template
+// finishes and it continues to read `data`. So `data` shouldn't be modified
+// after a *ContextError* is returned.
+// If the template engine panics, the panic is recovered and returned as an
+// error.
+func (t *Template) ExecuteContext(ctx context.Context, w io.Writer, data interface{}) error {
+	if t.T == nil {
+		return ErrNilTemplate
+	} else if ctx.Done() == nil { // ctx can't be cancelled
+		return t.execute(w, data)
+	} else if err := ctx.Err(); err != nil {
+		return &ContextError{Name: t.Name, Err: err}
 	}
-	switch tType.String() {
-	case "*template.Template": // golang templates
-		funcName = "Execute"
-		params = []reflect.Value{reflect.ValueOf(&result), reflect.ValueOf(data)}
-	case "*mustache.Template":
-		funcName = "FRender"
-		params = []reflect.Value{reflect.ValueOf(&result), reflect.ValueOf(data)}
-	default:
-		err = ErrUnknownTemplateType(reflect.TypeOf(t.T).String())
-	}
-
-	if err == nil {
-		rval := reflect.ValueOf(t.T).MethodByName(funcName).Call(params)
-		if !rval[0].IsNil() { // err != nil
-			err = rval[0].Interface().(error)
+
+	cw := &contextWriter{ctx: ctx, w: w}
+	done := make(chan error, 1)
+	tc := *t // the goroutine can outlive this call, so it mustn't share `t`
+	go func() {
+		done <- tc.execute(cw, data)
+	}()
+
+	select {
+	case err := <-done:
+		if ctxErr := ctx.Err(); err != nil && ctxErr != nil {
+			err = &ContextError{Name: t.Name, Err: ctxErr}
 		}
+		return err
+	case <-ctx.Done():
+		cw.close()
+		return &ContextError{Name: t.Name, Err: ctx.Err()}
 	}
+}
 
-	return
+// execute calls `t.T.Execute`, any panic is recovered and returned as an
+// error.
+func (t *Template) execute(w io.Writer, data interface{}) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("template '%s' panicked: %v", t.Name, r)
+		}
+	}()
+	return t.T.Execute(w, data)
+}
+
+// contextWriter writes to `w` until `ctx` is done or `close` is called.
+type contextWriter struct {
+	ctx    context.Context
+	w      io.Writer
+	mu     sync.Mutex
+	closed bool
+}
+
+func (cw *contextWriter) Write(p []byte) (int, error) {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	if err := cw.errLocked(); err != nil {
+		return 0, err
+	}
+	return cw.w.Write(p)
+}
+
+func (cw *contextWriter) err() error {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	return cw.errLocked()
+}
+
+func (cw *contextWriter) errLocked() error {
+	if cw.closed {
+		return context.Canceled
+	}
+	return cw.ctx.Err()
+}
+
+// writerErr returns a function that returns an error once execution writing
+// to `w` should stop (if `w` is used by `ExecuteContext`), template engines
+// can call it to stop loops that don't write anything.
+func writerErr(w io.Writer) func() error {
+	if cw, ok := w.(*contextWriter); ok {
+		return cw.err
+	}
+	return func() error { return nil }
+}
+
+func (cw *contextWriter) close() {
+	cw.mu.Lock()
+	cw.closed = true
+	cw.mu.Unlock()
 }
 
-// ExecuteToFile writes the result of `(*Template).Execute(data)` to the file at `path` (if no errors occurred).
-// If `force` is true, any existing file at `path` will be overwritten.
-func (t *Template)ExecuteToFile(data interface{}, path string, force bool) (f *os.File, err error) {
-	if f, err := os.Open(path); os.IsNotExist(err) {
-		f, err = os.Create(path)
-	} else if !force {
-		err = os.ErrExist
-	} else { // overwrite existing file data
-		if err = f.Truncate(0); err == nil {
-			_, err = f.Seek(0, 0)
+// ExecuteToFile writes the result of `(*Template).ExecuteTo(f, data)` to the
+// file at `path`. If `force` is true, any existing file at `path` will be
+// overwritten.
+// The result is written to a temporary file in the same directory as `path`,
+// which is only moved to `path` if no errors occurred. If an error occurs, `f`
+// will be nil and any existing file at `path` is left untouched.
+func (t *Template) ExecuteToFile(data interface{}, path string, force bool) (*os.File, error) {
+	return t.ExecuteToFileContext(context.Background(), data, path, force)
+}
+
+// ExecuteToFileContext is the same as `ExecuteToFile`, except execution is
+// stopped if `ctx` is cancelled or it's deadline passes (see
+// `ExecuteContext`).
+func (t *Template) ExecuteToFileContext(ctx context.Context, data interface{}, path string, force bool) (f *os.File, err error) {
+	mode := os.FileMode(0644)
+	if stat, statErr := os.Stat(path); statErr == nil {
+		if !force {
+			return nil, os.ErrExist
 		}
+		mode = stat.Mode().Perm()
+	} else if !os.IsNotExist(statErr) {
+		return nil, statErr
 	}
 
-	if err != nil {
-		return
+	if f, err = ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".*"); err != nil {
+		return nil, err
 	}
 
-	var out bytes.Buffer
-	if out, err = t.Execute(data); err != nil {
-		f = nil
-	} else {
-		_, err = f.Write(out.Bytes())
+	tmp := f.Name()
+	if err = t.ExecuteContext(ctx, f, data); err == nil {
+		if err = f.Chmod(mode); err == nil {
+			err = os.Rename(tmp, path)
+		}
+	}
+	f.Close()
+
+	if err != nil {
+		os.Remove(tmp)
+		return nil, err
 	}
 
+	if f, err = os.OpenFile(path, os.O_RDWR, 0); err == nil {
+		_, err = f.Seek(0, io.SeekEnd)
+	}
 	return
 }
 
 // LoadTemplateFilepath loads a Template from file `root`. All files in `partials`
-// that have the same template type (identified by file extension) are also
-// parsed and associated with the parsed root template.
+// that have the same template type (identified by file extension, including
+// any set by `RegisterTemplateAlias`) are also parsed and associated with the
+// parsed root template.
 func LoadTemplateFile(rootPath string, partialPaths ...string) (t Template, err error) {
 	var stat os.FileInfo
 	if stat, err = os.Stat(rootPath); err != nil {
@@ -186,9 +444,13 @@ func LoadTemplateFile(rootPath string, partialPaths ...string) (t Template, err
 
 	partials := make(map[string]io.Reader)
 	for _, path := range partialPaths {
+		if ReadTemplateLangauge(path) != lang {
+			continue
+		}
+
 		name := filepath.Base(path)
-		if lang == "mst" {
-			name = strings.TrimSuffix(name, filepath.Ext(name))
+		if e, ok := getTemplateEngine(lang); ok && e.trimPartialExt {
+			name = strings.TrimSuffix(name, filepath.Ext(name)) // the extension (or alias) of `lang`
 		}
 
 		if _, err = os.Stat(path); err != nil {
@@ -226,21 +488,26 @@ func LoadTemplateString(lang TemplateLanguage, rootName string, root string, par
 func LoadTemplate(lang TemplateLanguage, rootName string, root io.Reader, partials map[string]io.Reader) (t Template, err error) {
 	t.Name = rootName
 
-	switch TemplateLanguage(lang) {
-	case TMPL:
-		t.T, err = loadTemplateTmpl(rootName, root, partials)
-	case HMPL:
-		t.T, err = loadTemplateHmpl(rootName, root, partials)
-	case MST:
-		t.T, err = loadTemplateMst(rootName, root, partials)
-	default:
+	e, ok := getTemplateEngine(lang)
+	if !ok || e.engine == nil {
 		err = ErrUnsupportedTemplate(lang.String())
+		return
 	}
 
+	t.T, err = e.engine.Parse(rootName, root, partials)
 	return
 }
 
-func loadTemplateTmpl(rootName string, root io.Reader, partials map[string]io.Reader) (*tmpl.Template, error) {
+// mstTemplate wraps *mst.Template to implement *Executable*.
+type mstTemplate struct {
+	*mst.Template
+}
+
+func (t mstTemplate) Execute(w io.Writer, data interface{}) error {
+	return t.FRender(w, data)
+}
+
+func loadTemplateTmpl(rootName string, root io.Reader, partials map[string]io.Reader) (Executable, error) {
 	var template *tmpl.Template
 
 	if buf, err := ioutil.ReadAll(root); err != nil {
@@ -260,7 +527,7 @@ func loadTemplateTmpl(rootName string, root io.Reader, partials map[string]io.Re
 	return template, nil
 }
 
-func loadTemplateHmpl(rootName string, root io.Reader, partials map[string]io.Reader) (*hmpl.Template, error) {
+func loadTemplateHmpl(rootName string, root io.Reader, partials map[string]io.Reader) (Executable, error) {
 	var template *hmpl.Template
 
 	if buf, err := ioutil.ReadAll(root); err != nil {
@@ -280,7 +547,7 @@ func loadTemplateHmpl(rootName string, root io.Reader, partials map[string]io.Re
 	return template, nil
 }
 
-func loadTemplateMst(rootName string, root io.Reader, partials map[string]io.Reader) (*mst.Template, error) {
+func loadTemplateMst(rootName string, root io.Reader, partials map[string]io.Reader) (Executable, error) {
 	var template *mst.Template
 
 	mstprv := new(mst.StaticProvider)
@@ -299,6 +566,5 @@ func loadTemplateMst(rootName string, root io.Reader, partials map[string]io.Rea
 		return nil, err
 	}
 
-	return template, nil
+	return mstTemplate{template}, nil
 }
-
@@ -0,0 +1,1307 @@
+package dati
+
+/*
+Copyright (C) 2023 gearsix <gearsix@tuta.io>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const HBS TemplateLanguage = "hbs"
+
+func init() {
+	registerTemplateLanguage(HBS, []string{"hbs", "handlebars"}, EngineFunc(loadTemplateHbs), true)
+
+	RegisterHandlebarsHelper("if", hbsIf)
+	RegisterHandlebarsHelper("unless", hbsUnless)
+	RegisterHandlebarsHelper("each", hbsEach)
+	RegisterHandlebarsHelper("with", hbsWith)
+	RegisterHandlebarsHelper("lookup", hbsLookupHelper)
+}
+
+// the maximum number of partials that can be nested when executing a
+// handlebars template (to stop partials that include themselves)
+const hbsMaxPartialDepth = 1000
+
+// HandlebarsHelper is the function signature of a handlebars helper, see
+// `RegisterHandlebarsHelper`. `args` are the values of the arguments the
+// helper was called with. The result is escaped, unless it's a
+// *HandlebarsSafeString* or the helper was called as a block
+// (`{{#helper}}...{{/helper}}`).
+type HandlebarsHelper func(args []interface{}, opts HandlebarsOptions) (interface{}, error)
+
+// HandlebarsSafeString can be returned by a *HandlebarsHelper* to stop the
+// result from being escaped.
+type HandlebarsSafeString string
+
+// HandlebarsOptions is passed to a *HandlebarsHelper* when it's called.
+type HandlebarsOptions struct {
+	// Name is the name the helper was called by.
+	Name string
+	// Hash is the values of any `key=value` arguments.
+	Hash map[string]interface{}
+	// Context is the value of `this` where the helper was called.
+	Context interface{}
+
+	state   *hbsState
+	scope   *hbsScope
+	fn      []hbsNode
+	inverse []hbsNode
+	params  []string
+	w       io.Writer // where a block being rendered is written, see `fnTo`
+}
+
+// IsBlock returns true if the helper was called as a block.
+func (opts HandlebarsOptions) IsBlock() bool {
+	return opts.fn != nil
+}
+
+// Fn returns the result of executing the block the helper was called with
+// against `context`. If the helper wasn't called as a block, "" is returned.
+func (opts HandlebarsOptions) Fn(context interface{}) (string, error) {
+	return opts.FnData(context, nil)
+}
+
+// FnData is the same as `Fn`, except the keys in `data` are set as
+// @variables (e.g. "index" is @index) and `blockParams` are set to the
+// block parameters (e.g. `{{#helper as |a b|}}`).
+func (opts HandlebarsOptions) FnData(context interface{}, data map[string]interface{}, blockParams ...interface{}) (string, error) {
+	return opts.state.execute(opts.fn, opts.scope, context, data, opts.params, blockParams)
+}
+
+// Inverse returns the result of executing the {{else}} section of the block
+// the helper was called with against `context`.
+func (opts HandlebarsOptions) Inverse(context interface{}) (string, error) {
+	return opts.state.execute(opts.inverse, opts.scope, context, nil, nil, nil)
+}
+
+// hbsWritten is returned by the built-in helpers when they've written the
+// result of their block to `HandlebarsOptions.w`, instead of returning it.
+type hbsWritten struct{}
+
+// fnTo is the same as `FnData`, except if `opts.w` is set the result is
+// written to it and *hbsWritten* is returned.
+func (opts HandlebarsOptions) fnTo(context interface{}, data map[string]interface{}, blockParams ...interface{}) (interface{}, error) {
+	if opts.w == nil {
+		return opts.FnData(context, data, blockParams...)
+	}
+	return hbsWritten{}, opts.state.executeTo(opts.w, opts.fn, opts.scope, context, data, opts.params, blockParams)
+}
+
+// inverseTo is the same as `Inverse`, except if `opts.w` is set the result
+// is written to it and *hbsWritten* is returned.
+func (opts HandlebarsOptions) inverseTo(context interface{}) (interface{}, error) {
+	if opts.w == nil {
+		return opts.Inverse(context)
+	}
+	return hbsWritten{}, opts.state.executeTo(opts.w, opts.inverse, opts.scope, context, nil, nil, nil)
+}
+
+// Data returns the value of the @variable `name` (e.g. "index" for
+// @index) where the helper was called.
+func (opts HandlebarsOptions) Data(name string) interface{} {
+	v, _ := opts.scope.data(name)
+	return v
+}
+
+var (
+	hbsHelpersMu sync.RWMutex
+	hbsHelpers   = make(map[string]HandlebarsHelper)
+)
+
+// RegisterHandlebarsHelper makes `helper` available to all handlebars
+// templates as `name`. Helpers take precedence over values in the data with
+// the same name. If `name` is already registered, it will be replaced
+// (including the built-in "if", "unless", "each", "with" & "lookup"
+// helpers). If `helper` is nil, `name` is removed.
+func RegisterHandlebarsHelper(name string, helper HandlebarsHelper) {
+	hbsHelpersMu.Lock()
+	defer hbsHelpersMu.Unlock()
+	if helper == nil {
+		delete(hbsHelpers, name)
+	} else {
+		hbsHelpers[name] = helper
+	}
+}
+
+func getHandlebarsHelper(name string) (helper HandlebarsHelper, ok bool) {
+	hbsHelpersMu.RLock()
+	defer hbsHelpersMu.RUnlock()
+	helper, ok = hbsHelpers[name]
+	return
+}
+
+// hbsTemplate is a parsed handlebars template, it implements *Executable*.
+type hbsTemplate struct {
+	name     string
+	nodes    []hbsNode
+	partials map[string][]hbsNode
+}
+
+func loadTemplateHbs(rootName string, root io.Reader, partials map[string]io.Reader) (Executable, error) {
+	t := &hbsTemplate{name: rootName, partials: make(map[string][]hbsNode)}
+
+	for name, partial := range partials {
+		if buf, err := ioutil.ReadAll(partial); err != nil {
+			return nil, err
+		} else if t.partials[name], err = parseHbs(name, string(buf)); err != nil {
+			return nil, err
+		}
+	}
+
+	if buf, err := ioutil.ReadAll(root); err != nil {
+		return nil, err
+	} else if t.nodes, err = parseHbs(rootName, string(buf)); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// Execute writes the result of applying `t` to `data` to `w`.
+func (t *hbsTemplate) Execute(w io.Writer, data interface{}) error {
+	s := &hbsState{t: t, err: writerErr(w)}
+	scope := &hbsScope{ctx: data, newCtx: true, vars: map[string]interface{}{"root": data}}
+	return s.render(w, t.nodes, scope)
+}
+
+/* parsing */
+
+type hbsNode interface{}
+
+// {{call}}, {{{call}}} or {{& call}}
+type hbsMustache struct {
+	call *hbsCall
+	raw  bool
+	line int
+}
+
+// {{#call}}fn{{else}}inverse{{/call}}, or {{^call}}fn{{/call}} if inverted
+type hbsBlock struct {
+	call     *hbsCall
+	params   []string
+	fn       []hbsNode
+	inverse  []hbsNode
+	inverted bool
+	line     int
+
+	chained bool // an `{{else call}}` block
+	inElse  bool // set while parsing the inverse
+}
+
+// {{> name context key=value}}
+type hbsPartial struct {
+	name   string
+	ctx    hbsValue
+	hash   map[string]hbsValue
+	indent string
+	line   int
+}
+
+// hbsValue is a *hbsPath, *hbsCall (a subexpression) or hbsLiteral
+type hbsValue interface{}
+
+type hbsLiteral struct {
+	v interface{}
+}
+
+type hbsPath struct {
+	original string
+	data     bool // an @variable
+	up       int  // number of "../"
+	this     bool // starts with "this" or "./"
+	parts    []string
+}
+
+// isHelper returns true if `p` could be the name of a helper
+func (p *hbsPath) isHelper() bool {
+	return !p.data && !p.this && p.up == 0 && len(p.parts) == 1
+}
+
+type hbsCall struct {
+	name hbsValue
+	args []hbsValue
+	hash map[string]hbsValue
+}
+
+type hbsToken struct {
+	kind       byte // 0 for text, otherwise the tag type (see `lexHbs`)
+	text       string
+	line       int
+	stripLeft  bool // {{~
+	stripRight bool // ~}}
+	standalone bool
+	indent     string
+}
+
+var hbsBlockParams = regexp.MustCompile(`^as\s+\|([^|]*)\|`)
+
+// lexHbs splits `src` into text & tag tokens. Tag kinds are:
+//   - 'm' for {{x}}, '{' for {{{x}}}, '&' for {{& x}}
+//   - '#', '^', '/' & '>' for {{#x}}, {{^x}}, {{/x}} & {{> x}}
+//   - 'e' for {{else}}, '!' for comments
+func lexHbs(name, src string) (tokens []hbsToken, err error) {
+	line := 1
+	for len(src) > 0 {
+		start := strings.Index(src, "{{")
+		if start < 0 {
+			tokens = append(tokens, hbsToken{text: src, line: line})
+			break
+		} else if start > 0 && src[start-1] == '\\' { // \{{ is escaped
+			tokens = append(tokens, hbsToken{text: src[:start-1] + "{{", line: line})
+			line += strings.Count(src[:start], "\n")
+			src = src[start+2:]
+			continue
+		}
+		if start > 0 {
+			tokens = append(tokens, hbsToken{text: src[:start], line: line})
+			line += strings.Count(src[:start], "\n")
+		}
+
+		tok := hbsToken{line: line}
+		i := start + 2
+		if tok.stripLeft = strings.HasPrefix(src[i:], "~"); tok.stripLeft {
+			i++
+		}
+
+		closing := "}}"
+		switch {
+		case strings.HasPrefix(src[i:], "!--"):
+			tok.kind, closing = '!', "--}}"
+			i += 3
+		case strings.HasPrefix(src[i:], "{"):
+			tok.kind, closing = '{', "}}}"
+			i++
+		case i < len(src) && strings.IndexByte("!#^/>&", src[i]) >= 0:
+			tok.kind = src[i]
+			i++
+		default:
+			tok.kind = 'm'
+		}
+
+		end := strings.Index(src[i:], closing)
+		if closing == "}}}" && (end < 0 || strings.Contains(src[i:i+end], "}~}}")) {
+			if e := strings.Index(src[i:], "}~}}"); e >= 0 {
+				end, closing = e, "}~}}"
+			}
+		}
+		if end < 0 {
+			return nil, fmt.Errorf("%s:%d: missing %s", name, line, closing)
+		}
+		content := src[i : i+end]
+		if strings.HasSuffix(content, "~") {
+			tok.stripRight, content = true, content[:len(content)-1]
+		} else if strings.HasPrefix(closing, "}~") {
+			tok.stripRight = true
+		}
+		if tok.kind == '!' && strings.HasSuffix(content, "--") {
+			content = content[:len(content)-2]
+		}
+		tok.text = strings.TrimSpace(content)
+
+		if tok.kind == 'm' && (tok.text == "else" || strings.HasPrefix(tok.text, "else ")) {
+			tok.kind, tok.text = 'e', strings.TrimSpace(tok.text[4:])
+		} else if tok.kind == '^' && len(tok.text) == 0 {
+			tok.kind = 'e'
+		}
+
+		tokens = append(tokens, tok)
+		line += strings.Count(src[:i+end+len(closing)], "\n")
+		src = src[i+end+len(closing):]
+	}
+
+	trimHbsTokens(tokens)
+	return tokens, nil
+}
+
+// trimHbsTokens applies whitespace control (`~`) and removes the whitespace
+// around "standalone" tags (blocks, comments & partials that are the only
+// thing on their line).
+func trimHbsTokens(tokens []hbsToken) {
+	for i := range tokens {
+		tok := &tokens[i]
+		if tok.kind == 0 || strings.IndexByte("#^/e!>", tok.kind) < 0 {
+			continue
+		}
+
+		prevOK := i == 0
+		if i > 0 && tokens[i-1].kind == 0 {
+			text := tokens[i-1].text
+			nl := strings.LastIndexByte(text, '\n')
+			if indent := text[nl+1:]; strings.Trim(indent, " \t") == "" && (nl >= 0 || i == 1) {
+				prevOK, tok.indent = true, indent
+			}
+		}
+		nextOK := i == len(tokens)-1
+		if i+1 < len(tokens) && tokens[i+1].kind == 0 {
+			text := tokens[i+1].text
+			nl := strings.IndexByte(text, '\n')
+			if nl < 0 {
+				nextOK = i+1 == len(tokens)-1 && strings.Trim(text, " \t") == ""
+			} else {
+				nextOK = strings.Trim(text[:nl], " \t\r") == ""
+			}
+		}
+		tok.standalone = prevOK && nextOK
+	}
+
+	for i := range tokens {
+		tok := tokens[i]
+		if tok.kind == 0 {
+			continue
+		}
+		if i > 0 && tokens[i-1].kind == 0 {
+			if tok.stripLeft {
+				tokens[i-1].text = strings.TrimRight(tokens[i-1].text, " \t\r\n")
+			} else if tok.standalone {
+				tokens[i-1].text = strings.TrimRight(tokens[i-1].text, " \t")
+			}
+		}
+		if i+1 < len(tokens) && tokens[i+1].kind == 0 {
+			if tok.stripRight {
+				tokens[i+1].text = strings.TrimLeft(tokens[i+1].text, " \t\r\n")
+			} else if tok.standalone {
+				text := strings.TrimLeft(tokens[i+1].text, " \t")
+				text = strings.TrimPrefix(text, "\r")
+				tokens[i+1].text = strings.TrimPrefix(text, "\n")
+			}
+		}
+	}
+}
+
+// parseHbs parses the handlebars template `src`, named `name`.
+func parseHbs(name, src string) ([]hbsNode, error) {
+	tokens, err := lexHbs(name, src)
+	if err != nil {
+		return nil, err
+	}
+
+	var nodes []hbsNode
+	var stack []*hbsBlock
+	add := func(n hbsNode) {
+		if len(stack) == 0 {
+			nodes = append(nodes, n)
+		} else if top := stack[len(stack)-1]; top.inElse {
+			top.inverse = append(top.inverse, n)
+		} else {
+			top.fn = append(top.fn, n)
+		}
+	}
+	errorf := func(line int, format string, a ...interface{}) error {
+		return fmt.Errorf("%s:%d: %s", name, line, fmt.Sprintf(format, a...))
+	}
+
+	for _, tok := range tokens {
+		switch tok.kind {
+		case 0:
+			if len(tok.text) > 0 {
+				add(tok.text)
+			}
+		case '!':
+		case 'm', '{', '&':
+			call, params, err := parseHbsExpr(tok.text)
+			if err != nil {
+				return nil, errorf(tok.line, "%s", err)
+			} else if params != nil {
+				return nil, errorf(tok.line, "block params are only allowed in blocks")
+			}
+			add(&hbsMustache{call: call, raw: tok.kind != 'm', line: tok.line})
+		case '#', '^':
+			call, params, err := parseHbsExpr(tok.text)
+			if err != nil {
+				return nil, errorf(tok.line, "%s", err)
+			}
+			block := &hbsBlock{call: call, params: params, inverted: tok.kind == '^', line: tok.line}
+			add(block)
+			stack = append(stack, block)
+		case 'e':
+			if len(stack) == 0 {
+				return nil, errorf(tok.line, "unexpected {{else}}")
+			}
+			top := stack[len(stack)-1]
+			if top.inElse {
+				return nil, errorf(tok.line, "unexpected {{else}}, '%s' already has one", hbsCallName(top.call))
+			}
+			top.inElse = true
+			if len(tok.text) > 0 {
+				call, params, err := parseHbsExpr(tok.text)
+				if err != nil {
+					return nil, errorf(tok.line, "%s", err)
+				}
+				block := &hbsBlock{call: call, params: params, line: tok.line, chained: true}
+				add(block)
+				stack = append(stack, block)
+			}
+		case '/':
+			for len(stack) > 0 && stack[len(stack)-1].chained {
+				stack = stack[:len(stack)-1]
+			}
+			if len(stack) == 0 {
+				return nil, errorf(tok.line, "unexpected {{/%s}}", tok.text)
+			} else if open := hbsCallName(stack[len(stack)-1].call); open != tok.text {
+				return nil, errorf(tok.line, "{{/%s}} does not match {{#%s}}", tok.text, open)
+			}
+			stack = stack[:len(stack)-1]
+		case '>':
+			partial, err := parseHbsPartial(tok.text)
+			if err != nil {
+				return nil, errorf(tok.line, "%s", err)
+			}
+			partial.line = tok.line
+			if tok.standalone {
+				partial.indent = tok.indent
+			}
+			add(partial)
+		}
+	}
+
+	for len(stack) > 0 && stack[len(stack)-1].chained {
+		stack = stack[:len(stack)-1]
+	}
+	if len(stack) > 0 {
+		block := stack[len(stack)-1]
+		return nil, errorf(block.line, "missing {{/%s}}", hbsCallName(block.call))
+	}
+	return nodes, nil
+}
+
+func hbsCallName(c *hbsCall) string {
+	if p, ok := c.name.(*hbsPath); ok {
+		return p.original
+	}
+	return fmt.Sprint(c.name)
+}
+
+func parseHbsPartial(src string) (*hbsPartial, error) {
+	s := hbsScanner{src: src}
+	var name string
+	if len(src) > 0 && (src[0] == '"' || src[0] == '\'') {
+		lit, err := s.str()
+		if err != nil {
+			return nil, err
+		}
+		name = lit.v.(string)
+	} else {
+		name = s.word()
+	}
+	if len(name) == 0 {
+		return nil, fmt.Errorf("missing partial name")
+	}
+
+	p := &hbsPartial{name: name}
+	for s.skipSpace(); !s.done(); s.skipSpace() {
+		if key, ok := s.hashKey(); ok {
+			v, err := s.value()
+			if err != nil {
+				return nil, err
+			}
+			if p.hash == nil {
+				p.hash = make(map[string]hbsValue)
+			}
+			p.hash[key] = v
+		} else if p.ctx != nil || p.hash != nil {
+			return nil, fmt.Errorf("partial '%s' has more than one context", name)
+		} else {
+			v, err := s.value()
+			if err != nil {
+				return nil, err
+			}
+			p.ctx = v
+		}
+	}
+	return p, nil
+}
+
+// parseHbsExpr parses the contents of a mustache or block tag
+func parseHbsExpr(src string) (*hbsCall, []string, error) {
+	s := hbsScanner{src: src}
+	call, err := s.call(false)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var params []string
+	if s.skipSpace(); !s.done() {
+		m := hbsBlockParams.FindStringSubmatch(s.src[s.pos:])
+		if m == nil {
+			return nil, nil, fmt.Errorf("unexpected '%s'", s.src[s.pos:])
+		}
+		params = strings.Fields(m[1])
+		if len(params) == 0 {
+			return nil, nil, fmt.Errorf("empty block params")
+		}
+		s.pos += len(m[0])
+		if s.skipSpace(); !s.done() {
+			return nil, nil, fmt.Errorf("unexpected '%s' after block params", s.src[s.pos:])
+		}
+	}
+	return call, params, nil
+}
+
+type hbsScanner struct {
+	src string
+	pos int
+}
+
+func (s *hbsScanner) done() bool {
+	return s.pos >= len(s.src)
+}
+
+func (s *hbsScanner) skipSpace() {
+	for !s.done() && strings.IndexByte(" \t\r\n", s.src[s.pos]) >= 0 {
+		s.pos++
+	}
+}
+
+// call reads a helper/path name, followed by any arguments. If `sub` is
+// true, it's a subexpression that ends with ')'.
+func (s *hbsScanner) call(sub bool) (*hbsCall, error) {
+	c := &hbsCall{}
+	for {
+		s.skipSpace()
+		if s.done() {
+			if sub {
+				return nil, fmt.Errorf("missing ')'")
+			}
+			break
+		} else if s.src[s.pos] == ')' {
+			if !sub {
+				return nil, fmt.Errorf("unexpected ')'")
+			}
+			s.pos++
+			break
+		} else if !sub && c.name != nil && hbsBlockParams.MatchString(s.src[s.pos:]) {
+			break
+		}
+
+		if key, ok := s.hashKey(); ok {
+			v, err := s.value()
+			if err != nil {
+				return nil, err
+			}
+			if c.hash == nil {
+				c.hash = make(map[string]hbsValue)
+			}
+			c.hash[key] = v
+			continue
+		} else if c.hash != nil {
+			return nil, fmt.Errorf("arguments must be before key=value arguments")
+		}
+
+		v, err := s.value()
+		if err != nil {
+			return nil, err
+		} else if c.name == nil {
+			c.name = v
+		} else {
+			c.args = append(c.args, v)
+		}
+	}
+
+	if c.name == nil {
+		return nil, fmt.Errorf("empty expression")
+	}
+	return c, nil
+}
+
+// hashKey reads "key=" if it's next
+func (s *hbsScanner) hashKey() (string, bool) {
+	i := s.pos
+	for i < len(s.src) && (isHCLIdentChar(rune(s.src[i]), i == s.pos) || s.src[i] == '@') {
+		i++
+	}
+	if i > s.pos && i < len(s.src) && s.src[i] == '=' {
+		key := s.src[s.pos:i]
+		s.pos = i + 1
+		return key, true
+	}
+	return "", false
+}
+
+func (s *hbsScanner) value() (hbsValue, error) {
+	if s.done() {
+		return nil, fmt.Errorf("missing value")
+	}
+
+	switch s.src[s.pos] {
+	case '(':
+		s.pos++
+		return s.call(true)
+	case '"', '\'':
+		return s.str()
+	}
+
+	word := s.word()
+	switch word {
+	case "":
+		return nil, fmt.Errorf("unexpected '%c'", s.src[s.pos])
+	case "true", "false":
+		return hbsLiteral{word == "true"}, nil
+	case "null", "undefined":
+		return hbsLiteral{nil}, nil
+	}
+	if i, err := strconv.Atoi(word); err == nil {
+		return hbsLiteral{i}, nil
+	} else if f, err := strconv.ParseFloat(word, 64); err == nil && strings.Trim(word, "-0123456789.") == "" {
+		return hbsLiteral{f}, nil
+	}
+	return parseHbsPath(word)
+}
+
+func (s *hbsScanner) str() (hbsLiteral, error) {
+	quote := s.src[s.pos]
+	var b strings.Builder
+	for s.pos++; !s.done(); s.pos++ {
+		c := s.src[s.pos]
+		if c == quote {
+			s.pos++
+			return hbsLiteral{b.String()}, nil
+		} else if c == '\\' && s.pos+1 < len(s.src) && s.src[s.pos+1] == quote {
+			s.pos++
+			c = quote
+		}
+		b.WriteByte(c)
+	}
+	return hbsLiteral{}, fmt.Errorf("missing closing %c", quote)
+}
+
+// word reads a path, which can contain [segment literals]
+func (s *hbsScanner) word() string {
+	start := s.pos
+	for !s.done() && strings.IndexByte(" \t\r\n()|=", s.src[s.pos]) < 0 {
+		if s.src[s.pos] == '[' {
+			if end := strings.IndexByte(s.src[s.pos:], ']'); end > 0 {
+				s.pos += end
+			}
+		}
+		s.pos++
+	}
+	return s.src[start:s.pos]
+}
+
+func parseHbsPath(word string) (*hbsPath, error) {
+	p := &hbsPath{original: word}
+	if strings.HasPrefix(word, "@") {
+		p.data, word = true, word[1:]
+	}
+
+	for {
+		switch {
+		case strings.HasPrefix(word, "../"):
+			p.up, word = p.up+1, word[3:]
+			continue
+		case word == "..":
+			p.up, word = p.up+1, ""
+		case word == "." || word == "this":
+			p.this, word = true, ""
+		case strings.HasPrefix(word, "./"):
+			p.this, word = true, word[2:]
+		case strings.HasPrefix(word, "this.") || strings.HasPrefix(word, "this/"):
+			p.this, word = true, word[5:]
+		}
+		break
+	}
+
+	for len(word) > 0 {
+		var part string
+		if word[0] == '[' {
+			end := strings.IndexByte(word, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("missing ']' in '%s'", p.original)
+			}
+			part, word = word[1:end], word[end+1:]
+		} else {
+			end := strings.IndexAny(word, "./")
+			if end < 0 {
+				end = len(word)
+			}
+			part, word = word[:end], word[end:]
+		}
+		if len(part) == 0 && !strings.HasPrefix(p.original, "[") {
+			return nil, fmt.Errorf("invalid path '%s'", p.original)
+		}
+		p.parts = append(p.parts, part)
+
+		if len(word) > 0 {
+			if word[0] != '.' && word[0] != '/' {
+				return nil, fmt.Errorf("invalid path '%s'", p.original)
+			} else if word = word[1:]; len(word) == 0 {
+				return nil, fmt.Errorf("invalid path '%s'", p.original)
+			}
+		}
+	}
+
+	if p.data && len(p.parts) == 0 {
+		return nil, fmt.Errorf("invalid path '%s'", p.original)
+	}
+	return p, nil
+}
+
+/* execution */
+
+type hbsState struct {
+	t            *hbsTemplate
+	partialDepth int
+	err          func() error // checked by loops, see `writerErr`
+}
+
+// hbsScope is the context, @variables & block params of a block
+type hbsScope struct {
+	ctx    interface{}
+	newCtx bool // true if `ctx` is different to the ctx of `parent`
+	vars   map[string]interface{}
+	params map[string]interface{}
+	parent *hbsScope
+}
+
+func (scope *hbsScope) data(name string) (interface{}, bool) {
+	for ; scope != nil; scope = scope.parent {
+		if v, ok := scope.vars[name]; ok {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+// hbsError is an error that indicates where it occurred
+type hbsError struct {
+	err error
+}
+
+func (err hbsError) Error() string {
+	return err.err.Error()
+}
+
+func (s *hbsState) errorf(name string, line int, err error) error {
+	if _, ok := err.(hbsError); ok {
+		return err
+	}
+	return hbsError{fmt.Errorf("%s:%d: %s", name, line, err)}
+}
+
+// execute renders `nodes` against `ctx` in a new scope, the result is
+// returned as a string.
+func (s *hbsState) execute(nodes []hbsNode, parent *hbsScope, ctx interface{}, vars map[string]interface{}, params []string, blockParams []interface{}) (string, error) {
+	var b strings.Builder
+	err := s.executeTo(&b, nodes, parent, ctx, vars, params, blockParams)
+	return b.String(), err
+}
+
+// executeTo renders `nodes` against `ctx` in a new scope, the result is
+// written to `w`.
+func (s *hbsState) executeTo(w io.Writer, nodes []hbsNode, parent *hbsScope, ctx interface{}, vars map[string]interface{}, params []string, blockParams []interface{}) error {
+	if len(nodes) == 0 {
+		return nil
+	}
+
+	scope := &hbsScope{ctx: ctx, newCtx: !hbsSame(ctx, parent.ctx), vars: vars, parent: parent}
+	for i, name := range params {
+		if scope.params == nil {
+			scope.params = make(map[string]interface{})
+		}
+		if i < len(blockParams) {
+			scope.params[name] = blockParams[i]
+		} else {
+			scope.params[name] = nil
+		}
+	}
+
+	return s.render(w, nodes, scope)
+}
+
+func (s *hbsState) render(w io.Writer, nodes []hbsNode, scope *hbsScope) (err error) {
+	for _, node := range nodes {
+		switch n := node.(type) {
+		case string:
+			_, err = io.WriteString(w, n)
+		case *hbsMustache:
+			var v interface{}
+			if v, err = s.call(n.call, scope, nil, nil); err != nil {
+				return s.errorf(s.t.name, n.line, err)
+			}
+			str := hbsString(v)
+			if _, safe := v.(HandlebarsSafeString); !n.raw && !safe {
+				str = hbsEscape(str)
+			}
+			_, err = io.WriteString(w, str)
+		case *hbsBlock:
+			var v interface{}
+			if v, err = s.call(n.call, scope, n, w); err != nil {
+				return s.errorf(s.t.name, n.line, err)
+			}
+			if _, written := v.(hbsWritten); !written {
+				_, err = io.WriteString(w, hbsString(v))
+			}
+		case *hbsPartial:
+			if err = s.partial(w, n, scope); err != nil {
+				return s.errorf(s.t.name, n.line, err)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// call evaluates `c`, if `block` is not nil it's called as a block. If `w`
+// is not nil, the built-in helpers write the result of `block` to it.
+func (s *hbsState) call(c *hbsCall, scope *hbsScope, block *hbsBlock, w io.Writer) (interface{}, error) {
+	path, isPath := c.name.(*hbsPath)
+	var helper HandlebarsHelper
+	if isPath && path.isHelper() {
+		helper, _ = getHandlebarsHelper(path.parts[0])
+	}
+
+	if helper == nil {
+		if len(c.args) > 0 || len(c.hash) > 0 {
+			return nil, fmt.Errorf("missing helper '%s'", hbsCallName(c))
+		}
+		v, err := s.eval(c.name, scope)
+		if err != nil || block == nil {
+			return v, err
+		}
+		return hbsBlockHelperMissing(v, s.options(c, scope, block, w))
+	}
+
+	args := make([]interface{}, len(c.args))
+	for i, arg := range c.args {
+		v, err := s.eval(arg, scope)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = v
+	}
+	opts := s.options(c, scope, block, w)
+	for key, arg := range c.hash {
+		v, err := s.eval(arg, scope)
+		if err != nil {
+			return nil, err
+		}
+		opts.Hash[key] = v
+	}
+	return helper(args, opts)
+}
+
+func (s *hbsState) options(c *hbsCall, scope *hbsScope, block *hbsBlock, w io.Writer) HandlebarsOptions {
+	opts := HandlebarsOptions{
+		Name:    hbsCallName(c),
+		Hash:    make(map[string]interface{}),
+		Context: scope.ctx,
+		state:   s,
+		scope:   scope,
+	}
+	if block != nil {
+		opts.fn, opts.inverse, opts.params = block.fn, block.inverse, block.params
+		if block.inverted {
+			opts.fn, opts.inverse = opts.inverse, opts.fn
+		}
+		if opts.fn == nil {
+			opts.fn = []hbsNode{} // so IsBlock is true
+		}
+		opts.w = w
+	}
+	return opts
+}
+
+func (s *hbsState) eval(v hbsValue, scope *hbsScope) (interface{}, error) {
+	switch val := v.(type) {
+	case hbsLiteral:
+		return val.v, nil
+	case *hbsCall:
+		return s.call(val, scope, nil, nil)
+	case *hbsPath:
+		return s.resolve(val, scope), nil
+	}
+	return nil, fmt.Errorf("invalid value %v", v)
+}
+
+func (s *hbsState) resolve(p *hbsPath, scope *hbsScope) interface{} {
+	parts := p.parts
+	var v interface{}
+
+	if p.data {
+		v, _ = scope.data(parts[0])
+		parts = parts[1:]
+	} else if !p.this && p.up == 0 && len(parts) > 0 {
+		found := false
+		for sc := scope; sc != nil && !found; sc = sc.parent {
+			v, found = sc.params[parts[0]]
+		}
+		if found {
+			parts = parts[1:]
+		} else {
+			v = scope.ctx
+		}
+	} else {
+		for i := 0; i < p.up && scope != nil; i++ {
+			for scope.parent != nil && !scope.newCtx {
+				scope = scope.parent
+			}
+			scope = scope.parent
+		}
+		if scope != nil {
+			v = scope.ctx
+		}
+	}
+
+	for _, part := range parts {
+		v = hbsLookup(v, part)
+	}
+	return v
+}
+
+func (s *hbsState) partial(w io.Writer, p *hbsPartial, scope *hbsScope) error {
+	nodes, ok := s.t.partials[p.name]
+	if !ok {
+		return fmt.Errorf("partial '%s' not found", p.name)
+	} else if s.partialDepth >= hbsMaxPartialDepth {
+		return fmt.Errorf("partials are nested more than %d levels deep", hbsMaxPartialDepth)
+	}
+
+	ctx := scope.ctx
+	if p.ctx != nil {
+		var err error
+		if ctx, err = s.eval(p.ctx, scope); err != nil {
+			return err
+		}
+	}
+	if len(p.hash) > 0 {
+		m := make(map[string]interface{})
+		if existing, ok := toStringMap(ctx); ok {
+			for key, val := range existing {
+				m[key] = val
+			}
+		}
+		for key, arg := range p.hash {
+			v, err := s.eval(arg, scope)
+			if err != nil {
+				return err
+			}
+			m[key] = v
+		}
+		ctx = m
+	}
+
+	s.partialDepth++
+	defer func() { s.partialDepth-- }()
+	partialScope := &hbsScope{ctx: ctx, newCtx: !hbsSame(ctx, scope.ctx), parent: scope}
+
+	if len(p.indent) > 0 {
+		w = &hbsIndentWriter{w: w, indent: p.indent}
+	}
+	if err := s.render(w, nodes, partialScope); err != nil {
+		if _, ok := err.(hbsError); !ok {
+			err = s.errorf(p.name, p.line, err)
+		}
+		return err
+	}
+	return nil
+}
+
+// hbsIndentWriter writes `indent` to `w` before every line written to it
+type hbsIndentWriter struct {
+	w       io.Writer
+	indent  string
+	midLine bool // true if the last byte written wasn't a newline
+}
+
+func (iw *hbsIndentWriter) Write(p []byte) (n int, err error) {
+	for len(p) > 0 {
+		if !iw.midLine {
+			if _, err = io.WriteString(iw.w, iw.indent); err != nil {
+				return
+			}
+			iw.midLine = true
+		}
+
+		line := p
+		if i := bytes.IndexByte(p, '\n'); i >= 0 {
+			line = p[:i+1]
+		}
+		var written int
+		written, err = iw.w.Write(line)
+		if n += written; err != nil {
+			return
+		}
+		iw.midLine = line[len(line)-1] != '\n'
+		p = p[len(line):]
+	}
+	return
+}
+
+/* helpers */
+
+// hbsBlockHelperMissing is used for blocks that aren't a helper, e.g.
+// `{{#items}}...{{/items}}`.
+func hbsBlockHelperMissing(v interface{}, opts HandlebarsOptions) (interface{}, error) {
+	switch {
+	case v == true:
+		return opts.fnTo(opts.Context, nil)
+	case v == false || v == nil:
+		return opts.inverseTo(opts.Context)
+	}
+	if rv := reflect.ValueOf(v); (rv.Kind() == reflect.Slice || rv.Kind() == reflect.Array) && !isHbsBytes(rv) {
+		if rv.Len() == 0 {
+			return opts.inverseTo(opts.Context)
+		}
+		return hbsEach([]interface{}{v}, opts)
+	}
+	return opts.fnTo(v, nil)
+}
+
+func hbsIf(args []interface{}, opts HandlebarsOptions) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("#%s requires exactly one argument", opts.Name)
+	}
+	includeZero, _ := opts.Hash["includeZero"].(bool)
+	if hbsTruthy(args[0], includeZero) {
+		return opts.fnTo(opts.Context, nil)
+	}
+	return opts.inverseTo(opts.Context)
+}
+
+func hbsUnless(args []interface{}, opts HandlebarsOptions) (interface{}, error) {
+	opts.fn, opts.inverse = opts.inverse, opts.fn
+	return hbsIf(args, opts)
+}
+
+func hbsWith(args []interface{}, opts HandlebarsOptions) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("#%s requires exactly one argument", opts.Name)
+	} else if hbsIsEmpty(args[0]) {
+		return opts.inverseTo(opts.Context)
+	}
+	return opts.fnTo(args[0], nil, args[0])
+}
+
+// hbsEach executes the block for each item in a list or map (in order of
+// the sorted keys). @index, @key, @first & @last are set for each item.
+func hbsEach(args []interface{}, opts HandlebarsOptions) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("#%s requires exactly one argument", opts.Name)
+	}
+
+	var keys []interface{}
+	var items []interface{}
+	rv := hbsIndirect(reflect.ValueOf(args[0]))
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		if isHbsBytes(rv) {
+			break
+		}
+		for i := 0; i < rv.Len(); i++ {
+			keys = append(keys, i)
+			items = append(items, rv.Index(i).Interface())
+		}
+	case reflect.Map:
+		m, _ := toStringMap(rv.Interface())
+		sorted := make([]string, 0, len(m))
+		for key := range m {
+			sorted = append(sorted, key)
+		}
+		sort.Strings(sorted)
+		for _, key := range sorted {
+			keys = append(keys, key)
+			items = append(items, m[key])
+		}
+	}
+
+	if len(items) == 0 {
+		return opts.inverseTo(opts.Context)
+	}
+
+	w := opts.w
+	var b *strings.Builder
+	if w == nil { // not rendering a block, return the result
+		b = &strings.Builder{}
+		w = b
+	}
+	for i, item := range items {
+		if err := opts.state.err(); err != nil {
+			return nil, err
+		}
+		data := map[string]interface{}{
+			"index": i,
+			"key":   keys[i],
+			"first": i == 0,
+			"last":  i == len(items)-1,
+		}
+		err := opts.state.executeTo(w, opts.fn, opts.scope, item, data, opts.params, []interface{}{item, keys[i]})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if b != nil {
+		return b.String(), nil
+	}
+	return hbsWritten{}, nil
+}
+
+func hbsLookupHelper(args []interface{}, opts HandlebarsOptions) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, fmt.Errorf("%s requires exactly two arguments", opts.Name)
+	}
+	return hbsLookup(args[0], hbsString(args[1])), nil
+}
+
+/* values */
+
+func hbsIndirect(rv reflect.Value) reflect.Value {
+	for rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Interface {
+		if rv.IsNil() {
+			return reflect.Value{}
+		}
+		rv = rv.Elem()
+	}
+	return rv
+}
+
+func isHbsBytes(rv reflect.Value) bool {
+	return rv.Type().Elem().Kind() == reflect.Uint8
+}
+
+// hbsLookup returns the value of `key` in `v`, which can be a map, struct
+// (exported field name), list (index) or the "length" of a list or string.
+func hbsLookup(v interface{}, key string) interface{} {
+	rv := hbsIndirect(reflect.ValueOf(v))
+	switch rv.Kind() {
+	case reflect.Map:
+		if rv.Type().Key().Kind() == reflect.String {
+			if val := rv.MapIndex(reflect.ValueOf(key).Convert(rv.Type().Key())); val.IsValid() {
+				return val.Interface()
+			}
+			return nil
+		}
+		iter := rv.MapRange()
+		for iter.Next() {
+			if fmt.Sprint(iter.Key().Interface()) == key {
+				return iter.Value().Interface()
+			}
+		}
+	case reflect.Struct:
+		if f, ok := rv.Type().FieldByName(key); ok && f.PkgPath == "" {
+			return rv.FieldByIndex(f.Index).Interface()
+		}
+	case reflect.Slice, reflect.Array, reflect.String:
+		if key == "length" {
+			return rv.Len()
+		} else if i, err := strconv.Atoi(key); err == nil && i >= 0 && i < rv.Len() && rv.Kind() != reflect.String {
+			return rv.Index(i).Interface()
+		}
+	}
+	return nil
+}
+
+// hbsIsEmpty returns true for nil, false, "" & empty lists
+func hbsIsEmpty(v interface{}) bool {
+	switch val := v.(type) {
+	case nil:
+		return true
+	case bool:
+		return !val
+	case string:
+		return len(val) == 0
+	case HandlebarsSafeString:
+		return len(val) == 0
+	}
+	rv := hbsIndirect(reflect.ValueOf(v))
+	switch rv.Kind() {
+	case reflect.Invalid:
+		return true
+	case reflect.Slice, reflect.Array:
+		return rv.Len() == 0
+	}
+	return false
+}
+
+// hbsTruthy returns false if `v` is empty (see `hbsIsEmpty`), zero (unless
+// `includeZero` is true) or NaN.
+func hbsTruthy(v interface{}, includeZero bool) bool {
+	if hbsIsEmpty(v) {
+		return false
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return includeZero || rv.Int() != 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return includeZero || rv.Uint() != 0
+	case reflect.Float32, reflect.Float64:
+		return !math.IsNaN(rv.Float()) && (includeZero || rv.Float() != 0)
+	}
+	return true
+}
+
+// hbsSame returns true if `a` & `b` are the same value, maps & lists are
+// compared by reference.
+func hbsSame(a, b interface{}) bool {
+	ra, rb := reflect.ValueOf(a), reflect.ValueOf(b)
+	if !ra.IsValid() || !rb.IsValid() {
+		return ra.IsValid() == rb.IsValid()
+	} else if ra.Type() != rb.Type() {
+		return false
+	}
+	switch ra.Kind() {
+	case reflect.Map, reflect.Ptr:
+		return ra.Pointer() == rb.Pointer()
+	case reflect.Slice:
+		return ra.Pointer() == rb.Pointer() && ra.Len() == rb.Len()
+	}
+	return ra.Type().Comparable() && a == b
+}
+
+// hbsString returns `v` as it's written in a template
+func hbsString(v interface{}) string {
+	switch val := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return val
+	case HandlebarsSafeString:
+		return string(val)
+	case []byte:
+		return string(val)
+	}
+	if l, ok := toList(v); ok {
+		items := make([]string, len(l))
+		for i, item := range l {
+			items[i] = hbsString(item)
+		}
+		return strings.Join(items, ",")
+	}
+	return fmt.Sprint(v)
+}
+
+var hbsEscaper = strings.NewReplacer(
+	"&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;",
+	"'", "&#x27;", "`", "&#x60;", "=", "&#x3D;",
+)
+
+func hbsEscape(s string) string {
+	return hbsEscaper.Replace(s)
+}
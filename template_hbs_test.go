@@ -0,0 +1,263 @@
+package dati
+
+/*
+Copyright (C) 2023 gearsix <gearsix@tuta.io>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const hbsData = `{
+	"title": "<eg>",
+	"html": "<b>eg</b>",
+	"zero": 0,
+	"empty": [],
+	"items": ["a", "b", "c"],
+	"people": [{"name": "x", "age": 1}, {"name": "y", "age": 2}],
+	"map": {"b": 2, "a": 1},
+	"author": {"name": "gearsix", "site": {"url": "notabug.org"}}
+}`
+
+var hbsGood = map[string]string{
+	`{{title}}`:                           `&lt;eg&gt;`,
+	`{{{title}}}`:                         `<eg>`,
+	`{{& title}}`:                         `<eg>`,
+	`{{author.name}} {{author/site/url}}`: `gearsix notabug.org`,
+	`{{#with author}}{{name}} {{../title}}{{/with}}`:                           `gearsix &lt;eg&gt;`,
+	`{{#with missing}}x{{else}}y{{/with}}`:                                     `y`,
+	`{{#with author as |a|}}{{a.name}}{{/with}}`:                               `gearsix`,
+	`{{#if title}}yes{{else}}no{{/if}}`:                                        `yes`,
+	`{{#if zero}}yes{{else}}no{{/if}}`:                                         `no`,
+	`{{#if zero includeZero=true}}yes{{else}}no{{/if}}`:                        `yes`,
+	`{{#if empty}}yes{{else if zero}}zero{{else}}no{{/if}}`:                    `no`,
+	`{{#unless empty}}yes{{else}}no{{/unless}}`:                                `yes`,
+	`{{#each items}}{{@index}}{{this}}{{/each}}`:                               `0a1b2c`,
+	`{{#each items}}{{#if @first}}[{{/if}}{{.}}{{#if @last}}]{{/if}}{{/each}}`: `[abc]`,
+	`{{#each map}}{{@key}}={{this}};{{/each}}`:                                 `a=1;b=2;`,
+	`{{#each people as |p i|}}{{i}}:{{p.name}} {{/each}}`:                      `0:x 1:y `,
+	`{{#each people}}{{name}}{{age}}{{../title}}{{@root.zero}}{{/each}}`:       `x1&lt;eg&gt;0y2&lt;eg&gt;0`,
+	`{{#each empty}}x{{else}}none{{/each}}`:                                    `none`,
+	`{{#people}}{{name}}{{/people}}`:                                           `xy`,
+	`{{^empty}}none{{/empty}}`:                                                 `none`,
+	`{{items.length}} {{items.[1]}} {{lookup items 2}}`:                        `3 b c`,
+	`{{lookup map (lookup items 0)}}`:                                          `1`,
+	`{{! comment }}{{!-- {{title}} --}}x`:                                      `x`,
+	`a {{~title~}} b`:                                                          `a&lt;eg&gt;b`,
+	`\{{title}}`:                                                               `{{title}}`,
+	"<ul>\n  {{#each items}}\n  <li>{{this}}</li>\n  {{/each}}\n</ul>":         "<ul>\n  <li>a</li>\n  <li>b</li>\n  <li>c</li>\n</ul>",
+	`{{> partial}}`:                    `gearsix`,
+	`{{> partial author}}`:             `gearsix`,
+	`{{> p name="y" age=3}}`:           `y3`,
+	`{{#each people}}{{> p}}{{/each}}`: `x1y2`,
+	"<p>\n  {{> lines}}\n</p>":         "<p>\n  a\n  b\n</p>",
+}
+
+var hbsPartials = map[string]string{
+	"partial": `{{#if author}}{{author.name}}{{else}}{{name}}{{/if}}`,
+	"p":       `{{name}}{{age}}`,
+	"lines":   "a\nb\n",
+}
+
+var hbsBad = []string{
+	`{{title`,
+	`{{#if title}}x`,
+	`{{#if title}}x{{/with}}`,
+	`{{/if}}`,
+	`{{else}}`,
+	`{{#if title}}{{else}}{{else}}{{/if}}`,
+	`{{missing title}}`,
+	`{{#if}}x{{/if}}`,
+	`{{> missing}}`,
+	`{{title as |x|}}`,
+	`{{lookup (title}}`,
+	`{{"a" b=1 c}}`,
+}
+
+func TestExecuteHbs(t *testing.T) {
+	var data map[string]interface{}
+	if err := LoadData(JSON, strings.NewReader(hbsData), &data); err != nil {
+		t.Skip("setup failure:", err)
+	}
+
+	for root, expect := range hbsGood {
+		tmpl, err := LoadTemplateString(HBS, "root", root, hbsPartials)
+		if err != nil {
+			t.Fatalf("'%s' failed to load: %s", root, err)
+		}
+		result, err := tmpl.Execute(data)
+		validateExecute(t, result.String(), expect, err)
+	}
+
+	for _, root := range hbsBad {
+		tmpl, err := LoadTemplateString(HBS, "root", root, hbsPartials)
+		if err == nil {
+			_, err = tmpl.Execute(data)
+		}
+		if err == nil {
+			t.Fatalf("bad template passed: '%s'", root)
+		} else if !strings.HasPrefix(err.Error(), "root:") {
+			t.Fatalf("error does not indicate the template: %s", err)
+		}
+	}
+}
+
+func TestExecuteHbsStruct(t *testing.T) {
+	type item struct {
+		Name  string
+		Items []int
+	}
+	tmpl, err := LoadTemplateString(HBS, "root", `{{Name}}:{{#each Items}}{{this}}{{/each}}`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := tmpl.Execute(item{Name: "eg", Items: []int{1, 2}})
+	validateExecute(t, result.String(), "eg:12", err)
+}
+
+// hbsWrites records each call to Write
+type hbsWrites []string
+
+func (w *hbsWrites) Write(p []byte) (int, error) {
+	*w = append(*w, string(p))
+	return len(p), nil
+}
+
+func TestExecuteHbsStreams(t *testing.T) {
+	tmpl, err := LoadTemplateString(HBS, "root", `{{#each .}}{{#if this}}{{this}}{{/if}}{{/each}}`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var writes hbsWrites
+	if err = tmpl.ExecuteTo(&writes, []string{"a", "b", "c"}); err != nil {
+		t.Fatal(err)
+	} else if expect := (hbsWrites{"a", "b", "c"}); !reflect.DeepEqual(writes, expect) {
+		t.Fatalf("blocks were not streamed: %q should be %q", writes, expect)
+	}
+}
+
+func TestExecuteHbsRecursivePartial(t *testing.T) {
+	tmpl, err := LoadTemplateString(HBS, "root", `{{> loop}}`,
+		map[string]string{"loop": `{{> loop}}`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = tmpl.Execute(nil); err == nil {
+		t.Fatal("recursive partial passed")
+	}
+}
+
+func TestRegisterHandlebarsHelper(t *testing.T) {
+	RegisterHandlebarsHelper("upper", func(args []interface{}, opts HandlebarsOptions) (interface{}, error) {
+		if opts.IsBlock() {
+			s, err := opts.Fn(opts.Context)
+			return strings.ToUpper(s), err
+		} else if len(args) != 1 {
+			return nil, fmt.Errorf("upper requires one argument")
+		}
+		s := strings.ToUpper(fmt.Sprint(args[0]))
+		if suffix, ok := opts.Hash["suffix"]; ok {
+			s += fmt.Sprint(suffix)
+		}
+		return s, nil
+	})
+	RegisterHandlebarsHelper("bold", func(args []interface{}, opts HandlebarsOptions) (interface{}, error) {
+		return HandlebarsSafeString("<b>" + hbsEscape(fmt.Sprint(args[0])) + "</b>"), nil
+	})
+	RegisterHandlebarsHelper("index", func(args []interface{}, opts HandlebarsOptions) (interface{}, error) {
+		return opts.Data("index"), nil
+	})
+	defer func() {
+		RegisterHandlebarsHelper("upper", nil)
+		RegisterHandlebarsHelper("bold", nil)
+		RegisterHandlebarsHelper("index", nil)
+	}()
+
+	var data map[string]interface{}
+	if err := LoadData(JSON, strings.NewReader(hbsData), &data); err != nil {
+		t.Skip("setup failure:", err)
+	}
+
+	good := map[string]string{
+		`{{upper author.name suffix="!"}}`:                 `GEARSIX!`,
+		`{{upper (lookup author "name") suffix="<"}}`:      `GEARSIX&lt;`,
+		`{{#upper}}{{author.name}}{{/upper}}`:              `GEARSIX`,
+		`{{bold title}}`:                                   `<b>&lt;eg&gt;</b>`,
+		`{{#each items}}{{index}}{{/each}}`:                `012`,
+		`{{#with author}}{{upper name suffix=0}}{{/with}}`: `GEARSIX0`,
+	}
+	for root, expect := range good {
+		tmpl, err := LoadTemplateString(HBS, "root", root, nil)
+		if err != nil {
+			t.Fatalf("'%s' failed to load: %s", root, err)
+		}
+		result, err := tmpl.Execute(data)
+		validateExecute(t, result.String(), expect, err)
+	}
+
+	tmpl, err := LoadTemplateString(HBS, "root", `{{upper}}`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = tmpl.Execute(data); err == nil || !strings.Contains(err.Error(), "upper requires one argument") {
+		t.Fatalf("helper error not returned: %v", err)
+	}
+
+	RegisterHandlebarsHelper("upper", nil)
+	if result, err := tmpl.Execute(map[string]string{"upper": "eg"}); err != nil {
+		t.Fatal(err)
+	} else if result.String() != "eg" {
+		t.Fatalf("removed helper was called: %s", result.String())
+	}
+}
+
+func TestLoadTemplateFileHbs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dati")
+	if err != nil {
+		t.Skip("setup failure:", err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"root.hbs":        `{{> head}}{{#each items}}{{> item}}{{/each}}`,
+		"head.handlebars": `{{title}}:`,
+		"item.hbs":        `{{this}}`,
+		"ignored.mst":     `{{eg}}`,
+	}
+	var partials []string
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Skip("setup failure:", err)
+		}
+		if name != "root.hbs" {
+			partials = append(partials, path)
+		}
+	}
+
+	tmpl, err := LoadTemplateFile(filepath.Join(dir, "root.hbs"), partials...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := tmpl.Execute(map[string]interface{}{"title": "eg", "items": []int{1, 2}})
+	validateExecute(t, result.String(), "eg:12", err)
+}
@@ -0,0 +1,2171 @@
+package dati
+
+/*
+Copyright (C) 2023 gearsix <gearsix@tuta.io>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const JINJA TemplateLanguage = "jinja"
+
+func init() {
+	RegisterTemplateLanguage(JINJA, []string{"j2", "jinja", "jinja2"}, EngineFunc(loadTemplateJinja))
+}
+
+// the maximum number of templates (includes, macros & parent templates)
+// that can be nested when executing a jinja template
+const jinjaMaxDepth = 1000
+
+// the maximum number of items `range()` can return (the same as MAX_RANGE in
+// jinja's sandbox) and the maximum length of a string or list created by
+// `*` or padding (e.g. the `center` & `indent` filters), so templates can't
+// exhaust memory
+const (
+	jinjaMaxRange  = 100000
+	jinjaMaxRepeat = 1 << 20
+)
+
+// jinjaTemplate is a parsed jinja template, it implements *Executable*.
+type jinjaTemplate struct {
+	name     string
+	nodes    []jinjaNode
+	blocks   map[string]*jinjaBlock
+	partials map[string]*jinjaTemplate // shared by all templates parsed together
+}
+
+// loadTemplateJinja parses `root` and `partials` as jinja templates (see
+// https://jinja.palletsprojects.com/en/3.1.x/templates/). Templates used by
+// {% extends %}, {% include %}, {% import %} & {% from %} are looked up in
+// `partials` by name. Output isn't escaped unless the "escape" filter is
+// used and undefined values can be chained (`{{ missing.key }}` is "").
+func loadTemplateJinja(rootName string, root io.Reader, partials map[string]io.Reader) (Executable, error) {
+	all := make(map[string]*jinjaTemplate)
+
+	for name, partial := range partials {
+		buf, err := ioutil.ReadAll(partial)
+		if err != nil {
+			return nil, err
+		}
+		if all[name], err = parseJinja(name, string(buf)); err != nil {
+			return nil, err
+		}
+		all[name].partials = all
+	}
+
+	buf, err := ioutil.ReadAll(root)
+	if err != nil {
+		return nil, err
+	}
+	t, err := parseJinja(rootName, string(buf))
+	if err != nil {
+		return nil, err
+	}
+	t.partials = all
+	return t, nil
+}
+
+// Execute writes the result of applying `t` to `data` to `w`.
+func (t *jinjaTemplate) Execute(w io.Writer, data interface{}) error {
+	s := &jinjaState{partials: t.partials, err: writerErr(w)}
+	return s.renderTemplate(w, t, &jinjaFrame{vars: make(map[string]interface{}), data: data})
+}
+
+/* lexing */
+
+type jinjaTokenKind int
+
+const (
+	jinjaTokText       jinjaTokenKind = iota
+	jinjaTokVarBegin                  // {{
+	jinjaTokVarEnd                    // }}
+	jinjaTokBlockBegin                // {%
+	jinjaTokBlockEnd                  // %}
+	jinjaTokName
+	jinjaTokString
+	jinjaTokInt
+	jinjaTokFloat
+	jinjaTokOp
+	jinjaTokEOF
+)
+
+type jinjaToken struct {
+	kind jinjaTokenKind
+	val  string
+	line int
+}
+
+var (
+	jinjaRawBegin = regexp.MustCompile(`^\{%(-?)\s*raw\s*(-?)%\}`)
+	jinjaRawEnd   = regexp.MustCompile(`\{%(-?)\s*endraw\s*(-?)%\}`)
+)
+
+type jinjaLexer struct {
+	name   string
+	src    string
+	pos    int
+	line   int
+	tokens []jinjaToken
+}
+
+func (l *jinjaLexer) errorf(format string, a ...interface{}) error {
+	return fmt.Errorf("%s:%d: %s", l.name, l.line, fmt.Sprintf(format, a...))
+}
+
+func (l *jinjaLexer) emit(kind jinjaTokenKind, val string) {
+	l.tokens = append(l.tokens, jinjaToken{kind: kind, val: val, line: l.line})
+}
+
+// advance moves `l.pos` to `pos`, counting any lines passed
+func (l *jinjaLexer) advance(pos int) {
+	l.line += strings.Count(l.src[l.pos:pos], "\n")
+	l.pos = pos
+}
+
+// lexJinja splits `src` into text, {{ }}/{% %} delimiters and the tokens
+// between them. Comments are removed, {% raw %} blocks are returned as text
+// and `-` whitespace control is applied to the surrounding text.
+func lexJinja(name, src string) ([]jinjaToken, error) {
+	l := &jinjaLexer{name: name, src: src, line: 1}
+
+	lstrip := false
+	for l.pos < len(l.src) {
+		start := l.nextTag()
+		end := start
+		if end < 0 {
+			end = len(l.src)
+		}
+
+		text := l.src[l.pos:end]
+		if lstrip {
+			text = strings.TrimLeft(text, " \t\r\n")
+		}
+		if start >= 0 && start+2 < len(l.src) && l.src[start+2] == '-' {
+			text = strings.TrimRight(text, " \t\r\n")
+		}
+		if len(text) > 0 {
+			l.emit(jinjaTokText, text)
+		}
+		l.advance(end)
+		if start < 0 {
+			break
+		}
+
+		var err error
+		switch l.src[start+1] {
+		case '#':
+			close := strings.Index(l.src[start+2:], "#}")
+			if close < 0 {
+				return nil, l.errorf("missing #}")
+			}
+			close += start + 2
+			lstrip = close > start+2 && l.src[close-1] == '-'
+			l.advance(close + 2)
+		case '%':
+			if m := jinjaRawBegin.FindStringSubmatch(l.src[start:]); m != nil {
+				body := start + len(m[0])
+				loc := jinjaRawEnd.FindStringSubmatchIndex(l.src[body:])
+				if loc == nil {
+					return nil, l.errorf("missing {%% endraw %%}")
+				}
+				raw := l.src[body : body+loc[0]]
+				if m[2] == "-" {
+					raw = strings.TrimLeft(raw, " \t\r\n")
+				}
+				if loc[3] > loc[2] {
+					raw = strings.TrimRight(raw, " \t\r\n")
+				}
+				l.emit(jinjaTokText, raw)
+				lstrip = loc[5] > loc[4]
+				l.advance(body + loc[1])
+				continue
+			}
+			l.emit(jinjaTokBlockBegin, "{%")
+			lstrip, err = l.lexTag("%}", jinjaTokBlockEnd)
+		case '{':
+			l.emit(jinjaTokVarBegin, "{{")
+			lstrip, err = l.lexTag("}}", jinjaTokVarEnd)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	l.emit(jinjaTokEOF, "")
+	return l.tokens, nil
+}
+
+// nextTag returns the index of the next "{{", "{%" or "{#" or -1
+func (l *jinjaLexer) nextTag() int {
+	for i := l.pos; i+1 < len(l.src); i++ {
+		if l.src[i] == '{' && strings.IndexByte("{%#", l.src[i+1]) >= 0 {
+			return i
+		}
+	}
+	return -1
+}
+
+// lexTag lexes the tokens in a tag, until `close` is found. `lstrip` is
+// true if the tag ends with "-".
+func (l *jinjaLexer) lexTag(close string, kind jinjaTokenKind) (lstrip bool, err error) {
+	l.advance(l.pos + 2)
+	if l.pos < len(l.src) && l.src[l.pos] == '-' {
+		l.advance(l.pos + 1)
+	}
+
+	depth := 0
+	for {
+		for l.pos < len(l.src) && strings.IndexByte(" \t\r\n", l.src[l.pos]) >= 0 {
+			l.advance(l.pos + 1)
+		}
+		rest := l.src[l.pos:]
+		if len(rest) == 0 {
+			return false, l.errorf("missing %s", close)
+		} else if depth == 0 && strings.HasPrefix(rest, "-"+close) {
+			l.emit(kind, close)
+			l.advance(l.pos + 3)
+			return true, nil
+		} else if depth == 0 && strings.HasPrefix(rest, close) {
+			l.emit(kind, close)
+			l.advance(l.pos + 2)
+			return false, nil
+		}
+
+		c := rest[0]
+		switch {
+		case isJinjaNameChar(c, true):
+			end := 1
+			for end < len(rest) && isJinjaNameChar(rest[end], false) {
+				end++
+			}
+			l.emit(jinjaTokName, rest[:end])
+			l.advance(l.pos + end)
+		case c >= '0' && c <= '9':
+			end, float := lexJinjaNumber(rest)
+			num := strings.Replace(rest[:end], "_", "", -1)
+			if float {
+				l.emit(jinjaTokFloat, num)
+			} else {
+				l.emit(jinjaTokInt, num)
+			}
+			l.advance(l.pos + end)
+		case c == '"' || c == '\'':
+			s, end, ok := lexJinjaString(rest)
+			if !ok {
+				return false, l.errorf("unterminated string")
+			}
+			l.emit(jinjaTokString, s)
+			l.advance(l.pos + end)
+		default:
+			op := ""
+			for _, o := range []string{"//", "**", "==", "!=", "<=", ">="} {
+				if strings.HasPrefix(rest, o) {
+					op = o
+					break
+				}
+			}
+			if len(op) == 0 && strings.IndexByte("+-*/%~()[]{}.,:|=<>", c) >= 0 {
+				op = string(c)
+			}
+			if len(op) == 0 {
+				return false, l.errorf("unexpected character '%c'", c)
+			}
+			switch op {
+			case "(", "[", "{":
+				depth++
+			case ")", "]", "}":
+				if depth--; depth < 0 {
+					return false, l.errorf("unexpected '%s'", op)
+				}
+			}
+			l.emit(jinjaTokOp, op)
+			l.advance(l.pos + len(op))
+		}
+	}
+}
+
+func isJinjaNameChar(c byte, first bool) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (!first && c >= '0' && c <= '9')
+}
+
+func lexJinjaNumber(src string) (end int, float bool) {
+	digits := func() {
+		for end < len(src) && (src[end] >= '0' && src[end] <= '9' || src[end] == '_') {
+			end++
+		}
+	}
+	digits()
+	if end+1 < len(src) && src[end] == '.' && src[end+1] >= '0' && src[end+1] <= '9' {
+		float = true
+		end++
+		digits()
+	}
+	if end < len(src) && (src[end] == 'e' || src[end] == 'E') {
+		i := end + 1
+		if i < len(src) && (src[i] == '+' || src[i] == '-') {
+			i++
+		}
+		if i < len(src) && src[i] >= '0' && src[i] <= '9' {
+			float, end = true, i
+			digits()
+		}
+	}
+	return
+}
+
+func lexJinjaString(src string) (s string, end int, ok bool) {
+	quote := src[0]
+	var b strings.Builder
+	for end = 1; end < len(src); end++ {
+		c := src[end]
+		if c == quote {
+			return b.String(), end + 1, true
+		} else if c == '\\' && end+1 < len(src) {
+			end++
+			switch src[end] {
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			case 'r':
+				b.WriteByte('\r')
+			case '\\', '"', '\'':
+				b.WriteByte(src[end])
+			default:
+				b.WriteByte('\\')
+				b.WriteByte(src[end])
+			}
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return "", end, false
+}
+
+/* parsing */
+
+type jinjaNode interface{}
+
+type jinjaOutput struct {
+	expr jinjaExpr
+	line int
+}
+
+type jinjaIf struct {
+	conds  []jinjaExpr
+	bodies [][]jinjaNode
+	els    []jinjaNode
+	line   int
+}
+
+type jinjaFor struct {
+	targets []string
+	iter    jinjaExpr
+	cond    jinjaExpr
+	body    []jinjaNode
+	els     []jinjaNode
+	line    int
+}
+
+// {% set a, b = expr %}, {% set ns.attr = expr %} or {% set a %}body{% endset %}
+type jinjaSet struct {
+	targets []string
+	attr    string
+	expr    jinjaExpr
+	body    []jinjaNode
+	line    int
+}
+
+type jinjaBlock struct {
+	name string
+	tmpl string // name of the template the block is defined in
+	body []jinjaNode
+	line int
+}
+
+type jinjaExtends struct {
+	expr jinjaExpr
+	line int
+}
+
+type jinjaInclude struct {
+	expr          jinjaExpr
+	ignoreMissing bool
+	noContext     bool
+	line          int
+}
+
+// {% import expr as as %} or {% from expr import names %}
+type jinjaImport struct {
+	expr        jinjaExpr
+	as          string
+	names       [][2]string // name, alias
+	withContext bool
+	line        int
+}
+
+type jinjaMacro struct {
+	name     string
+	params   []string
+	defaults []jinjaExpr
+	body     []jinjaNode
+	line     int
+}
+
+// {% call(params) macro() %}body{% endcall %}
+type jinjaCallBlock struct {
+	call  *jinjaCall
+	macro *jinjaMacro // the body, called as `caller`
+	line  int
+}
+
+type jinjaFilterBlock struct {
+	filters []*jinjaFilterExpr
+	body    []jinjaNode
+	line    int
+}
+
+type jinjaWith struct {
+	targets []string
+	exprs   []jinjaExpr
+	body    []jinjaNode
+	line    int
+}
+
+type jinjaExpr interface{}
+
+type jinjaConst struct {
+	v interface{}
+}
+
+type jinjaName struct {
+	name string
+}
+
+type jinjaGetAttr struct {
+	obj  jinjaExpr
+	name string
+}
+
+type jinjaGetItem struct {
+	obj, key jinjaExpr
+}
+
+type jinjaSlice struct {
+	obj, start, stop, step jinjaExpr
+}
+
+type jinjaBinary struct {
+	op   string
+	l, r jinjaExpr
+}
+
+type jinjaUnary struct {
+	op string
+	x  jinjaExpr
+}
+
+type jinjaCompare struct {
+	x   jinjaExpr
+	ops []string
+	ys  []jinjaExpr
+}
+
+// `a if test else b`, `b` may be nil
+type jinjaCond struct {
+	test, a, b jinjaExpr
+}
+
+type jinjaFilterExpr struct {
+	x      jinjaExpr
+	name   string
+	args   []jinjaExpr
+	kwargs map[string]jinjaExpr
+}
+
+type jinjaTestExpr struct {
+	x      jinjaExpr
+	name   string
+	args   []jinjaExpr
+	negate bool
+}
+
+type jinjaCall struct {
+	fn     jinjaExpr
+	args   []jinjaExpr
+	kwargs map[string]jinjaExpr
+}
+
+type jinjaList struct {
+	items []jinjaExpr
+}
+
+type jinjaDict struct {
+	keys, vals []jinjaExpr
+}
+
+type jinjaParser struct {
+	t      *jinjaTemplate
+	tokens []jinjaToken
+	pos    int
+}
+
+// parseJinja parses the jinja template `src`, named `name`.
+func parseJinja(name, src string) (*jinjaTemplate, error) {
+	// like jinja, a single trailing newline is removed
+	if strings.HasSuffix(src, "\n") {
+		src = strings.TrimSuffix(strings.TrimSuffix(src, "\n"), "\r")
+	}
+
+	tokens, err := lexJinja(name, src)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &jinjaParser{
+		t:      &jinjaTemplate{name: name, blocks: make(map[string]*jinjaBlock)},
+		tokens: tokens,
+	}
+	if p.t.nodes, _, err = p.parseNodes(); err != nil {
+		return nil, err
+	}
+	return p.t, nil
+}
+
+func (p *jinjaParser) peek() jinjaToken {
+	return p.tokens[p.pos]
+}
+
+func (p *jinjaParser) next() jinjaToken {
+	tok := p.tokens[p.pos]
+	if tok.kind != jinjaTokEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *jinjaParser) errorf(format string, a ...interface{}) error {
+	return fmt.Errorf("%s:%d: %s", p.t.name, p.peek().line, fmt.Sprintf(format, a...))
+}
+
+// isOp returns true if the next token is one of the operators `ops`
+func (p *jinjaParser) isOp(ops ...string) bool {
+	tok := p.peek()
+	if tok.kind == jinjaTokOp {
+		for _, op := range ops {
+			if tok.val == op {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isName returns true if the next token is one of the names `names`
+func (p *jinjaParser) isName(names ...string) bool {
+	tok := p.peek()
+	if tok.kind == jinjaTokName {
+		for _, name := range names {
+			if tok.val == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (p *jinjaParser) expectOp(op string) error {
+	if !p.isOp(op) {
+		return p.errorf("expected '%s', found %s", op, p.describe())
+	}
+	p.next()
+	return nil
+}
+
+func (p *jinjaParser) expectName(name string) error {
+	if !p.isName(name) {
+		return p.errorf("expected '%s', found %s", name, p.describe())
+	}
+	p.next()
+	return nil
+}
+
+func (p *jinjaParser) expectBlockEnd() error {
+	if p.peek().kind != jinjaTokBlockEnd {
+		return p.errorf("expected '%%}', found %s", p.describe())
+	}
+	p.next()
+	return nil
+}
+
+func (p *jinjaParser) ident() (string, error) {
+	if tok := p.peek(); tok.kind != jinjaTokName {
+		return "", p.errorf("expected a name, found %s", p.describe())
+	}
+	return p.next().val, nil
+}
+
+func (p *jinjaParser) describe() string {
+	switch tok := p.peek(); tok.kind {
+	case jinjaTokEOF:
+		return "end of template"
+	case jinjaTokText:
+		return "text"
+	case jinjaTokString:
+		return strconv.Quote(tok.val)
+	default:
+		return "'" + tok.val + "'"
+	}
+}
+
+// parseNodes parses nodes until a {% tag %} named one of `end` is found,
+// the name of that tag is returned (the rest of the tag isn't parsed).
+func (p *jinjaParser) parseNodes(end ...string) (nodes []jinjaNode, tag string, err error) {
+	for {
+		tok := p.next()
+		switch tok.kind {
+		case jinjaTokEOF:
+			if len(end) > 0 {
+				return nil, "", fmt.Errorf("%s:%d: missing {%% %s %%}", p.t.name, tok.line, end[len(end)-1])
+			}
+			return nodes, "", nil
+		case jinjaTokText:
+			nodes = append(nodes, tok.val)
+		case jinjaTokVarBegin:
+			expr, err := p.parseTuple(true)
+			if err != nil {
+				return nil, "", err
+			} else if p.peek().kind != jinjaTokVarEnd {
+				return nil, "", p.errorf("expected '}}', found %s", p.describe())
+			}
+			p.next()
+			nodes = append(nodes, &jinjaOutput{expr: expr, line: tok.line})
+		case jinjaTokBlockBegin:
+			name, err := p.ident()
+			if err != nil {
+				return nil, "", err
+			}
+			for _, e := range end {
+				if name == e {
+					return nodes, name, nil
+				}
+			}
+			node, err := p.parseStatement(name, tok.line)
+			if err != nil {
+				return nil, "", err
+			}
+			nodes = append(nodes, node)
+		default:
+			return nil, "", p.errorf("unexpected %s", p.describe())
+		}
+	}
+}
+
+// parseEnd parses the rest of an {% end... %} tag, `name` is the optional
+// name that can follow it (e.g. {% endblock name %}).
+func (p *jinjaParser) parseEnd(name string) error {
+	if len(name) > 0 && p.isName(name) {
+		p.next()
+	}
+	return p.expectBlockEnd()
+}
+
+func (p *jinjaParser) parseStatement(tag string, line int) (node jinjaNode, err error) {
+	switch tag {
+	case "if":
+		node, err = p.parseIf(line)
+	case "for":
+		node, err = p.parseFor(line)
+	case "set":
+		node, err = p.parseSet(line)
+	case "block":
+		node, err = p.parseBlock(line)
+	case "extends":
+		n := &jinjaExtends{line: line}
+		if n.expr, err = p.parseExpr(true); err == nil {
+			node, err = n, p.expectBlockEnd()
+		}
+	case "include":
+		node, err = p.parseInclude(line)
+	case "import", "from":
+		node, err = p.parseImport(tag, line)
+	case "macro":
+		node, err = p.parseMacro(line)
+	case "call":
+		node, err = p.parseCallBlock(line)
+	case "filter":
+		node, err = p.parseFilterBlock(line)
+	case "with":
+		node, err = p.parseWith(line)
+	default:
+		return nil, fmt.Errorf("%s:%d: unknown tag '%s'", p.t.name, line, tag)
+	}
+	return
+}
+
+func (p *jinjaParser) parseIf(line int) (jinjaNode, error) {
+	n := &jinjaIf{line: line}
+	tag := "if"
+	for tag == "if" || tag == "elif" {
+		cond, err := p.parseTuple(true)
+		if err != nil {
+			return nil, err
+		} else if err = p.expectBlockEnd(); err != nil {
+			return nil, err
+		}
+		body, end, err := p.parseNodes("elif", "else", "endif")
+		if err != nil {
+			return nil, err
+		}
+		n.conds, n.bodies, tag = append(n.conds, cond), append(n.bodies, body), end
+	}
+	if tag == "else" {
+		if err := p.expectBlockEnd(); err != nil {
+			return nil, err
+		}
+		var err error
+		if n.els, _, err = p.parseNodes("endif"); err != nil {
+			return nil, err
+		}
+	}
+	return n, p.parseEnd("")
+}
+
+func (p *jinjaParser) parseTargets() (targets []string, err error) {
+	paren := p.isOp("(")
+	if paren {
+		p.next()
+	}
+	for {
+		name, err := p.ident()
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, name)
+		if !p.isOp(",") {
+			break
+		}
+		p.next()
+	}
+	if paren {
+		err = p.expectOp(")")
+	}
+	return
+}
+
+func (p *jinjaParser) parseFor(line int) (jinjaNode, error) {
+	n := &jinjaFor{line: line}
+	var err error
+	if n.targets, err = p.parseTargets(); err != nil {
+		return nil, err
+	} else if err = p.expectName("in"); err != nil {
+		return nil, err
+	} else if n.iter, err = p.parseTuple(false); err != nil {
+		return nil, err
+	}
+	if p.isName("if") {
+		p.next()
+		if n.cond, err = p.parseExpr(true); err != nil {
+			return nil, err
+		}
+	}
+	if p.isName("recursive") {
+		return nil, p.errorf("recursive loops are not supported")
+	} else if err = p.expectBlockEnd(); err != nil {
+		return nil, err
+	}
+
+	var tag string
+	if n.body, tag, err = p.parseNodes("else", "endfor"); err != nil {
+		return nil, err
+	} else if tag == "else" {
+		if err = p.expectBlockEnd(); err != nil {
+			return nil, err
+		} else if n.els, _, err = p.parseNodes("endfor"); err != nil {
+			return nil, err
+		}
+	}
+	return n, p.parseEnd("")
+}
+
+func (p *jinjaParser) parseSet(line int) (jinjaNode, error) {
+	n := &jinjaSet{line: line}
+	var err error
+	if n.targets, err = p.parseTargets(); err != nil {
+		return nil, err
+	}
+	if len(n.targets) == 1 && p.isOp(".") {
+		p.next()
+		if n.attr, err = p.ident(); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.peek().kind == jinjaTokBlockEnd && len(n.targets) == 1 && len(n.attr) == 0 {
+		p.next()
+		if n.body, _, err = p.parseNodes("endset"); err != nil {
+			return nil, err
+		}
+		return n, p.parseEnd("")
+	}
+
+	if err = p.expectOp("="); err != nil {
+		return nil, err
+	} else if n.expr, err = p.parseTuple(true); err != nil {
+		return nil, err
+	}
+	return n, p.expectBlockEnd()
+}
+
+func (p *jinjaParser) parseBlock(line int) (jinjaNode, error) {
+	name, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+	for p.isName("scoped", "required") {
+		p.next()
+	}
+	if err = p.expectBlockEnd(); err != nil {
+		return nil, err
+	} else if _, ok := p.t.blocks[name]; ok {
+		return nil, fmt.Errorf("%s:%d: block '%s' defined twice", p.t.name, line, name)
+	}
+
+	n := &jinjaBlock{name: name, tmpl: p.t.name, line: line}
+	p.t.blocks[name] = n
+	if n.body, _, err = p.parseNodes("endblock"); err != nil {
+		return nil, err
+	}
+	return n, p.parseEnd(name)
+}
+
+// parseContext parses an optional "with context" or "without context"
+func (p *jinjaParser) parseContext(def bool) (bool, error) {
+	if p.isName("with", "without") {
+		with := p.next().val == "with"
+		return with, p.expectName("context")
+	}
+	return def, nil
+}
+
+func (p *jinjaParser) parseInclude(line int) (jinjaNode, error) {
+	n := &jinjaInclude{line: line}
+	var err error
+	if n.expr, err = p.parseExpr(true); err != nil {
+		return nil, err
+	}
+	if p.isName("ignore") {
+		p.next()
+		if err = p.expectName("missing"); err != nil {
+			return nil, err
+		}
+		n.ignoreMissing = true
+	}
+	with, err := p.parseContext(true)
+	if err != nil {
+		return nil, err
+	}
+	n.noContext = !with
+	return n, p.expectBlockEnd()
+}
+
+func (p *jinjaParser) parseImport(tag string, line int) (jinjaNode, error) {
+	n := &jinjaImport{line: line}
+	var err error
+	if n.expr, err = p.parseExpr(true); err != nil {
+		return nil, err
+	}
+
+	if tag == "import" {
+		if err = p.expectName("as"); err != nil {
+			return nil, err
+		} else if n.as, err = p.ident(); err != nil {
+			return nil, err
+		}
+	} else {
+		if err = p.expectName("import"); err != nil {
+			return nil, err
+		}
+		for {
+			if p.isName("with", "without") && len(n.names) > 0 {
+				break
+			}
+			var name [2]string
+			if name[0], err = p.ident(); err != nil {
+				return nil, err
+			}
+			name[1] = name[0]
+			if p.isName("as") {
+				p.next()
+				if name[1], err = p.ident(); err != nil {
+					return nil, err
+				}
+			}
+			n.names = append(n.names, name)
+			if !p.isOp(",") {
+				break
+			}
+			p.next()
+		}
+	}
+
+	if n.withContext, err = p.parseContext(false); err != nil {
+		return nil, err
+	}
+	return n, p.expectBlockEnd()
+}
+
+// parseParams parses "(a, b=default)"
+func (p *jinjaParser) parseParams(m *jinjaMacro) error {
+	if err := p.expectOp("("); err != nil {
+		return err
+	}
+	for !p.isOp(")") {
+		name, err := p.ident()
+		if err != nil {
+			return err
+		}
+		var def jinjaExpr
+		if p.isOp("=") {
+			p.next()
+			if def, err = p.parseExpr(true); err != nil {
+				return err
+			}
+		}
+		m.params, m.defaults = append(m.params, name), append(m.defaults, def)
+		if !p.isOp(",") {
+			break
+		}
+		p.next()
+	}
+	return p.expectOp(")")
+}
+
+func (p *jinjaParser) parseMacro(line int) (jinjaNode, error) {
+	m := &jinjaMacro{line: line}
+	var err error
+	if m.name, err = p.ident(); err != nil {
+		return nil, err
+	} else if err = p.parseParams(m); err != nil {
+		return nil, err
+	} else if err = p.expectBlockEnd(); err != nil {
+		return nil, err
+	} else if m.body, _, err = p.parseNodes("endmacro"); err != nil {
+		return nil, err
+	}
+	return m, p.parseEnd(m.name)
+}
+
+func (p *jinjaParser) parseCallBlock(line int) (jinjaNode, error) {
+	n := &jinjaCallBlock{macro: &jinjaMacro{name: "caller", line: line}, line: line}
+	if p.isOp("(") {
+		if err := p.parseParams(n.macro); err != nil {
+			return nil, err
+		}
+	}
+
+	expr, err := p.parseExpr(true)
+	if err != nil {
+		return nil, err
+	}
+	var ok bool
+	if n.call, ok = expr.(*jinjaCall); !ok {
+		return nil, fmt.Errorf("%s:%d: {%% call %%} requires a call expression", p.t.name, line)
+	} else if err = p.expectBlockEnd(); err != nil {
+		return nil, err
+	} else if n.macro.body, _, err = p.parseNodes("endcall"); err != nil {
+		return nil, err
+	}
+	return n, p.parseEnd("")
+}
+
+func (p *jinjaParser) parseFilterBlock(line int) (jinjaNode, error) {
+	n := &jinjaFilterBlock{line: line}
+	for {
+		f, err := p.parseFilter(nil)
+		if err != nil {
+			return nil, err
+		}
+		n.filters = append(n.filters, f)
+		if !p.isOp("|") {
+			break
+		}
+		p.next()
+	}
+
+	var err error
+	if err = p.expectBlockEnd(); err != nil {
+		return nil, err
+	} else if n.body, _, err = p.parseNodes("endfilter"); err != nil {
+		return nil, err
+	}
+	return n, p.parseEnd("")
+}
+
+func (p *jinjaParser) parseWith(line int) (jinjaNode, error) {
+	n := &jinjaWith{line: line}
+	for p.peek().kind != jinjaTokBlockEnd {
+		name, err := p.ident()
+		if err != nil {
+			return nil, err
+		} else if err = p.expectOp("="); err != nil {
+			return nil, err
+		}
+		expr, err := p.parseExpr(true)
+		if err != nil {
+			return nil, err
+		}
+		n.targets, n.exprs = append(n.targets, name), append(n.exprs, expr)
+		if !p.isOp(",") {
+			break
+		}
+		p.next()
+	}
+
+	var err error
+	if err = p.expectBlockEnd(); err != nil {
+		return nil, err
+	} else if n.body, _, err = p.parseNodes("endwith"); err != nil {
+		return nil, err
+	}
+	return n, p.parseEnd("")
+}
+
+// parseTuple parses an expression, or a list of expressions separated by
+// commas (which is returned as a *jinjaList*).
+func (p *jinjaParser) parseTuple(withCond bool) (jinjaExpr, error) {
+	expr, err := p.parseExpr(withCond)
+	if err != nil || !p.isOp(",") {
+		return expr, err
+	}
+
+	tuple := &jinjaList{items: []jinjaExpr{expr}}
+	for p.isOp(",") {
+		p.next()
+		if p.peek().kind == jinjaTokBlockEnd || p.peek().kind == jinjaTokVarEnd {
+			break
+		}
+		if expr, err = p.parseExpr(withCond); err != nil {
+			return nil, err
+		}
+		tuple.items = append(tuple.items, expr)
+	}
+	return tuple, nil
+}
+
+// parseExpr parses an expression, `withCond` is false if `a if b else c`
+// shouldn't be parsed (e.g. in {% for x in y if z %}).
+func (p *jinjaParser) parseExpr(withCond bool) (jinjaExpr, error) {
+	expr, err := p.parseOr()
+	if err != nil || !withCond {
+		return expr, err
+	}
+
+	for p.isName("if") {
+		p.next()
+		cond := &jinjaCond{a: expr}
+		if cond.test, err = p.parseOr(); err != nil {
+			return nil, err
+		}
+		if p.isName("else") {
+			p.next()
+			if cond.b, err = p.parseExpr(true); err != nil {
+				return nil, err
+			}
+		}
+		expr = cond
+	}
+	return expr, nil
+}
+
+func (p *jinjaParser) parseOr() (jinjaExpr, error) {
+	expr, err := p.parseAnd()
+	for err == nil && p.isName("or") {
+		p.next()
+		var r jinjaExpr
+		if r, err = p.parseAnd(); err == nil {
+			expr = &jinjaBinary{op: "or", l: expr, r: r}
+		}
+	}
+	return expr, err
+}
+
+func (p *jinjaParser) parseAnd() (jinjaExpr, error) {
+	expr, err := p.parseNot()
+	for err == nil && p.isName("and") {
+		p.next()
+		var r jinjaExpr
+		if r, err = p.parseNot(); err == nil {
+			expr = &jinjaBinary{op: "and", l: expr, r: r}
+		}
+	}
+	return expr, err
+}
+
+func (p *jinjaParser) parseNot() (jinjaExpr, error) {
+	if p.isName("not") {
+		p.next()
+		x, err := p.parseNot()
+		return &jinjaUnary{op: "not", x: x}, err
+	}
+	return p.parseCompare()
+}
+
+func (p *jinjaParser) parseCompare() (jinjaExpr, error) {
+	x, err := p.parseMath1()
+	if err != nil {
+		return nil, err
+	}
+
+	cmp := &jinjaCompare{x: x}
+	for {
+		var op string
+		if p.isOp("==", "!=", "<", ">", "<=", ">=") {
+			op = p.next().val
+		} else if p.isName("in") {
+			op = p.next().val
+		} else if p.isName("not") && p.pos+1 < len(p.tokens) &&
+			p.tokens[p.pos+1].kind == jinjaTokName && p.tokens[p.pos+1].val == "in" {
+			p.pos += 2
+			op = "not in"
+		} else {
+			break
+		}
+		y, err := p.parseMath1()
+		if err != nil {
+			return nil, err
+		}
+		cmp.ops, cmp.ys = append(cmp.ops, op), append(cmp.ys, y)
+	}
+
+	if len(cmp.ops) == 0 {
+		return x, nil
+	}
+	return cmp, nil
+}
+
+func (p *jinjaParser) parseBinary(next func() (jinjaExpr, error), ops ...string) (jinjaExpr, error) {
+	expr, err := next()
+	for err == nil && p.isOp(ops...) {
+		op := p.next().val
+		var r jinjaExpr
+		if r, err = next(); err == nil {
+			expr = &jinjaBinary{op: op, l: expr, r: r}
+		}
+	}
+	return expr, err
+}
+
+func (p *jinjaParser) parseMath1() (jinjaExpr, error) {
+	return p.parseBinary(p.parseConcat, "+", "-")
+}
+
+func (p *jinjaParser) parseConcat() (jinjaExpr, error) {
+	return p.parseBinary(p.parseMath2, "~")
+}
+
+func (p *jinjaParser) parseMath2() (jinjaExpr, error) {
+	return p.parseBinary(p.parsePow, "*", "/", "//", "%")
+}
+
+func (p *jinjaParser) parsePow() (jinjaExpr, error) {
+	return p.parseBinary(func() (jinjaExpr, error) { return p.parseUnary(true) }, "**")
+}
+
+func (p *jinjaParser) parseUnary(withFilter bool) (expr jinjaExpr, err error) {
+	if p.isOp("-", "+") {
+		op := p.next().val
+		var x jinjaExpr
+		if x, err = p.parseUnary(false); err != nil {
+			return nil, err
+		}
+		expr = &jinjaUnary{op: op, x: x}
+	} else if expr, err = p.parsePrimary(); err != nil {
+		return nil, err
+	} else if expr, err = p.parsePostfix(expr); err != nil {
+		return nil, err
+	}
+
+	if withFilter {
+		expr, err = p.parseFilterExpr(expr)
+	}
+	return
+}
+
+func (p *jinjaParser) parsePrimary() (jinjaExpr, error) {
+	tok := p.peek()
+	switch tok.kind {
+	case jinjaTokName:
+		p.next()
+		switch tok.val {
+		case "true", "True":
+			return &jinjaConst{true}, nil
+		case "false", "False":
+			return &jinjaConst{false}, nil
+		case "none", "None":
+			return &jinjaConst{nil}, nil
+		}
+		return &jinjaName{tok.val}, nil
+	case jinjaTokString:
+		s := p.next().val
+		for p.peek().kind == jinjaTokString {
+			s += p.next().val
+		}
+		return &jinjaConst{s}, nil
+	case jinjaTokInt:
+		p.next()
+		i, err := strconv.Atoi(tok.val)
+		if err != nil {
+			return nil, p.errorf("invalid integer '%s'", tok.val)
+		}
+		return &jinjaConst{i}, nil
+	case jinjaTokFloat:
+		p.next()
+		f, err := strconv.ParseFloat(tok.val, 64)
+		if err != nil {
+			return nil, p.errorf("invalid float '%s'", tok.val)
+		}
+		return &jinjaConst{f}, nil
+	case jinjaTokOp:
+		switch tok.val {
+		case "(":
+			p.next()
+			if p.isOp(")") {
+				p.next()
+				return &jinjaList{}, nil
+			}
+			expr, err := p.parseTuple(true)
+			if err != nil {
+				return nil, err
+			}
+			return expr, p.expectOp(")")
+		case "[":
+			p.next()
+			list := &jinjaList{}
+			for !p.isOp("]") {
+				item, err := p.parseExpr(true)
+				if err != nil {
+					return nil, err
+				}
+				list.items = append(list.items, item)
+				if !p.isOp(",") {
+					break
+				}
+				p.next()
+			}
+			return list, p.expectOp("]")
+		case "{":
+			p.next()
+			dict := &jinjaDict{}
+			for !p.isOp("}") {
+				key, err := p.parseExpr(true)
+				if err != nil {
+					return nil, err
+				} else if err = p.expectOp(":"); err != nil {
+					return nil, err
+				}
+				val, err := p.parseExpr(true)
+				if err != nil {
+					return nil, err
+				}
+				dict.keys, dict.vals = append(dict.keys, key), append(dict.vals, val)
+				if !p.isOp(",") {
+					break
+				}
+				p.next()
+			}
+			return dict, p.expectOp("}")
+		}
+	}
+	return nil, p.errorf("unexpected %s", p.describe())
+}
+
+func (p *jinjaParser) parsePostfix(expr jinjaExpr) (jinjaExpr, error) {
+	for {
+		switch {
+		case p.isOp("."):
+			p.next()
+			tok := p.next()
+			switch tok.kind {
+			case jinjaTokName:
+				expr = &jinjaGetAttr{obj: expr, name: tok.val}
+			case jinjaTokInt:
+				i, _ := strconv.Atoi(tok.val)
+				expr = &jinjaGetItem{obj: expr, key: &jinjaConst{i}}
+			default:
+				p.pos--
+				return nil, p.errorf("expected a name after '.', found %s", p.describe())
+			}
+		case p.isOp("["):
+			p.next()
+			var err error
+			if expr, err = p.parseSubscript(expr); err != nil {
+				return nil, err
+			} else if err = p.expectOp("]"); err != nil {
+				return nil, err
+			}
+		case p.isOp("("):
+			call := &jinjaCall{fn: expr}
+			if err := p.parseArgs(&call.args, &call.kwargs); err != nil {
+				return nil, err
+			}
+			expr = call
+		default:
+			return expr, nil
+		}
+	}
+}
+
+// parseSubscript parses the contents of `[...]`
+func (p *jinjaParser) parseSubscript(obj jinjaExpr) (jinjaExpr, error) {
+	var parts [3]jinjaExpr
+	i := 0
+	for {
+		if !p.isOp(":", "]") {
+			expr, err := p.parseExpr(true)
+			if err != nil {
+				return nil, err
+			}
+			parts[i] = expr
+		}
+		if !p.isOp(":") || i == 2 {
+			break
+		}
+		p.next()
+		i++
+	}
+
+	if i == 0 {
+		if parts[0] == nil {
+			return nil, p.errorf("expected a subscript, found %s", p.describe())
+		}
+		return &jinjaGetItem{obj: obj, key: parts[0]}, nil
+	}
+	return &jinjaSlice{obj: obj, start: parts[0], stop: parts[1], step: parts[2]}, nil
+}
+
+// parseArgs parses "(arg, key=arg)"
+func (p *jinjaParser) parseArgs(args *[]jinjaExpr, kwargs *map[string]jinjaExpr) error {
+	if err := p.expectOp("("); err != nil {
+		return err
+	}
+	for !p.isOp(")") {
+		if tok := p.peek(); tok.kind == jinjaTokName && p.pos+1 < len(p.tokens) &&
+			p.tokens[p.pos+1].kind == jinjaTokOp && p.tokens[p.pos+1].val == "=" {
+			p.pos += 2
+			val, err := p.parseExpr(true)
+			if err != nil {
+				return err
+			}
+			if *kwargs == nil {
+				*kwargs = make(map[string]jinjaExpr)
+			}
+			(*kwargs)[tok.val] = val
+		} else if len(*kwargs) > 0 {
+			return p.errorf("positional argument follows keyword argument")
+		} else {
+			arg, err := p.parseExpr(true)
+			if err != nil {
+				return err
+			}
+			*args = append(*args, arg)
+		}
+		if !p.isOp(",") {
+			break
+		}
+		p.next()
+	}
+	return p.expectOp(")")
+}
+
+func (p *jinjaParser) parseFilter(x jinjaExpr) (*jinjaFilterExpr, error) {
+	f := &jinjaFilterExpr{x: x}
+	var err error
+	if f.name, err = p.ident(); err != nil {
+		return nil, err
+	}
+	for p.isOp(".") { // filters can have dotted names
+		p.next()
+		part, err := p.ident()
+		if err != nil {
+			return nil, err
+		}
+		f.name += "." + part
+	}
+	if _, ok := getJinjaFilter(f.name); !ok {
+		return nil, p.errorf("no filter named '%s'", f.name)
+	}
+	if p.isOp("(") {
+		err = p.parseArgs(&f.args, &f.kwargs)
+	}
+	return f, err
+}
+
+func (p *jinjaParser) parseFilterExpr(expr jinjaExpr) (jinjaExpr, error) {
+	for {
+		switch {
+		case p.isOp("|"):
+			p.next()
+			f, err := p.parseFilter(expr)
+			if err != nil {
+				return nil, err
+			}
+			expr = f
+		case p.isName("is"):
+			p.next()
+			t := &jinjaTestExpr{x: expr}
+			if p.isName("not") {
+				p.next()
+				t.negate = true
+			}
+			var err error
+			if t.name, err = p.ident(); err != nil {
+				return nil, err
+			}
+			if _, ok := jinjaTests[t.name]; !ok {
+				return nil, p.errorf("no test named '%s'", t.name)
+			}
+			if p.isOp("(") {
+				var kwargs map[string]jinjaExpr
+				if err = p.parseArgs(&t.args, &kwargs); err != nil {
+					return nil, err
+				}
+			} else if tok := p.peek(); (tok.kind == jinjaTokName && !p.isName("else", "or", "and", "if", "is", "in", "not")) ||
+				tok.kind == jinjaTokString || tok.kind == jinjaTokInt || tok.kind == jinjaTokFloat || p.isOp("[", "{") {
+				arg, err := p.parsePrimary()
+				if err != nil {
+					return nil, err
+				} else if arg, err = p.parsePostfix(arg); err != nil {
+					return nil, err
+				}
+				t.args = []jinjaExpr{arg}
+			}
+			expr = t
+		case p.isOp("("):
+			call := &jinjaCall{fn: expr}
+			if err := p.parseArgs(&call.args, &call.kwargs); err != nil {
+				return nil, err
+			}
+			expr = call
+		default:
+			return expr, nil
+		}
+	}
+}
+
+/* execution */
+
+type jinjaState struct {
+	partials map[string]*jinjaTemplate
+	depth    int
+	err      func() error // checked by loops, see `writerErr`
+}
+
+// jinjaRender is the state of rendering a template and it's parents
+type jinjaRender struct {
+	name   string                   // the name of the template being rendered (for errors)
+	blocks map[string][]*jinjaBlock // block overrides, from the child-most template
+	parent *jinjaTemplate           // set by {% extends %}
+}
+
+// jinjaFrame holds the variables set in a scope
+type jinjaFrame struct {
+	vars   map[string]interface{}
+	parent *jinjaFrame
+	data   interface{} // the root frame has the data the template is executed with
+}
+
+func (f *jinjaFrame) child() *jinjaFrame {
+	return &jinjaFrame{vars: make(map[string]interface{}), parent: f}
+}
+
+func (f *jinjaFrame) root() *jinjaFrame {
+	for f.parent != nil {
+		f = f.parent
+	}
+	return f
+}
+
+func (f *jinjaFrame) lookup(name string) interface{} {
+	for frame := f; frame != nil; frame = frame.parent {
+		if v, ok := frame.vars[name]; ok {
+			return v
+		}
+	}
+	if v, ok := jinjaField(f.root().data, name); ok {
+		return v
+	} else if fn, ok := jinjaGlobals[name]; ok {
+		return fn
+	}
+	return jinjaUndefined{name}
+}
+
+// jinjaError is an error that indicates where it occurred
+type jinjaError struct {
+	err error
+}
+
+func (err jinjaError) Error() string {
+	return err.err.Error()
+}
+
+func (r *jinjaRender) errorf(line int, err error) error {
+	if _, ok := err.(jinjaError); ok {
+		return err
+	}
+	return jinjaError{fmt.Errorf("%s:%d: %s", r.name, line, err)}
+}
+
+func (s *jinjaState) enter() error {
+	if s.depth++; s.depth > jinjaMaxDepth {
+		return fmt.Errorf("templates are nested more than %d levels deep", jinjaMaxDepth)
+	}
+	return nil
+}
+
+func (s *jinjaState) leave() {
+	s.depth--
+}
+
+func (s *jinjaState) template(name string) (*jinjaTemplate, error) {
+	if t, ok := s.partials[name]; ok {
+		return t, nil
+	}
+	return nil, fmt.Errorf("template '%s' not found", name)
+}
+
+// renderTemplate renders `t`, and any templates it extends.
+func (s *jinjaState) renderTemplate(w io.Writer, t *jinjaTemplate, f *jinjaFrame) error {
+	if err := s.enter(); err != nil {
+		return err
+	}
+	defer s.leave()
+
+	r := &jinjaRender{name: t.name, blocks: make(map[string][]*jinjaBlock)}
+	for depth := 0; t != nil; depth++ {
+		if depth > jinjaMaxDepth {
+			return fmt.Errorf("templates are nested more than %d levels deep", jinjaMaxDepth)
+		}
+		for name, block := range t.blocks {
+			r.blocks[name] = append(r.blocks[name], block)
+		}
+		r.name = t.name
+		if err := s.render(w, t.nodes, f, r); err != nil {
+			return err
+		}
+		t, r.parent = r.parent, nil
+	}
+	return nil
+}
+
+func (s *jinjaState) render(w io.Writer, nodes []jinjaNode, f *jinjaFrame, r *jinjaRender) error {
+	for _, node := range nodes {
+		if r.parent != nil { // output after {% extends %} is ignored
+			w = ioutil.Discard
+			if _, ok := node.(*jinjaBlock); ok {
+				continue
+			}
+		}
+
+		var err error
+		var line int
+		switch n := node.(type) {
+		case string:
+			_, err = io.WriteString(w, n)
+		case *jinjaOutput:
+			line = n.line
+			var v interface{}
+			if v, err = s.eval(n.expr, f, r); err == nil {
+				_, err = io.WriteString(w, jinjaString(v))
+			}
+		case *jinjaIf:
+			line = n.line
+			err = s.renderIf(w, n, f, r)
+		case *jinjaFor:
+			line = n.line
+			err = s.renderFor(w, n, f, r)
+		case *jinjaSet:
+			line = n.line
+			err = s.renderSet(n, f, r)
+		case *jinjaBlock:
+			line = n.line
+			err = s.renderBlock(w, n.name, 0, f, r)
+		case *jinjaExtends:
+			line = n.line
+			if r.parent != nil {
+				err = fmt.Errorf("template extends more than one template")
+				break
+			}
+			var v interface{}
+			if v, err = s.eval(n.expr, f, r); err == nil {
+				r.parent, err = s.template(jinjaString(v))
+			}
+		case *jinjaInclude:
+			line = n.line
+			err = s.renderInclude(w, n, f, r)
+		case *jinjaImport:
+			line = n.line
+			err = s.renderImport(n, f, r)
+		case *jinjaMacro:
+			f.vars[n.name] = s.macro(n, f, r.name)
+		case *jinjaCallBlock:
+			line = n.line
+			caller := s.macro(n.macro, f, r.name)
+			var v interface{}
+			if v, err = s.call(n.call, f, r, caller); err == nil {
+				_, err = io.WriteString(w, jinjaString(v))
+			}
+		case *jinjaFilterBlock:
+			line = n.line
+			var b strings.Builder
+			if err = s.render(&b, n.body, f, r); err != nil {
+				return err
+			}
+			var v interface{} = b.String()
+			for _, filter := range n.filters {
+				if v, err = s.filter(filter, v, f, r); err != nil {
+					break
+				}
+			}
+			if err == nil {
+				_, err = io.WriteString(w, jinjaString(v))
+			}
+		case *jinjaWith:
+			line = n.line
+			scope := f.child()
+			for i, target := range n.targets {
+				if scope.vars[target], err = s.eval(n.exprs[i], scope, r); err != nil {
+					break
+				}
+			}
+			if err == nil {
+				err = s.render(w, n.body, scope, r)
+			}
+		}
+
+		if err != nil {
+			return r.errorf(line, err)
+		}
+	}
+	return nil
+}
+
+func (s *jinjaState) renderIf(w io.Writer, n *jinjaIf, f *jinjaFrame, r *jinjaRender) error {
+	for i, cond := range n.conds {
+		v, err := s.eval(cond, f, r)
+		if err != nil {
+			return err
+		} else if jinjaTruthy(v) {
+			return s.render(w, n.bodies[i], f, r)
+		}
+	}
+	return s.render(w, n.els, f, r)
+}
+
+func (s *jinjaState) renderFor(w io.Writer, n *jinjaFor, f *jinjaFrame, r *jinjaRender) error {
+	v, err := s.eval(n.iter, f, r)
+	if err != nil {
+		return err
+	}
+	items, err := jinjaIter(v)
+	if err != nil {
+		return err
+	}
+
+	if n.cond != nil {
+		var filtered []interface{}
+		for _, item := range items {
+			scope := f.child()
+			if err = s.err(); err != nil {
+				return err
+			} else if err = jinjaAssign(scope, n.targets, item); err != nil {
+				return err
+			} else if v, err = s.eval(n.cond, scope, r); err != nil {
+				return err
+			} else if jinjaTruthy(v) {
+				filtered = append(filtered, item)
+			}
+		}
+		items = filtered
+	}
+
+	if len(items) == 0 {
+		return s.render(w, n.els, f, r)
+	}
+
+	loop := &jinjaLoop{items: items}
+	if parent, ok := f.lookup("loop").(*jinjaLoop); ok {
+		loop.depth0 = parent.depth0 + 1
+	}
+	for i, item := range items {
+		scope := f.child()
+		loop.index0 = i
+		scope.vars["loop"] = loop
+		if err = s.err(); err != nil {
+			return err
+		} else if err = jinjaAssign(scope, n.targets, item); err != nil {
+			return err
+		} else if err = s.render(w, n.body, scope, r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// jinjaAssign sets `targets` in `f` to `v`, if there's more than one target
+// `v` is unpacked.
+func jinjaAssign(f *jinjaFrame, targets []string, v interface{}) error {
+	if len(targets) == 1 {
+		f.vars[targets[0]] = v
+		return nil
+	}
+	items, err := jinjaIter(v)
+	if err != nil {
+		return err
+	} else if len(items) != len(targets) {
+		return fmt.Errorf("can't unpack %d values into %d names", len(items), len(targets))
+	}
+	for i, target := range targets {
+		f.vars[target] = items[i]
+	}
+	return nil
+}
+
+func (s *jinjaState) renderSet(n *jinjaSet, f *jinjaFrame, r *jinjaRender) error {
+	var v interface{}
+	var err error
+	if n.body != nil || n.expr == nil {
+		var b strings.Builder
+		err = s.render(&b, n.body, f, r)
+		v = b.String()
+	} else {
+		v, err = s.eval(n.expr, f, r)
+	}
+	if err != nil {
+		return err
+	}
+
+	if len(n.attr) > 0 {
+		ns, ok := f.lookup(n.targets[0]).(jinjaNamespace)
+		if !ok {
+			return fmt.Errorf("can't set attribute '%s' on '%s', it isn't a namespace", n.attr, n.targets[0])
+		}
+		ns[n.attr] = v
+		return nil
+	}
+	return jinjaAssign(f, n.targets, v)
+}
+
+// renderBlock renders the override of block `name` at `level` in the block
+// chain, `super()` renders the next level.
+func (s *jinjaState) renderBlock(w io.Writer, name string, level int, f *jinjaFrame, r *jinjaRender) error {
+	chain := r.blocks[name]
+	if level >= len(chain) {
+		return fmt.Errorf("block '%s' has no parent block", name)
+	}
+	block := chain[level]
+
+	scope := f.child()
+	scope.vars["super"] = jinjaFunc(func(args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+		var b strings.Builder
+		err := s.renderBlock(&b, name, level+1, f, r)
+		return jinjaMarkup(b.String()), err
+	})
+
+	prev := r.name
+	r.name = block.tmpl
+	defer func() { r.name = prev }()
+	return s.render(w, block.body, scope, r)
+}
+
+// self returns the `self` variable, which has a function to render each
+// block in the template being rendered (e.g. `self.title()`).
+func (s *jinjaState) self(f *jinjaFrame, r *jinjaRender) jinjaNamespace {
+	self := make(jinjaNamespace)
+	for name := range r.blocks {
+		name := name
+		self[name] = jinjaFunc(func(args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+			var b strings.Builder
+			err := s.renderBlock(&b, name, 0, f, r)
+			return jinjaMarkup(b.String()), err
+		})
+	}
+	return self
+}
+
+// templateNames returns the template names `v` evaluates to (a name or a
+// list of names).
+func templateNames(v interface{}) []string {
+	if name, ok := v.(string); ok {
+		return []string{name}
+	}
+	var names []string
+	if items, err := jinjaIter(v); err == nil {
+		for _, item := range items {
+			names = append(names, jinjaString(item))
+		}
+	}
+	return names
+}
+
+func (s *jinjaState) renderInclude(w io.Writer, n *jinjaInclude, f *jinjaFrame, r *jinjaRender) error {
+	v, err := s.eval(n.expr, f, r)
+	if err != nil {
+		return err
+	}
+
+	names := templateNames(v)
+	for _, name := range names {
+		t, ok := s.partials[name]
+		if !ok {
+			continue
+		}
+		scope := f.child()
+		if n.noContext {
+			scope = &jinjaFrame{vars: make(map[string]interface{})}
+		}
+		return s.renderTemplate(w, t, scope)
+	}
+
+	if n.ignoreMissing {
+		return nil
+	}
+	return fmt.Errorf("template '%s' not found", strings.Join(names, "', '"))
+}
+
+func (s *jinjaState) renderImport(n *jinjaImport, f *jinjaFrame, r *jinjaRender) error {
+	v, err := s.eval(n.expr, f, r)
+	if err != nil {
+		return err
+	}
+	t, err := s.template(jinjaString(v))
+	if err != nil {
+		return err
+	}
+
+	scope := &jinjaFrame{vars: make(map[string]interface{})}
+	if n.withContext {
+		scope = f.child()
+	}
+	if err = s.renderTemplate(ioutil.Discard, t, scope); err != nil {
+		return err
+	}
+
+	if len(n.as) > 0 {
+		f.vars[n.as] = jinjaNamespace(scope.vars)
+		return nil
+	}
+	for _, name := range n.names {
+		v, ok := scope.vars[name[0]]
+		if !ok {
+			return fmt.Errorf("'%s' does not export '%s'", t.name, name[0])
+		}
+		f.vars[name[1]] = v
+	}
+	return nil
+}
+
+// macro returns `m` as a function that can be called. Macros can access the
+// variables set where they're defined.
+func (s *jinjaState) macro(m *jinjaMacro, defined *jinjaFrame, tmpl string) jinjaFunc {
+	return func(args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+		if err := s.enter(); err != nil {
+			return nil, err
+		}
+		defer s.leave()
+
+		r := &jinjaRender{name: tmpl}
+		scope := defined.child()
+		rest := make(map[string]interface{})
+		for key, val := range kwargs {
+			rest[key] = val
+		}
+
+		for i, param := range m.params {
+			if i < len(args) {
+				scope.vars[param] = args[i]
+			} else if v, ok := rest[param]; ok {
+				scope.vars[param] = v
+			} else if m.defaults[i] != nil {
+				v, err := s.eval(m.defaults[i], scope, r)
+				if err != nil {
+					return nil, err
+				}
+				scope.vars[param] = v
+			} else {
+				scope.vars[param] = jinjaUndefined{param}
+			}
+			delete(rest, param)
+		}
+
+		varargs := make([]interface{}, 0)
+		if len(args) > len(m.params) {
+			varargs = append(varargs, args[len(m.params):]...)
+		}
+		scope.vars["varargs"] = varargs
+		if caller, ok := rest["caller"]; ok {
+			scope.vars["caller"] = caller
+			delete(rest, "caller")
+		}
+		scope.vars["kwargs"] = rest
+
+		var b strings.Builder
+		err := s.render(&b, m.body, scope, r)
+		return jinjaMarkup(b.String()), err
+	}
+}
+
+func (s *jinjaState) eval(e jinjaExpr, f *jinjaFrame, r *jinjaRender) (interface{}, error) {
+	switch x := e.(type) {
+	case *jinjaConst:
+		return x.v, nil
+	case *jinjaName:
+		v := f.lookup(x.name)
+		if _, ok := v.(jinjaUndefined); ok && x.name == "self" {
+			v = s.self(f, r)
+		}
+		return v, nil
+	case *jinjaGetAttr:
+		obj, err := s.eval(x.obj, f, r)
+		if err != nil {
+			return nil, err
+		}
+		return jinjaAttr(obj, x.name), nil
+	case *jinjaGetItem:
+		obj, err := s.eval(x.obj, f, r)
+		if err != nil {
+			return nil, err
+		}
+		key, err := s.eval(x.key, f, r)
+		if err != nil {
+			return nil, err
+		}
+		return jinjaItem(obj, key), nil
+	case *jinjaSlice:
+		return s.evalSlice(x, f, r)
+	case *jinjaBinary:
+		return s.evalBinary(x, f, r)
+	case *jinjaUnary:
+		v, err := s.eval(x.x, f, r)
+		if err != nil {
+			return nil, err
+		}
+		switch x.op {
+		case "not":
+			return !jinjaTruthy(v), nil
+		case "-":
+			return jinjaMath("-", 0, v)
+		}
+		return jinjaMath("+", 0, v)
+	case *jinjaCompare:
+		l, err := s.eval(x.x, f, r)
+		if err != nil {
+			return nil, err
+		}
+		for i, op := range x.ops {
+			rv, err := s.eval(x.ys[i], f, r)
+			if err != nil {
+				return nil, err
+			}
+			if ok, err := jinjaCompareOp(op, l, rv); err != nil || !ok {
+				return false, err
+			}
+			l = rv
+		}
+		return true, nil
+	case *jinjaCond:
+		test, err := s.eval(x.test, f, r)
+		if err != nil {
+			return nil, err
+		} else if jinjaTruthy(test) {
+			return s.eval(x.a, f, r)
+		} else if x.b == nil {
+			return jinjaUndefined{}, nil
+		}
+		return s.eval(x.b, f, r)
+	case *jinjaFilterExpr:
+		v, err := s.eval(x.x, f, r)
+		if err != nil {
+			return nil, err
+		}
+		return s.filter(x, v, f, r)
+	case *jinjaTestExpr:
+		v, err := s.eval(x.x, f, r)
+		if err != nil {
+			return nil, err
+		}
+		args, err := s.evalList(x.args, f, r)
+		if err != nil {
+			return nil, err
+		}
+		ok, err := jinjaTests[x.name](v, args)
+		return ok != x.negate, err
+	case *jinjaCall:
+		return s.call(x, f, r, nil)
+	case *jinjaList:
+		return s.evalList(x.items, f, r)
+	case *jinjaDict:
+		m := make(map[string]interface{}, len(x.keys))
+		for i, key := range x.keys {
+			k, err := s.eval(key, f, r)
+			if err != nil {
+				return nil, err
+			}
+			if m[jinjaString(k)], err = s.eval(x.vals[i], f, r); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	}
+	return nil, fmt.Errorf("invalid expression %T", e)
+}
+
+func (s *jinjaState) evalList(exprs []jinjaExpr, f *jinjaFrame, r *jinjaRender) ([]interface{}, error) {
+	list := make([]interface{}, len(exprs))
+	for i, expr := range exprs {
+		v, err := s.eval(expr, f, r)
+		if err != nil {
+			return nil, err
+		}
+		list[i] = v
+	}
+	return list, nil
+}
+
+func (s *jinjaState) evalKwargs(exprs map[string]jinjaExpr, f *jinjaFrame, r *jinjaRender) (map[string]interface{}, error) {
+	kwargs := make(map[string]interface{}, len(exprs))
+	for key, expr := range exprs {
+		v, err := s.eval(expr, f, r)
+		if err != nil {
+			return nil, err
+		}
+		kwargs[key] = v
+	}
+	return kwargs, nil
+}
+
+func (s *jinjaState) evalBinary(x *jinjaBinary, f *jinjaFrame, r *jinjaRender) (interface{}, error) {
+	l, err := s.eval(x.l, f, r)
+	if err != nil {
+		return nil, err
+	}
+	switch x.op {
+	case "and":
+		if !jinjaTruthy(l) {
+			return l, nil
+		}
+		return s.eval(x.r, f, r)
+	case "or":
+		if jinjaTruthy(l) {
+			return l, nil
+		}
+		return s.eval(x.r, f, r)
+	}
+
+	rv, err := s.eval(x.r, f, r)
+	if err != nil {
+		return nil, err
+	}
+	if x.op == "~" {
+		return jinjaString(l) + jinjaString(rv), nil
+	}
+	return jinjaMath(x.op, l, rv)
+}
+
+func (s *jinjaState) evalSlice(x *jinjaSlice, f *jinjaFrame, r *jinjaRender) (interface{}, error) {
+	obj, err := s.eval(x.obj, f, r)
+	if err != nil {
+		return nil, err
+	}
+	var bounds [3]*int
+	for i, expr := range []jinjaExpr{x.start, x.stop, x.step} {
+		if expr == nil {
+			continue
+		}
+		v, err := s.eval(expr, f, r)
+		if err != nil {
+			return nil, err
+		} else if _, ok := v.(jinjaUndefined); ok || v == nil {
+			continue
+		}
+		n, ok := jinjaInt(v)
+		if !ok {
+			return nil, fmt.Errorf("slice indices must be integers, not %s", jinjaTypeName(v))
+		}
+		bounds[i] = &n
+	}
+	return jinjaSliceValue(obj, bounds[0], bounds[1], bounds[2])
+}
+
+func (s *jinjaState) filter(x *jinjaFilterExpr, v interface{}, f *jinjaFrame, r *jinjaRender) (interface{}, error) {
+	filter, ok := getJinjaFilter(x.name)
+	if !ok {
+		return nil, fmt.Errorf("no filter named '%s'", x.name)
+	}
+	args, err := s.evalList(x.args, f, r)
+	if err != nil {
+		return nil, err
+	}
+	kwargs, err := s.evalKwargs(x.kwargs, f, r)
+	if err != nil {
+		return nil, err
+	}
+	return filter(v, args, kwargs)
+}
+
+// call calls `c`, `caller` is set if it's called by {% call %}
+func (s *jinjaState) call(c *jinjaCall, f *jinjaFrame, r *jinjaRender, caller jinjaFunc) (interface{}, error) {
+	fn, err := s.eval(c.fn, f, r)
+	if err != nil {
+		return nil, err
+	}
+	args, err := s.evalList(c.args, f, r)
+	if err != nil {
+		return nil, err
+	}
+	kwargs, err := s.evalKwargs(c.kwargs, f, r)
+	if err != nil {
+		return nil, err
+	}
+	if caller != nil {
+		kwargs["caller"] = caller
+	}
+
+	switch callable := fn.(type) {
+	case jinjaFunc:
+		return callable(args, kwargs)
+	case jinjaUndefined:
+		return nil, fmt.Errorf("'%s' is undefined", callable.name)
+	}
+	return nil, fmt.Errorf("%s is not callable", jinjaTypeName(fn))
+}
+
+// jinjaSortedKeys returns the keys of `m` in order
+func jinjaSortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
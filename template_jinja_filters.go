@@ -0,0 +1,1799 @@
+package dati
+
+/*
+Copyright (C) 2023 gearsix <gearsix@tuta.io>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"math"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+func init() {
+	for name, filter := range map[string]JinjaFilter{
+		"abs":         jinjaAbs,
+		"attr":        jinjaAttrFilter,
+		"batch":       jinjaBatch,
+		"capitalize":  jinjaStringFilter(jinjaCapitalize),
+		"center":      jinjaCenter,
+		"default":     jinjaDefault,
+		"d":           jinjaDefault,
+		"dictsort":    jinjaDictsort,
+		"escape":      jinjaEscapeFilter,
+		"e":           jinjaEscapeFilter,
+		"forceescape": jinjaForceEscape,
+		"first":       jinjaFirst,
+		"float":       jinjaFloatFilter,
+		"format":      jinjaFormatFilter,
+		"groupby":     jinjaGroupby,
+		"indent":      jinjaIndent,
+		"int":         jinjaIntFilter,
+		"items":       jinjaItemsFilter,
+		"join":        jinjaJoin,
+		"last":        jinjaLast,
+		"length":      jinjaLength,
+		"count":       jinjaLength,
+		"list":        jinjaListFilter,
+		"lower":       jinjaStringFilter(strings.ToLower),
+		"map":         jinjaMap,
+		"max":         jinjaMinMax(1),
+		"min":         jinjaMinMax(-1),
+		"pprint":      jinjaPprint,
+		"reject":      jinjaSelect(false, false),
+		"rejectattr":  jinjaSelect(false, true),
+		"replace":     jinjaReplace,
+		"reverse":     jinjaReverse,
+		"round":       jinjaRound,
+		"safe":        jinjaSafe,
+		"select":      jinjaSelect(true, false),
+		"selectattr":  jinjaSelect(true, true),
+		"sort":        jinjaSort,
+		"string":      jinjaStringFilter(func(s string) string { return s }),
+		"striptags":   jinjaStringFilter(jinjaStriptags),
+		"sum":         jinjaSum,
+		"title":       jinjaStringFilter(jinjaTitle),
+		"tojson":      jinjaTojson,
+		"trim":        jinjaTrim,
+		"truncate":    jinjaTruncate,
+		"unique":      jinjaUnique,
+		"upper":       jinjaStringFilter(strings.ToUpper),
+		"urlencode":   jinjaUrlencode,
+		"wordcount":   jinjaWordcount,
+	} {
+		RegisterJinjaFilter(name, filter)
+	}
+}
+
+// JinjaFilter is the function signature of a jinja filter, see
+// `RegisterJinjaFilter`. `value` is the value being filtered, `args` and
+// `kwargs` are the positional and keyword arguments the filter was called
+// with (e.g. `{{ value|filter(1, key=2) }}`).
+type JinjaFilter func(value interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error)
+
+var (
+	jinjaFiltersMu sync.RWMutex
+	jinjaFilters   = make(map[string]JinjaFilter)
+)
+
+// RegisterJinjaFilter makes `filter` available to all jinja templates as
+// `name`. Filters are checked when a template is parsed, so they should be
+// registered before loading any templates that use them. If `name` is
+// already registered, it will be replaced (including the built-in filters).
+// If `filter` is nil, `name` is removed.
+func RegisterJinjaFilter(name string, filter JinjaFilter) {
+	jinjaFiltersMu.Lock()
+	defer jinjaFiltersMu.Unlock()
+	if filter == nil {
+		delete(jinjaFilters, name)
+	} else {
+		jinjaFilters[name] = filter
+	}
+}
+
+func getJinjaFilter(name string) (filter JinjaFilter, ok bool) {
+	jinjaFiltersMu.RLock()
+	defer jinjaFiltersMu.RUnlock()
+	filter, ok = jinjaFilters[name]
+	return
+}
+
+/* values */
+
+// jinjaUndefined is the value of a variable that isn't set
+type jinjaUndefined struct {
+	name string
+}
+
+// jinjaMarkup is a string that has already been escaped
+type jinjaMarkup string
+
+// jinjaFunc is a value that can be called (e.g. a macro)
+type jinjaFunc func(args []interface{}, kwargs map[string]interface{}) (interface{}, error)
+
+// jinjaNamespace is the result of `namespace()` or {% import %}
+type jinjaNamespace map[string]interface{}
+
+// jinjaGroup is an item in the result of the groupby filter
+type jinjaGroup []interface{}
+
+// jinjaLoop is the `loop` variable in a {% for %} loop
+type jinjaLoop struct {
+	items  []interface{}
+	index0 int
+	depth0 int
+}
+
+func (l *jinjaLoop) attr(name string) interface{} {
+	switch name {
+	case "index":
+		return l.index0 + 1
+	case "index0":
+		return l.index0
+	case "revindex":
+		return len(l.items) - l.index0
+	case "revindex0":
+		return len(l.items) - l.index0 - 1
+	case "first":
+		return l.index0 == 0
+	case "last":
+		return l.index0 == len(l.items)-1
+	case "length":
+		return len(l.items)
+	case "depth":
+		return l.depth0 + 1
+	case "depth0":
+		return l.depth0
+	case "previtem":
+		if l.index0 > 0 {
+			return l.items[l.index0-1]
+		}
+	case "nextitem":
+		if l.index0 < len(l.items)-1 {
+			return l.items[l.index0+1]
+		}
+	case "cycle":
+		index := l.index0
+		return jinjaFunc(func(args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+			if len(args) == 0 {
+				return nil, fmt.Errorf("no items for cycling given")
+			}
+			return args[index%len(args)], nil
+		})
+	}
+	return jinjaUndefined{name}
+}
+
+var jinjaGlobals = map[string]jinjaFunc{
+	"range": func(args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+		var n [3]int
+		n[2] = 1
+		for i, arg := range args {
+			v, ok := jinjaInt(arg)
+			if !ok || i > 2 {
+				return nil, fmt.Errorf("invalid argument to range(): %s", jinjaRepr(arg))
+			}
+			n[i] = v
+		}
+		start, stop, step := 0, n[0], n[2]
+		if len(args) > 1 {
+			start, stop = n[0], n[1]
+		}
+		if step == 0 {
+			return nil, fmt.Errorf("range() step must not be zero")
+		} else if (float64(stop)-float64(start))/float64(step) > jinjaMaxRange {
+			return nil, fmt.Errorf("range() is too big, the maximum is %d items", jinjaMaxRange)
+		}
+		list := make([]interface{}, 0)
+		for i := start; (step > 0 && i < stop) || (step < 0 && i > stop); i += step {
+			list = append(list, i)
+		}
+		return list, nil
+	},
+	"dict": func(args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+		return kwargs, nil
+	},
+	"namespace": func(args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+		ns := make(jinjaNamespace)
+		for _, arg := range args {
+			m, _ := toStringMap(arg)
+			for key, val := range m {
+				ns[key] = val
+			}
+		}
+		for key, val := range kwargs {
+			ns[key] = val
+		}
+		return ns, nil
+	},
+}
+
+// jinjaField returns the value of the key (or exported struct field)
+// `name` in `v`.
+func jinjaField(v interface{}, name string) (interface{}, bool) {
+	if m, ok := v.(map[string]interface{}); ok {
+		val, ok := m[name]
+		return val, ok
+	}
+
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Interface {
+		if rv.IsNil() {
+			return nil, false
+		}
+		rv = rv.Elem()
+	}
+	switch rv.Kind() {
+	case reflect.Map:
+		if rv.Type().Key().Kind() == reflect.String {
+			if val := rv.MapIndex(reflect.ValueOf(name).Convert(rv.Type().Key())); val.IsValid() {
+				return val.Interface(), true
+			}
+			return nil, false
+		}
+		iter := rv.MapRange()
+		for iter.Next() {
+			if fmt.Sprint(iter.Key().Interface()) == name {
+				return iter.Value().Interface(), true
+			}
+		}
+	case reflect.Struct:
+		if f, ok := rv.Type().FieldByName(name); ok && f.PkgPath == "" {
+			return rv.FieldByIndex(f.Index).Interface(), true
+		}
+	}
+	return nil, false
+}
+
+// jinjaAttr returns the attribute `name` of `v` (`v.name`), which is a key,
+// struct field or method (e.g. `dict.items()`).
+func jinjaAttr(v interface{}, name string) interface{} {
+	switch val := v.(type) {
+	case jinjaUndefined:
+		return jinjaUndefined{name}
+	case *jinjaLoop:
+		return val.attr(name)
+	case jinjaGroup:
+		if name == "grouper" {
+			return val[0]
+		} else if name == "list" {
+			return val[1]
+		}
+	}
+
+	if field, ok := jinjaField(v, name); ok {
+		return field
+	} else if method := jinjaMethod(v, name); method != nil {
+		return method
+	}
+	return jinjaUndefined{name}
+}
+
+// jinjaItem returns `obj[key]`
+func jinjaItem(obj, key interface{}) interface{} {
+	if i, ok := jinjaInt(key); ok {
+		if _, isStr := key.(string); !isStr {
+			if list, ok := toList(obj); ok {
+				if i < 0 {
+					i += len(list)
+				}
+				if i >= 0 && i < len(list) {
+					return list[i]
+				}
+				return jinjaUndefined{}
+			} else if s, ok := jinjaStr(obj); ok {
+				runes := []rune(s)
+				if i < 0 {
+					i += len(runes)
+				}
+				if i >= 0 && i < len(runes) {
+					return string(runes[i])
+				}
+				return jinjaUndefined{}
+			}
+		}
+	}
+	if field, ok := jinjaField(obj, jinjaString(key)); ok {
+		return field
+	} else if name, ok := key.(string); ok {
+		return jinjaAttr(obj, name)
+	}
+	return jinjaUndefined{}
+}
+
+// jinjaAttrPath returns the value of the dotted path `path` in `v` (used by
+// filters with an "attribute" argument).
+func jinjaAttrPath(v interface{}, path string) interface{} {
+	for _, part := range strings.Split(path, ".") {
+		if i, err := strconv.Atoi(part); err == nil {
+			v = jinjaItem(v, i)
+		} else {
+			v = jinjaAttr(v, part)
+		}
+	}
+	return v
+}
+
+// jinjaMethod returns the python method `name` of `v`, if it's supported
+func jinjaMethod(v interface{}, name string) jinjaFunc {
+	if s, ok := jinjaStr(v); ok {
+		return jinjaStringMethod(s, name)
+	}
+	m, ok := toStringMap(v)
+	if !ok {
+		return nil
+	}
+
+	switch name {
+	case "items", "keys", "values":
+		return func(args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+			list := make([]interface{}, 0, len(m))
+			for _, key := range jinjaSortedKeys(m) {
+				switch name {
+				case "items":
+					list = append(list, []interface{}{key, m[key]})
+				case "keys":
+					list = append(list, key)
+				case "values":
+					list = append(list, m[key])
+				}
+			}
+			return list, nil
+		}
+	case "get":
+		return func(args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+			if len(args) == 0 {
+				return nil, fmt.Errorf("get() requires a key")
+			} else if val, ok := m[jinjaString(args[0])]; ok {
+				return val, nil
+			} else if len(args) > 1 {
+				return args[1], nil
+			}
+			return nil, nil
+		}
+	}
+	return nil
+}
+
+func jinjaStringMethod(s, name string) jinjaFunc {
+	str := func(fn func(args []interface{}) interface{}) jinjaFunc {
+		return func(args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+			return fn(args), nil
+		}
+	}
+	arg := func(args []interface{}, i int) string {
+		if i < len(args) {
+			return jinjaString(args[i])
+		}
+		return ""
+	}
+
+	switch name {
+	case "upper":
+		return str(func([]interface{}) interface{} { return strings.ToUpper(s) })
+	case "lower":
+		return str(func([]interface{}) interface{} { return strings.ToLower(s) })
+	case "title":
+		return str(func([]interface{}) interface{} { return jinjaTitle(s) })
+	case "capitalize":
+		return str(func([]interface{}) interface{} { return jinjaCapitalize(s) })
+	case "strip", "lstrip", "rstrip":
+		return str(func(args []interface{}) interface{} {
+			cutset := " \t\r\n\v\f"
+			if len(args) > 0 {
+				cutset = arg(args, 0)
+			}
+			switch name {
+			case "lstrip":
+				return strings.TrimLeft(s, cutset)
+			case "rstrip":
+				return strings.TrimRight(s, cutset)
+			}
+			return strings.Trim(s, cutset)
+		})
+	case "startswith":
+		return str(func(args []interface{}) interface{} { return strings.HasPrefix(s, arg(args, 0)) })
+	case "endswith":
+		return str(func(args []interface{}) interface{} { return strings.HasSuffix(s, arg(args, 0)) })
+	case "replace":
+		return str(func(args []interface{}) interface{} { return strings.Replace(s, arg(args, 0), arg(args, 1), -1) })
+	case "split":
+		return str(func(args []interface{}) interface{} {
+			var parts []string
+			if len(args) == 0 || args[0] == nil {
+				parts = strings.Fields(s)
+			} else {
+				parts = strings.Split(s, arg(args, 0))
+			}
+			list := make([]interface{}, len(parts))
+			for i, part := range parts {
+				list[i] = part
+			}
+			return list
+		})
+	case "join":
+		return func(args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+			if len(args) == 0 {
+				return nil, fmt.Errorf("join() requires an argument")
+			}
+			items, err := jinjaIter(args[0])
+			if err != nil {
+				return nil, err
+			}
+			parts := make([]string, len(items))
+			for i, item := range items {
+				parts[i] = jinjaString(item)
+			}
+			return strings.Join(parts, s), nil
+		}
+	}
+	return nil
+}
+
+// jinjaIter returns the items in `v`, maps return their keys (in order)
+func jinjaIter(v interface{}) ([]interface{}, error) {
+	if v == nil {
+		return nil, nil
+	} else if _, ok := v.(jinjaUndefined); ok {
+		return nil, nil
+	} else if s, ok := jinjaStr(v); ok {
+		var items []interface{}
+		for _, r := range s {
+			items = append(items, string(r))
+		}
+		return items, nil
+	} else if list, ok := toList(v); ok {
+		return list, nil
+	} else if m, ok := toStringMap(v); ok {
+		keys := jinjaSortedKeys(m)
+		items := make([]interface{}, len(keys))
+		for i, key := range keys {
+			items[i] = key
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("%s is not iterable", jinjaTypeName(v))
+}
+
+// jinjaStr returns `v` if it's a string
+func jinjaStr(v interface{}) (string, bool) {
+	switch s := v.(type) {
+	case string:
+		return s, true
+	case jinjaMarkup:
+		return string(s), true
+	}
+	return "", false
+}
+
+// jinjaNum returns `v` as a number, `isInt` is true if `v` is an integer
+func jinjaNum(v interface{}) (f float64, i int, isInt bool, ok bool) {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Bool:
+		if rv.Bool() {
+			i = 1
+		}
+		return float64(i), i, true, true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i = int(rv.Int())
+		return float64(i), i, true, true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		i = int(rv.Uint())
+		return float64(i), i, true, true
+	case reflect.Float32, reflect.Float64:
+		f = rv.Float()
+		return f, int(f), false, true
+	}
+	return 0, 0, false, false
+}
+
+// jinjaInt returns `v` as an int, if it's a whole number
+func jinjaInt(v interface{}) (int, bool) {
+	f, i, isInt, ok := jinjaNum(v)
+	if !ok || (!isInt && f != math.Trunc(f)) {
+		return 0, false
+	}
+	return i, true
+}
+
+func jinjaTypeName(v interface{}) string {
+	switch v.(type) {
+	case jinjaUndefined:
+		return "undefined"
+	case nil:
+		return "none"
+	case bool:
+		return "bool"
+	case string, jinjaMarkup:
+		return "str"
+	case jinjaFunc:
+		return "function"
+	}
+	if _, _, isInt, ok := jinjaNum(v); ok && isInt {
+		return "int"
+	} else if ok {
+		return "float"
+	} else if _, ok := toList(v); ok {
+		return "list"
+	} else if _, ok := toStringMap(v); ok {
+		return "dict"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// jinjaTruthy returns the truthiness of `v`, as python would
+func jinjaTruthy(v interface{}) bool {
+	switch val := v.(type) {
+	case nil, jinjaUndefined:
+		return false
+	case bool:
+		return val
+	case string:
+		return len(val) > 0
+	case jinjaMarkup:
+		return len(val) > 0
+	case jinjaFunc, *jinjaLoop:
+		return true
+	}
+	if f, _, _, ok := jinjaNum(v); ok {
+		return f != 0
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return rv.Len() > 0
+	case reflect.Ptr, reflect.Interface:
+		return !rv.IsNil()
+	}
+	return true
+}
+
+// jinjaString returns `v` as it's written in a template. Whole floats are
+// written without a fraction, since formats like JSON decode every number as
+// a float64.
+func jinjaString(v interface{}) string {
+	switch val := v.(type) {
+	case jinjaUndefined:
+		return ""
+	case nil:
+		return "None"
+	case string:
+		return val
+	case jinjaMarkup:
+		return string(val)
+	case bool:
+		if val {
+			return "True"
+		}
+		return "False"
+	case []byte:
+		return string(val)
+	case jinjaFunc:
+		return "<function>"
+	}
+
+	if f, i, isInt, ok := jinjaNum(v); ok {
+		if isInt {
+			return strconv.Itoa(i)
+		}
+		return jinjaFloatString(f)
+	}
+	if _, ok := toList(v); ok {
+		return jinjaRepr(v)
+	} else if _, ok := toStringMap(v); ok {
+		return jinjaRepr(v)
+	}
+	return fmt.Sprint(v)
+}
+
+func jinjaFloatString(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	case math.IsNaN(f):
+		return "nan"
+	}
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// jinjaRepr returns `v` as python would represent it
+func jinjaRepr(v interface{}) string {
+	if s, ok := jinjaStr(v); ok {
+		return "'" + strings.NewReplacer(`\`, `\\`, "'", `\'`, "\n", `\n`).Replace(s) + "'"
+	} else if list, ok := toList(v); ok {
+		items := make([]string, len(list))
+		for i, item := range list {
+			items[i] = jinjaRepr(item)
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	} else if m, ok := toStringMap(v); ok {
+		var items []string
+		for _, key := range jinjaSortedKeys(m) {
+			items = append(items, jinjaRepr(key)+": "+jinjaRepr(m[key]))
+		}
+		return "{" + strings.Join(items, ", ") + "}"
+	}
+	return jinjaString(v)
+}
+
+var jinjaEscaper = strings.NewReplacer(
+	"&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&#34;", "'", "&#39;",
+)
+
+func jinjaEscape(v interface{}) jinjaMarkup {
+	if m, ok := v.(jinjaMarkup); ok {
+		return m
+	}
+	return jinjaMarkup(jinjaEscaper.Replace(jinjaString(v)))
+}
+
+// jinjaMath applies the operator `op` to `l` & `r`
+func jinjaMath(op string, l, r interface{}) (interface{}, error) {
+	lf, li, lInt, lok := jinjaNum(l)
+	rf, ri, rInt, rok := jinjaNum(r)
+	if !lok || !rok {
+		return jinjaMathOther(op, l, r)
+	}
+
+	if lInt && rInt {
+		switch op {
+		case "+":
+			return li + ri, nil
+		case "-":
+			return li - ri, nil
+		case "*":
+			return li * ri, nil
+		case "//", "%":
+			if ri == 0 {
+				return nil, fmt.Errorf("division by zero")
+			}
+			q, m := li/ri, li%ri
+			if m != 0 && (m < 0) != (ri < 0) {
+				q, m = q-1, m+ri
+			}
+			if op == "//" {
+				return q, nil
+			}
+			return m, nil
+		case "**":
+			if ri >= 0 {
+				result := 1
+				for n := 0; n < ri; n++ {
+					result *= li
+				}
+				return result, nil
+			}
+		}
+	}
+
+	switch op {
+	case "+":
+		return lf + rf, nil
+	case "-":
+		return lf - rf, nil
+	case "*":
+		return lf * rf, nil
+	case "/", "//", "%":
+		if rf == 0 {
+			return nil, fmt.Errorf("division by zero")
+		} else if op == "/" {
+			return lf / rf, nil
+		} else if op == "//" {
+			return math.Floor(lf / rf), nil
+		}
+		m := math.Mod(lf, rf)
+		if m != 0 && (m < 0) != (rf < 0) {
+			m += rf
+		}
+		return m, nil
+	case "**":
+		return math.Pow(lf, rf), nil
+	}
+	return nil, fmt.Errorf("unsupported operator '%s'", op)
+}
+
+// jinjaMathOther applies `op` to values that aren't both numbers
+func jinjaMathOther(op string, l, r interface{}) (interface{}, error) {
+	ls, lStr := jinjaStr(l)
+	rs, rStr := jinjaStr(r)
+	switch {
+	case op == "+" && lStr && rStr:
+		return ls + rs, nil
+	case op == "*" && lStr:
+		if n, ok := jinjaInt(r); ok {
+			return jinjaRepeat(ls, n)
+		}
+	case op == "%" && lStr:
+		args := []interface{}{r}
+		if list, ok := r.([]interface{}); ok {
+			args = list
+		}
+		return jinjaPercentFormat(ls, args)
+	case op == "+":
+		if ll, ok := toList(l); ok {
+			if rl, ok := toList(r); ok {
+				return append(append([]interface{}{}, ll...), rl...), nil
+			}
+		}
+	case op == "*":
+		if ll, ok := toList(l); ok {
+			if n, ok := jinjaInt(r); ok {
+				if n > 0 && len(ll) > jinjaMaxRepeat/n {
+					return nil, fmt.Errorf("list is too long, the maximum is %d items", jinjaMaxRepeat)
+				}
+				list := make([]interface{}, 0)
+				for i := 0; i < n; i++ {
+					list = append(list, ll...)
+				}
+				return list, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("unsupported operand types for %s: %s and %s", op, jinjaTypeName(l), jinjaTypeName(r))
+}
+
+// jinjaRepeat returns `s` repeated `n` times, or an error if the result would
+// be longer than jinjaMaxRepeat. If `n` is negative, "" is returned.
+func jinjaRepeat(s string, n int) (string, error) {
+	if n <= 0 {
+		return "", nil
+	} else if len(s) > jinjaMaxRepeat/n {
+		return "", fmt.Errorf("string is too long, the maximum is %d bytes", jinjaMaxRepeat)
+	}
+	return strings.Repeat(s, n), nil
+}
+
+func jinjaEqual(a, b interface{}) bool {
+	if af, _, _, ok := jinjaNum(a); ok {
+		if bf, _, _, ok := jinjaNum(b); ok {
+			return af == bf
+		}
+	}
+	if as, ok := jinjaStr(a); ok {
+		bs, ok := jinjaStr(b)
+		return ok && as == bs
+	}
+	if _, ok := a.(jinjaUndefined); ok {
+		_, ok = b.(jinjaUndefined)
+		return ok
+	}
+	if al, ok := toList(a); ok {
+		bl, ok := toList(b)
+		if !ok || len(al) != len(bl) {
+			return false
+		}
+		for i := range al {
+			if !jinjaEqual(al[i], bl[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// jinjaOrder returns -1, 0 or 1 if `a` is less than, equal to or greater
+// than `b`.
+func jinjaOrder(a, b interface{}) (int, error) {
+	if af, _, _, ok := jinjaNum(a); ok {
+		if bf, _, _, ok := jinjaNum(b); ok {
+			switch {
+			case af < bf:
+				return -1, nil
+			case af > bf:
+				return 1, nil
+			}
+			return 0, nil
+		}
+	}
+	if as, ok := jinjaStr(a); ok {
+		if bs, ok := jinjaStr(b); ok {
+			return strings.Compare(as, bs), nil
+		}
+	}
+	return 0, fmt.Errorf("can't compare %s and %s", jinjaTypeName(a), jinjaTypeName(b))
+}
+
+func jinjaContains(container, item interface{}) (bool, error) {
+	if s, ok := jinjaStr(container); ok {
+		sub, ok := jinjaStr(item)
+		if !ok {
+			return false, fmt.Errorf("'in <string>' requires a string, not %s", jinjaTypeName(item))
+		}
+		return strings.Contains(s, sub), nil
+	} else if list, ok := toList(container); ok {
+		for _, v := range list {
+			if jinjaEqual(v, item) {
+				return true, nil
+			}
+		}
+		return false, nil
+	} else if _, ok := toStringMap(container); ok {
+		_, ok = jinjaField(container, jinjaString(item))
+		return ok, nil
+	} else if _, ok := container.(jinjaUndefined); ok {
+		return false, nil
+	}
+	return false, fmt.Errorf("%s is not a container", jinjaTypeName(container))
+}
+
+func jinjaCompareOp(op string, l, r interface{}) (bool, error) {
+	switch op {
+	case "==":
+		return jinjaEqual(l, r), nil
+	case "!=":
+		return !jinjaEqual(l, r), nil
+	case "in":
+		return jinjaContains(r, l)
+	case "not in":
+		ok, err := jinjaContains(r, l)
+		return !ok, err
+	}
+
+	n, err := jinjaOrder(l, r)
+	if err != nil {
+		return false, err
+	}
+	switch op {
+	case "<":
+		return n < 0, nil
+	case ">":
+		return n > 0, nil
+	case "<=":
+		return n <= 0, nil
+	}
+	return n >= 0, nil
+}
+
+// jinjaSliceValue returns `obj[start:stop:step]`
+func jinjaSliceValue(obj interface{}, start, stop, step *int) (interface{}, error) {
+	var items []interface{}
+	s, isStr := jinjaStr(obj)
+	if isStr {
+		for _, r := range s {
+			items = append(items, string(r))
+		}
+	} else if list, ok := toList(obj); ok {
+		items = list
+	} else if _, ok := obj.(jinjaUndefined); ok {
+		return obj, nil
+	} else {
+		return nil, fmt.Errorf("%s can't be sliced", jinjaTypeName(obj))
+	}
+
+	n, st := len(items), 1
+	if step != nil {
+		if st = *step; st == 0 {
+			return nil, fmt.Errorf("slice step can't be zero")
+		}
+	}
+	bound := func(i *int, def int) int {
+		if i == nil {
+			return def
+		}
+		v := *i
+		if v < 0 {
+			if v += n; v < 0 {
+				v = 0
+				if st < 0 {
+					v = -1
+				}
+			}
+		} else if v >= n {
+			v = n
+			if st < 0 {
+				v = n - 1
+			}
+		}
+		return v
+	}
+
+	var lo, hi int
+	if st > 0 {
+		lo, hi = bound(start, 0), bound(stop, n)
+	} else {
+		lo, hi = bound(start, n-1), bound(stop, -1)
+	}
+
+	result := make([]interface{}, 0)
+	for i := lo; (st > 0 && i < hi) || (st < 0 && i > hi); i += st {
+		result = append(result, items[i])
+	}
+	if isStr {
+		var b strings.Builder
+		for _, r := range result {
+			b.WriteString(r.(string))
+		}
+		return b.String(), nil
+	}
+	return result, nil
+}
+
+// jinjaPercentFormat applies python's printf-style formatting
+func jinjaPercentFormat(format string, args []interface{}) (string, error) {
+	var b strings.Builder
+	next := 0
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			b.WriteByte(format[i])
+			continue
+		}
+		if i++; i >= len(format) {
+			return "", fmt.Errorf("incomplete format")
+		} else if format[i] == '%' {
+			b.WriteByte('%')
+			continue
+		}
+
+		var arg interface{}
+		if format[i] == '(' {
+			end := strings.IndexByte(format[i:], ')')
+			if end < 0 || len(args) == 0 {
+				return "", fmt.Errorf("incomplete format key")
+			}
+			arg, _ = jinjaField(args[0], format[i+1:i+end])
+			i += end + 1
+		} else {
+			if next >= len(args) {
+				return "", fmt.Errorf("not enough arguments for format string")
+			}
+			arg = args[next]
+			next++
+		}
+
+		spec := "%"
+		for i < len(format) && strings.IndexByte("-+ 0#.123456789", format[i]) >= 0 {
+			spec += string(format[i])
+			i++
+		}
+		if i >= len(format) {
+			return "", fmt.Errorf("incomplete format")
+		}
+		switch verb := format[i]; verb {
+		case 's':
+			fmt.Fprintf(&b, spec+"s", jinjaString(arg))
+		case 'r':
+			fmt.Fprintf(&b, spec+"s", jinjaRepr(arg))
+		case 'd', 'i', 'x', 'X', 'o', 'c':
+			_, n, _, ok := jinjaNum(arg)
+			if !ok {
+				return "", fmt.Errorf("%%%c format: a number is required, not %s", verb, jinjaTypeName(arg))
+			}
+			if verb == 'i' {
+				verb = 'd'
+			}
+			fmt.Fprintf(&b, spec+string(verb), n)
+		case 'f', 'F', 'e', 'E', 'g', 'G':
+			f, _, _, ok := jinjaNum(arg)
+			if !ok {
+				return "", fmt.Errorf("%%%c format: a number is required, not %s", verb, jinjaTypeName(arg))
+			}
+			if verb == 'F' {
+				verb = 'f'
+			}
+			fmt.Fprintf(&b, spec+string(verb), f)
+		default:
+			return "", fmt.Errorf("unsupported format character '%c'", verb)
+		}
+	}
+	return b.String(), nil
+}
+
+/* filters */
+
+// jinjaArg returns the argument at position `i` or named `name`, `def` is
+// returned if neither are set.
+func jinjaArg(args []interface{}, kwargs map[string]interface{}, i int, name string, def interface{}) interface{} {
+	if i >= 0 && i < len(args) {
+		return args[i]
+	} else if v, ok := kwargs[name]; ok {
+		return v
+	}
+	return def
+}
+
+func jinjaIntArg(args []interface{}, kwargs map[string]interface{}, i int, name string, def int) (int, error) {
+	v := jinjaArg(args, kwargs, i, name, def)
+	n, ok := jinjaInt(v)
+	if !ok {
+		return 0, fmt.Errorf("'%s' must be an integer, not %s", name, jinjaTypeName(v))
+	}
+	return n, nil
+}
+
+// jinjaStringFilter returns a *JinjaFilter* that applies `fn` to the value
+// as a string.
+func jinjaStringFilter(fn func(string) string) JinjaFilter {
+	return func(v interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+		return fn(jinjaString(v)), nil
+	}
+}
+
+func jinjaCapitalize(s string) string {
+	for i, r := range s {
+		return string(unicode.ToUpper(r)) + strings.ToLower(s[i+len(string(r)):])
+	}
+	return s
+}
+
+func jinjaTitle(s string) string {
+	var b strings.Builder
+	prev := false
+	for _, r := range s {
+		if prev {
+			b.WriteRune(unicode.ToLower(r))
+		} else {
+			b.WriteRune(unicode.ToUpper(r))
+		}
+		prev = unicode.IsLetter(r) || unicode.IsDigit(r) || r == '\''
+	}
+	return b.String()
+}
+
+var jinjaTags = regexp.MustCompile(`(?s)<!--.*?-->|<[^>]*>`)
+
+func jinjaStriptags(s string) string {
+	return html.UnescapeString(strings.Join(strings.Fields(jinjaTags.ReplaceAllString(s, "")), " "))
+}
+
+func jinjaAbs(v interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+	f, i, isInt, ok := jinjaNum(v)
+	switch {
+	case !ok:
+		return nil, fmt.Errorf("bad operand type for abs(): %s", jinjaTypeName(v))
+	case isInt && i < 0:
+		return -i, nil
+	case isInt:
+		return i, nil
+	}
+	return math.Abs(f), nil
+}
+
+func jinjaAttrFilter(v interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+	return jinjaAttr(v, jinjaString(jinjaArg(args, kwargs, 0, "name", ""))), nil
+}
+
+func jinjaBatch(v interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+	n, err := jinjaIntArg(args, kwargs, 0, "linecount", 1)
+	if err != nil {
+		return nil, err
+	} else if n < 1 {
+		return nil, fmt.Errorf("'linecount' must be greater than 0")
+	} else if n > jinjaMaxRepeat {
+		return nil, fmt.Errorf("'linecount' must be less than %d", jinjaMaxRepeat)
+	}
+	items, err := jinjaIter(v)
+	if err != nil {
+		return nil, err
+	}
+
+	fill, doFill := kwargs["fill_with"]
+	if len(args) > 1 {
+		fill, doFill = args[1], true
+	}
+	batches := make([]interface{}, 0)
+	for i := 0; i < len(items); i += n {
+		end := i + n
+		if end > len(items) {
+			end = len(items)
+		}
+		batch := append([]interface{}{}, items[i:end]...)
+		for doFill && fill != nil && len(batch) < n {
+			batch = append(batch, fill)
+		}
+		batches = append(batches, batch)
+	}
+	return batches, nil
+}
+
+func jinjaCenter(v interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+	width, err := jinjaIntArg(args, kwargs, 0, "width", 80)
+	if err != nil {
+		return nil, err
+	}
+	s := jinjaString(v)
+	pad := width - len([]rune(s))
+	if pad <= 0 {
+		return s, nil
+	}
+	left := pad / 2
+	if pad%2 == 1 && width%2 == 1 {
+		left++
+	}
+	padding, err := jinjaRepeat(" ", pad)
+	if err != nil {
+		return nil, err
+	}
+	return padding[:left] + s + padding[left:], nil
+}
+
+func jinjaDefault(v interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+	def := jinjaArg(args, kwargs, 0, "default_value", "")
+	boolean := jinjaTruthy(jinjaArg(args, kwargs, 1, "boolean", false))
+	if _, ok := v.(jinjaUndefined); ok || (boolean && !jinjaTruthy(v)) {
+		return def, nil
+	}
+	return v, nil
+}
+
+// jinjaSortItems sorts `items` by the value `key` returns for each
+func jinjaSortItems(items []interface{}, key func(interface{}) interface{}, caseSensitive, reverse bool) {
+	keys := make([]interface{}, len(items))
+	for i, item := range items {
+		keys[i] = key(item)
+		if s, ok := jinjaStr(keys[i]); ok && !caseSensitive {
+			keys[i] = strings.ToLower(s)
+		}
+	}
+
+	indexes := make([]int, len(items))
+	for i := range indexes {
+		indexes[i] = i
+	}
+	sort.SliceStable(indexes, func(i, j int) bool {
+		a, b := keys[indexes[i]], keys[indexes[j]]
+		n, err := jinjaOrder(a, b)
+		if err != nil {
+			n = strings.Compare(jinjaString(a), jinjaString(b))
+		}
+		if reverse {
+			return n > 0
+		}
+		return n < 0
+	})
+
+	sorted := make([]interface{}, len(items))
+	for i, index := range indexes {
+		sorted[i] = items[index]
+	}
+	copy(items, sorted)
+}
+
+func jinjaPairs(v interface{}) ([]interface{}, error) {
+	if _, ok := v.(jinjaUndefined); ok {
+		return make([]interface{}, 0), nil
+	}
+	m, ok := toStringMap(v)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a dict", jinjaTypeName(v))
+	}
+	pairs := make([]interface{}, 0, len(m))
+	for _, key := range jinjaSortedKeys(m) {
+		pairs = append(pairs, []interface{}{key, m[key]})
+	}
+	return pairs, nil
+}
+
+func jinjaDictsort(v interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+	pairs, err := jinjaPairs(v)
+	if err != nil {
+		return nil, err
+	}
+	caseSensitive := jinjaTruthy(jinjaArg(args, kwargs, 0, "case_sensitive", false))
+	by := jinjaString(jinjaArg(args, kwargs, 1, "by", "key"))
+	reverse := jinjaTruthy(jinjaArg(args, kwargs, 2, "reverse", false))
+
+	index := 0
+	if by == "value" {
+		index = 1
+	} else if by != "key" {
+		return nil, fmt.Errorf("you can only sort by either 'key' or 'value'")
+	}
+	jinjaSortItems(pairs, func(pair interface{}) interface{} {
+		return pair.([]interface{})[index]
+	}, caseSensitive, reverse)
+	return pairs, nil
+}
+
+func jinjaEscapeFilter(v interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+	return jinjaEscape(v), nil
+}
+
+func jinjaForceEscape(v interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+	return jinjaEscape(jinjaString(v)), nil
+}
+
+func jinjaFirst(v interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+	items, err := jinjaIter(v)
+	if err != nil || len(items) == 0 {
+		return jinjaUndefined{"first"}, err
+	}
+	return items[0], nil
+}
+
+func jinjaLast(v interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+	items, err := jinjaIter(v)
+	if err != nil || len(items) == 0 {
+		return jinjaUndefined{"last"}, err
+	}
+	return items[len(items)-1], nil
+}
+
+func jinjaFloatFilter(v interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+	if f, _, _, ok := jinjaNum(v); ok {
+		return f, nil
+	} else if f, err := strconv.ParseFloat(strings.TrimSpace(jinjaString(v)), 64); err == nil {
+		return f, nil
+	}
+	return jinjaArg(args, kwargs, 0, "default", 0.0), nil
+}
+
+func jinjaIntFilter(v interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+	base, err := jinjaIntArg(args, kwargs, 1, "base", 10)
+	if err != nil {
+		return nil, err
+	}
+	if _, i, _, ok := jinjaNum(v); ok {
+		return i, nil
+	}
+	s := strings.Replace(strings.TrimSpace(jinjaString(v)), "_", "", -1)
+	if base == 16 || base == 8 || base == 2 {
+		s = strings.TrimPrefix(strings.TrimPrefix(strings.ToLower(s), "0x"), "0o")
+		s = strings.TrimPrefix(s, "0b")
+	}
+	if i, err := strconv.ParseInt(s, base, 0); err == nil {
+		return int(i), nil
+	} else if f, err := strconv.ParseFloat(s, 64); err == nil && base == 10 {
+		return int(f), nil
+	}
+	return jinjaArg(args, kwargs, 0, "default", 0), nil
+}
+
+func jinjaFormatFilter(v interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+	if len(kwargs) > 0 {
+		args = []interface{}{kwargs}
+	}
+	return jinjaPercentFormat(jinjaString(v), args)
+}
+
+func jinjaGroupby(v interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+	attr := jinjaString(jinjaArg(args, kwargs, 0, "attribute", ""))
+	def := jinjaArg(args, kwargs, 1, "default", nil)
+	items, err := jinjaIter(v)
+	if err != nil {
+		return nil, err
+	}
+
+	key := func(item interface{}) interface{} {
+		k := jinjaAttrPath(item, attr)
+		if _, ok := k.(jinjaUndefined); ok && def != nil {
+			return def
+		}
+		return k
+	}
+	sorted := append([]interface{}{}, items...)
+	jinjaSortItems(sorted, key, true, false)
+
+	groups := make([]interface{}, 0)
+	for _, item := range sorted {
+		k := key(item)
+		if last := len(groups) - 1; last >= 0 && jinjaEqual(groups[last].(jinjaGroup)[0], k) {
+			group := groups[last].(jinjaGroup)
+			group[1] = append(group[1].([]interface{}), item)
+			continue
+		}
+		groups = append(groups, jinjaGroup{k, []interface{}{item}})
+	}
+	return groups, nil
+}
+
+func jinjaIndent(v interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+	indent := "    "
+	switch width := jinjaArg(args, kwargs, 0, "width", 4).(type) {
+	case string:
+		indent = width
+	default:
+		n, ok := jinjaInt(width)
+		if !ok {
+			return nil, fmt.Errorf("'width' must be an integer or string")
+		} else if n < 0 {
+			return nil, fmt.Errorf("'width' must not be negative")
+		}
+		var err error
+		if indent, err = jinjaRepeat(" ", n); err != nil {
+			return nil, err
+		}
+	}
+	first := jinjaTruthy(jinjaArg(args, kwargs, 1, "first", false))
+	blank := jinjaTruthy(jinjaArg(args, kwargs, 2, "blank", false))
+
+	lines := strings.Split(jinjaString(v), "\n")
+	for i, line := range lines {
+		if (i > 0 || first) && (blank || len(strings.TrimSpace(line)) > 0) {
+			lines[i] = indent + line
+		}
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+func jinjaItemsFilter(v interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+	return jinjaPairs(v)
+}
+
+func jinjaJoin(v interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+	sep := jinjaString(jinjaArg(args, kwargs, 0, "d", ""))
+	attr, hasAttr := jinjaArg(args, kwargs, 1, "attribute", nil).(string)
+	items, err := jinjaIter(v)
+	if err != nil {
+		return nil, err
+	}
+	parts := make([]string, len(items))
+	for i, item := range items {
+		if hasAttr {
+			item = jinjaAttrPath(item, attr)
+		}
+		parts[i] = jinjaString(item)
+	}
+	return strings.Join(parts, sep), nil
+}
+
+func jinjaLength(v interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+	if s, ok := jinjaStr(v); ok {
+		return len([]rune(s)), nil
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return rv.Len(), nil
+	}
+	if _, ok := v.(jinjaUndefined); ok {
+		return 0, nil
+	}
+	return nil, fmt.Errorf("%s has no length", jinjaTypeName(v))
+}
+
+func jinjaListFilter(v interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+	items, err := jinjaIter(v)
+	if items == nil && err == nil {
+		items = make([]interface{}, 0)
+	}
+	return items, err
+}
+
+func jinjaMap(v interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+	items, err := jinjaIter(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var fn func(interface{}) (interface{}, error)
+	if attr, ok := kwargs["attribute"]; ok {
+		def, hasDef := kwargs["default"]
+		fn = func(item interface{}) (interface{}, error) {
+			val := jinjaAttrPath(item, jinjaString(attr))
+			if _, ok := val.(jinjaUndefined); ok && hasDef {
+				return def, nil
+			}
+			return val, nil
+		}
+	} else if len(args) > 0 {
+		name := jinjaString(args[0])
+		filter, ok := getJinjaFilter(name)
+		if !ok {
+			return nil, fmt.Errorf("no filter named '%s'", name)
+		}
+		fn = func(item interface{}) (interface{}, error) {
+			return filter(item, args[1:], kwargs)
+		}
+	} else {
+		return nil, fmt.Errorf("map requires a filter or attribute")
+	}
+
+	result := make([]interface{}, len(items))
+	for i, item := range items {
+		if result[i], err = fn(item); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// jinjaMinMax returns the min (`sign` is -1) or max (`sign` is 1) filter
+func jinjaMinMax(sign int) JinjaFilter {
+	return func(v interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+		caseSensitive := jinjaTruthy(jinjaArg(args, kwargs, 0, "case_sensitive", false))
+		attr, hasAttr := jinjaArg(args, kwargs, 1, "attribute", nil).(string)
+		items, err := jinjaIter(v)
+		if err != nil || len(items) == 0 {
+			return jinjaUndefined{}, err
+		}
+
+		items = append([]interface{}{}, items...)
+		jinjaSortItems(items, func(item interface{}) interface{} {
+			if hasAttr {
+				return jinjaAttrPath(item, attr)
+			}
+			return item
+		}, caseSensitive, sign > 0)
+		return items[0], nil
+	}
+}
+
+func jinjaPprint(v interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+	return jinjaRepr(v), nil
+}
+
+// jinjaSelect returns the select (`keep` is true) or reject filter, if
+// `attr` is true the selectattr or rejectattr filter is returned.
+func jinjaSelect(keep, attr bool) JinjaFilter {
+	return func(v interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+		items, err := jinjaIter(v)
+		if err != nil {
+			return nil, err
+		}
+
+		var path string
+		if attr {
+			if len(args) == 0 {
+				return nil, fmt.Errorf("missing attribute")
+			}
+			path, args = jinjaString(args[0]), args[1:]
+		}
+		test := func(item interface{}) (bool, error) {
+			return jinjaTruthy(item), nil
+		}
+		if len(args) > 0 {
+			name := jinjaString(args[0])
+			fn, ok := jinjaTests[name]
+			if !ok {
+				return nil, fmt.Errorf("no test named '%s'", name)
+			}
+			testArgs := args[1:]
+			test = func(item interface{}) (bool, error) {
+				return fn(item, testArgs)
+			}
+		}
+
+		result := make([]interface{}, 0)
+		for _, item := range items {
+			val := item
+			if attr {
+				val = jinjaAttrPath(item, path)
+			}
+			if ok, err := test(val); err != nil {
+				return nil, err
+			} else if ok == keep {
+				result = append(result, item)
+			}
+		}
+		return result, nil
+	}
+}
+
+func jinjaReplace(v interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+	old := jinjaString(jinjaArg(args, kwargs, 0, "old", ""))
+	new := jinjaString(jinjaArg(args, kwargs, 1, "new", ""))
+	count, err := jinjaIntArg(args, kwargs, 2, "count", -1)
+	if err != nil {
+		return nil, err
+	}
+	return strings.Replace(jinjaString(v), old, new, count), nil
+}
+
+func jinjaReverse(v interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+	if s, ok := jinjaStr(v); ok {
+		runes := []rune(s)
+		for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+			runes[i], runes[j] = runes[j], runes[i]
+		}
+		return string(runes), nil
+	}
+	items, err := jinjaIter(v)
+	if err != nil {
+		return nil, err
+	}
+	reversed := make([]interface{}, len(items))
+	for i, item := range items {
+		reversed[len(items)-1-i] = item
+	}
+	return reversed, nil
+}
+
+func jinjaRound(v interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+	precision, err := jinjaIntArg(args, kwargs, 0, "precision", 0)
+	if err != nil {
+		return nil, err
+	}
+	f, _, _, ok := jinjaNum(v)
+	if !ok {
+		return nil, fmt.Errorf("can't round %s", jinjaTypeName(v))
+	}
+
+	p := math.Pow(10, float64(precision))
+	switch method := jinjaString(jinjaArg(args, kwargs, 1, "method", "common")); method {
+	case "common":
+		return math.RoundToEven(f*p) / p, nil
+	case "ceil":
+		return math.Ceil(f*p) / p, nil
+	case "floor":
+		return math.Floor(f*p) / p, nil
+	default:
+		return nil, fmt.Errorf("method must be common, ceil or floor, not '%s'", method)
+	}
+}
+
+func jinjaSafe(v interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+	return jinjaMarkup(jinjaString(v)), nil
+}
+
+func jinjaSort(v interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+	reverse := jinjaTruthy(jinjaArg(args, kwargs, 0, "reverse", false))
+	caseSensitive := jinjaTruthy(jinjaArg(args, kwargs, 1, "case_sensitive", false))
+	attr, hasAttr := jinjaArg(args, kwargs, 2, "attribute", nil).(string)
+	items, err := jinjaIter(v)
+	if err != nil {
+		return nil, err
+	}
+
+	sorted := append(make([]interface{}, 0, len(items)), items...)
+	jinjaSortItems(sorted, func(item interface{}) interface{} {
+		if hasAttr {
+			return jinjaAttrPath(item, attr)
+		}
+		return item
+	}, caseSensitive, reverse)
+	return sorted, nil
+}
+
+func jinjaSum(v interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+	attr, hasAttr := jinjaArg(args, kwargs, 0, "attribute", nil).(string)
+	total := jinjaArg(args, kwargs, 1, "start", 0)
+	items, err := jinjaIter(v)
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range items {
+		if hasAttr {
+			item = jinjaAttrPath(item, attr)
+		}
+		if total, err = jinjaMath("+", total, item); err != nil {
+			return nil, err
+		}
+	}
+	return total, nil
+}
+
+func jinjaTojson(v interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+	var buf []byte
+	var err error
+	if n, ok := jinjaInt(jinjaArg(args, kwargs, 0, "indent", nil)); ok {
+		if n < 0 {
+			return nil, fmt.Errorf("'indent' must not be negative")
+		}
+		var indent string
+		if indent, err = jinjaRepeat(" ", n); err != nil {
+			return nil, err
+		}
+		buf, err = json.MarshalIndent(v, "", indent)
+	} else {
+		buf, err = json.Marshal(v)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return jinjaMarkup(strings.Replace(string(buf), "'", `'`, -1)), nil
+}
+
+func jinjaTrim(v interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+	if chars := jinjaArg(args, kwargs, 0, "chars", nil); chars != nil {
+		return strings.Trim(jinjaString(v), jinjaString(chars)), nil
+	}
+	return strings.TrimSpace(jinjaString(v)), nil
+}
+
+func jinjaTruncate(v interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+	length, err := jinjaIntArg(args, kwargs, 0, "length", 255)
+	if err != nil {
+		return nil, err
+	}
+	killwords := jinjaTruthy(jinjaArg(args, kwargs, 1, "killwords", false))
+	end := jinjaString(jinjaArg(args, kwargs, 2, "end", "..."))
+	leeway, err := jinjaIntArg(args, kwargs, 3, "leeway", 5)
+	if err != nil {
+		return nil, err
+	}
+
+	s := []rune(jinjaString(v))
+	if len(s) <= length+leeway {
+		return string(s), nil
+	}
+	cut := length - len([]rune(end))
+	if cut < 0 {
+		cut = 0
+	}
+	result := string(s[:cut])
+	if !killwords {
+		if i := strings.LastIndexByte(result, ' '); i >= 0 {
+			result = result[:i]
+		}
+	}
+	return result + end, nil
+}
+
+func jinjaUnique(v interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+	caseSensitive := jinjaTruthy(jinjaArg(args, kwargs, 0, "case_sensitive", false))
+	attr, hasAttr := jinjaArg(args, kwargs, 1, "attribute", nil).(string)
+	items, err := jinjaIter(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var seen []interface{}
+	result := make([]interface{}, 0)
+	for _, item := range items {
+		key := item
+		if hasAttr {
+			key = jinjaAttrPath(item, attr)
+		}
+		if s, ok := jinjaStr(key); ok && !caseSensitive {
+			key = strings.ToLower(s)
+		}
+		if ok, _ := jinjaContains(seen, key); !ok {
+			seen = append(seen, key)
+			result = append(result, item)
+		}
+	}
+	return result, nil
+}
+
+func jinjaUrlencode(v interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+	escape := func(v interface{}) string {
+		return strings.Replace(url.QueryEscape(jinjaString(v)), "+", "%20", -1)
+	}
+	if _, ok := jinjaStr(v); !ok {
+		var pairs []interface{}
+		if _, ok := toStringMap(v); ok {
+			pairs, _ = jinjaPairs(v)
+		} else if list, ok := toList(v); ok {
+			pairs = list
+		}
+		if pairs != nil {
+			parts := make([]string, 0, len(pairs))
+			for _, pair := range pairs {
+				kv, _ := toList(pair)
+				if len(kv) != 2 {
+					return nil, fmt.Errorf("urlencode requires a dict or list of pairs")
+				}
+				parts = append(parts, escape(kv[0])+"="+escape(kv[1]))
+			}
+			return strings.Join(parts, "&"), nil
+		}
+	}
+	return strings.Replace(escape(v), "%2F", "/", -1), nil
+}
+
+func jinjaWordcount(v interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+	return len(strings.Fields(jinjaString(v))), nil
+}
+
+/* tests */
+
+// jinjaTest1 returns a test that requires one argument
+func jinjaTest1(name string, test func(v, arg interface{}) (bool, error)) func(interface{}, []interface{}) (bool, error) {
+	return func(v interface{}, args []interface{}) (bool, error) {
+		if len(args) != 1 {
+			return false, fmt.Errorf("test '%s' requires one argument", name)
+		}
+		return test(v, args[0])
+	}
+}
+
+func jinjaCompareTest(op string) func(v, arg interface{}) (bool, error) {
+	return func(v, arg interface{}) (bool, error) {
+		return jinjaCompareOp(op, v, arg)
+	}
+}
+
+func jinjaIs(test func(v interface{}) bool) func(interface{}, []interface{}) (bool, error) {
+	return func(v interface{}, args []interface{}) (bool, error) {
+		return test(v), nil
+	}
+}
+
+var jinjaTests = map[string]func(v interface{}, args []interface{}) (bool, error){
+	"defined": jinjaIs(func(v interface{}) bool {
+		_, ok := v.(jinjaUndefined)
+		return !ok
+	}),
+	"undefined": jinjaIs(func(v interface{}) bool {
+		_, ok := v.(jinjaUndefined)
+		return ok
+	}),
+	"none":    jinjaIs(func(v interface{}) bool { return v == nil }),
+	"boolean": jinjaIs(func(v interface{}) bool { _, ok := v.(bool); return ok }),
+	"true":    jinjaIs(func(v interface{}) bool { return v == true }),
+	"false":   jinjaIs(func(v interface{}) bool { return v == false }),
+	"number": jinjaIs(func(v interface{}) bool {
+		_, isBool := v.(bool)
+		_, _, _, ok := jinjaNum(v)
+		return ok && !isBool
+	}),
+	"integer": jinjaIs(func(v interface{}) bool {
+		_, isBool := v.(bool)
+		_, _, isInt, _ := jinjaNum(v)
+		return isInt && !isBool
+	}),
+	"float": jinjaIs(func(v interface{}) bool {
+		_, _, isInt, ok := jinjaNum(v)
+		return ok && !isInt
+	}),
+	"string":  jinjaIs(func(v interface{}) bool { _, ok := jinjaStr(v); return ok }),
+	"mapping": jinjaIs(func(v interface{}) bool { _, ok := toStringMap(v); return ok }),
+	"sequence": jinjaIs(func(v interface{}) bool {
+		_, isStr := jinjaStr(v)
+		_, isList := toList(v)
+		_, isMap := toStringMap(v)
+		return isStr || isList || isMap
+	}),
+	"iterable": jinjaIs(func(v interface{}) bool {
+		_, err := jinjaIter(v)
+		_, isUndefined := v.(jinjaUndefined)
+		return err == nil && v != nil && !isUndefined
+	}),
+	"callable": jinjaIs(func(v interface{}) bool { _, ok := v.(jinjaFunc); return ok }),
+	"escaped":  jinjaIs(func(v interface{}) bool { _, ok := v.(jinjaMarkup); return ok }),
+	"lower": jinjaIs(func(v interface{}) bool {
+		s, ok := jinjaStr(v)
+		return ok && s == strings.ToLower(s)
+	}),
+	"upper": jinjaIs(func(v interface{}) bool {
+		s, ok := jinjaStr(v)
+		return ok && s == strings.ToUpper(s)
+	}),
+	"even": jinjaIs(func(v interface{}) bool { i, ok := jinjaInt(v); return ok && i%2 == 0 }),
+	"odd":  jinjaIs(func(v interface{}) bool { i, ok := jinjaInt(v); return ok && i%2 != 0 }),
+	"divisibleby": jinjaTest1("divisibleby", func(v, arg interface{}) (bool, error) {
+		i, ok := jinjaInt(v)
+		n, nok := jinjaInt(arg)
+		if !ok || !nok || n == 0 {
+			return false, fmt.Errorf("divisibleby requires non-zero integers")
+		}
+		return i%n == 0, nil
+	}),
+	"sameas": jinjaTest1("sameas", func(v, arg interface{}) (bool, error) {
+		return jinjaEqual(v, arg) && jinjaTypeName(v) == jinjaTypeName(arg), nil
+	}),
+	"in": jinjaTest1("in", func(v, arg interface{}) (bool, error) {
+		return jinjaContains(arg, v)
+	}),
+	"eq":          jinjaTest1("eq", jinjaCompareTest("==")),
+	"equalto":     jinjaTest1("equalto", jinjaCompareTest("==")),
+	"==":          jinjaTest1("==", jinjaCompareTest("==")),
+	"ne":          jinjaTest1("ne", jinjaCompareTest("!=")),
+	"!=":          jinjaTest1("!=", jinjaCompareTest("!=")),
+	"lt":          jinjaTest1("lt", jinjaCompareTest("<")),
+	"lessthan":    jinjaTest1("lessthan", jinjaCompareTest("<")),
+	"<":           jinjaTest1("<", jinjaCompareTest("<")),
+	"le":          jinjaTest1("le", jinjaCompareTest("<=")),
+	"<=":          jinjaTest1("<=", jinjaCompareTest("<=")),
+	"gt":          jinjaTest1("gt", jinjaCompareTest(">")),
+	"greaterthan": jinjaTest1("greaterthan", jinjaCompareTest(">")),
+	">":           jinjaTest1(">", jinjaCompareTest(">")),
+	"ge":          jinjaTest1("ge", jinjaCompareTest(">=")),
+	">=":          jinjaTest1(">=", jinjaCompareTest(">=")),
+}
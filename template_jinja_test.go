@@ -0,0 +1,282 @@
+package dati
+
+/*
+Copyright (C) 2023 gearsix <gearsix@tuta.io>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const jinjaData = `{
+	"title": "<eg>",
+	"n": 7,
+	"f": 2.5,
+	"empty": [],
+	"items": ["a", "b", "c"],
+	"nums": [3, 1, 2],
+	"people": [
+		{"name": "x", "age": 2, "city": "b"},
+		{"name": "y", "age": 1, "city": "a"},
+		{"name": "z", "age": 3, "city": "b"}
+	],
+	"map": {"b": 2, "a": 1},
+	"author": {"name": "gearsix", "site": {"url": "notabug.org"}}
+}`
+
+var jinjaGood = map[string]string{
+	// expressions
+	`{{ title }}`:   `<eg>`,
+	`{{ title|e }}`: `&lt;eg&gt;`,
+	`{{ author.name }} {{ author['site'].url }}`:                         `gearsix notabug.org`,
+	`{{ items[1] }}{{ items.0 }}{{ items[-1] }}`:                         `bac`,
+	`{{ items[1:] }} {{ items[::-1]|join }}`:                             `['b', 'c'] cba`,
+	`{{ missing }}|{{ missing.x }}`:                                      `|`,
+	`{{ 1 + 2 * 3 }} {{ 7 / 2 }} {{ 7 // 2 }} {{ -7 % 3 }} {{ 2 ** 3 }}`: `7 3.5 3 2 8`,
+	`{{ "-" * 3 }}|{{ "a" * -1 }}|{{ range(3, 0, -1)|list }}`:            `---||[3, 2, 1]`,
+	`{{ n * f }} {{ "a" ~ n ~ none }}`:                                   `17.5 a7None`,
+	`{{ "ab" * 2 }} {{ [1] + [2] }}`:                                     `abab [1, 2]`,
+	`{{ n > 5 and not empty }} {{ 1 < n <= 7 }} {{ "a" in items }}`:      `True True True`,
+	`{{ "d" not in items }} {{ "eg" in title }} {{ "a" in map }}`:        `True True True`,
+	`{{ "yes" if n is odd else "no" }} {{ "x" if empty }}`:               `yes `,
+	`{{ missing is defined }} {{ n is divisibleby 7 }} {{ n is gt(5) }}`: `False True True`,
+	`{{ {"a": 1, "b": [1, 2]} }}`:                                        `{'a': 1, 'b': [1, 2]}`,
+	`{{ map.items()|list }} {{ map.get("c", 3) }}`:                       `[['a', 1], ['b', 2]] 3`,
+	`{{ "a,b".split(",") }} {{ " x ".strip() ~ "|" }} {{ "x".upper() }}`: `['a', 'b'] x| X`,
+	`{{ "%s=%03d" % ("n", n) }} {{ "%.2f"|format(f) }}`:                  `n=007 2.50`,
+	`{{ range(3)|list }} {{ range(1, 7, 2)|join(",") }}`:                 `[0, 1, 2] 1,3,5`,
+	`{# comment #}x{#- {{ title }} -#}  y`:                               `xy`,
+	`{% raw %}{{ title }}{% endraw %}`:                                   `{{ title }}`,
+	"a  {{- n -}}  b\n{%- if true %} c{% endif %}":                       `a7b c`,
+	// filters
+	`{{ missing|default("d") }} {{ empty|default("d", true) }} {{ n|d }}`:                 `d d 7`,
+	`{{ title|e }} {{ title|safe|e }} {{ "<b>"|forceescape }}`:                            `&lt;eg&gt; <eg> &lt;b&gt;`,
+	`{{ items|first }}{{ items|last }} {{ items|length }} {{ map|count }}`:                `ac 3 2`,
+	`{{ nums|sort }} {{ nums|sort(reverse=true)|join }} {{ nums|max }}`:                   `[1, 2, 3] 321 3`,
+	`{{ people|sort(attribute="age")|map(attribute="name")|join }}`:                       `yxz`,
+	`{{ people|selectattr("age", "gt", 1)|map(attribute="name")|join }}`:                  `xz`,
+	`{{ people|rejectattr("city", "eq", "b")|map(attribute="name")|join }}`:               `y`,
+	`{{ nums|select("odd")|list }} {{ nums|reject("odd")|list }}`:                         `[3, 1] [2]`,
+	`{{ items|map("upper")|join("-") }} {{ people|sum(attribute="age") }}`:                `A-B-C 6`,
+	`{{ map|dictsort }} {{ map|dictsort(by="value", reverse=true)|first }}`:               `[['a', 1], ['b', 2]] ['b', 2]`,
+	`{% for g in people|groupby("city") %}{{ g.grouper }}{{ g.list|length }}{% endfor %}`: `a1b2`,
+	`{{ "hello world"|title }} {{ "hELLO"|capitalize }} {{ "A"|lower }}`:                  `Hello World Hello a`,
+	`{{ "  x  "|trim }}|{{ "x"|center(5) }}|{{ "a\nb"|indent(2) }}`:                       "x|  x  |a\n  b",
+	`{{ "<p>a  <b>b</b></p>"|striptags }} {{ "a b c"|wordcount }}`:                        `a b 3`,
+	`{{ "hello world foo"|truncate(9, leeway=0) }} {{ "abc"|reverse }}`:                   `hello... cba`,
+	`{{ "aXbX"|replace("X", "-") }} {{ f|round }} {{ 2.567|round(2, "floor") }}`:          `a-b- 2 2.56`,
+	`{{ "3"|int + 1 }} {{ "x"|int(5) }} {{ n|float }} {{ f|int }} {{ -3|abs }}`:           `4 5 7 2 3`,
+	`{{ [1, 1, "A", "a"]|unique|list }} {{ items|batch(2)|list }}`:                        `[1, 'A'] [['a', 'b'], ['c']]`,
+	`{{ author|tojson }} {{ "a b/c"|urlencode }} {{ map|urlencode }}`:                     `{"name":"gearsix","site":{"url":"notabug.org"}} a%20b/c a=1&b=2`,
+	`{{ author|attr("name") }} {{ n|string ~ 1 }} {{ map|items|list|length }}`:            `gearsix 71 2`,
+	// statements
+	`{% if empty %}a{% elif n == 7 %}b{% else %}c{% endif %}`:                                          `b`,
+	`{% for i in items %}{{ loop.index }}{{ i }}{% if not loop.last %},{% endif %}{% endfor %}`:        `1a,2b,3c`,
+	`{% for i in items %}{{ loop.cycle("x", "y") }}{{ loop.revindex0 }}{% endfor %}`:                   `x2y1x0`,
+	`{% for k, v in map.items() %}{{ k }}{{ v }}{% endfor %}`:                                          `a1b2`,
+	`{% for k in map %}{{ k }}{% endfor %}`:                                                            `ab`,
+	`{% for i in empty %}x{% else %}none{% endfor %}`:                                                  `none`,
+	`{% for i in nums if i > 1 %}{{ i }}{{ loop.length }}{% endfor %}`:                                 `3222`,
+	`{% for p in people %}{% for i in items %}{{ loop.depth }}{% endfor %}{% endfor %}`:                `222222222`,
+	`{% set x = n + 1 %}{{ x }}{% set a, b = 1, 2 %}{{ a }}{{ b }}`:                                    `812`,
+	`{% set x %}<{{ n }}>{% endset %}{{ x }}`:                                                          `<7>`,
+	`{% set ns = namespace(c=0) %}{% for i in items %}{% set ns.c = ns.c + 1 %}{% endfor %}{{ ns.c }}`: `3`,
+	`{% set x = 1 %}{% for i in items %}{% set x = 2 %}{% endfor %}{{ x }}`:                            `1`,
+	`{% with a = 1, b = n %}{{ a + b }}{% endwith %}{{ a }}`:                                           `8`,
+	`{% filter upper %}{{ author.name }}{% endfilter %}`:                                               `GEARSIX`,
+	`{% macro m(a, b="B") %}{{ a }}{{ b }}{% endmacro %}{{ m(1) }}{{ m(1, b=2) }}{{ m("<") }}`:         `1B12<B`,
+	`{% macro m() %}[{{ caller() }}]{% endmacro %}{% call m() %}{{ n }}{% endcall %}`:                  `[7]`,
+	`{% macro m() %}{{ varargs|length }}{{ kwargs.x }}{% endmacro %}{{ m(1, 2, x=3) }}`:                `23`,
+	`{% include "partial" %}`:                                                 `gearsix`,
+	`{% for i in items %}{% include "item" %}{% endfor %}`:                    `[a][b][c]`,
+	`{% include ["missing", "item"] %}{% include "missing" ignore missing %}`: `[]`,
+	`{% import "macros" as m %}{{ m.bold(n) }}`:                               `<b>7</b>`,
+	`{% from "macros" import bold as b, x %}{{ b(x) }}`:                       `<b>1</b>`,
+	`{% extends "base" %}{% block head %}{{ super() }}!{% endblock %}ignored`: `[head!|body]`,
+	`{% extends "base" %}{% block body %}{{ title }}{% endblock %}`:           `[head|<eg>]`,
+	`{% extends "child" %}{% block body %}{{ super() }}{{ n }}{% endblock %}`: `[head|child7]`,
+	`{% block x %}{{ n }}{% endblock %}{{ self.x() }}`:                        `77`,
+}
+
+var jinjaPartials = map[string]string{
+	"partial": `{{ author.name }}`,
+	"item":    `[{{ i }}]`,
+	"macros":  `{% set x = 1 %}{% macro bold(s) %}<b>{{ s }}</b>{% endmacro %}`,
+	"base":    `[{% block head %}head{% endblock %}|{% block body %}body{% endblock %}]`,
+	"child":   `{% extends "base" %}{% block body %}child{% endblock %}`,
+}
+
+var jinjaBad = []string{
+	`{{ title`,
+	`{{ title }`,
+	`{% if title %}x`,
+	`{% if title %}x{% endfor %}`,
+	`{% endif %}`,
+	`{% for %}{% endfor %}`,
+	`{% bogus %}`,
+	`{{ title|missing }}`,
+	`{{ title is missing }}`,
+	`{{ 1 + }}`,
+	`{{ (1 }}`,
+	`{{ 1 / 0 }}`,
+	`{{ "a" - 1 }}`,
+	`{{ n < "a" }}`,
+	`{% include "missing" %}`,
+	`{% extends "missing" %}`,
+	`{{ missing() }}`,
+	`{{ title() }}`,
+	`{{ range(1, 2, 0) }}`,
+	`{{ range(100000000) }}`,
+	`{{ range(-9000000000000000000, 9000000000000000000) }}`,
+	`{{ "a" * 100000000000 }}`,
+	`{{ [1] * 100000000000 }}`,
+	`{{ "a"|indent(-3) }}`,
+	`{{ "a"|indent(100000000000) }}`,
+	`{{ title|tojson(indent=-1) }}`,
+	`{{ "a"|center(100000000000) }}`,
+	`{{ [1]|batch(100000000000, 0) }}`,
+}
+
+func TestExecuteJinja(t *testing.T) {
+	var data map[string]interface{}
+	if err := LoadData(JSON, strings.NewReader(jinjaData), &data); err != nil {
+		t.Skip("setup failure:", err)
+	}
+
+	for root, expect := range jinjaGood {
+		tmpl, err := LoadTemplateString(JINJA, "root", root, jinjaPartials)
+		if err != nil {
+			t.Fatalf("'%s' failed to load: %s", root, err)
+		}
+		result, err := tmpl.Execute(data)
+		if err != nil {
+			t.Fatalf("'%s' failed to execute: %s", root, err)
+		}
+		validateExecute(t, result.String(), expect, err)
+	}
+
+	for _, root := range jinjaBad {
+		tmpl, err := LoadTemplateString(JINJA, "root", root, jinjaPartials)
+		if err == nil {
+			_, err = tmpl.Execute(data)
+		}
+		if err == nil {
+			t.Fatalf("bad template passed: '%s'", root)
+		} else if !strings.HasPrefix(err.Error(), "root:") {
+			t.Fatalf("error does not indicate the template: %s", err)
+		}
+	}
+}
+
+func TestExecuteJinjaStruct(t *testing.T) {
+	type item struct {
+		Name  string
+		Items []int
+	}
+	tmpl, err := LoadTemplateString(JINJA, "root", `{{ Name }}:{% for i in Items %}{{ i }}{% endfor %}`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := tmpl.Execute(item{Name: "eg", Items: []int{1, 2}})
+	validateExecute(t, result.String(), "eg:12", err)
+}
+
+func TestExecuteJinjaRecursive(t *testing.T) {
+	tmpl, err := LoadTemplateString(JINJA, "root", `{% include "loop" %}`,
+		map[string]string{"loop": `{% include "loop" %}`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = tmpl.Execute(nil); err == nil {
+		t.Fatal("recursive include passed")
+	}
+
+	tmpl, err = LoadTemplateString(JINJA, "root", `{% extends "a" %}`,
+		map[string]string{"a": `{% extends "b" %}`, "b": `{% extends "a" %}`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = tmpl.Execute(nil); err == nil {
+		t.Fatal("recursive extends passed")
+	}
+}
+
+func TestRegisterJinjaFilter(t *testing.T) {
+	RegisterJinjaFilter("wrap", func(v interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("wrap requires one argument")
+		}
+		return fmt.Sprint(args[0], v, args[0]), nil
+	})
+	defer RegisterJinjaFilter("wrap", nil)
+
+	tmpl, err := LoadTemplateString(JINJA, "root", `{{ name|wrap("*") }}{{ name|wrap(1)|upper }}`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := tmpl.Execute(map[string]string{"name": "eg"})
+	validateExecute(t, result.String(), "*eg*1EG1", err)
+
+	tmpl, err = LoadTemplateString(JINJA, "root", `{{ name|wrap }}`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = tmpl.Execute(nil); err == nil || !strings.Contains(err.Error(), "wrap requires one argument") {
+		t.Fatalf("filter error not returned: %v", err)
+	}
+
+	RegisterJinjaFilter("wrap", nil)
+	if _, err = LoadTemplateString(JINJA, "root", `{{ name|wrap("*") }}`, nil); err == nil {
+		t.Fatal("removed filter passed")
+	}
+}
+
+func TestLoadTemplateFileJinja(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dati")
+	if err != nil {
+		t.Skip("setup failure:", err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"page.j2":      `{% extends "base.jinja" %}{% block body %}{% for i in items %}{% include "item.j2" %}{% endfor %}{% endblock %}`,
+		"base.jinja":   `{{ title }}:{% block body %}{% endblock %}`,
+		"item.j2":      `{{ i }}`,
+		"ignored.tmpl": `{{.eg}}`,
+	}
+	var partials []string
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Skip("setup failure:", err)
+		}
+		if name != "page.j2" {
+			partials = append(partials, path)
+		}
+	}
+
+	tmpl, err := LoadTemplateFile(filepath.Join(dir, "page.j2"), partials...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := tmpl.Execute(map[string]interface{}{"title": "eg", "items": []int{1, 2}})
+	validateExecute(t, result.String(), "eg:12", err)
+}
@@ -0,0 +1,1605 @@
+package dati
+
+/*
+Copyright (C) 2023 gearsix <gearsix@tuta.io>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const LIQUID TemplateLanguage = "liquid"
+
+func init() {
+	RegisterTemplateLanguage(LIQUID, []string{"liquid"}, EngineFunc(loadTemplateLiquid))
+}
+
+// the maximum number of templates that can be nested (by {% include %} or
+// {% render %}) when executing a liquid template
+const liquidMaxDepth = 1000
+
+// the maximum number of items in a range literal (e.g. "(1..10)"), so
+// templates can't exhaust memory or loop forever
+const liquidMaxRange = 100000
+
+// liquidTemplate is a parsed liquid template, it implements *Executable*.
+type liquidTemplate struct {
+	name     string
+	nodes    []liquidNode
+	partials map[string]*liquidTemplate // shared by all templates parsed together
+}
+
+// loadTemplateLiquid parses `root` and `partials` as liquid templates (see
+// https://shopify.github.io/liquid/). Templates used by {% include %} and
+// {% render %} are looked up in `partials` by name, "name.liquid" is tried
+// if "name" isn't found. Jekyll-style includes are also supported
+// (`{% include note.html title="eg" %}`, where `include.title` is "eg").
+// Whole numbers in the data are treated as integers, since formats like JSON
+// decode every number as a float64. Float literals (e.g. "4.0") and the
+// results of float arithmetic are never treated as integers.
+func loadTemplateLiquid(rootName string, root io.Reader, partials map[string]io.Reader) (Executable, error) {
+	all := make(map[string]*liquidTemplate)
+
+	for name, partial := range partials {
+		buf, err := ioutil.ReadAll(partial)
+		if err != nil {
+			return nil, err
+		}
+		if all[name], err = parseLiquid(name, string(buf)); err != nil {
+			return nil, err
+		}
+		all[name].partials = all
+	}
+
+	buf, err := ioutil.ReadAll(root)
+	if err != nil {
+		return nil, err
+	}
+	t, err := parseLiquid(rootName, string(buf))
+	if err != nil {
+		return nil, err
+	}
+	t.partials = all
+	return t, nil
+}
+
+func (t *liquidTemplate) Execute(w io.Writer, data interface{}) error {
+	s := &liquidState{
+		partials: t.partials,
+		counters: make(map[string]int),
+		cycles:   make(map[string]int),
+		offsets:  make(map[string]int),
+		err:      writerErr(w),
+	}
+	c := &liquidContext{name: t.name, scopes: []map[string]interface{}{{}}, data: data}
+	err := s.renderTemplate(w, t, c)
+	if err == errLiquidBreak || err == errLiquidContinue {
+		err = nil
+	}
+	return err
+}
+
+/* lexing */
+
+type liquidTokenKind int
+
+const (
+	liquidTokText liquidTokenKind = iota
+	liquidTokOutput
+	liquidTokTag
+)
+
+// liquidToken is a piece of text, an {{ output }} or a {% tag %}
+type liquidToken struct {
+	kind   liquidTokenKind
+	name   string // the tag name
+	markup string // the text, or the contents of the output or tag
+	line   int
+}
+
+var (
+	liquidOpen     = regexp.MustCompile(`\{[{%]`)
+	liquidRawEnd   = regexp.MustCompile(`\{%-?\s*endraw\s*-?%\}`)
+	liquidComments = regexp.MustCompile(`\{%-?\s*(end)?comment\s*(-?)%\}`)
+	liquidTagName  = regexp.MustCompile(`^(#|[A-Za-z_]\w*)`)
+)
+
+type liquidLexer struct {
+	src      string
+	pos      int
+	line     int
+	trimNext bool
+	tokens   []liquidToken
+}
+
+func (l *liquidLexer) advance(n int) string {
+	s := l.src[l.pos : l.pos+n]
+	l.line += strings.Count(s, "\n")
+	l.pos += n
+	return s
+}
+
+func (l *liquidLexer) text(s string) {
+	if l.trimNext {
+		s = strings.TrimLeft(s, " \t\r\n")
+		l.trimNext = false
+	}
+	if len(s) > 0 {
+		l.tokens = append(l.tokens, liquidToken{kind: liquidTokText, markup: s, line: l.line})
+	}
+}
+
+// trimPrev trims the trailing whitespace of the last text token
+func (l *liquidLexer) trimPrev() {
+	if n := len(l.tokens); n > 0 && l.tokens[n-1].kind == liquidTokText {
+		l.tokens[n-1].markup = strings.TrimRight(l.tokens[n-1].markup, " \t\r\n")
+	}
+}
+
+// tagEnd returns the index of `closer` in l.src, ignoring quoted strings
+func (l *liquidLexer) tagEnd(from int, closer string) int {
+	var quote byte
+	for i := from; i < len(l.src); i++ {
+		switch c := l.src[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case strings.HasPrefix(l.src[i:], closer):
+			return i
+		}
+	}
+	return -1
+}
+
+func lexLiquid(src string) ([]liquidToken, error) {
+	l := &liquidLexer{src: src, line: 1}
+	for l.pos < len(l.src) {
+		next := liquidOpen.FindStringIndex(l.src[l.pos:])
+		if next == nil {
+			l.text(l.advance(len(l.src) - l.pos))
+			break
+		}
+		l.text(l.advance(next[0]))
+
+		line := l.line
+		closer := "}}"
+		if l.src[l.pos+1] == '%' {
+			closer = "%}"
+		}
+		end := l.tagEnd(l.pos+2, closer)
+		if end < 0 {
+			return nil, liquidSyntaxErrorf(line, "'%s' was never closed", l.src[l.pos:l.pos+2])
+		}
+		markup := l.advance(end + 2 - l.pos)
+		markup = markup[2 : len(markup)-2]
+		if strings.HasPrefix(markup, "-") {
+			markup = markup[1:]
+			l.trimPrev()
+		}
+		trimNext := false
+		if strings.HasSuffix(markup, "-") {
+			markup = markup[:len(markup)-1]
+			trimNext = true
+		}
+		markup = strings.TrimSpace(markup)
+
+		if closer == "}}" {
+			l.tokens = append(l.tokens, liquidToken{kind: liquidTokOutput, markup: markup, line: line})
+			l.trimNext = trimNext
+			continue
+		}
+
+		name := liquidTagName.FindString(markup)
+		markup = strings.TrimSpace(markup[len(name):])
+		l.trimNext = trimNext
+		switch name {
+		case "":
+			return nil, liquidSyntaxErrorf(line, "missing tag name")
+		case "#":
+		case "raw":
+			m := liquidRawEnd.FindStringIndex(l.src[l.pos:])
+			if m == nil {
+				return nil, liquidSyntaxErrorf(line, "'raw' tag was never closed")
+			}
+			raw := l.advance(m[0])
+			if strings.HasPrefix(l.src[l.pos:], "{%-") {
+				raw = strings.TrimRight(raw, " \t\r\n")
+			}
+			if l.trimNext {
+				raw = strings.TrimLeft(raw, " \t\r\n")
+			}
+			l.tokens = append(l.tokens, liquidToken{kind: liquidTokText, markup: raw, line: line})
+			end := l.advance(m[1] - m[0])
+			l.trimNext = strings.HasSuffix(end, "-%}")
+		case "comment":
+			if err := l.skipComment(line); err != nil {
+				return nil, err
+			}
+		case "liquid":
+			if err := l.liquidTag(markup, line); err != nil {
+				return nil, err
+			}
+		default:
+			l.tokens = append(l.tokens, liquidToken{kind: liquidTokTag, name: name, markup: markup, line: line})
+		}
+	}
+	return l.tokens, nil
+}
+
+// skipComment skips to the end of a (possibly nested) {% comment %} block
+func (l *liquidLexer) skipComment(line int) error {
+	for depth := 1; depth > 0; {
+		m := liquidComments.FindStringSubmatchIndex(l.src[l.pos:])
+		if m == nil {
+			return liquidSyntaxErrorf(line, "'comment' tag was never closed")
+		}
+		if m[2] < 0 {
+			depth++
+		} else {
+			depth--
+		}
+		l.advance(m[1])
+		l.trimNext = m[5] > m[4]
+	}
+	return nil
+}
+
+// liquidTag adds a tag token for each line in the markup of a {% liquid %}
+// tag.
+func (l *liquidLexer) liquidTag(markup string, line int) error {
+	comment := 0
+	for i, s := range strings.Split(markup, "\n") {
+		s = strings.TrimSpace(s)
+		name := liquidTagName.FindString(s)
+		switch {
+		case name == "comment":
+			comment++
+		case name == "endcomment" && comment > 0:
+			comment--
+		case comment > 0 || name == "#" || s == "":
+		case name == "":
+			return liquidSyntaxErrorf(line+i, "missing tag name")
+		default:
+			l.tokens = append(l.tokens, liquidToken{
+				kind:   liquidTokTag,
+				name:   name,
+				markup: strings.TrimSpace(s[len(name):]),
+				line:   line + i,
+			})
+		}
+	}
+	if comment > 0 {
+		return liquidSyntaxErrorf(line, "'comment' tag was never closed")
+	}
+	return nil
+}
+
+// liquidTok is a token in the markup of an output or tag
+type liquidTok struct {
+	kind byte // 'n'ame, 's'tring, 'i'nt, 'f'loat or 'p'unctuation
+	val  string
+}
+
+var liquidMarkupToken = regexp.MustCompile(`^(?:` +
+	`(?P<s>"[^"]*"|'[^']*')|` +
+	`(?P<f>-?\d+\.\d+)|` +
+	`(?P<i>-?\d+)|` +
+	`(?P<n>[A-Za-z_][\w-]*\??)|` +
+	`(?P<p>==|!=|<>|<=|>=|\.\.|[<>.\[\]():,|=]))`)
+
+func lexLiquidMarkup(markup string) ([]liquidTok, error) {
+	var toks []liquidTok
+	kinds := liquidMarkupToken.SubexpNames()
+	for markup = strings.TrimSpace(markup); len(markup) > 0; markup = strings.TrimSpace(markup) {
+		m := liquidMarkupToken.FindStringSubmatchIndex(markup)
+		if m == nil {
+			return nil, fmt.Errorf("unexpected character '%c'", markup[0])
+		}
+		for i := 1; i < len(kinds); i++ {
+			if m[i*2] >= 0 {
+				val := markup[m[i*2]:m[i*2+1]]
+				if kinds[i] == "s" {
+					val = val[1 : len(val)-1]
+				}
+				toks = append(toks, liquidTok{kinds[i][0], val})
+				break
+			}
+		}
+		markup = markup[m[1]:]
+	}
+	return toks, nil
+}
+
+/* parsing */
+
+type liquidNode interface{}
+
+type liquidOutput struct {
+	line int
+	expr *liquidFiltered
+}
+
+type liquidBranch struct {
+	cond liquidExpr // nil for {% else %}
+	body []liquidNode
+}
+
+type liquidIf struct {
+	line     int
+	unless   bool
+	branches []liquidBranch
+}
+
+type liquidWhen struct {
+	vals []liquidExpr
+	body []liquidNode
+}
+
+type liquidCase struct {
+	line  int
+	val   liquidExpr
+	whens []liquidWhen
+	els   []liquidNode
+}
+
+type liquidFor struct {
+	line     int
+	tablerow bool
+	name     string
+	markup   string // the collection markup, used by `offset:continue`
+	iter     liquidExpr
+	reversed bool
+	limit    liquidExpr
+	offset   liquidExpr
+	cont     bool // offset:continue
+	cols     liquidExpr
+	body     []liquidNode
+	els      []liquidNode
+}
+
+type liquidLoopControl struct {
+	line int
+	err  error // errLiquidBreak or errLiquidContinue
+}
+
+type liquidCycle struct {
+	line  int
+	group liquidExpr
+	vals  []liquidExpr
+	key   string
+}
+
+type liquidAssign struct {
+	line int
+	name string
+	expr *liquidFiltered
+}
+
+type liquidCapture struct {
+	line int
+	name string
+	body []liquidNode
+}
+
+type liquidCounter struct {
+	line  int
+	name  string
+	delta int
+}
+
+type liquidParam struct {
+	name string
+	val  liquidExpr
+}
+
+type liquidInclude struct {
+	line    int
+	render  bool // {% render %} has an isolated scope
+	tmpl    liquidExpr
+	alias   string
+	with    liquidExpr
+	forEach bool
+	params  []liquidParam
+	jekyll  bool // params are set in `include`
+}
+
+type liquidExpr interface{}
+
+type liquidLiteral struct {
+	v interface{}
+}
+
+type liquidVariable struct {
+	name string
+	base liquidExpr // set for keys of a literal (e.g. `"abc".size`)
+	keys []liquidExpr
+}
+
+type liquidRange struct {
+	from, to liquidExpr
+}
+
+type liquidCompare struct {
+	op   string
+	l, r liquidExpr
+}
+
+type liquidLogic struct {
+	op   string // "and" or "or"
+	l, r liquidExpr
+}
+
+type liquidFilterCall struct {
+	name   string
+	args   []liquidExpr
+	kwargs []liquidParam
+}
+
+type liquidFiltered struct {
+	val     liquidExpr
+	filters []liquidFilterCall
+}
+
+type liquidParser struct {
+	tokens []liquidToken
+	pos    int
+}
+
+// liquidSyntaxError is an error found when parsing a liquid template, at
+// `line` of the template.
+type liquidSyntaxError struct {
+	line int
+	msg  string
+}
+
+func (err liquidSyntaxError) Error() string {
+	return fmt.Sprintf("%d: %s", err.line, err.msg)
+}
+
+func liquidSyntaxErrorf(line int, format string, args ...interface{}) error {
+	return liquidSyntaxError{line, fmt.Sprintf(format, args...)}
+}
+
+// liquidLineError returns `err` as a *liquidSyntaxError* at `line`, unless
+// it already is one (e.g. from a nested block).
+func liquidLineError(line int, err error) error {
+	if _, ok := err.(liquidSyntaxError); ok {
+		return err
+	}
+	return liquidSyntaxError{line, err.Error()}
+}
+
+func parseLiquid(name, src string) (*liquidTemplate, error) {
+	tokens, err := lexLiquid(src)
+	if err != nil {
+		return nil, fmt.Errorf("%s:%s", name, err)
+	}
+	p := &liquidParser{tokens: tokens}
+	nodes, _, err := p.parseBlock("")
+	if err != nil {
+		return nil, fmt.Errorf("%s:%s", name, err)
+	}
+	return &liquidTemplate{name: name, nodes: nodes}, nil
+}
+
+// parseBlock parses nodes until a tag named in `end` is found, which is
+// returned. If `end` is empty, nodes are parsed until the end of the
+// template. `opener` is the tag that opened the block, for errors.
+func (p *liquidParser) parseBlock(opener string, end ...string) ([]liquidNode, *liquidToken, error) {
+	var nodes []liquidNode
+	for ; p.pos < len(p.tokens); p.pos++ {
+		tok := &p.tokens[p.pos]
+		switch tok.kind {
+		case liquidTokText:
+			nodes = append(nodes, tok.markup)
+			continue
+		case liquidTokOutput:
+			expr, err := parseLiquidMarkup(tok.markup, (*liquidMarkupParser).filtered)
+			if err != nil {
+				return nil, nil, liquidLineError(tok.line, err)
+			}
+			nodes = append(nodes, &liquidOutput{tok.line, expr.(*liquidFiltered)})
+			continue
+		}
+
+		for _, e := range end {
+			if tok.name == e {
+				return nodes, tok, nil
+			}
+		}
+		node, err := p.parseTag(tok)
+		if err != nil {
+			return nil, nil, liquidLineError(tok.line, err)
+		}
+		nodes = append(nodes, node)
+	}
+	if len(end) > 0 {
+		return nil, nil, liquidSyntaxErrorf(p.tokens[len(p.tokens)-1].line, "'%s' tag was never closed", opener)
+	}
+	return nodes, nil, nil
+}
+
+func (p *liquidParser) parseTag(tok *liquidToken) (liquidNode, error) {
+	var node liquidNode
+	var err error
+	switch tok.name {
+	case "if", "unless":
+		node, err = p.parseIf(tok)
+	case "case":
+		node, err = p.parseCase(tok)
+	case "for", "tablerow":
+		node, err = p.parseFor(tok)
+	case "break":
+		node = &liquidLoopControl{tok.line, errLiquidBreak}
+	case "continue":
+		node = &liquidLoopControl{tok.line, errLiquidContinue}
+	case "cycle":
+		node, err = parseLiquidCycle(tok)
+	case "assign":
+		node, err = parseLiquidAssign(tok)
+	case "capture":
+		node, err = p.parseCapture(tok)
+	case "increment", "decrement":
+		node, err = parseLiquidCounter(tok)
+	case "include", "render":
+		node, err = parseLiquidInclude(tok)
+	case "echo":
+		var expr liquidExpr
+		if expr, err = parseLiquidMarkup(tok.markup, (*liquidMarkupParser).filtered); err == nil {
+			node = &liquidOutput{tok.line, expr.(*liquidFiltered)}
+		}
+	default:
+		err = fmt.Errorf("unexpected tag '%s'", tok.name)
+	}
+	return node, err
+}
+
+func (p *liquidParser) parseIf(tok *liquidToken) (liquidNode, error) {
+	n := &liquidIf{line: tok.line, unless: tok.name == "unless"}
+	cond, err := parseLiquidMarkup(tok.markup, (*liquidMarkupParser).condition)
+	for err == nil {
+		p.pos++
+		var body []liquidNode
+		var end *liquidToken
+		if body, end, err = p.parseBlock(tok.name, "elsif", "else", "end"+tok.name); err != nil {
+			return nil, err
+		}
+		n.branches = append(n.branches, liquidBranch{cond, body})
+
+		switch end.name {
+		case "elsif":
+			if cond == nil {
+				return nil, liquidSyntaxErrorf(end.line, "'elsif' after 'else'")
+			}
+			cond, err = parseLiquidMarkup(end.markup, (*liquidMarkupParser).condition)
+		case "else":
+			if cond == nil {
+				return nil, liquidSyntaxErrorf(end.line, "'else' after 'else'")
+			}
+			cond = nil
+		default:
+			return n, nil
+		}
+	}
+	return nil, err
+}
+
+func (p *liquidParser) parseCase(tok *liquidToken) (liquidNode, error) {
+	val, err := parseLiquidMarkup(tok.markup, (*liquidMarkupParser).value)
+	if err != nil {
+		return nil, err
+	}
+	n := &liquidCase{line: tok.line, val: val}
+
+	p.pos++
+	body, end, err := p.parseBlock("case", "when", "else", "endcase")
+	if err != nil {
+		return nil, err
+	}
+	for _, node := range body {
+		if s, ok := node.(string); !ok || len(strings.TrimSpace(s)) > 0 {
+			return nil, liquidSyntaxErrorf(tok.line, "'case' must be followed by 'when' or 'else'")
+		}
+	}
+	hasElse := false
+	for end.name != "endcase" {
+		if hasElse {
+			return nil, liquidSyntaxErrorf(end.line, "'%s' after 'else'", end.name)
+		}
+		var when liquidWhen
+		if end.name == "when" {
+			v, err := parseLiquidMarkup(end.markup, (*liquidMarkupParser).when)
+			if err != nil {
+				return nil, liquidLineError(end.line, err)
+			}
+			when.vals = v.([]liquidExpr)
+		}
+		p.pos++
+		if when.body, end, err = p.parseBlock("case", "when", "else", "endcase"); err != nil {
+			return nil, err
+		}
+		if when.vals == nil {
+			n.els, hasElse = when.body, true
+		} else {
+			n.whens = append(n.whens, when)
+		}
+	}
+	return n, nil
+}
+
+func (p *liquidParser) parseFor(tok *liquidToken) (liquidNode, error) {
+	n := &liquidFor{line: tok.line, tablerow: tok.name == "tablerow"}
+	_, err := parseLiquidMarkup(tok.markup, func(mp *liquidMarkupParser) (liquidExpr, error) {
+		return nil, mp.forParams(n)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	p.pos++
+	end := "end" + tok.name
+	body, endTok, err := p.parseBlock(tok.name, "else", end)
+	if err != nil {
+		return nil, err
+	}
+	n.body = body
+	if endTok.name == "else" && !n.tablerow {
+		p.pos++
+		if n.els, _, err = p.parseBlock(tok.name, end); err != nil {
+			return nil, err
+		}
+	} else if endTok.name == "else" {
+		return nil, liquidSyntaxErrorf(endTok.line, "unexpected tag 'else'")
+	}
+	return n, nil
+}
+
+func (p *liquidParser) parseCapture(tok *liquidToken) (liquidNode, error) {
+	name, err := parseLiquidMarkup(tok.markup, (*liquidMarkupParser).variableName)
+	if err != nil {
+		return nil, err
+	}
+	p.pos++
+	body, _, err := p.parseBlock("capture", "endcapture")
+	if err != nil {
+		return nil, err
+	}
+	return &liquidCapture{tok.line, name.(string), body}, nil
+}
+
+func parseLiquidCycle(tok *liquidToken) (liquidNode, error) {
+	n := &liquidCycle{line: tok.line, key: tok.markup}
+	_, err := parseLiquidMarkup(tok.markup, func(mp *liquidMarkupParser) (liquidExpr, error) {
+		for {
+			v, err := mp.value()
+			if err != nil {
+				return nil, err
+			}
+			if len(n.vals) == 0 && n.group == nil && mp.accept("p", ":") {
+				n.group = v
+				continue
+			}
+			n.vals = append(n.vals, v)
+			if !mp.accept("p", ",") {
+				return nil, nil
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if n.group != nil {
+		n.key = ""
+	}
+	return n, nil
+}
+
+func parseLiquidAssign(tok *liquidToken) (liquidNode, error) {
+	n := &liquidAssign{line: tok.line}
+	_, err := parseLiquidMarkup(tok.markup, func(mp *liquidMarkupParser) (liquidExpr, error) {
+		name, err := mp.variableName()
+		if err != nil {
+			return nil, err
+		} else if err = mp.expect("p", "="); err != nil {
+			return nil, err
+		}
+		n.name = name.(string)
+		expr, err := mp.filtered()
+		if err == nil {
+			n.expr = expr.(*liquidFiltered)
+		}
+		return nil, err
+	})
+	return n, err
+}
+
+func parseLiquidCounter(tok *liquidToken) (liquidNode, error) {
+	name, err := parseLiquidMarkup(tok.markup, (*liquidMarkupParser).variableName)
+	if err != nil {
+		return nil, err
+	}
+	delta := 1
+	if tok.name == "decrement" {
+		delta = -1
+	}
+	return &liquidCounter{tok.line, name.(string), delta}, nil
+}
+
+// liquidIncludeName matches an unquoted template name that's a file path
+var liquidIncludeName = regexp.MustCompile(`^[^\s,'"]*[./][^\s,'"]*`)
+
+func parseLiquidInclude(tok *liquidToken) (liquidNode, error) {
+	n := &liquidInclude{line: tok.line, render: tok.name == "render"}
+	markup := tok.markup
+	if path := liquidIncludeName.FindString(markup); len(path) > 0 {
+		n.tmpl = &liquidLiteral{path}
+		n.jekyll = !n.render
+		markup = markup[len(path):]
+	}
+
+	_, err := parseLiquidMarkup(markup, func(mp *liquidMarkupParser) (liquidExpr, error) {
+		var err error
+		if n.tmpl == nil {
+			if n.tmpl, err = mp.value(); err != nil {
+				return nil, err
+			}
+		}
+		if mp.accept("n", "with") || mp.accept("n", "for") {
+			n.forEach = mp.toks[mp.pos-1].val == "for"
+			if n.with, err = mp.value(); err != nil {
+				return nil, err
+			}
+			if mp.accept("n", "as") {
+				if mp.peek().kind != 'n' {
+					return nil, fmt.Errorf("expected a name after 'as'")
+				}
+				n.alias = mp.next().val
+			}
+		}
+		for mp.peek().kind != 0 {
+			mp.accept("p", ",")
+			if mp.peek().kind != 'n' {
+				return nil, fmt.Errorf("unexpected '%s'", mp.peek().val)
+			}
+			param := liquidParam{name: mp.next().val}
+			if mp.accept("p", "=") {
+				n.jekyll = true
+			} else if err = mp.expect("p", ":"); err != nil {
+				return nil, err
+			}
+			if param.val, err = mp.value(); err != nil {
+				return nil, err
+			}
+			n.params = append(n.params, param)
+		}
+		return nil, nil
+	})
+	return n, err
+}
+
+// liquidMarkupParser parses the markup of an output or tag
+type liquidMarkupParser struct {
+	toks []liquidTok
+	pos  int
+}
+
+// parseLiquidMarkup lexes `markup` and parses it with `parse`, which must
+// consume all of the markup.
+func parseLiquidMarkup(markup string, parse func(*liquidMarkupParser) (liquidExpr, error)) (liquidExpr, error) {
+	toks, err := lexLiquidMarkup(markup)
+	if err != nil {
+		return nil, err
+	}
+	mp := &liquidMarkupParser{toks: toks}
+	expr, err := parse(mp)
+	if err == nil && mp.pos < len(mp.toks) {
+		err = fmt.Errorf("unexpected '%s'", mp.toks[mp.pos].val)
+	}
+	return expr, err
+}
+
+func (mp *liquidMarkupParser) peek() liquidTok {
+	if mp.pos < len(mp.toks) {
+		return mp.toks[mp.pos]
+	}
+	return liquidTok{}
+}
+
+func (mp *liquidMarkupParser) next() liquidTok {
+	tok := mp.peek()
+	if mp.pos < len(mp.toks) {
+		mp.pos++
+	}
+	return tok
+}
+
+func (mp *liquidMarkupParser) accept(kind string, vals ...string) bool {
+	tok := mp.peek()
+	if tok.kind != kind[0] {
+		return false
+	}
+	for _, v := range vals {
+		if tok.val == v {
+			mp.pos++
+			return true
+		}
+	}
+	return false
+}
+
+func (mp *liquidMarkupParser) expect(kind, val string) error {
+	if !mp.accept(kind, val) {
+		if tok := mp.peek(); tok.kind != 0 {
+			return fmt.Errorf("expected '%s', found '%s'", val, tok.val)
+		}
+		return fmt.Errorf("expected '%s'", val)
+	}
+	return nil
+}
+
+func (mp *liquidMarkupParser) variableName() (liquidExpr, error) {
+	if tok := mp.next(); tok.kind == 'n' {
+		return tok.val, nil
+	} else if tok.kind != 0 {
+		return nil, fmt.Errorf("invalid variable name '%s'", tok.val)
+	}
+	return nil, fmt.Errorf("missing variable name")
+}
+
+func (mp *liquidMarkupParser) value() (liquidExpr, error) {
+	tok := mp.next()
+	switch tok.kind {
+	case 's':
+		if next := mp.peek(); next.kind == 'p' && (next.val == "." || next.val == "[") {
+			v, err := mp.variable("", nil)
+			if err == nil {
+				v.(*liquidVariable).base = &liquidLiteral{tok.val}
+			}
+			return v, err
+		}
+		return &liquidLiteral{tok.val}, nil
+	case 'i':
+		i, err := strconv.Atoi(tok.val)
+		return &liquidLiteral{i}, err
+	case 'f':
+		f, err := strconv.ParseFloat(tok.val, 64)
+		return &liquidLiteral{liquidFloat(f)}, err
+	case 'n':
+		switch tok.val {
+		case "true":
+			return &liquidLiteral{true}, nil
+		case "false":
+			return &liquidLiteral{false}, nil
+		case "nil", "null":
+			return &liquidLiteral{nil}, nil
+		case "empty":
+			return &liquidLiteral{liquidEmpty{}}, nil
+		case "blank":
+			return &liquidLiteral{liquidBlank{}}, nil
+		}
+		return mp.variable(tok.val, nil)
+	case 'p':
+		if tok.val == "(" {
+			from, err := mp.value()
+			if err != nil {
+				return nil, err
+			} else if err = mp.expect("p", ".."); err != nil {
+				return nil, err
+			}
+			to, err := mp.value()
+			if err != nil {
+				return nil, err
+			}
+			return &liquidRange{from, to}, mp.expect("p", ")")
+		} else if tok.val == "[" {
+			key, err := mp.value()
+			if err != nil {
+				return nil, err
+			}
+			if err = mp.expect("p", "]"); err != nil {
+				return nil, err
+			}
+			return mp.variable("", []liquidExpr{key})
+		}
+		return nil, fmt.Errorf("unexpected '%s'", tok.val)
+	}
+	return nil, fmt.Errorf("missing value")
+}
+
+func (mp *liquidMarkupParser) variable(name string, keys []liquidExpr) (liquidExpr, error) {
+	for {
+		if mp.accept("p", ".") {
+			tok := mp.next()
+			if tok.kind != 'n' && tok.kind != 'i' {
+				return nil, fmt.Errorf("expected a name after '.'")
+			}
+			if tok.kind == 'i' {
+				i, _ := strconv.Atoi(tok.val)
+				keys = append(keys, &liquidLiteral{i})
+			} else {
+				keys = append(keys, &liquidLiteral{tok.val})
+			}
+		} else if mp.accept("p", "[") {
+			key, err := mp.value()
+			if err != nil {
+				return nil, err
+			} else if err = mp.expect("p", "]"); err != nil {
+				return nil, err
+			}
+			keys = append(keys, key)
+		} else {
+			return &liquidVariable{name: name, keys: keys}, nil
+		}
+	}
+}
+
+func (mp *liquidMarkupParser) filtered() (liquidExpr, error) {
+	val, err := mp.value()
+	if err != nil {
+		return nil, err
+	}
+	f := &liquidFiltered{val: val}
+	for mp.accept("p", "|") {
+		tok := mp.next()
+		if tok.kind != 'n' {
+			return nil, fmt.Errorf("missing filter name")
+		} else if _, ok := getLiquidFilter(tok.val); !ok {
+			return nil, fmt.Errorf("unknown filter '%s'", tok.val)
+		}
+		call := liquidFilterCall{name: tok.val}
+		if mp.accept("p", ":") {
+			for {
+				if mp.peek().kind == 'n' && mp.pos+1 < len(mp.toks) &&
+					mp.toks[mp.pos+1].kind == 'p' && mp.toks[mp.pos+1].val == ":" {
+					param := liquidParam{name: mp.next().val}
+					mp.next()
+					if param.val, err = mp.value(); err != nil {
+						return nil, err
+					}
+					call.kwargs = append(call.kwargs, param)
+				} else {
+					arg, err := mp.value()
+					if err != nil {
+						return nil, err
+					}
+					call.args = append(call.args, arg)
+				}
+				if !mp.accept("p", ",") {
+					break
+				}
+			}
+		}
+		f.filters = append(f.filters, call)
+	}
+	return f, nil
+}
+
+// condition parses a condition, `and` & `or` are evaluated right to left
+func (mp *liquidMarkupParser) condition() (liquidExpr, error) {
+	l, err := mp.value()
+	if err != nil {
+		return nil, err
+	}
+	if mp.accept("p", "==", "!=", "<>", "<", ">", "<=", ">=") || mp.accept("n", "contains") {
+		op := mp.toks[mp.pos-1].val
+		r, err := mp.value()
+		if err != nil {
+			return nil, err
+		}
+		l = &liquidCompare{op, l, r}
+	}
+	if mp.accept("n", "and", "or") {
+		op := mp.toks[mp.pos-1].val
+		r, err := mp.condition()
+		if err != nil {
+			return nil, err
+		}
+		return &liquidLogic{op, l, r}, nil
+	}
+	return l, nil
+}
+
+// when parses the values of a {% when %} tag
+func (mp *liquidMarkupParser) when() (liquidExpr, error) {
+	var vals []liquidExpr
+	for {
+		v, err := mp.value()
+		if err != nil {
+			return nil, err
+		}
+		vals = append(vals, v)
+		if !mp.accept("p", ",") && !mp.accept("n", "or") {
+			return vals, nil
+		}
+	}
+}
+
+// forParams parses the markup of a {% for %} or {% tablerow %} tag
+func (mp *liquidMarkupParser) forParams(n *liquidFor) error {
+	name, err := mp.variableName()
+	if err != nil {
+		return err
+	} else if err = mp.expect("n", "in"); err != nil {
+		return err
+	}
+	n.name = name.(string)
+	start := mp.pos
+	if n.iter, err = mp.value(); err != nil {
+		return err
+	}
+	for _, tok := range mp.toks[start:mp.pos] {
+		n.markup += tok.val
+	}
+
+	for mp.peek().kind != 0 {
+		mp.accept("p", ",")
+		if mp.accept("n", "reversed") {
+			n.reversed = true
+			continue
+		}
+		tok := mp.next()
+		if err = mp.expect("p", ":"); err != nil {
+			return err
+		}
+		switch {
+		case tok.val == "limit":
+			n.limit, err = mp.value()
+		case tok.val == "offset" && mp.accept("n", "continue"):
+			n.cont = true
+		case tok.val == "offset":
+			n.offset, err = mp.value()
+		case tok.val == "cols" && n.tablerow:
+			n.cols, err = mp.value()
+		default:
+			err = fmt.Errorf("unknown parameter '%s'", tok.val)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/* rendering */
+
+var (
+	errLiquidBreak    = errors.New("'break' used outside of a loop")
+	errLiquidContinue = errors.New("'continue' used outside of a loop")
+)
+
+type liquidState struct {
+	partials map[string]*liquidTemplate
+	depth    int
+	counters map[string]int // {% increment %} & {% decrement %}
+	cycles   map[string]int // {% cycle %}
+	offsets  map[string]int // offset:continue
+	err      func() error   // checked by loops, see `writerErr`
+}
+
+// liquidContext holds the variables available to the template being rendered
+type liquidContext struct {
+	name   string                   // the name of the template being rendered (for errors)
+	scopes []map[string]interface{} // scopes[0] has the variables set by assign & capture
+	data   interface{}
+}
+
+func (c *liquidContext) lookup(name string) interface{} {
+	for i := len(c.scopes) - 1; i >= 0; i-- {
+		if v, ok := c.scopes[i][name]; ok {
+			return v
+		}
+	}
+	v, _ := liquidField(c.data, name)
+	return v
+}
+
+func (c *liquidContext) push(vars map[string]interface{}) {
+	c.scopes = append(c.scopes, vars)
+}
+
+func (c *liquidContext) pop() {
+	c.scopes = c.scopes[:len(c.scopes)-1]
+}
+
+// liquidError is an error that indicates where it occurred
+type liquidError struct {
+	err error
+}
+
+func (err liquidError) Error() string {
+	return err.err.Error()
+}
+
+func (c *liquidContext) errorf(line int, err error) error {
+	if _, ok := err.(liquidError); ok || err == errLiquidBreak || err == errLiquidContinue {
+		return err
+	}
+	return liquidError{fmt.Errorf("%s:%d: %s", c.name, line, err)}
+}
+
+func (s *liquidState) template(name string) (*liquidTemplate, error) {
+	if t, ok := s.partials[name]; ok {
+		return t, nil
+	}
+	for _, ext := range TemplateExtensions(LIQUID) {
+		if t, ok := s.partials[name+"."+ext]; ok {
+			return t, nil
+		}
+	}
+	return nil, fmt.Errorf("template '%s' not found", name)
+}
+
+func (s *liquidState) renderTemplate(w io.Writer, t *liquidTemplate, c *liquidContext) error {
+	if s.depth++; s.depth > liquidMaxDepth {
+		return fmt.Errorf("templates are nested more than %d levels deep", liquidMaxDepth)
+	}
+	defer func() { s.depth-- }()
+
+	prev := c.name
+	c.name = t.name
+	defer func() { c.name = prev }()
+	return s.render(w, t.nodes, c)
+}
+
+func (s *liquidState) render(w io.Writer, nodes []liquidNode, c *liquidContext) error {
+	for _, node := range nodes {
+		var err error
+		var line int
+		switch n := node.(type) {
+		case string:
+			_, err = io.WriteString(w, n)
+		case *liquidOutput:
+			line = n.line
+			var v interface{}
+			if v, err = s.evalFiltered(n.expr, c); err == nil {
+				_, err = io.WriteString(w, liquidString(v))
+			}
+		case *liquidIf:
+			line = n.line
+			err = s.renderIf(w, n, c)
+		case *liquidCase:
+			line = n.line
+			err = s.renderCase(w, n, c)
+		case *liquidFor:
+			line = n.line
+			if n.tablerow {
+				err = s.renderTablerow(w, n, c)
+			} else {
+				err = s.renderFor(w, n, c)
+			}
+		case *liquidLoopControl:
+			line, err = n.line, n.err
+		case *liquidCycle:
+			line = n.line
+			err = s.renderCycle(w, n, c)
+		case *liquidAssign:
+			line = n.line
+			var v interface{}
+			if v, err = s.evalFiltered(n.expr, c); err == nil {
+				c.scopes[0][n.name] = v
+			}
+		case *liquidCapture:
+			line = n.line
+			var b strings.Builder
+			if err = s.render(&b, n.body, c); err == nil {
+				c.scopes[0][n.name] = b.String()
+			}
+		case *liquidCounter:
+			line = n.line
+			v := s.counters[n.name]
+			if n.delta < 0 {
+				v--
+				s.counters[n.name] = v
+			} else {
+				s.counters[n.name] = v + 1
+			}
+			_, err = io.WriteString(w, strconv.Itoa(v))
+		case *liquidInclude:
+			line = n.line
+			err = s.renderInclude(w, n, c)
+		default:
+			err = fmt.Errorf("unknown node %T", node)
+		}
+		if err != nil {
+			return c.errorf(line, err)
+		}
+	}
+	return nil
+}
+
+func (s *liquidState) renderIf(w io.Writer, n *liquidIf, c *liquidContext) error {
+	for i, branch := range n.branches {
+		ok := true
+		if branch.cond != nil {
+			v, err := s.eval(branch.cond, c)
+			if err != nil {
+				return err
+			}
+			ok = liquidTruthy(v)
+			if n.unless && i == 0 {
+				ok = !ok
+			}
+		}
+		if ok {
+			return s.render(w, branch.body, c)
+		}
+	}
+	return nil
+}
+
+func (s *liquidState) renderCase(w io.Writer, n *liquidCase, c *liquidContext) error {
+	v, err := s.eval(n.val, c)
+	if err != nil {
+		return err
+	}
+	matched := false
+	for _, when := range n.whens {
+		for _, expr := range when.vals {
+			wv, err := s.eval(expr, c)
+			if err != nil {
+				return err
+			}
+			if liquidEqual(v, wv) {
+				matched = true
+				if err = s.render(w, when.body, c); err != nil {
+					return err
+				}
+				break
+			}
+		}
+	}
+	if !matched {
+		return s.render(w, n.els, c)
+	}
+	return nil
+}
+
+// loopItems returns the items `n` iterates over
+func (s *liquidState) loopItems(n *liquidFor, c *liquidContext) ([]interface{}, error) {
+	v, err := s.eval(n.iter, c)
+	if err != nil {
+		return nil, err
+	}
+	items := liquidIter(v)
+
+	offset := 0
+	key := n.name + "-" + n.markup
+	if n.cont {
+		offset = s.offsets[key]
+	} else if n.offset != nil {
+		if offset, err = s.evalInt(n.offset, c, "offset"); err != nil {
+			return nil, err
+		}
+	}
+	if offset > len(items) {
+		offset = len(items)
+	} else if offset < 0 {
+		offset = 0
+	}
+	items = items[offset:]
+	if n.limit != nil {
+		limit, err := s.evalInt(n.limit, c, "limit")
+		if err != nil {
+			return nil, err
+		}
+		if limit >= 0 && limit < len(items) {
+			items = items[:limit]
+		}
+	}
+	s.offsets[key] = offset + len(items)
+
+	if n.reversed {
+		reversed := make([]interface{}, len(items))
+		for i, item := range items {
+			reversed[len(items)-1-i] = item
+		}
+		items = reversed
+	}
+	return items, nil
+}
+
+func (s *liquidState) evalInt(e liquidExpr, c *liquidContext, name string) (int, error) {
+	v, err := s.eval(e, c)
+	if err != nil {
+		return 0, err
+	}
+	if _, i, isInt, ok := liquidNum(v); ok && isInt {
+		return i, nil
+	}
+	return 0, fmt.Errorf("'%s' must be an integer", name)
+}
+
+func (s *liquidState) renderFor(w io.Writer, n *liquidFor, c *liquidContext) error {
+	items, err := s.loopItems(n, c)
+	if err != nil {
+		return err
+	} else if len(items) == 0 {
+		return s.render(w, n.els, c)
+	}
+
+	parent := c.lookup("forloop")
+	loop := make(map[string]interface{})
+	c.push(map[string]interface{}{"forloop": loop})
+	defer c.pop()
+	for i, item := range items {
+		if err = s.err(); err != nil {
+			return err
+		}
+		loop["name"] = n.name + "-" + n.markup
+		loop["length"] = len(items)
+		loop["index"] = i + 1
+		loop["index0"] = i
+		loop["rindex"] = len(items) - i
+		loop["rindex0"] = len(items) - i - 1
+		loop["first"] = i == 0
+		loop["last"] = i == len(items)-1
+		loop["parentloop"] = parent
+		c.scopes[len(c.scopes)-1][n.name] = item
+
+		if err = s.render(w, n.body, c); err == errLiquidBreak {
+			break
+		} else if err != nil && err != errLiquidContinue {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *liquidState) renderTablerow(w io.Writer, n *liquidFor, c *liquidContext) error {
+	items, err := s.loopItems(n, c)
+	if err != nil {
+		return err
+	}
+	cols := len(items)
+	if n.cols != nil {
+		if cols, err = s.evalInt(n.cols, c, "cols"); err != nil {
+			return err
+		}
+	}
+	if cols < 1 {
+		cols = 1
+	}
+
+	loop := make(map[string]interface{})
+	c.push(map[string]interface{}{"tablerowloop": loop})
+	defer c.pop()
+	if _, err = io.WriteString(w, "<tr class=\"row1\">\n"); err != nil {
+		return err
+	}
+	for i, item := range items {
+		if err = s.err(); err != nil {
+			return err
+		}
+		col, row := i%cols, i/cols
+		loop["length"] = len(items)
+		loop["index"] = i + 1
+		loop["index0"] = i
+		loop["rindex"] = len(items) - i
+		loop["rindex0"] = len(items) - i - 1
+		loop["first"] = i == 0
+		loop["last"] = i == len(items)-1
+		loop["col"] = col + 1
+		loop["col0"] = col
+		loop["row"] = row + 1
+		loop["col_first"] = col == 0
+		loop["col_last"] = col == cols-1
+		c.scopes[len(c.scopes)-1][n.name] = item
+
+		fmt.Fprintf(w, "<td class=\"col%d\">", col+1)
+		if err = s.render(w, n.body, c); err != nil && err != errLiquidContinue && err != errLiquidBreak {
+			return err
+		}
+		io.WriteString(w, "</td>")
+		if err == errLiquidBreak {
+			break
+		}
+		if col == cols-1 && i < len(items)-1 {
+			fmt.Fprintf(w, "</tr>\n<tr class=\"row%d\">", row+2)
+		}
+	}
+	_, err = io.WriteString(w, "</tr>\n")
+	return err
+}
+
+func (s *liquidState) renderCycle(w io.Writer, n *liquidCycle, c *liquidContext) error {
+	key := n.key
+	if n.group != nil {
+		g, err := s.eval(n.group, c)
+		if err != nil {
+			return err
+		}
+		key = liquidString(g)
+	}
+	i := s.cycles[key]
+	s.cycles[key] = (i + 1) % len(n.vals)
+	v, err := s.eval(n.vals[i%len(n.vals)], c)
+	if err == nil {
+		_, err = io.WriteString(w, liquidString(v))
+	}
+	return err
+}
+
+func (s *liquidState) renderInclude(w io.Writer, n *liquidInclude, c *liquidContext) error {
+	v, err := s.eval(n.tmpl, c)
+	if err != nil {
+		return err
+	}
+	name := liquidString(v)
+	t, err := s.template(name)
+	if err != nil {
+		return err
+	}
+
+	vars := make(map[string]interface{})
+	params := make(map[string]interface{})
+	for _, param := range n.params {
+		if params[param.name], err = s.eval(param.val, c); err != nil {
+			return err
+		}
+	}
+	if n.jekyll {
+		vars["include"] = params
+	} else {
+		for k, v := range params {
+			vars[k] = v
+		}
+	}
+
+	ctx := c
+	if n.render {
+		ctx = &liquidContext{name: c.name, scopes: []map[string]interface{}{vars}, data: c.data}
+	} else {
+		c.push(vars)
+		defer c.pop()
+	}
+
+	alias := n.alias
+	if alias == "" {
+		alias = name
+		if i := strings.LastIndex(alias, "/"); i >= 0 {
+			alias = alias[i+1:]
+		}
+		if i := strings.Index(alias, "."); i >= 0 {
+			alias = alias[:i]
+		}
+	}
+	if n.with == nil {
+		return s.renderTemplate(w, t, ctx)
+	}
+
+	with, err := s.eval(n.with, c)
+	if err != nil {
+		return err
+	}
+	if list, ok := toList(with); ok && n.forEach {
+		for _, item := range list {
+			vars[alias] = item
+			if err = s.renderTemplate(w, t, ctx); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	vars[alias] = with
+	return s.renderTemplate(w, t, ctx)
+}
+
+func (s *liquidState) eval(e liquidExpr, c *liquidContext) (interface{}, error) {
+	switch x := e.(type) {
+	case *liquidLiteral:
+		return x.v, nil
+	case *liquidVariable:
+		var v interface{}
+		if x.base != nil {
+			v = x.base.(*liquidLiteral).v
+		} else if x.name != "" {
+			v = c.lookup(x.name)
+		}
+		for i, key := range x.keys {
+			k, err := s.eval(key, c)
+			if err != nil {
+				return nil, err
+			}
+			if i == 0 && x.name == "" && x.base == nil {
+				v = c.lookup(liquidString(k))
+			} else {
+				v = liquidIndex(v, k)
+			}
+		}
+		return v, nil
+	case *liquidRange:
+		from, err := s.evalInt(x.from, c, "range start")
+		if err != nil {
+			return nil, err
+		}
+		to, err := s.evalInt(x.to, c, "range end")
+		if err != nil {
+			return nil, err
+		}
+		if float64(to)-float64(from) >= liquidMaxRange {
+			return nil, fmt.Errorf("range is too big, the maximum is %d items", liquidMaxRange)
+		}
+		list := make([]interface{}, 0)
+		for i := from; i <= to; i++ {
+			list = append(list, i)
+		}
+		return list, nil
+	case *liquidCompare:
+		l, err := s.eval(x.l, c)
+		if err != nil {
+			return nil, err
+		}
+		r, err := s.eval(x.r, c)
+		if err != nil {
+			return nil, err
+		}
+		return liquidCompareOp(x.op, l, r)
+	case *liquidLogic:
+		l, err := s.eval(x.l, c)
+		if err != nil {
+			return nil, err
+		}
+		r, err := s.eval(x.r, c)
+		if err != nil {
+			return nil, err
+		}
+		if x.op == "and" {
+			return liquidTruthy(l) && liquidTruthy(r), nil
+		}
+		return liquidTruthy(l) || liquidTruthy(r), nil
+	case *liquidFiltered:
+		return s.evalFiltered(x, c)
+	}
+	return nil, fmt.Errorf("unknown expression %T", e)
+}
+
+func (s *liquidState) evalFiltered(f *liquidFiltered, c *liquidContext) (interface{}, error) {
+	v, err := s.eval(f.val, c)
+	if err != nil {
+		return nil, err
+	}
+	for _, call := range f.filters {
+		filter, ok := getLiquidFilter(call.name)
+		if !ok {
+			return nil, fmt.Errorf("unknown filter '%s'", call.name)
+		}
+		args := make([]interface{}, len(call.args))
+		for i, arg := range call.args {
+			if args[i], err = s.eval(arg, c); err != nil {
+				return nil, err
+			}
+		}
+		kwargs := make(map[string]interface{})
+		for _, param := range call.kwargs {
+			if kwargs[param.name], err = s.eval(param.val, c); err != nil {
+				return nil, err
+			}
+		}
+		if v, err = filter(v, args, kwargs); err != nil {
+			return nil, fmt.Errorf("%s: %s", call.name, err)
+		}
+	}
+	return v, nil
+}
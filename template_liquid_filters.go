@@ -0,0 +1,1278 @@
+package dati
+
+/*
+Copyright (C) 2023 gearsix <gearsix@tuta.io>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"html"
+	"math"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/yuin/goldmark"
+)
+
+func init() {
+	for name, filter := range map[string]LiquidFilter{
+		"abs":                      liquidAbs,
+		"append":                   liquidStringArgs(1, func(s string, a []string) string { return s + a[0] }),
+		"array_to_sentence_string": liquidArrayToSentence,
+		"at_least":                 liquidArith('>'),
+		"at_most":                  liquidArith('<'),
+		"base64_decode":            liquidBase64Decode,
+		"base64_encode":            liquidStringFilter(liquidBase64Encode),
+		"capitalize":               liquidStringFilter(liquidCapitalize),
+		"ceil":                     liquidRounding(math.Ceil),
+		"cgi_escape":               liquidStringFilter(url.QueryEscape),
+		"compact":                  liquidCompact,
+		"concat":                   liquidConcat,
+		"date":                     liquidDate(""),
+		"date_to_long_string":      liquidDate("%d %B %Y"),
+		"date_to_rfc822":           liquidDate("%a, %d %b %Y %H:%M:%S %z"),
+		"date_to_string":           liquidDate("%d %b %Y"),
+		"date_to_xml_schema":       liquidDate("%Y-%m-%dT%H:%M:%S%:z"),
+		"default":                  liquidDefault,
+		"divided_by":               liquidArith('/'),
+		"downcase":                 liquidStringFilter(strings.ToLower),
+		"escape":                   liquidStringFilter(liquidEscaper.Replace),
+		"escape_once":              liquidStringFilter(liquidEscapeOnce),
+		"first":                    liquidFirst,
+		"floor":                    liquidRounding(math.Floor),
+		"group_by":                 liquidGroupBy,
+		"inspect":                  liquidJsonify,
+		"join":                     liquidJoin,
+		"jsonify":                  liquidJsonify,
+		"last":                     liquidLast,
+		"lstrip":                   liquidStringFilter(liquidLstrip),
+		"map":                      liquidMap,
+		"markdownify":              liquidMarkdownify,
+		"minus":                    liquidArith('-'),
+		"modulo":                   liquidArith('%'),
+		"newline_to_br":            liquidStringFilter(liquidNewlineToBr),
+		"normalize_whitespace":     liquidStringFilter(liquidNormalizeWhitespace),
+		"number_of_words":          liquidNumberOfWords,
+		"plus":                     liquidArith('+'),
+		"pop":                      liquidPop,
+		"prepend":                  liquidStringArgs(1, func(s string, a []string) string { return a[0] + s }),
+		"push":                     liquidPush,
+		"remove":                   liquidStringArgs(1, func(s string, a []string) string { return strings.Replace(s, a[0], "", -1) }),
+		"remove_first":             liquidStringArgs(1, func(s string, a []string) string { return strings.Replace(s, a[0], "", 1) }),
+		"remove_last":              liquidStringArgs(1, func(s string, a []string) string { return liquidReplaceLast(s, a[0], "") }),
+		"replace":                  liquidStringArgs(2, func(s string, a []string) string { return strings.Replace(s, a[0], a[1], -1) }),
+		"replace_first":            liquidStringArgs(2, func(s string, a []string) string { return strings.Replace(s, a[0], a[1], 1) }),
+		"replace_last":             liquidStringArgs(2, func(s string, a []string) string { return liquidReplaceLast(s, a[0], a[1]) }),
+		"reverse":                  liquidReverse,
+		"round":                    liquidRound,
+		"rstrip":                   liquidStringFilter(liquidRstrip),
+		"shift":                    liquidShift,
+		"size":                     liquidSizeFilter,
+		"slice":                    liquidSlice,
+		"slugify":                  liquidStringFilter(liquidSlugify),
+		"sort":                     liquidSort(false),
+		"sort_natural":             liquidSort(true),
+		"split":                    liquidSplit,
+		"strip":                    liquidStringFilter(strings.TrimSpace),
+		"strip_html":               liquidStringFilter(liquidStripHTML),
+		"strip_newlines":           liquidStringFilter(liquidStripNewlines),
+		"sum":                      liquidSum,
+		"times":                    liquidArith('*'),
+		"truncate":                 liquidTruncate,
+		"truncatewords":            liquidTruncateWords,
+		"uniq":                     liquidUniq,
+		"unshift":                  liquidUnshift,
+		"upcase":                   liquidStringFilter(strings.ToUpper),
+		"uri_escape":               liquidStringFilter(liquidURIEscape),
+		"url_decode":               liquidURLDecode,
+		"url_encode":               liquidStringFilter(url.QueryEscape),
+		"where":                    liquidWhere,
+		"xml_escape":               liquidStringFilter(liquidEscaper.Replace),
+	} {
+		RegisterLiquidFilter(name, filter)
+	}
+}
+
+// LiquidFilter is the function signature of a liquid filter, see
+// `RegisterLiquidFilter`. `value` is the value being filtered, `args` and
+// `kwargs` are the positional and named arguments the filter was called
+// with (e.g. `{{ value | filter: 1, key: 2 }}`).
+type LiquidFilter func(value interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error)
+
+var (
+	liquidFiltersMu sync.RWMutex
+	liquidFilters   = make(map[string]LiquidFilter)
+)
+
+// RegisterLiquidFilter makes `filter` available to all liquid templates as
+// `name`. Filters are checked when a template is parsed, so they should be
+// registered before loading any templates that use them. If `name` is
+// already registered, it will be replaced (including the built-in filters).
+// If `filter` is nil, `name` is removed.
+func RegisterLiquidFilter(name string, filter LiquidFilter) {
+	liquidFiltersMu.Lock()
+	defer liquidFiltersMu.Unlock()
+	if filter == nil {
+		delete(liquidFilters, name)
+	} else {
+		liquidFilters[name] = filter
+	}
+}
+
+func getLiquidFilter(name string) (filter LiquidFilter, ok bool) {
+	liquidFiltersMu.RLock()
+	defer liquidFiltersMu.RUnlock()
+	filter, ok = liquidFilters[name]
+	return
+}
+
+/* values */
+
+// liquidEmpty is `empty`, which is equal to empty strings, arrays & hashes
+type liquidEmpty struct{}
+
+// liquidBlank is `blank`, which is equal to empty values, nil, false and
+// strings that are only whitespace
+type liquidBlank struct{}
+
+// liquidField returns the value of the key (or exported struct field)
+// `name` in `v`.
+func liquidField(v interface{}, name string) (interface{}, bool) {
+	if m, ok := v.(map[string]interface{}); ok {
+		val, ok := m[name]
+		return val, ok
+	}
+
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Interface {
+		if rv.IsNil() {
+			return nil, false
+		}
+		rv = rv.Elem()
+	}
+	switch rv.Kind() {
+	case reflect.Map:
+		if rv.Type().Key().Kind() == reflect.String {
+			if val := rv.MapIndex(reflect.ValueOf(name).Convert(rv.Type().Key())); val.IsValid() {
+				return val.Interface(), true
+			}
+			return nil, false
+		}
+		iter := rv.MapRange()
+		for iter.Next() {
+			if fmt.Sprint(iter.Key().Interface()) == name {
+				return iter.Value().Interface(), true
+			}
+		}
+	case reflect.Struct:
+		if f, ok := rv.Type().FieldByName(name); ok && f.PkgPath == "" {
+			return rv.FieldByIndex(f.Index).Interface(), true
+		}
+	}
+	return nil, false
+}
+
+// liquidIndex returns `obj[key]`, arrays, strings & hashes also have the
+// "size", "first" and "last" keys.
+func liquidIndex(obj, key interface{}) interface{} {
+	if list, ok := toList(obj); ok {
+		if _, i, isInt, ok := liquidNum(key); ok && isInt {
+			if i < 0 {
+				i += len(list)
+			}
+			if i >= 0 && i < len(list) {
+				return list[i]
+			}
+			return nil
+		}
+	}
+
+	name, ok := key.(string)
+	if !ok {
+		name = liquidString(key)
+	}
+	if v, ok := liquidField(obj, name); ok {
+		return v
+	}
+	switch name {
+	case "size":
+		if n, ok := liquidSize(obj); ok {
+			return n
+		}
+	case "first", "last":
+		if list, ok := toList(obj); ok && len(list) > 0 {
+			if name == "first" {
+				return list[0]
+			}
+			return list[len(list)-1]
+		}
+	}
+	return nil
+}
+
+// liquidSize returns the length of a string, array or hash
+func liquidSize(v interface{}) (int, bool) {
+	if s, ok := v.(string); ok {
+		return len([]rune(s)), true
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return rv.Len(), true
+	}
+	return 0, false
+}
+
+// liquidIter returns the items in `v`, hashes return [key, value] pairs
+func liquidIter(v interface{}) []interface{} {
+	switch v.(type) {
+	case nil, liquidEmpty, liquidBlank:
+		return nil
+	}
+	if list, ok := toList(v); ok {
+		return list
+	} else if m, ok := toStringMap(v); ok {
+		keys := make([]string, 0, len(m))
+		for key := range m {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		items := make([]interface{}, len(keys))
+		for i, key := range keys {
+			items[i] = []interface{}{key, m[key]}
+		}
+		return items
+	}
+	return []interface{}{v}
+}
+
+// liquidFloat is a number that's never treated as an integer, even if it's
+// whole (e.g. the literal "4.0" or the result of "2.0 | plus: 1"). It's
+// written with a decimal point.
+type liquidFloat float64
+
+// liquidNum returns `v` as a number, `isInt` is true if `v` is an integer or
+// a whole float64 that isn't a *liquidFloat*.
+func liquidNum(v interface{}) (f float64, i int, isInt bool, ok bool) {
+	if lf, isFloat := v.(liquidFloat); isFloat {
+		return float64(lf), int(lf), false, true
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i = int(rv.Int())
+		return float64(i), i, true, true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		i = int(rv.Uint())
+		return float64(i), i, true, true
+	case reflect.Float32, reflect.Float64:
+		f = rv.Float()
+		isInt = f == math.Trunc(f) && math.Abs(f) < 1<<53
+		return f, int(f), isInt, true
+	}
+	return 0, 0, false, false
+}
+
+// liquidToNum converts `v` to a number, strings are parsed and anything
+// else is 0.
+func liquidToNum(v interface{}) (f float64, i int, isInt bool) {
+	var ok bool
+	if f, i, isInt, ok = liquidNum(v); ok {
+		return
+	}
+	s := strings.TrimSpace(liquidString(v))
+	if n, err := strconv.Atoi(s); err == nil {
+		return float64(n), n, true
+	} else if f, err = strconv.ParseFloat(s, 64); err == nil {
+		return f, int(f), false
+	}
+	return 0, 0, true
+}
+
+// liquidTruthy returns false for nil & false, everything else is true
+func liquidTruthy(v interface{}) bool {
+	if b, ok := v.(bool); ok {
+		return b
+	}
+	return v != nil
+}
+
+func liquidTypeName(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "nil"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case liquidEmpty:
+		return "empty"
+	case liquidBlank:
+		return "blank"
+	}
+	if _, _, _, ok := liquidNum(v); ok {
+		return "number"
+	} else if _, ok := toList(v); ok {
+		return "array"
+	} else if _, ok := toStringMap(v); ok {
+		return "hash"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// liquidTimeFormat is how times are written when they're output
+const liquidTimeFormat = "2006-01-02 15:04:05 -0700"
+
+// liquidString returns `v` as it's written in a template. Arrays are
+// written as the concatenation of their items.
+func liquidString(v interface{}) string {
+	switch val := v.(type) {
+	case nil, liquidEmpty, liquidBlank:
+		return ""
+	case string:
+		return val
+	case bool:
+		return strconv.FormatBool(val)
+	case []byte:
+		return string(val)
+	case time.Time:
+		return val.Format(liquidTimeFormat)
+	case liquidFloat:
+		s := strconv.FormatFloat(float64(val), 'f', -1, 64)
+		if !strings.ContainsAny(s, ".IN") {
+			s += ".0"
+		}
+		return s
+	case fmt.Stringer:
+		return val.String()
+	}
+
+	if f, i, isInt, ok := liquidNum(v); ok {
+		if isInt {
+			return strconv.Itoa(i)
+		}
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	} else if list, ok := toList(v); ok {
+		var b strings.Builder
+		for _, item := range list {
+			b.WriteString(liquidString(item))
+		}
+		return b.String()
+	} else if _, ok := toStringMap(v); ok {
+		return liquidInspect(v)
+	}
+	return fmt.Sprint(v)
+}
+
+// liquidInspect returns `v` as JSON, or as it would be written in a
+// template if it can't be encoded.
+func liquidInspect(v interface{}) string {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return fmt.Sprint(v)
+	}
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+// liquidIsEmpty returns true if `v` is an empty string, array or hash
+func liquidIsEmpty(v interface{}) bool {
+	switch v.(type) {
+	case liquidEmpty, liquidBlank:
+		return true
+	}
+	n, ok := liquidSize(v)
+	return ok && n == 0
+}
+
+func liquidEqual(a, b interface{}) bool {
+	switch b.(type) {
+	case liquidEmpty:
+		return liquidIsEmpty(a)
+	case liquidBlank:
+		s, ok := a.(string)
+		return a == nil || a == false || liquidIsEmpty(a) || (ok && len(strings.TrimSpace(s)) == 0)
+	}
+	switch a.(type) {
+	case liquidEmpty, liquidBlank:
+		return liquidEqual(b, a)
+	}
+
+	if af, _, _, ok := liquidNum(a); ok {
+		bf, _, _, ok := liquidNum(b)
+		return ok && af == bf
+	} else if as, ok := a.(string); ok {
+		bs, ok := b.(string)
+		return ok && as == bs
+	} else if al, ok := toList(a); ok {
+		bl, ok := toList(b)
+		if !ok || len(al) != len(bl) {
+			return false
+		}
+		for i := range al {
+			if !liquidEqual(al[i], bl[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// liquidOrder returns -1, 0 or 1 if `a` is less than, equal to or greater
+// than `b`.
+func liquidOrder(a, b interface{}) (int, error) {
+	if af, _, _, ok := liquidNum(a); ok {
+		if bf, _, _, ok := liquidNum(b); ok {
+			switch {
+			case af < bf:
+				return -1, nil
+			case af > bf:
+				return 1, nil
+			}
+			return 0, nil
+		}
+	} else if as, ok := a.(string); ok {
+		if bs, ok := b.(string); ok {
+			return strings.Compare(as, bs), nil
+		}
+	} else if at, ok := a.(time.Time); ok {
+		if bt, ok := b.(time.Time); ok {
+			switch {
+			case at.Before(bt):
+				return -1, nil
+			case at.After(bt):
+				return 1, nil
+			}
+			return 0, nil
+		}
+	}
+	return 0, fmt.Errorf("can't compare %s with %s", liquidTypeName(a), liquidTypeName(b))
+}
+
+func liquidCompareOp(op string, l, r interface{}) (bool, error) {
+	switch op {
+	case "==":
+		return liquidEqual(l, r), nil
+	case "!=", "<>":
+		return !liquidEqual(l, r), nil
+	case "contains":
+		if s, ok := l.(string); ok {
+			return strings.Contains(s, liquidString(r)), nil
+		} else if list, ok := toList(l); ok {
+			for _, item := range list {
+				if liquidEqual(item, r) {
+					return true, nil
+				}
+			}
+			return false, nil
+		}
+		_, ok := liquidField(l, liquidString(r))
+		return ok, nil
+	}
+
+	if l == nil || r == nil {
+		return false, nil
+	}
+	n, err := liquidOrder(l, r)
+	if err != nil {
+		return false, err
+	}
+	switch op {
+	case "<":
+		return n < 0, nil
+	case ">":
+		return n > 0, nil
+	case "<=":
+		return n <= 0, nil
+	}
+	return n >= 0, nil
+}
+
+var liquidTimeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	liquidTimeFormat,
+	"2006-01-02 15:04:05 MST",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+	time.RFC1123Z,
+	time.RFC1123,
+	time.RFC822Z,
+	time.RFC822,
+	"January 2, 2006",
+	"Jan 2, 2006",
+	"2 January 2006",
+	"2 Jan 2006",
+}
+
+// liquidTime returns `v` as a time, "now" & "today" are the current time
+// and numbers are seconds since the unix epoch.
+func liquidTime(v interface{}) (time.Time, bool) {
+	if t, ok := v.(time.Time); ok {
+		return t, true
+	} else if f, _, _, ok := liquidNum(v); ok {
+		return time.Unix(int64(f), 0), true
+	}
+
+	s, ok := v.(string)
+	if !ok {
+		return time.Time{}, false
+	}
+	s = strings.TrimSpace(s)
+	if s == "now" || s == "today" {
+		return time.Now(), true
+	} else if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(n, 0), true
+	}
+	for _, layout := range liquidTimeLayouts {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// liquidStrftime formats `t` with the strftime directives in `format`
+func liquidStrftime(t time.Time, format string) string {
+	var b strings.Builder
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' || i+1 >= len(format) {
+			b.WriteByte(format[i])
+			continue
+		}
+		i++
+		var flag byte
+		if strings.IndexByte("-^:", format[i]) >= 0 && i+1 < len(format) {
+			flag = format[i]
+			i++
+		}
+
+		var s string
+		hour12 := t.Hour() % 12
+		if hour12 == 0 {
+			hour12 = 12
+		}
+		switch format[i] {
+		case 'Y':
+			s = strconv.Itoa(t.Year())
+		case 'C':
+			s = fmt.Sprintf("%02d", t.Year()/100)
+		case 'y':
+			s = fmt.Sprintf("%02d", t.Year()%100)
+		case 'm':
+			s = fmt.Sprintf("%02d", t.Month())
+		case 'B':
+			s = t.Month().String()
+		case 'b', 'h':
+			s = t.Month().String()[:3]
+		case 'd':
+			s = fmt.Sprintf("%02d", t.Day())
+		case 'e':
+			s = fmt.Sprintf("%2d", t.Day())
+		case 'j':
+			s = fmt.Sprintf("%03d", t.YearDay())
+		case 'H':
+			s = fmt.Sprintf("%02d", t.Hour())
+		case 'k':
+			s = fmt.Sprintf("%2d", t.Hour())
+		case 'I':
+			s = fmt.Sprintf("%02d", hour12)
+		case 'l':
+			s = fmt.Sprintf("%2d", hour12)
+		case 'M':
+			s = fmt.Sprintf("%02d", t.Minute())
+		case 'S':
+			s = fmt.Sprintf("%02d", t.Second())
+		case 'L':
+			s = fmt.Sprintf("%03d", t.Nanosecond()/1e6)
+		case 'p':
+			s = t.Format("PM")
+		case 'P':
+			s = t.Format("pm")
+		case 'A':
+			s = t.Weekday().String()
+		case 'a':
+			s = t.Weekday().String()[:3]
+		case 'u':
+			s = strconv.Itoa((int(t.Weekday())+6)%7 + 1)
+		case 'w':
+			s = strconv.Itoa(int(t.Weekday()))
+		case 'z':
+			if flag == ':' {
+				s = t.Format("-07:00")
+			} else {
+				s = t.Format("-0700")
+			}
+		case 'Z':
+			s = t.Format("MST")
+		case 's':
+			s = strconv.FormatInt(t.Unix(), 10)
+		case 'F':
+			s = t.Format("2006-01-02")
+		case 'T', 'X':
+			s = t.Format("15:04:05")
+		case 'D', 'x':
+			s = t.Format("01/02/06")
+		case 'R':
+			s = t.Format("15:04")
+		case 'r':
+			s = t.Format("03:04:05 PM")
+		case 'c':
+			s = t.Format("Mon Jan _2 15:04:05 2006")
+		case '%':
+			s = "%"
+		default:
+			s = "%" + format[i:i+1]
+			if flag != 0 {
+				s = "%" + string(flag) + format[i:i+1]
+			}
+		}
+
+		switch flag {
+		case '-':
+			if trimmed := strings.TrimLeft(s, "0 "); len(trimmed) > 0 {
+				s = trimmed
+			} else if len(s) > 0 {
+				s = "0"
+			}
+		case '^':
+			s = strings.ToUpper(s)
+		}
+		b.WriteString(s)
+	}
+	return b.String()
+}
+
+/* filters */
+
+// liquidStringFilter returns a *LiquidFilter* that applies `fn` to the value
+// as a string.
+func liquidStringFilter(fn func(string) string) LiquidFilter {
+	return func(v interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+		return fn(liquidString(v)), nil
+	}
+}
+
+// liquidStringArgs returns a *LiquidFilter* that calls `fn` with the value
+// and the first `n` arguments as strings.
+func liquidStringArgs(n int, fn func(s string, args []string) string) LiquidFilter {
+	return func(v interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+		if len(args) < n {
+			return nil, fmt.Errorf("expected %d arguments, got %d", n, len(args))
+		}
+		strs := make([]string, n)
+		for i := range strs {
+			strs[i] = liquidString(args[i])
+		}
+		return fn(liquidString(v), strs), nil
+	}
+}
+
+// liquidArith returns a *LiquidFilter* that applies `op` to the value and
+// its argument. If both are integers, the result is an integer ("/" is
+// floored division), otherwise it's a *liquidFloat*. ">" & "<" return the
+// max & min.
+func liquidArith(op byte) LiquidFilter {
+	return func(v interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("expected 1 argument, got %d", len(args))
+		}
+		af, ai, aInt := liquidToNum(v)
+		bf, bi, bInt := liquidToNum(args[0])
+
+		if aInt && bInt {
+			switch op {
+			case '+':
+				return ai + bi, nil
+			case '-':
+				return ai - bi, nil
+			case '*':
+				return ai * bi, nil
+			case '/', '%':
+				if bi == 0 {
+					return nil, fmt.Errorf("divided by 0")
+				}
+				q, m := ai/bi, ai%bi
+				if m != 0 && (m < 0) != (bi < 0) {
+					q, m = q-1, m+bi
+				}
+				if op == '/' {
+					return q, nil
+				}
+				return m, nil
+			case '>':
+				if ai > bi {
+					return ai, nil
+				}
+				return bi, nil
+			case '<':
+				if ai < bi {
+					return ai, nil
+				}
+				return bi, nil
+			}
+		}
+
+		switch op {
+		case '+':
+			return liquidFloat(af + bf), nil
+		case '-':
+			return liquidFloat(af - bf), nil
+		case '*':
+			return liquidFloat(af * bf), nil
+		case '/', '%':
+			if bf == 0 {
+				return nil, fmt.Errorf("divided by 0")
+			} else if op == '/' {
+				return liquidFloat(af / bf), nil
+			}
+			return liquidFloat(af - bf*math.Floor(af/bf)), nil
+		case '>':
+			return liquidFloat(math.Max(af, bf)), nil
+		}
+		return liquidFloat(math.Min(af, bf)), nil
+	}
+}
+
+func liquidAbs(v interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+	f, i, isInt := liquidToNum(v)
+	if isInt && i < 0 {
+		return -i, nil
+	} else if isInt {
+		return i, nil
+	}
+	return liquidFloat(math.Abs(f)), nil
+}
+
+func liquidRounding(fn func(float64) float64) LiquidFilter {
+	return func(v interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+		f, _, _ := liquidToNum(v)
+		return int(fn(f)), nil
+	}
+}
+
+func liquidRound(v interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+	f, _, _ := liquidToNum(v)
+	precision := 0
+	if len(args) > 0 {
+		_, precision, _ = liquidToNum(args[0])
+	}
+	if precision <= 0 {
+		return int(math.Round(f)), nil
+	}
+	p := math.Pow(10, float64(precision))
+	return liquidFloat(math.Round(f*p) / p), nil
+}
+
+func liquidCapitalize(s string) string {
+	for i, r := range s {
+		return string(unicode.ToUpper(r)) + strings.ToLower(s[i+len(string(r)):])
+	}
+	return s
+}
+
+var liquidEscaper = strings.NewReplacer(
+	"&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;", "'", "&#39;",
+)
+
+var liquidHTMLTags = regexp.MustCompile(`(?is)<script.*?</script>|<style.*?</style>|<!--.*?-->|<[^>]*>`)
+
+func liquidStripHTML(s string) string {
+	return liquidHTMLTags.ReplaceAllString(s, "")
+}
+
+func liquidEscapeOnce(s string) string {
+	return liquidEscaper.Replace(html.UnescapeString(s))
+}
+
+func liquidLstrip(s string) string {
+	return strings.TrimLeftFunc(s, unicode.IsSpace)
+}
+
+func liquidRstrip(s string) string {
+	return strings.TrimRightFunc(s, unicode.IsSpace)
+}
+
+func liquidNewlineToBr(s string) string {
+	return strings.Replace(strings.Replace(s, "\r\n", "\n", -1), "\n", "<br />\n", -1)
+}
+
+func liquidNormalizeWhitespace(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+func liquidStripNewlines(s string) string {
+	return strings.NewReplacer("\r\n", "", "\n", "").Replace(s)
+}
+
+func liquidBase64Encode(s string) string {
+	return base64.StdEncoding.EncodeToString([]byte(s))
+}
+
+func liquidReplaceLast(s, old, new string) string {
+	i := strings.LastIndex(s, old)
+	if i < 0 {
+		return s
+	}
+	return s[:i] + new + s[i+len(old):]
+}
+
+func liquidSlugify(s string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(s) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+		} else {
+			dash = true
+		}
+	}
+	return b.String()
+}
+
+func liquidURIEscape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c < 0x80 && (unicode.IsLetter(rune(c)) || unicode.IsDigit(rune(c)) ||
+			strings.IndexByte("-_.!~*'();/?:@&=+$,#[]", c) >= 0) {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func liquidURLDecode(v interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+	return url.QueryUnescape(liquidString(v))
+}
+
+func liquidBase64Decode(v interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+	buf, err := base64.StdEncoding.DecodeString(liquidString(v))
+	return string(buf), err
+}
+
+func liquidArrayToSentence(v interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+	connector := "and"
+	if len(args) > 0 {
+		connector = liquidString(args[0])
+	}
+	items := liquidIter(v)
+	words := make([]string, len(items))
+	for i, item := range items {
+		words[i] = liquidString(item)
+	}
+	switch len(words) {
+	case 0:
+		return "", nil
+	case 1:
+		return words[0], nil
+	case 2:
+		return words[0] + " " + connector + " " + words[1], nil
+	}
+	return strings.Join(words[:len(words)-1], ", ") + ", " + connector + " " + words[len(words)-1], nil
+}
+
+// liquidProperty returns the values of `items`, or the property named by
+// the first argument in `args` of each item.
+func liquidProperty(items []interface{}, args []interface{}) []interface{} {
+	if len(args) == 0 || args[0] == nil {
+		return items
+	}
+	name := liquidString(args[0])
+	vals := make([]interface{}, len(items))
+	for i, item := range items {
+		vals[i] = liquidIndex(item, name)
+	}
+	return vals
+}
+
+func liquidCompact(v interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+	items := liquidIter(v)
+	vals := liquidProperty(items, args)
+	result := make([]interface{}, 0, len(items))
+	for i, item := range items {
+		if vals[i] != nil {
+			result = append(result, item)
+		}
+	}
+	return result, nil
+}
+
+func liquidConcat(v interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("expected 1 argument, got %d", len(args))
+	}
+	list, ok := toList(args[0])
+	if !ok {
+		return nil, fmt.Errorf("expected an array argument")
+	}
+	return append(append([]interface{}{}, liquidIter(v)...), list...), nil
+}
+
+// liquidDate returns the date filter, if `format` is empty the format is
+// the first argument.
+func liquidDate(format string) LiquidFilter {
+	return func(v interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+		f := format
+		if len(f) == 0 && len(args) > 0 {
+			f = liquidString(args[0])
+		}
+		t, ok := liquidTime(v)
+		if !ok || len(f) == 0 {
+			return v, nil
+		}
+		return liquidStrftime(t, f), nil
+	}
+}
+
+func liquidDefault(v interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+	var fallback interface{}
+	if len(args) > 0 {
+		fallback = args[0]
+	}
+	if v == false && liquidTruthy(kwargs["allow_false"]) {
+		return v, nil
+	} else if !liquidTruthy(v) || liquidIsEmpty(v) {
+		return fallback, nil
+	}
+	return v, nil
+}
+
+func liquidFirst(v interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+	if s, ok := v.(string); ok {
+		for _, r := range s {
+			return string(r), nil
+		}
+		return nil, nil
+	}
+	return liquidIndex(v, "first"), nil
+}
+
+func liquidLast(v interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+	if s, ok := v.(string); ok && len(s) > 0 {
+		runes := []rune(s)
+		return string(runes[len(runes)-1]), nil
+	} else if ok {
+		return nil, nil
+	}
+	return liquidIndex(v, "last"), nil
+}
+
+func liquidGroupBy(v interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("expected 1 argument, got %d", len(args))
+	}
+	items := liquidIter(v)
+	vals := liquidProperty(items, args)
+
+	groups := make([]interface{}, 0)
+	index := make(map[string]map[string]interface{})
+	for i, item := range items {
+		name := liquidString(vals[i])
+		group, ok := index[name]
+		if !ok {
+			group = map[string]interface{}{"name": name, "items": []interface{}{}, "size": 0}
+			index[name] = group
+			groups = append(groups, group)
+		}
+		group["items"] = append(group["items"].([]interface{}), item)
+		group["size"] = group["size"].(int) + 1
+	}
+	return groups, nil
+}
+
+func liquidJoin(v interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+	sep := " "
+	if len(args) > 0 {
+		sep = liquidString(args[0])
+	}
+	items := liquidIter(v)
+	strs := make([]string, len(items))
+	for i, item := range items {
+		strs[i] = liquidString(item)
+	}
+	return strings.Join(strs, sep), nil
+}
+
+func liquidJsonify(v interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+	return liquidInspect(v), nil
+}
+
+func liquidMap(v interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("expected 1 argument, got %d", len(args))
+	}
+	return liquidProperty(liquidIter(v), args), nil
+}
+
+func liquidMarkdownify(v interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+	var buf bytes.Buffer
+	err := goldmark.Convert([]byte(liquidString(v)), &buf)
+	return buf.String(), err
+}
+
+func liquidNumberOfWords(v interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+	return len(strings.Fields(liquidString(v))), nil
+}
+
+func liquidPop(v interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+	items := liquidIter(v)
+	if len(items) == 0 {
+		return items, nil
+	}
+	return append([]interface{}{}, items[:len(items)-1]...), nil
+}
+
+func liquidPush(v interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+	return append(append([]interface{}{}, liquidIter(v)...), args...), nil
+}
+
+func liquidShift(v interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+	items := liquidIter(v)
+	if len(items) == 0 {
+		return items, nil
+	}
+	return append([]interface{}{}, items[1:]...), nil
+}
+
+func liquidUnshift(v interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+	return append(append([]interface{}{}, args...), liquidIter(v)...), nil
+}
+
+func liquidReverse(v interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+	items := liquidIter(v)
+	reversed := make([]interface{}, len(items))
+	for i, item := range items {
+		reversed[len(items)-1-i] = item
+	}
+	return reversed, nil
+}
+
+func liquidSizeFilter(v interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+	n, _ := liquidSize(v)
+	return n, nil
+}
+
+func liquidSlice(v interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("expected at least 1 argument")
+	}
+	_, start, _ := liquidToNum(args[0])
+	length := 1
+	if len(args) > 1 {
+		_, length, _ = liquidToNum(args[1])
+	}
+
+	var items []interface{}
+	s, isStr := v.(string)
+	if isStr {
+		for _, r := range s {
+			items = append(items, string(r))
+		}
+	} else {
+		items = liquidIter(v)
+	}
+	if start < 0 {
+		start += len(items)
+	}
+	end := start + length
+	if start < 0 {
+		start = 0
+	}
+	if end > len(items) {
+		end = len(items)
+	}
+
+	result := make([]interface{}, 0)
+	if start < end {
+		result = append(result, items[start:end]...)
+	}
+	if isStr {
+		return liquidString(result), nil
+	}
+	return result, nil
+}
+
+// liquidSort returns the sort filter, or the sort_natural filter if
+// `natural` is true (which ignores case).
+func liquidSort(natural bool) LiquidFilter {
+	return func(v interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+		items := append([]interface{}{}, liquidIter(v)...)
+		vals := liquidProperty(items, args)
+		keys := make([]interface{}, len(items))
+		for i, val := range vals {
+			keys[i] = val
+			if s, ok := val.(string); ok && natural {
+				keys[i] = strings.ToLower(s)
+			}
+		}
+
+		indexes := make([]int, len(items))
+		for i := range indexes {
+			indexes[i] = i
+		}
+		var err error
+		sort.SliceStable(indexes, func(i, j int) bool {
+			a, b := keys[indexes[i]], keys[indexes[j]]
+			if a == nil || b == nil {
+				return b == nil && a != nil
+			}
+			n, e := liquidOrder(a, b)
+			if e != nil && err == nil {
+				err = e
+			}
+			return n < 0
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		sorted := make([]interface{}, len(items))
+		for i, index := range indexes {
+			sorted[i] = items[index]
+		}
+		return sorted, nil
+	}
+}
+
+func liquidSplit(v interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("expected 1 argument, got %d", len(args))
+	}
+	s, sep := liquidString(v), liquidString(args[0])
+	var parts []string
+	if sep == " " {
+		parts = strings.Fields(s)
+	} else {
+		parts = strings.Split(s, sep)
+		for len(parts) > 0 && parts[len(parts)-1] == "" {
+			parts = parts[:len(parts)-1]
+		}
+	}
+	result := make([]interface{}, len(parts))
+	for i, part := range parts {
+		result[i] = part
+	}
+	return result, nil
+}
+
+func liquidSum(v interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+	var fsum float64
+	isum, allInt := 0, true
+	for _, val := range liquidProperty(liquidIter(v), args) {
+		f, i, isInt := liquidToNum(val)
+		fsum += f
+		isum += i
+		allInt = allInt && isInt
+	}
+	if allInt {
+		return isum, nil
+	}
+	return fsum, nil
+}
+
+func liquidTruncate(v interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+	n, ellipsis := 50, "..."
+	if len(args) > 0 {
+		_, n, _ = liquidToNum(args[0])
+	}
+	if len(args) > 1 {
+		ellipsis = liquidString(args[1])
+	}
+	s := []rune(liquidString(v))
+	if len(s) <= n {
+		return string(s), nil
+	}
+	cut := n - len([]rune(ellipsis))
+	if cut < 0 {
+		cut = 0
+	}
+	return string(s[:cut]) + ellipsis, nil
+}
+
+func liquidTruncateWords(v interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+	n, ellipsis := 15, "..."
+	if len(args) > 0 {
+		_, n, _ = liquidToNum(args[0])
+	}
+	if len(args) > 1 {
+		ellipsis = liquidString(args[1])
+	}
+	if n < 1 {
+		n = 1
+	}
+	s := liquidString(v)
+	words := strings.Fields(s)
+	if len(words) <= n {
+		return s, nil
+	}
+	return strings.Join(words[:n], " ") + ellipsis, nil
+}
+
+func liquidUniq(v interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+	items := liquidIter(v)
+	vals := liquidProperty(items, args)
+	result := make([]interface{}, 0, len(items))
+	var seen []interface{}
+	for i, item := range items {
+		dup := false
+		for _, s := range seen {
+			if liquidEqual(s, vals[i]) {
+				dup = true
+				break
+			}
+		}
+		if !dup {
+			seen = append(seen, vals[i])
+			result = append(result, item)
+		}
+	}
+	return result, nil
+}
+
+func liquidWhere(v interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+	if len(args) < 1 || len(args) > 2 {
+		return nil, fmt.Errorf("expected 1 or 2 arguments, got %d", len(args))
+	}
+	items := liquidIter(v)
+	vals := liquidProperty(items, args[:1])
+	result := make([]interface{}, 0, len(items))
+	for i, item := range items {
+		if (len(args) == 1 && liquidTruthy(vals[i])) ||
+			(len(args) == 2 && (liquidEqual(vals[i], args[1]) || liquidString(vals[i]) == liquidString(args[1]))) {
+			result = append(result, item)
+		}
+	}
+	return result, nil
+}
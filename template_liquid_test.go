@@ -0,0 +1,274 @@
+package dati
+
+/*
+Copyright (C) 2023 gearsix <gearsix@tuta.io>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const liquidData = `{
+	"title": "<eg>",
+	"n": 7,
+	"f": 2.5,
+	"none": [],
+	"spaces": "  ",
+	"lines": "a\nb",
+	"items": ["a", "b", "c"],
+	"nums": [3, 1, 2],
+	"people": [
+		{"name": "x", "age": 2, "city": "b"},
+		{"name": "y", "age": 1, "city": "a"},
+		{"name": "z", "age": 3, "city": "b", "admin": true}
+	],
+	"map": {"b": 2, "a": 1},
+	"date": "2023-04-05 06:07:08",
+	"author": {"name": "gearsix", "site": {"url": "notabug.org"}}
+}`
+
+var liquidGood = map[string]string{
+	// output
+	`{{ title }} {{ author.name }} {{ author["site"].url }}`:               `<eg> gearsix notabug.org`,
+	`{{ items[1] }}{{ items.first }}{{ items.last }}{{ items[-1] }}`:       `bacc`,
+	`{{ items.size }} {{ map.size }} {{ "abc".size }} {{ missing.x }}|`:    `3 2 3 |`,
+	`{{ items }} {{ n }} {{ f }} {{ true }} {{ nil }}|{{ (1..3) }}`:        `abc 7 2.5 true |123`,
+	`a {{- n -}} b {%- if true %} c {% endif -%} d`:                        `a7b c d`,
+	`{% raw %}{{ title }}{% endraw %}{% comment %}{{ x }}{% endcomment %}`: `{{ title }}`,
+	`{% comment %}{% comment %}{% endcomment %}x{% endcomment %}y`:         `y`,
+	`{% # comment %}x`: `x`,
+	"{% liquid\n  assign x = n | plus: 1\n  # comment\n  if x > 7\n    echo x\n  endif\n%}": `8`,
+	// filters
+	`{{ title | escape }} {{ "&lt;" | escape_once }} {{ "a<b>c</b>" | strip_html }}`:                           `&lt;eg&gt; &lt; ac`,
+	`{{ "hello" | capitalize }} {{ "A" | downcase }} {{ "a" | upcase }}`:                                       `Hello a A`,
+	`{{ "a" | append: "b" | prepend: "c" }} {{ "aXbX" | replace: "X", "-" }}`:                                  `cab a-b-`,
+	`{{ "aXbX" | replace_first: "X", "-" }} {{ "aXbX" | remove_last: "X" }} {{ "aXb" | remove: "X" }}`:         `a-bX aXb ab`,
+	`{{ "  x  " | strip }}|{{ "  x" | lstrip }}|{{ "x  " | rstrip }}|`:                                         `x|x|x|`,
+	`{{ "a,b,c" | split: "," | join: "-" }} {{ "a b  c" | split: " " | size }}`:                                `a-b-c 3`,
+	`{{ "hello world" | truncate: 8 }} {{ "a b c d" | truncatewords: 2 }}`:                                     `hello... a b...`,
+	`{{ "hello" | slice: 1, 3 }} {{ "hello" | slice: -2 }} {{ items | slice: 1, 5 | join }}`:                   `ell l b c`,
+	`{{ 4 | plus: 2 }} {{ 4 | minus: 6 }} {{ 3 | times: f }} {{ 7 | divided_by: 2 }}`:                          `6 -2 7.5 3`,
+	`{{ 7 | divided_by: 2.5 }} {{ -7 | modulo: 3 }} {{ 1.5 | ceil }} {{ 1.5 | floor }}`:                        `2.8 2 2 1`,
+	`{{ f | round }} {{ 3.14159 | round: 2 }} {{ -3 | abs }} {{ "2" | plus: 1 }}`:                              `3 3.14 3 3`,
+	`{{ 10 | divided_by: 4.0 }} {{ 2.0 | plus: 1 }} {{ n | divided_by: 2 }} {{ "4.0" | times: 2 }}`:            `2.5 3.0 3 8.0`,
+	`{{ 5 | at_least: 7 }} {{ 5 | at_most: 2.5 }} {{ -2.0 | abs }} {{ 2.0 | plus: 1 | minus: 1 }}`:             `7 2.5 2.0 2.0`,
+	`{{ 1 | at_least: 5 }} {{ 9 | at_most: 5 }}`:                                                               `5 5`,
+	`{{ nums | sort | join: "," }} {{ items | reverse | join }} {{ nums | first }}`:                            `1,2,3 c b a 3`,
+	`{{ people | sort: "age" | map: "name" | join }} {{ people | map: "age" | sum }}`:                          `y x z 6`,
+	`{{ people | where: "city", "b" | map: "name" | join }} {{ people | where: "admin" | size }}`:              `x z 1`,
+	`{{ "B,a,C" | split: "," | sort_natural | join }} {{ "a,a,b" | split: "," | uniq | join }}`:                `a B C a b`,
+	`{{ missing | default: "d" }} {{ none | default: "d" }} {{ false | default: 1, allow_false: true }}`:       `d d false`,
+	`{{ people | map: "missing" | compact | size }} {{ items | concat: nums | size }}`:                         `0 6`,
+	`{{ "a b" | url_encode }} {{ "a+b%21" | url_decode }} {{ "Hello, World!" | slugify }}`:                     `a+b a b! hello-world`,
+	`{{ author | jsonify }} {{ items | array_to_sentence_string }}`:                                            `{"name":"gearsix","site":{"url":"notabug.org"}} a, b, and c`,
+	`{{ date | date: "%Y/%-m/%d %H:%M %a %b" }} {{ date | date_to_string }}`:                                   `2023/4/05 06:07 Wed Apr 05 Apr 2023`,
+	`{% assign groups = people | group_by: "city" %}{% for g in groups %}{{ g.name }}{{ g.size }}{% endfor %}`: `b2a1`,
+	`{{ "# x" | markdownify | strip }} {{ lines | newline_to_br | strip_newlines }}`:                           `<h1>x</h1> a<br />b`,
+	// tags
+	`{% if n > 5 and items contains "a" %}a{% elsif n == 7 %}b{% else %}c{% endif %}`:                                     `a`,
+	`{% if none == empty %}a{% endif %}{% if spaces == blank %}b{% endif %}{% if "" %}c{% endif %}`:                       `abc`,
+	`{% if false or true and false %}a{% else %}b{% endif %}`:                                                             `b`,
+	`{% unless n == 7 %}a{% else %}b{% endunless %}{% if title contains "eg" %}c{% endif %}`:                              `bc`,
+	`{% case n %}{% when 1, 2 %}a{% when 7 or 8 %}b{% else %}c{% endcase %}`:                                              `b`,
+	`{% case "x" %}{% when "y" %}a{% else %}c{% endcase %}`:                                                               `c`,
+	`{% for i in items %}{{ forloop.index }}{{ i }}{% unless forloop.last %},{% endunless %}{% endfor %}`:                 `1a,2b,3c`,
+	`{% for i in (1..n) limit: 3 offset: 2 %}{{ i }}{% endfor %}`:                                                         `345`,
+	`{% for i in items reversed %}{{ i }}{{ forloop.rindex0 }}{% endfor %}`:                                               `c2b1a0`,
+	`{% for i in nums %}{% if i == 1 %}{% continue %}{% endif %}{% if i == 2 %}{% break %}{% endif %}{{ i }}{% endfor %}`: `3`,
+	`{% for i in none %}x{% else %}none{% endfor %}`:                                                                      `none`,
+	`{% for p in map %}{{ p[0] }}{{ p[1] }}{% endfor %}`:                                                                  `a1b2`,
+	`{% for i in items %}{% for j in nums %}{{ forloop.parentloop.index }}{% endfor %}{% endfor %}`:                       `111222333`,
+	`{% for i in items limit: 1 %}{{ i }}{% endfor %}{% for i in items offset: continue %}{{ i }}{% endfor %}`:            `abc`,
+	`{% for i in items %}{% cycle "x", "y" %}{% endfor %}{% cycle "g": 1, 2 %}{% cycle "g": 1, 2 %}`:                      `xyx12`,
+	`{% tablerow i in items cols: 2 %}{{ i }}{% endtablerow %}`:                                                           "<tr class=\"row1\">\n<td class=\"col1\">a</td><td class=\"col2\">b</td></tr>\n<tr class=\"row2\"><td class=\"col1\">c</td></tr>\n",
+	`{% assign x = items | join: "," %}{{ x }}{% for i in items %}{% assign y = i %}{% endfor %}{{ y }}`:                  `a,b,cc`,
+	`{% capture x %}<{{ n }}>{% endcapture %}{{ x }}`:                                                                     `<7>`,
+	`{% increment c %}{% increment c %}{% decrement d %}{% decrement d %}`:                                                `01-1-2`,
+	`{% include "partial" %} {% include 'item' with "x" %} {% include "item" with "y" as v %}`:                            `gearsix x y`,
+	`{% include "item" for items %}|{% include "args", a: 1, b: n %}`:                                                     `abc|17`,
+	`{% include item.html title="eg" n=n %}`:                                                                              `eg7`,
+	`{% assign v = 1 %}{% render "item", item: 2 %}{% render "args" %}{% render "item" with v %}`:                         `21`,
+	`{% include "assign" %}{{ z }}`:                                                                                       `1`,
+}
+
+var liquidPartials = map[string]string{
+	"partial":       `{{ author.name }}`,
+	"item.liquid":   `{{ item }}{{ v }}`,
+	"args":          `{{ a }}{{ b }}`,
+	"item.html":     `{{ include.title }}{{ include.n }}`,
+	"assign.liquid": `{% assign z = 1 %}`,
+}
+
+var liquidBad = []string{
+	`{{ title`,
+	`{% if title %}`,
+	`{% if title %}x{% endfor %}`,
+	`{% endif %}`,
+	`{% else %}`,
+	`{% bogus %}`,
+	`{% for %}{% endfor %}`,
+	`{% for i items %}{% endfor %}`,
+	`{% for i in items bogus: 1 %}{% endfor %}`,
+	`{% case n %}x{% when 1 %}{% endcase %}`,
+	`{% if n %}{% else %}{% else %}{% endif %}`,
+	`{{ title | missing }}`,
+	`{{ title | }}`,
+	`{{ title ^ }}`,
+	`{% assign = 1 %}`,
+	`{% raw %}`,
+	`{% comment %}`,
+	`{{ 1 | divided_by: 0 }}`,
+	`{% if n > "a" %}{% endif %}`,
+	`{% include "missing" %}`,
+	`{{ "a" | append }}`,
+	`{% for i in (1..100000000000) %}{% endfor %}`,
+	`{% for i in (-9000000000000000000..9000000000000000000) %}{% endfor %}`,
+}
+
+func TestExecuteLiquid(t *testing.T) {
+	var data map[string]interface{}
+	if err := LoadData(JSON, strings.NewReader(liquidData), &data); err != nil {
+		t.Skip("setup failure:", err)
+	}
+
+	for root, expect := range liquidGood {
+		tmpl, err := LoadTemplateString(LIQUID, "root", root, liquidPartials)
+		if err != nil {
+			t.Fatalf("'%s' failed to load: %s", root, err)
+		}
+		result, err := tmpl.Execute(data)
+		if err != nil {
+			t.Fatalf("'%s' failed to execute: %s", root, err)
+		}
+		validateExecute(t, result.String(), expect, err)
+	}
+
+	for _, root := range liquidBad {
+		tmpl, err := LoadTemplateString(LIQUID, "root", root, liquidPartials)
+		if err == nil {
+			_, err = tmpl.Execute(data)
+		}
+		if err == nil {
+			t.Fatalf("bad template passed: '%s'", root)
+		} else if !strings.HasPrefix(err.Error(), "root:") {
+			t.Fatalf("error does not indicate the template: %s", err)
+		}
+	}
+
+	root := "{% for i in items %}\n{% if title %}"
+	expect := "root:2: 'if' tag was never closed"
+	if _, err := LoadTemplateString(LIQUID, "root", root, nil); err == nil || err.Error() != expect {
+		t.Fatalf("'%s' returned '%v', should be '%s'", root, err, expect)
+	}
+}
+
+func TestExecuteLiquidStruct(t *testing.T) {
+	type item struct {
+		Name  string
+		Items []int
+		Date  time.Time
+	}
+	tmpl, err := LoadTemplateString(LIQUID, "root",
+		`{{ Name }}:{% for i in Items %}{{ i }}{% endfor %}:{{ Date | date: "%F" }}`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := tmpl.Execute(item{Name: "eg", Items: []int{1, 2}, Date: time.Date(2023, 1, 2, 0, 0, 0, 0, time.UTC)})
+	validateExecute(t, result.String(), "eg:12:2023-01-02", err)
+}
+
+func TestExecuteLiquidRecursive(t *testing.T) {
+	tmpl, err := LoadTemplateString(LIQUID, "root", `{% include "loop" %}`,
+		map[string]string{"loop": `{% include "loop" %}`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = tmpl.Execute(nil); err == nil {
+		t.Fatal("recursive include passed")
+	}
+}
+
+func TestRegisterLiquidFilter(t *testing.T) {
+	RegisterLiquidFilter("wrap", func(v interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("wrap requires one argument")
+		}
+		s := fmt.Sprint(args[0], v, args[0])
+		if suffix, ok := kwargs["suffix"]; ok {
+			s += fmt.Sprint(suffix)
+		}
+		return s, nil
+	})
+	defer RegisterLiquidFilter("wrap", nil)
+
+	tmpl, err := LoadTemplateString(LIQUID, "root", `{{ name | wrap: "*" }}{{ name | wrap: 1, suffix: "!" | upcase }}`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := tmpl.Execute(map[string]string{"name": "eg"})
+	validateExecute(t, result.String(), "*eg*1EG1!", err)
+
+	tmpl, err = LoadTemplateString(LIQUID, "root", `{{ name | wrap }}`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = tmpl.Execute(nil); err == nil || !strings.Contains(err.Error(), "wrap requires one argument") {
+		t.Fatalf("filter error not returned: %v", err)
+	}
+
+	RegisterLiquidFilter("wrap", nil)
+	if _, err = LoadTemplateString(LIQUID, "root", `{{ name | wrap: "*" }}`, nil); err == nil {
+		t.Fatal("removed filter passed")
+	}
+}
+
+func TestLoadTemplateFileLiquid(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dati")
+	if err != nil {
+		t.Skip("setup failure:", err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"page.liquid":  `{% include "head" %}{% for i in items %}{% include "item.liquid" %}{% endfor %}`,
+		"head.liquid":  `{{ title }}:`,
+		"item.liquid":  `{{ i }}`,
+		"ignored.tmpl": `{{.eg}}`,
+	}
+	var partials []string
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Skip("setup failure:", err)
+		}
+		if name != "page.liquid" {
+			partials = append(partials, path)
+		}
+	}
+
+	tmpl, err := LoadTemplateFile(filepath.Join(dir, "page.liquid"), partials...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := tmpl.Execute(map[string]interface{}{"title": "eg", "items": []int{1, 2}})
+	validateExecute(t, result.String(), "eg:12", err)
+}
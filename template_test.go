@@ -19,12 +19,17 @@ along with this program.  If not, see <https://www.gnu.org/licenses/>.
 
 import (
 	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 )
 
 const tmplRootGood = `{{.eg}} {{ template "tmplPartialGood" . }}`
@@ -45,10 +50,46 @@ const mstResult = `0 0`
 const mstRootBad = `{{> badPartial.mst}}{{#doesnt-exist}}{{/exit}}`
 const mstPartialBad = `p{{$}}{{ > noexist}`
 
+const hbsRootGood = `{{eg}} {{> hbsPartialGood}}`
+const hbsPartialGood = `{{eg}}`
+const hbsResult = `0 0`
+const hbsRootBad = `{{> badPartial}}{{#doesnt-exist}}{{/exit}}`
+const hbsPartialBad = `{{#if}}{{ > noexist}`
+
+const jinjaRootGood = `{{ eg }} {% include "jinjaPartialGood.jinja" %}`
+const jinjaPartialGood = `{{ eg }}`
+const jinjaResult = `0 0`
+const jinjaRootBad = `{% include "badPartial" %}{% if %}{% endfor %}`
+const jinjaPartialBad = `{% for %}{{ noexist`
+
+const liquidRootGood = `{{ eg }} {% include "liquidPartialGood" %}`
+const liquidPartialGood = `{{ eg }}`
+const liquidResult = `0 0`
+const liquidRootBad = `{% include "badPartial" %}{% if %}{% endfor %}`
+const liquidPartialBad = `{% for %}{{ noexist`
+
+// templateLanguageTests are the good & bad templates of each template
+// language that's loaded & executed by the same table-driven tests.
+// `partial` is the name the good root template includes the partial by.
+var templateLanguageTests = []struct {
+	lang                    TemplateLanguage
+	rootGood, rootBad       string
+	partial                 string
+	partialGood, partialBad string
+	result                  string
+}{
+	{HBS, hbsRootGood, hbsRootBad, "hbsPartialGood", hbsPartialGood, hbsPartialBad, hbsResult},
+	{JINJA, jinjaRootGood, jinjaRootBad, "jinjaPartialGood.jinja", jinjaPartialGood, jinjaPartialBad, jinjaResult},
+	{LIQUID, liquidRootGood, liquidRootBad, "liquidPartialGood", liquidPartialGood, liquidPartialBad, liquidResult},
+}
+
 var templateExts = []string{
 	".tmpl", "tmpl", "TMPL", ".TMPL",
 	".hmpl", "hmpl", "HMPL", ".HMPL",
 	".mst", "mst", "MST", ".MST",
+	".hbs", "hbs", "HBS", ".HBS",
+	".jinja", "j2", "JINJA2", ".J2",
+	".liquid", "liquid", "LIQUID", ".LIQUID",
 	".NONE", "-", ".", "",
 }
 
@@ -56,7 +97,7 @@ func TestIsTemplateLanguage(t *testing.T) {
 	for i, ext := range templateExts {
 		var target bool
 
-		if i < 12 {
+		if i < 24 {
 			target = true
 		}
 
@@ -77,6 +118,12 @@ func TestReadTemplateLanguage(t *testing.T) {
 			target = HMPL
 		} else if i < 12 {
 			target = MST
+		} else if i < 16 {
+			target = HBS
+		} else if i < 20 {
+			target = JINJA
+		} else if i < 24 {
+			target = LIQUID
 		} else {
 			target = ""
 		}
@@ -93,9 +140,12 @@ func TestReadTemplateLanguage(t *testing.T) {
 
 func validateTemplate(t *testing.T, template Template, templateType string, rootName string, partialNames ...string) {
 	types := map[string]string{
-		"tmpl": "*template.Template",
-		"hmpl": "*template.Template",
-		"mst":  "*mustache.Template",
+		"tmpl":   "*template.Template",
+		"hmpl":   "*template.Template",
+		"mst":    "dati.mstTemplate",
+		"hbs":    "*dati.hbsTemplate",
+		"jinja":  "*dati.jinjaTemplate",
+		"liquid": "*dati.liquidTemplate",
 	}
 
 	rt := reflect.TypeOf(template.T).String()
@@ -123,13 +173,13 @@ func validateTemplate(t *testing.T, template Template, templateType string, root
 func validateTemplateFile(t *testing.T, template Template, rootPath string, partialPaths ...string) {
 	rType := getTemplateType(rootPath)
 	rName := filepath.Base(rootPath)
-	if rType == "mst" {
+	if rType == "mst" || rType == "hbs" {
 		rName = strings.TrimSuffix(rName, filepath.Ext(rName))
 	}
 	var pNames []string
 	for _, path := range partialPaths {
 		name := filepath.Base(path)
-		if rType == "mst" {
+		if rType == "mst" || rType == "hbs" {
 			name = strings.TrimSuffix(name, filepath.Ext(name))
 		}
 		pNames = append(pNames, name)
@@ -156,7 +206,7 @@ func TestLoadTemplateFile(t *testing.T) {
 	createFile(goodPartials[len(goodPartials)-1], tmplPartialGood)
 	badRoots = append(badRoots, tdir+"/badRoot.tmpl")
 	createFile(badRoots[len(badRoots)-1], tmplRootBad)
-	badPartials = append(badRoots, tdir+"/badPartials.tmpl")
+	badPartials = append(badPartials, tdir+"/badPartials.tmpl")
 	createFile(badPartials[len(badPartials)-1], tmplPartialBad)
 
 	goodRoots = append(goodRoots, tdir+"/goodRoot.hmpl")
@@ -165,7 +215,7 @@ func TestLoadTemplateFile(t *testing.T) {
 	createFile(goodPartials[len(goodPartials)-1], hmplPartialGood)
 	badRoots = append(badRoots, tdir+"/badRoot.hmpl")
 	createFile(badRoots[len(badRoots)-1], hmplRootBad)
-	badPartials = append(badRoots, tdir+"/badPartials.hmpl")
+	badPartials = append(badPartials, tdir+"/badPartials.hmpl")
 	createFile(badPartials[len(badPartials)-1], hmplPartialBad)
 
 	goodRoots = append(goodRoots, tdir+"/goodRoot.mst")
@@ -174,9 +224,21 @@ func TestLoadTemplateFile(t *testing.T) {
 	createFile(goodPartials[len(goodPartials)-1], mstPartialGood)
 	badRoots = append(badRoots, tdir+"/badRoot.mst")
 	createFile(badRoots[len(badRoots)-1], mstRootBad)
-	badPartials = append(badRoots, tdir+"/badPartials.mst")
+	badPartials = append(badPartials, tdir+"/badPartials.mst")
 	createFile(badPartials[len(badPartials)-1], mstPartialBad)
 
+	for _, tt := range templateLanguageTests {
+		ext := "." + string(tt.lang)
+		goodRoots = append(goodRoots, tdir+"/goodRoot"+ext)
+		createFile(goodRoots[len(goodRoots)-1], tt.rootGood)
+		goodPartials = append(goodPartials, tdir+"/"+string(tt.lang)+"PartialGood"+ext)
+		createFile(goodPartials[len(goodPartials)-1], tt.partialGood)
+		badRoots = append(badRoots, tdir+"/badRoot"+ext)
+		createFile(badRoots[len(badRoots)-1], tt.rootBad)
+		badPartials = append(badPartials, tdir+"/badPartials"+ext)
+		createFile(badPartials[len(badPartials)-1], tt.partialBad)
+	}
+
 	for i, root := range goodRoots { // good root, good partials
 		if template, e := LoadTemplateFile(root, goodPartials[i]); e != nil {
 			t.Fatal(e)
@@ -184,6 +246,13 @@ func TestLoadTemplateFile(t *testing.T) {
 			validateTemplateFile(t, template, root, goodPartials[i])
 		}
 	}
+	for i, root := range goodRoots { // good root, partials of every language
+		if template, e := LoadTemplateFile(root, badPartials[(i+1)%len(badPartials)], goodPartials[i]); e != nil {
+			t.Fatalf("partials of other languages were not ignored: %s", e)
+		} else {
+			validateTemplateFile(t, template, root, goodPartials[i])
+		}
+	}
 	for i, root := range badRoots { // bad root, good partials
 		if _, e := LoadTemplateFile(root, goodPartials[i]); e == nil {
 			t.Fatalf("no error for bad template with good partials\n")
@@ -250,6 +319,21 @@ func TestLoadTemplateString(t *testing.T) {
 		map[string]string{"mstPartialGood": mstPartialBad}); err == nil {
 		testInvalid(templateType, template)
 	}
+
+	for _, tt := range templateLanguageTests {
+		if template, err = LoadTemplateString(tt.lang, name, tt.rootGood,
+			map[string]string{tt.partial: tt.partialGood}); err != nil {
+			t.Fatalf("'%s' template failed to load", tt.lang)
+		}
+		if template, err = LoadTemplateString(tt.lang, name, tt.rootBad,
+			map[string]string{tt.partial: tt.partialGood}); err == nil {
+			testInvalid(tt.lang, template)
+		}
+		if template, err = LoadTemplateString(tt.lang, name, tt.rootGood,
+			map[string]string{tt.partial: tt.partialBad}); err == nil {
+			testInvalid(tt.lang, template)
+		}
+	}
 }
 
 // func TestLoadTemplateString(t *testing.T) {} // This is tested by TestLoadTemplateFile and TestLoadTemplateString
@@ -299,4 +383,306 @@ func TestExecute(t *testing.T) {
 	}
 	results, err = tmpl.Execute(data)
 	validateExecute(t, results.String(), mstResult, err)
+
+	if err = LoadData("json", strings.NewReader(good["json"]), &data); err != nil {
+		t.Skip("setup failure:", err)
+	}
+	for _, tt := range templateLanguageTests {
+		if tmpl, err = LoadTemplateString(tt.lang, string(tt.lang)+"RootGood", tt.rootGood,
+			map[string]string{tt.partial: tt.partialGood}); err != nil {
+			t.Skip("setup failure:", err)
+		}
+		results, err = tmpl.Execute(data)
+		validateExecute(t, results.String(), tt.result, err)
+	}
+}
+
+type testEngine struct{}
+
+func (testEngine) Parse(rootName string, root io.Reader, partials map[string]io.Reader) (Executable, error) {
+	buf, err := ioutil.ReadAll(root)
+	if err != nil {
+		return nil, err
+	}
+	return testTemplate(buf), nil
+}
+
+type testTemplate string
+
+func (t testTemplate) Execute(w io.Writer, data interface{}) error {
+	_, err := fmt.Fprintf(w, string(t), data)
+	return err
+}
+
+func TestRegisterTemplateLanguage(t *testing.T) {
+	const lang TemplateLanguage = "test"
+
+	RegisterTemplateLanguage(lang, []string{".tst"}, testEngine{})
+
+	for _, path := range []string{"test", "x.tst", "x.TST"} {
+		if l := ReadTemplateLangauge(path); l != lang {
+			t.Fatalf("'%s' returned '%s', not '%s'", path, l, lang)
+		}
+	}
+
+	found := false
+	for _, l := range TemplateLanguages() {
+		if l == lang {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("'%s' missing from TemplateLanguages()", lang)
+	}
+
+	template, err := LoadTemplateString(lang, "test", "x%vx", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := template.Execute(0)
+	validateExecute(t, results.String(), "x0x", err)
+
+	var nilTemplate Template
+	if _, err = nilTemplate.Execute(0); err != ErrNilTemplate {
+		t.Fatalf("nil template did not return ErrNilTemplate: %s", err)
+	}
+}
+
+func TestRegisterTemplateAlias(t *testing.T) {
+	for path, lang := range map[string]TemplateLanguage{
+		"x.gotmpl": TMPL, "x.GOHMPL": HMPL, "x.mu": MST, "x.mustache": MST,
+	} {
+		if l := ReadTemplateLangauge(path); l != lang {
+			t.Fatalf("'%s' returned '%s', not '%s'", path, l, lang)
+		}
+	}
+
+	RegisterTemplateAlias(".HTML", LIQUID)
+	defer RegisterTemplateAlias("html", "")
+
+	if l := ReadTemplateLangauge("x.html"); l != LIQUID {
+		t.Fatalf("'x.html' returned '%s', not '%s'", l, LIQUID)
+	}
+	if exts := TemplateExtensions(LIQUID); len(exts) != 2 || exts[0] != "liquid" || exts[1] != "html" {
+		t.Fatalf("TemplateExtensions returned %v", exts)
+	}
+
+	tdir, err := ioutil.TempDir("", "dati")
+	if err != nil {
+		t.Skip("setup failure:", err)
+	}
+	defer os.RemoveAll(tdir)
+
+	files := map[string]string{
+		"root.html":      `{% include "head" %} {% include "body.liquid" %}`,
+		"head.html":      `{{ eg }}`,
+		"body.liquid":    `{{ eg }}`,
+		"ignored.mst":    `{% for`,
+		"ignored.gotmpl": `{{ end }}`,
+	}
+	var partials []string
+	for name, data := range files {
+		path := filepath.Join(tdir, name)
+		if err = ioutil.WriteFile(path, []byte(data), 0666); err != nil {
+			t.Skip("setup failure:", err)
+		}
+		if name != "root.html" {
+			partials = append(partials, path)
+		}
+	}
+
+	template, err := LoadTemplateFile(filepath.Join(tdir, "root.html"), partials...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := template.Execute(map[string]interface{}{"eg": 0})
+	validateExecute(t, results.String(), "0 0", err)
+
+	// mustache partials are named without their extension, including aliases
+	RegisterTemplateAlias("txt", MST)
+	defer RegisterTemplateAlias("txt", "")
+	partials = nil
+	for name, data := range map[string]string{
+		"root.txt": `{{> head}} {{> body}}`,
+		"head.TXT": `{{eg}}`,
+		"body.mu":  `{{eg}}`,
+	} {
+		path := filepath.Join(tdir, name)
+		if err = ioutil.WriteFile(path, []byte(data), 0666); err != nil {
+			t.Skip("setup failure:", err)
+		}
+		partials = append(partials, path)
+	}
+	if template, err = LoadTemplateFile(filepath.Join(tdir, "root.txt"), partials...); err != nil {
+		t.Fatal(err)
+	}
+	results, err = template.Execute(map[string]interface{}{"eg": 0})
+	validateExecute(t, results.String(), "0 0", err)
+
+	RegisterTemplateAlias("html", "")
+	if l := ReadTemplateLangauge("x.html"); l != "" {
+		t.Fatalf("'x.html' returned '%s' after the alias was removed", l)
+	}
+}
+
+func TestExecuteTo(t *testing.T) {
+	var err error
+	var tmpl Template
+	var data map[string]interface{}
+	var results bytes.Buffer
+
+	if err = LoadData("json", strings.NewReader(good["json"]), &data); err != nil {
+		t.Skip("setup failure:", err)
+	}
+
+	if tmpl, err = LoadTemplateString("hmpl", "hmplRootGood", hmplRootGood,
+		map[string]string{"hmplPartialGood": hmplPartialGood}); err != nil {
+		t.Skip("setup failure:", err)
+	}
+	err = tmpl.ExecuteTo(&results, data)
+	validateExecute(t, results.String(), hmplResult, err)
+
+	results.Reset()
+	if tmpl, err = LoadTemplateString("mst", "mstRootGood", mstRootGood,
+		map[string]string{"mstPartialGood": mstPartialGood}); err != nil {
+		t.Skip("setup failure:", err)
+	}
+	err = tmpl.ExecuteTo(&results, data)
+	validateExecute(t, results.String(), mstResult, err)
+
+	var nilTemplate Template
+	if err = nilTemplate.ExecuteTo(&results, data); err != ErrNilTemplate {
+		t.Fatalf("nil template did not return ErrNilTemplate: %s", err)
+	}
+}
+
+func TestExecuteToFile(t *testing.T) {
+	var err error
+	var tmpl Template
+	var data map[string]interface{}
+	var f *os.File
+	path := filepath.Join(os.TempDir(), "executeToFile.txt")
+	os.Remove(path)
+
+	if err = LoadData("json", strings.NewReader(good["json"]), &data); err != nil {
+		t.Skip("setup failure:", err)
+	}
+	if tmpl, err = LoadTemplateString("tmpl", "tmplRootGood", tmplRootGood,
+		map[string]string{"tmplPartialGood": tmplPartialGood}); err != nil {
+		t.Skip("setup failure:", err)
+	}
+
+	if f, err = tmpl.ExecuteToFile(data, path, false); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	buf, err := ioutil.ReadFile(path)
+	validateExecute(t, string(buf), tmplResult, err)
+
+	if _, err = tmpl.ExecuteToFile(data, path, false); err != os.ErrExist {
+		t.Fatalf("existing file was not protected: %s", err)
+	}
+
+	if f, err = tmpl.ExecuteToFile(data, path, true); err != nil {
+		t.Fatal(err)
+	} else if f.Name() != path {
+		t.Fatalf("returned file '%s' is not '%s'", f.Name(), path)
+	}
+	f.Close()
+	buf, err = ioutil.ReadFile(path)
+	validateExecute(t, string(buf), tmplResult, err)
+
+	if tmpl, err = LoadTemplateString("tmpl", "tmplBadExecute", `x{{template "missing"}}`, nil); err != nil {
+		t.Skip("setup failure:", err)
+	}
+	if f, err = tmpl.ExecuteToFile(data, path, true); err == nil {
+		t.Fatal("bad template execution passed")
+	} else if f != nil {
+		t.Fatal("file is not nil")
+	}
+	buf, err = ioutil.ReadFile(path)
+	validateExecute(t, string(buf), tmplResult, err)
+	if tmp, _ := filepath.Glob(filepath.Join(os.TempDir(), ".executeToFile.txt.*")); len(tmp) > 0 {
+		t.Fatalf("temporary files were not removed: %s", tmp)
+	}
+}
+
+func TestExecuteContext(t *testing.T) {
+	var err error
+	var tmpl Template
+	var data map[string]interface{}
+	var results bytes.Buffer
+
+	if err = LoadData("json", strings.NewReader(good["json"]), &data); err != nil {
+		t.Skip("setup failure:", err)
+	}
+	if tmpl, err = LoadTemplateString("mst", "mstRootGood", mstRootGood,
+		map[string]string{"mstPartialGood": mstPartialGood}); err != nil {
+		t.Skip("setup failure:", err)
+	}
+	err = tmpl.ExecuteContext(context.Background(), &results, data)
+	validateExecute(t, results.String(), mstResult, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err = tmpl.ExecuteContext(ctx, &results, data); !errors.Is(err, context.Canceled) {
+		t.Fatalf("cancelled context did not return context.Canceled: %s", err)
+	}
+
+	block := make(chan struct{})
+	defer close(block)
+	wait := map[string]interface{}{"wait": func() string { <-block; return "" }}
+	for _, root := range []string{`x{{call .wait}}x`, `{{call .wait}}`} {
+		if tmpl, err = LoadTemplateString("tmpl", "runaway", root, nil); err != nil {
+			t.Skip("setup failure:", err)
+		}
+
+		ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+		err = tmpl.ExecuteContext(ctx, ioutil.Discard, wait)
+		cancel()
+		if _, ok := err.(*ContextError); !ok {
+			t.Fatalf("runaway template did not return a *ContextError: %s", err)
+		} else if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("runaway template did not return context.DeadlineExceeded: %s", err)
+		}
+	}
+
+	// loops that don't write anything stop once the context is done
+	items := map[string]interface{}{"items": []int{1, 2, 3}}
+	for lang, root := range map[TemplateLanguage]string{
+		HBS:    `{{#each items}}{{/each}}`,
+		JINJA:  `{% for i in items %}{% endfor %}`,
+		LIQUID: `{% for i in items %}{% endfor %}`,
+	} {
+		if tmpl, err = LoadTemplateString(lang, "loop", root, nil); err != nil {
+			t.Skip("setup failure:", err)
+		}
+		ctx, cancel = context.WithCancel(context.Background())
+		cw := &contextWriter{ctx: ctx, w: ioutil.Discard}
+		if err = tmpl.T.Execute(cw, items); err != nil {
+			t.Fatalf("%s: loop failed: %s", lang, err)
+		}
+		cancel()
+		if err = tmpl.T.Execute(cw, items); err == nil {
+			t.Fatalf("%s: loop did not stop when the context was cancelled", lang)
+		}
+	}
+
+	tmpl = Template{Name: "panic", T: panicTemplate{}}
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err = tmpl.ExecuteContext(ctx, ioutil.Discard, data); err == nil {
+		t.Fatal("panicking template did not return an error")
+	} else if _, ok := err.(*ContextError); ok {
+		t.Fatalf("panicking template returned a *ContextError: %s", err)
+	}
+	if err = tmpl.ExecuteContext(context.Background(), ioutil.Discard, data); err == nil {
+		t.Fatal("panicking template did not return an error without a deadline")
+	}
+}
+
+type panicTemplate struct{}
+
+func (panicTemplate) Execute(w io.Writer, data interface{}) error {
+	panic("test panic")
 }